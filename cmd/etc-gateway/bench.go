@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"etcd-gateway/pkg/client"
+	"etcd-gateway/pkg/types"
+)
+
+// seedBatch is how many keys bench writes per request while seeding.
+const seedBatch = 500
+
+// benchConfig is the load bench generates.
+type benchConfig struct {
+	clients   int
+	duration  time.Duration
+	rate      int
+	keys      int
+	valueSize int
+	writes    float64
+	watchers  int
+	interval  time.Duration
+	prefix    string
+}
+
+// recorder collects the latencies and errors of one kind of operation.
+type recorder struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    int
+}
+
+func (r *recorder) record(d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.errors++
+		return
+	}
+	r.latencies = append(r.latencies, d)
+}
+
+// benchResult is the summary of one kind of operation, as printed by -json.
+type benchResult struct {
+	Op         string  `json:"op"`
+	Count      int     `json:"count"`
+	Errors     int     `json:"errors"`
+	Throughput float64 `json:"throughput"`
+	P50        float64 `json:"p50Ms"`
+	P90        float64 `json:"p90Ms"`
+	P99        float64 `json:"p99Ms"`
+	Max        float64 `json:"maxMs"`
+}
+
+func (r *recorder) result(op string, elapsed time.Duration) benchResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	res := benchResult{Op: op, Count: len(r.latencies), Errors: r.errors}
+	if elapsed > 0 {
+		res.Throughput = float64(len(r.latencies)) / elapsed.Seconds()
+	}
+	if len(r.latencies) > 0 {
+		res.P50 = millis(percentile(r.latencies, 50))
+		res.P90 = millis(percentile(r.latencies, 90))
+		res.P99 = millis(percentile(r.latencies, 99))
+		res.Max = millis(r.latencies[len(r.latencies)-1])
+	}
+	return res
+}
+
+// percentile returns the p-th percentile of sorted, by the nearest rank.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (len(sorted)*p + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func runBench(ctx context.Context, args []string) error {
+	fs, conn := newFlagSet("bench")
+	var cfg benchConfig
+	fs.IntVar(&cfg.clients, "clients", 10, "concurrent clients reading and writing")
+	fs.DurationVar(&cfg.duration, "duration", 30*time.Second, "how long to generate load for")
+	fs.IntVar(&cfg.rate, "rate", 0, "reads and writes per second across all clients, 0 for as many as they can")
+	fs.IntVar(&cfg.keys, "keys", 1000, "keys to seed and spread the load over")
+	fs.IntVar(&cfg.valueSize, "value-size", 128, "size of written values in bytes")
+	fs.Float64Var(&cfg.writes, "writes", 0.1, "fraction of operations that are writes, from 0 to 1")
+	fs.IntVar(&cfg.watchers, "watchers", 0, "clients following the changes under the prefix")
+	fs.DurationVar(&cfg.interval, "watch-interval", time.Second, "how often watchers ask for changes")
+	fs.StringVar(&cfg.prefix, "prefix", "/bench/", "prefix to write the keys under, deleted afterwards")
+	keep := fs.Bool("keep", false, "keep the keys under the prefix afterwards")
+	asJSON := fs.Bool("json", false, "print the results as JSON, to compare between releases")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if cfg.clients < 1 || cfg.keys < 1 || cfg.valueSize < 20 || cfg.writes < 0 || cfg.writes > 1 || cfg.duration <= 0 {
+		return errors.New("bench needs -clients and -keys of at least 1, -value-size of at least 20, -writes between 0 and 1 and a positive -duration")
+	}
+	if !strings.HasSuffix(cfg.prefix, "/") {
+		return errors.New("-prefix must end in /")
+	}
+	// Operations that fail are counted rather than retried, so retries do
+	// not hide errors in the latencies.
+	c, err := conn.client(client.WithRetries(0, 0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Seeding %d keys under %s\n", cfg.keys, cfg.prefix)
+	if err := seed(ctx, c, cfg); err != nil {
+		return err
+	}
+	if !*keep {
+		defer func() {
+			// The load may have been interrupted; cleaning up must not be.
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if _, err := c.Delete(ctx, cfg.prefix, true); err != nil {
+				fmt.Fprintln(os.Stderr, "etc-gateway: deleting "+cfg.prefix+": "+err.Error())
+			}
+		}()
+	}
+
+	fmt.Fprintf(os.Stderr, "Running %d clients and %d watchers for %s\n", cfg.clients, cfg.watchers, cfg.duration)
+	results, elapsed := bench(ctx, c, cfg)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{
+			"seconds": elapsed.Seconds(),
+			"clients": cfg.clients,
+			"results": results,
+		})
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "OP\tCOUNT\tERRORS\tOPS/S\tP50 MS\tP90 MS\tP99 MS\tMAX MS\t")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\t\n", r.Op, r.Count, r.Errors, r.Throughput, r.P50, r.P90, r.P99, r.Max)
+	}
+	return w.Flush()
+}
+
+// seed writes every key bench reads, so reads do not measure 404s.
+func seed(ctx context.Context, c *client.Client, cfg benchConfig) error {
+	for start := 0; start < cfg.keys; start += seedBatch {
+		var req types.BatchPutRequest
+		for i := start; i < start+seedBatch && i < cfg.keys; i++ {
+			req.KVs = append(req.KVs, types.BatchPut{Key: benchKey(cfg.prefix, i), Value: benchValue(cfg.valueSize)})
+		}
+		if _, err := c.BatchPut(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func benchKey(prefix string, i int) string {
+	return prefix + "key-" + strconv.Itoa(i)
+}
+
+// benchValue returns a value of size bytes starting with the time it was
+// made, which watchers subtract from the time they see it to measure how
+// long changes take to reach them.
+func benchValue(size int) string {
+	v := strconv.FormatInt(time.Now().UnixNano(), 10) + " "
+	return v + strings.Repeat("x", size-len(v))
+}
+
+// bench generates the load of cfg until its duration is up or ctx is
+// cancelled, returning the results of reads, writes, watch polls and watch
+// delivery, and how long it ran.
+func bench(ctx context.Context, c *client.Client, cfg benchConfig) ([]benchResult, time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+
+	var reads, writes, polls, delivery recorder
+	var ticks <-chan time.Time
+	if cfg.rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(cfg.rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.watchers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watch(ctx, c, cfg, &polls, &delivery)
+		}()
+	}
+	for i := 0; i < cfg.clients; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for {
+				if ticks != nil {
+					select {
+					case <-ticks:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				key := benchKey(cfg.prefix, rnd.Intn(cfg.keys))
+				began := time.Now()
+				if rnd.Float64() < cfg.writes {
+					_, err := c.Put(ctx, key, benchValue(cfg.valueSize))
+					if ctx.Err() == nil {
+						writes.record(time.Since(began), err)
+					}
+				} else {
+					_, err := c.Value(ctx, key)
+					if ctx.Err() == nil {
+						reads.record(time.Since(began), err)
+					}
+				}
+			}
+		}(time.Now().UnixNano() + int64(i))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	results := []benchResult{reads.result("read", elapsed), writes.result("write", elapsed)}
+	if cfg.watchers > 0 {
+		results = append(results, polls.result("watch-poll", elapsed), delivery.result("watch-delay", elapsed))
+	}
+	return results, elapsed
+}
+
+// watch follows the changes under the prefix the way the CLI's watch does,
+// recording how long each poll takes and how long each written value took
+// to be seen.
+func watch(ctx context.Context, c *client.Client, cfg benchConfig, polls, delivery *recorder) {
+	var since int64
+	for ctx.Err() == nil {
+		began := time.Now()
+		if since == 0 {
+			count, err := c.Count(ctx, cfg.prefix)
+			if ctx.Err() == nil {
+				polls.record(time.Since(began), err)
+			}
+			since = count.Revision
+		} else {
+			resp, err := c.Changes(ctx, cfg.prefix, since, 0)
+			if ctx.Err() != nil {
+				return
+			}
+			polls.record(time.Since(began), err)
+			if client.IsGone(err) {
+				// Fell behind a compaction; start again from now on.
+				since = 0
+				continue
+			}
+			seen := time.Now()
+			for _, ev := range resp.Events {
+				if ev.Type != "PUT" {
+					continue
+				}
+				stamp, _, _ := strings.Cut(ev.Kv.Value, " ")
+				if nanos, err := strconv.ParseInt(stamp, 10, 64); err == nil {
+					delivery.record(seen.Sub(time.Unix(0, nanos)), nil)
+				}
+			}
+			if err == nil {
+				since = resp.Revision
+				if resp.More {
+					continue
+				}
+			}
+		}
+		select {
+		case <-time.After(cfg.interval):
+		case <-ctx.Done():
+		}
+	}
+}
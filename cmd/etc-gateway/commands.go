@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"etcd-gateway/pkg/client"
+	"etcd-gateway/pkg/types"
+)
+
+// rangePage is how many keys prefix listings read per request.
+const rangePage = 1000
+
+// prefixEnd returns the key after every key starting with prefix.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// The whole keyspace; etcd reads "\x00" as no end.
+	return "\x00"
+}
+
+// scanPrefix calls fn with every key under prefix, a page at a time.
+func scanPrefix(ctx context.Context, c *client.Client, prefix string, keysOnly bool, fn func(types.KeyValue)) error {
+	start, end := prefix, prefixEnd(prefix)
+	for {
+		resp, err := c.Range(ctx, client.RangeOptions{Start: start, End: end, Limit: rangePage, KeysOnly: keysOnly})
+		if err != nil {
+			return err
+		}
+		for _, kv := range resp.KVs {
+			fn(kv)
+		}
+		if !resp.More || len(resp.KVs) == 0 {
+			return nil
+		}
+		start = resp.KVs[len(resp.KVs)-1].Key + "\x00"
+	}
+}
+
+func runGet(ctx context.Context, args []string) error {
+	fs, conn := newFlagSet("get")
+	prefix := fs.Bool("prefix", false, "get every key under KEY, printing each key before its value")
+	keysOnly := fs.Bool("keys-only", false, "with -prefix, only print the keys")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	key := fs.Arg(0)
+	if !*prefix {
+		value, err := c.Value(ctx, key)
+		if err != nil {
+			return err
+		}
+		fmt.Println(value.Value)
+		return nil
+	}
+	return scanPrefix(ctx, c, key, *keysOnly, func(kv types.KeyValue) {
+		fmt.Println(kv.Key)
+		if !*keysOnly {
+			fmt.Println(kv.Value)
+		}
+	})
+}
+
+func runPut(ctx context.Context, args []string) error {
+	fs, conn := newFlagSet("put")
+	if err := parse(fs, args, 1, 2); err != nil {
+		return err
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	value := fs.Arg(1)
+	if fs.NArg() == 1 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	if _, err := c.Put(ctx, fs.Arg(0), value); err != nil {
+		return err
+	}
+	fmt.Println("OK")
+	return nil
+}
+
+func runDel(ctx context.Context, args []string) error {
+	fs, conn := newFlagSet("del")
+	prefix := fs.Bool("prefix", false, "also delete every key under KEY")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	resp, err := c.Delete(ctx, fs.Arg(0), *prefix)
+	if client.IsNotFound(err) {
+		fmt.Println(0)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Trashed {
+		fmt.Printf("%d (moved to trash)\n", resp.Deleted)
+		return nil
+	}
+	fmt.Println(resp.Deleted)
+	return nil
+}
+
+func runLs(ctx context.Context, args []string) error {
+	fs, conn := newFlagSet("ls")
+	recursive := fs.Bool("r", false, "list every key under PREFIX rather than its children")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	prefix := "/"
+	if fs.NArg() == 1 {
+		prefix = fs.Arg(0)
+	}
+	if *recursive {
+		return scanPrefix(ctx, c, prefix, true, func(kv types.KeyValue) {
+			fmt.Println(kv.Key)
+		})
+	}
+	children, err := c.Children(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if child.IsLeaf {
+			fmt.Println(child.ID)
+		} else {
+			fmt.Println(child.ID + "/")
+		}
+	}
+	return nil
+}
+
+func runWatch(ctx context.Context, args []string) error {
+	fs, conn := newFlagSet("watch")
+	rev := fs.Int64("rev", 0, "print changes after this revision instead of from now on")
+	interval := fs.Duration("interval", time.Second, "how often to ask the gateway for changes")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	prefix := fs.Arg(0)
+	since := *rev
+	if since == 0 {
+		count, err := c.Count(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		since = count.Revision
+	}
+	for {
+		resp, err := c.Changes(ctx, prefix, since, 0)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if client.IsGone(err) {
+			return fmt.Errorf("changes after revision %d have been compacted", since)
+		}
+		if err != nil {
+			return err
+		}
+		for _, ev := range resp.Events {
+			fmt.Println(ev.Type)
+			fmt.Println(ev.Kv.Key)
+			if ev.Type == "PUT" {
+				fmt.Println(ev.Kv.Value)
+			}
+		}
+		since = resp.Revision
+		if resp.More {
+			continue
+		}
+		select {
+		case <-time.After(*interval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func runExport(ctx context.Context, args []string) error {
+	fs, conn := newFlagSet("export")
+	format := fs.String("format", "json", "json, which restores with the restore job, or tar.gz")
+	out := fs.String("o", "", "file to write the export to instead of stdout")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	c, err := conn.client()
+	if err != nil {
+		return err
+	}
+	job, err := c.StartExport(ctx, types.ExportRequest{Prefix: fs.Arg(0), Format: *format})
+	if err != nil {
+		return err
+	}
+	if job, err = c.WaitJob(ctx, job.ID, 500*time.Millisecond); err != nil {
+		return err
+	}
+	if job.Status != "succeeded" {
+		return fmt.Errorf("export job %s %s: %s", job.ID, job.Status, job.Error)
+	}
+	var result types.ExportResult
+	if err := client.JobResult(job, &result); err != nil {
+		return err
+	}
+	artifact, err := c.JobArtifact(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	defer artifact.Close()
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := io.Copy(w, artifact); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d keys at revision %d\n", result.Keys, result.Revision)
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
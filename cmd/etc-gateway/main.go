@@ -0,0 +1,150 @@
+// Command etc-gateway talks to a running gateway the way etcdctl talks to
+// etcd, so reads and writes go through the gateway's authentication and are
+// audited under the caller's name.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"etcd-gateway/pkg/client"
+)
+
+// command is a subcommand; run gets the arguments after its name.
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+// commands is filled in by init, as the commands look up their own usage.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"get":    {"get [-prefix] [-keys-only] KEY", runGet},
+		"put":    {"put KEY [VALUE], reading VALUE from stdin when omitted", runPut},
+		"del":    {"del [-prefix] KEY", runDel},
+		"ls":     {"ls [-r] [PREFIX]", runLs},
+		"watch":  {"watch [-rev N] [-interval D] PREFIX", runWatch},
+		"export": {"export [-format json|tar.gz] [-o FILE] PREFIX", runExport},
+		"bench":  {"bench [-clients N] [-duration D] [-rate N] [-keys N] [-value-size N] [-writes F] [-watchers N] [-prefix P] [-json]", runBench},
+	}
+}
+
+// errUsage is returned for invalid arguments, after printing the usage.
+var errUsage = errors.New("invalid usage")
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: etc-gateway COMMAND [flags] ARGS")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nEvery command takes -gateway, -token, -user, -env, -cluster and -timeout,")
+	fmt.Fprintln(os.Stderr, "defaulting to ETC_GATEWAY_URL, ETC_GATEWAY_TOKEN and ETC_GATEWAY_USER.")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := cmd.run(ctx, os.Args[2:])
+	if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "etc-gateway: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+// connection holds the flags every command takes to reach the gateway.
+type connection struct {
+	gateway, token, user string
+	env, cluster         string
+	timeout              time.Duration
+}
+
+func envOrDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// newFlagSet returns the flags of command name, with the connection flags
+// registered on it.
+func newFlagSet(name string) (*flag.FlagSet, *connection) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: etc-gateway "+commands[name].usage)
+		fs.PrintDefaults()
+	}
+	conn := &connection{}
+	fs.StringVar(&conn.gateway, "gateway", envOrDefault("ETC_GATEWAY_URL", "http://localhost:8080"), "gateway URL")
+	fs.StringVar(&conn.token, "token", os.Getenv("ETC_GATEWAY_TOKEN"), "bearer token to authenticate with")
+	fs.StringVar(&conn.user, "user", os.Getenv("ETC_GATEWAY_USER"), "user to act as, for gateways not behind an authenticating proxy")
+	fs.StringVar(&conn.env, "env", "", "environment to use instead of the gateway's own keys")
+	fs.StringVar(&conn.cluster, "cluster", "", "cluster to use instead of the gateway's own")
+	fs.DurationVar(&conn.timeout, "timeout", 30*time.Second, "timeout of each request")
+	return fs, conn
+}
+
+// parse parses args into fs, checking that between min and max positional
+// arguments remain.
+func parse(fs *flag.FlagSet, args []string, min, max int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < min || fs.NArg() > max {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// client returns a client for the connection, configured with extra on top
+// of the connection flags.
+func (conn *connection) client(extra ...client.Option) (*client.Client, error) {
+	if conn.env != "" && conn.cluster != "" {
+		return nil, errors.New("-env and -cluster cannot be combined")
+	}
+	opts := []client.Option{client.WithHTTPClient(&http.Client{Timeout: conn.timeout})}
+	if conn.token != "" {
+		opts = append(opts, client.WithToken(conn.token))
+	}
+	if conn.user != "" {
+		opts = append(opts, client.WithUser(conn.user))
+	}
+	c, err := client.New(conn.gateway, append(opts, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	if conn.cluster != "" {
+		c = c.Cluster(conn.cluster)
+	}
+	if conn.env != "" {
+		c = c.Environment(conn.env)
+	}
+	return c, nil
+}
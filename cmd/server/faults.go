@@ -0,0 +1,41 @@
+//go:build faults
+
+package main
+
+import (
+	"os"
+	"strconv"
+
+	"etcd-gateway/internal/api"
+	"go.uber.org/zap"
+)
+
+// newFaults enables fault injection, configured from the FAULT_* variables
+// and changed later through /admin/faults. It is only built with -tags
+// faults, so no production binary can inject faults, however configured.
+func newFaults() *api.Faults {
+	config := api.FaultConfig{
+		LatencyMs: envDuration("FAULT_LATENCY", 0).Milliseconds(),
+		JitterMs:  envDuration("FAULT_JITTER", 0).Milliseconds(),
+		Prefixes:  splitList(os.Getenv("FAULT_PREFIXES")),
+	}
+	for key, rate := range map[string]*float64{"FAULT_ERROR_RATE": &config.ErrorRate, "FAULT_WATCH_DROP_RATE": &config.WatchDropRate} {
+		if v := os.Getenv(key); v != "" {
+			var err error
+			if *rate, err = strconv.ParseFloat(v, 64); err != nil {
+				logger.Fatal("Invalid "+key+":", zap.Error(err))
+			}
+		}
+	}
+	faults, err := api.NewFaults(config)
+	if err != nil {
+		logger.Fatal("Invalid fault injection settings:", zap.Error(err))
+	}
+	logger.Warn("Fault injection is enabled",
+		zap.Int64("latencyMs", config.LatencyMs),
+		zap.Int64("jitterMs", config.JitterMs),
+		zap.Float64("errorRate", config.ErrorRate),
+		zap.Float64("watchDropRate", config.WatchDropRate),
+		zap.Strings("prefixes", config.Prefixes))
+	return faults
+}
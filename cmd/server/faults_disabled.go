@@ -0,0 +1,21 @@
+//go:build !faults
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"etcd-gateway/internal/api"
+)
+
+// newFaults leaves fault injection off: it needs a binary built with -tags
+// faults. Setting FAULT_* variables anyway is reported rather than ignored.
+func newFaults() *api.Faults {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, "FAULT_") {
+			logger.Warn("Ignoring " + env[:strings.IndexByte(env, '=')] + ": fault injection needs a build with -tags faults")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"log/syslog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logRotation is how log files are rotated: when they reach MaxSizeMB, and
+// every Interval when it is positive. At most Keep rotated files are kept,
+// or all of them when Keep is zero.
+type logRotation struct {
+	MaxSizeMB int
+	Interval  time.Duration
+	Keep      int
+	Compress  bool
+}
+
+// logRotationFromEnv reads the LOG_ROTATE_* variables. It runs before the
+// logger exists, so it returns errors rather than logging them.
+func logRotationFromEnv() (logRotation, error) {
+	rotation := logRotation{MaxSizeMB: 100, Keep: 7, Compress: os.Getenv("LOG_ROTATE_COMPRESS") == "true"}
+	var err error
+	if v := os.Getenv("LOG_ROTATE_SIZE_MB"); v != "" {
+		if rotation.MaxSizeMB, err = strconv.Atoi(v); err != nil || rotation.MaxSizeMB <= 0 {
+			return rotation, errors.New("invalid LOG_ROTATE_SIZE_MB " + strconv.Quote(v))
+		}
+	}
+	if v := os.Getenv("LOG_ROTATE_INTERVAL"); v != "" {
+		if rotation.Interval, err = time.ParseDuration(v); err != nil || rotation.Interval <= 0 {
+			return rotation, errors.New("invalid LOG_ROTATE_INTERVAL " + strconv.Quote(v))
+		}
+	}
+	if v := os.Getenv("LOG_ROTATE_KEEP"); v != "" {
+		if rotation.Keep, err = strconv.Atoi(v); err != nil || rotation.Keep < 0 {
+			return rotation, errors.New("invalid LOG_ROTATE_KEEP " + strconv.Quote(v))
+		}
+	}
+	return rotation, nil
+}
+
+// logSinks opens each log destination once, so the application and access
+// logs can share one.
+type logSinks struct {
+	rotation logRotation
+	files    map[string]*lumberjack.Logger
+	syslogs  map[string]*syslog.Writer
+}
+
+func newLogSinks(rotation logRotation) *logSinks {
+	return &logSinks{
+		rotation: rotation,
+		files:    make(map[string]*lumberjack.Logger),
+		syslogs:  make(map[string]*syslog.Writer),
+	}
+}
+
+// file opens a rotated log file.
+func (s *logSinks) file(path string) *lumberjack.Logger {
+	if f, ok := s.files[path]; ok {
+		return f
+	}
+	f := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    s.rotation.MaxSizeMB,
+		MaxBackups: s.rotation.Keep,
+		Compress:   s.rotation.Compress,
+	}
+	if s.rotation.Interval > 0 {
+		go func() {
+			for range time.Tick(s.rotation.Interval) {
+				// Quiet periods would otherwise leave empty files behind.
+				if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+					f.Rotate()
+				}
+			}
+		}()
+	}
+	s.files[path] = f
+	return f
+}
+
+// syslog connects to the local syslog daemon when addr is empty, otherwise
+// to the one at addr over network.
+func (s *logSinks) syslog(network, addr string) (*syslog.Writer, error) {
+	key := network + "://" + addr
+	if w, ok := s.syslogs[key]; ok {
+		return w, nil
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "etcd-gateway")
+	if err != nil {
+		return nil, err
+	}
+	s.syslogs[key] = w
+	return w, nil
+}
+
+// core returns the core writing entries to a destination: "stdout",
+// "stderr", "file:/path" (or just "/path"), "syslog" for the local daemon,
+// or "syslog://host:port" (UDP) or "syslog+tcp://host:port" for a remote one.
+func (s *logSinks) core(dest string, enc zapcore.Encoder, level zapcore.LevelEnabler) (zapcore.Core, error) {
+	switch {
+	case dest == "stdout":
+		return zapcore.NewCore(enc, zapcore.Lock(os.Stdout), level), nil
+	case dest == "stderr":
+		return zapcore.NewCore(enc, zapcore.Lock(os.Stderr), level), nil
+	case dest == "syslog" || strings.HasPrefix(dest, "syslog://") || strings.HasPrefix(dest, "syslog+tcp://"):
+		var network, addr string
+		if dest != "syslog" {
+			u, err := url.Parse(dest)
+			if err != nil || u.Host == "" {
+				return nil, errors.New("invalid syslog address " + strconv.Quote(dest))
+			}
+			network, addr = "udp", u.Host
+			if u.Scheme == "syslog+tcp" {
+				network = "tcp"
+			}
+		}
+		w, err := s.syslog(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &syslogCore{LevelEnabler: level, enc: enc, w: w}, nil
+	case strings.HasPrefix(dest, "file:") || strings.HasPrefix(dest, "/"):
+		path := strings.TrimPrefix(dest, "file:")
+		if path == "" {
+			return nil, errors.New("missing path in " + strconv.Quote(dest))
+		}
+		return zapcore.NewCore(enc, zapcore.AddSync(s.file(path)), level), nil
+	}
+	return nil, errors.New("unknown log destination " + strconv.Quote(dest) + ", expected stdout, stderr, file:/path or syslog[://host:port]")
+}
+
+// build builds a logger from cfg writing to every destination in dests,
+// keeping cfg's encoding, level and sampling.
+func (s *logSinks) build(cfg zap.Config, dests []string) (*zap.Logger, error) {
+	enc := zapcore.NewJSONEncoder(cfg.EncoderConfig)
+	if cfg.Encoding == "console" {
+		enc = zapcore.NewConsoleEncoder(cfg.EncoderConfig)
+	}
+	var cores []zapcore.Core
+	for _, dest := range dests {
+		core, err := s.core(dest, enc.Clone(), cfg.Level)
+		if err != nil {
+			return nil, err
+		}
+		cores = append(cores, core)
+	}
+	core := zapcore.NewTee(cores...)
+	if cfg.Sampling != nil {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, cfg.Sampling.Initial, cfg.Sampling.Thereafter)
+	}
+	cfg.Sampling = nil
+	cfg.OutputPaths = nil
+	return cfg.Build(zap.WrapCore(func(zapcore.Core) zapcore.Core { return core }))
+}
+
+// syslogCore writes entries to syslog at the severity matching their level.
+type syslogCore struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	w   *syslog.Writer
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return &syslogCore{LevelEnabler: c.LevelEnabler, enc: enc, w: c.w}
+}
+
+func (c *syslogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *syslogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	msg := strings.TrimSuffix(buf.String(), "\n")
+	switch ent.Level {
+	case zapcore.DebugLevel:
+		return c.w.Debug(msg)
+	case zapcore.InfoLevel:
+		return c.w.Info(msg)
+	case zapcore.WarnLevel:
+		return c.w.Warning(msg)
+	case zapcore.ErrorLevel:
+		return c.w.Err(msg)
+	default:
+		return c.w.Crit(msg)
+	}
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"etcd-gateway/internal/api"
+	"etcd-gateway/internal/gatewaypb"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,42 +20,694 @@ import (
 	"github.com/gin-gonic/gin"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 )
 
 var (
-	logger     *zap.Logger
-	etcdClient *clientv3.Client
+	logger        *zap.Logger
+	logLevel      zap.AtomicLevel
+	etcdClient    *clientv3.Client
+	etcdTuning    api.EtcdTuning
+	revisionClock *api.RevisionClock
+	valueIndex    *api.ValueIndex
+	keyspaceCache *api.KeyspaceCache
+	valueCache    *api.ValueCache
+	growthSampler *api.GrowthSampler
+	trash         *api.Trash
+	auditLog      *api.AuditLog
+	rewriter      *api.Rewriter
+	gitopsSyncer  *api.GitOpsSyncer
+	gitExporter   *api.GitExporter
+	configMapSync *api.KubeSyncer
+	secretSync    *api.KubeSyncer
+	proposals     *api.Proposals
+	slackNotifier *api.SlackNotifier
+	expiry        *api.ExpiryNotifier
+	leaseKeeper   *api.LeaseKeeper
+	emailNotifier *api.EmailNotifier
+	alarmMonitor  *api.AlarmMonitor
+	etcdMonitor   *api.EtcdMonitor
+	scheduler     *api.Scheduler
+	backups       *api.Backups
+	mirror        *api.Mirror
+	jobs          *api.Jobs
+	artifacts     api.ArtifactStore
+
+	environments    []api.Environment
+	environmentDeps = make(map[string]apiDeps)
+	clusters        []api.Cluster
+	clusterDeps     = make(map[string]apiDeps)
+	clusterHandlers = api.NewClusters()
+	clusterRegistry *api.ClusterRegistry
+	timeouts        = api.DefaultTimeouts()
+	cachePolicies   []api.CachePolicy
+	// identityTrust says whose X-Forwarded-User the gateway believes.
+	identityTrust api.IdentityTrust
+	// slowRequestThreshold enables slow request logging when positive.
+	slowRequestThreshold time.Duration
+	accessLogger         *zap.Logger
+	accessLogConfig      api.AccessLogConfig
+	errorReporter        *api.ErrorReporter
+	// deniedPrefixes are never served, whatever the request.
+	deniedPrefixes     []string
+	validationWebhooks []api.ValidationWebhook
+	validationTimeout  time.Duration
+	mutationRules      []api.MutationRule
+	// faults is only set in binaries built with the faults tag.
+	faults *api.Faults
 )
 
 func init() {
 	var err error
+	logConfig := zap.NewDevelopmentConfig()
 	if os.Getenv("APP_ENV") == "production" {
-		logger, err = zap.NewProduction()
-	} else {
-		logger, err = zap.NewDevelopment()
+		logConfig = zap.NewProductionConfig()
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err = logConfig.Level.UnmarshalText([]byte(v)); err != nil {
+			fmt.Printf("Invalid LOG_LEVEL: %v\n", err)
+			os.Exit(1)
+		}
 	}
+	logLevel = logConfig.Level
+	rotation, err := logRotationFromEnv()
 	if err != nil {
 		fmt.Printf("Cannot create zap logger: %v\n", err)
 		os.Exit(1)
 	}
+	sinks := newLogSinks(rotation)
+	logOutput := envOrDefault("LOG_OUTPUT", "stderr")
+	if logger, err = sinks.build(logConfig, splitList(logOutput)); err != nil {
+		fmt.Printf("Cannot create zap logger: %v\n", err)
+		os.Exit(1)
+	}
 
-	etcdClient, err = clientv3.New(clientv3.Config{
-		Endpoints:   []string{"localhost:2379"},
-		DialTimeout: 5 * time.Second,
-	})
+	// The client connects in the background and reconnects by itself, so the
+	// gateway starts, and keeps serving what it can, while etcd is down;
+	// etcdMonitor reports it degraded until etcd can be reached.
+	etcdTuning = etcdTuningFromEnv()
+	etcdClient, err = clientv3.New(etcdTuning.Config([]string{"localhost:2379"}, grpc.WithChainUnaryInterceptor(api.EtcdTimingInterceptor)))
 	if err != nil {
-		logger.Fatal("Cannot connect to etcd:", zap.Error(err))
+		logger.Fatal("Cannot create etcd client:", zap.Error(err))
+	}
+	etcdMonitor = api.NewEtcdMonitor(etcdClient, envDuration("ETCD_PROBE_INTERVAL", 5*time.Second), logger)
+	if deniedPrefixes, err = api.ParseDeniedPrefixes(splitList(os.Getenv("DENIED_PREFIXES"))); err != nil {
+		logger.Fatal("Invalid DENIED_PREFIXES:", zap.Error(err))
+	}
+	if validationWebhooks, err = api.ParseValidationWebhooks(splitList(os.Getenv("VALIDATION_WEBHOOKS"))); err != nil {
+		logger.Fatal("Invalid VALIDATION_WEBHOOKS:", zap.Error(err))
+	}
+	validationTimeout = envDuration("VALIDATION_TIMEOUT", 5*time.Second)
+	if mutationRules, err = api.ParseMutationRules(splitList(os.Getenv("MUTATIONS"))); err != nil {
+		logger.Fatal("Invalid MUTATIONS:", zap.Error(err))
+	}
+	faults = newFaults()
+	guardClient(etcdClient)
+
+	revisionClock = api.NewRevisionClock(etcdClient, logger)
+
+	slowRequestThreshold = envDuration("SLOW_REQUEST_THRESHOLD", 0)
+	// Access logs are always JSON and unsampled by zap, which would drop
+	// entries sharing a message; AccessLogMiddleware samples by its own rules.
+	accessLogCfg := zap.NewProductionConfig()
+	accessLogCfg.Sampling = nil
+	accessLogCfg.DisableCaller = true
+	accessLogCfg.DisableStacktrace = true
+	if accessLogger, err = sinks.build(accessLogCfg, splitList(envOrDefault("ACCESS_LOG_OUTPUT", logOutput))); err != nil {
+		logger.Fatal("Cannot create access logger:", zap.Error(err))
+	}
+	accessLogConfig = api.AccessLogConfig{
+		SampleRate:    1,
+		Suppress:      splitList(envOrDefault("ACCESS_LOG_SUPPRESS", "/health,/ready")),
+		SlowThreshold: slowRequestThreshold,
+	}
+	if v := os.Getenv("ACCESS_LOG_SAMPLE"); v != "" {
+		if accessLogConfig.SampleRate, err = strconv.ParseFloat(v, 64); err != nil || accessLogConfig.SampleRate < 0 || accessLogConfig.SampleRate > 1 {
+			logger.Fatal("Invalid ACCESS_LOG_SAMPLE:", zap.Error(err))
+		}
+	}
+	if dsn := os.Getenv("SENTRY_DSN"); dsn != "" {
+		if errorReporter, err = api.NewErrorReporter(dsn, os.Getenv("SENTRY_RELEASE"), envOrDefault("SENTRY_ENVIRONMENT", os.Getenv("APP_ENV")), logger); err != nil {
+			logger.Fatal("Invalid SENTRY_DSN:", zap.Error(err))
+		}
+	}
+	timeouts.Read = envDuration("TIMEOUT_READ", timeouts.Read)
+	timeouts.Write = envDuration("TIMEOUT_WRITE", timeouts.Write)
+	timeouts.Watch = envDuration("TIMEOUT_WATCH", timeouts.Watch)
+	timeouts.Admin = envDuration("TIMEOUT_ADMIN", timeouts.Admin)
+	if timeouts.Routes, err = api.ParseRouteTimeouts(splitList(os.Getenv("TIMEOUT_ROUTES"))); err != nil {
+		logger.Fatal("Invalid TIMEOUT_ROUTES:", zap.Error(err))
+	}
+	if cachePolicies, err = api.ParseCachePolicies(splitList(os.Getenv("CACHE_CONTROL_POLICIES"))); err != nil {
+		logger.Fatal("Invalid CACHE_CONTROL_POLICIES:", zap.Error(err))
+	}
+
+	if prefixes := splitList(os.Getenv("SEARCH_INDEX_PREFIXES")); len(prefixes) > 0 {
+		valueIndex = api.NewValueIndex(etcdClient, logger, prefixes)
+	}
+	if prefixes := splitList(os.Getenv("CACHE_PREFIXES")); len(prefixes) > 0 {
+		keyspaceCache = api.NewKeyspaceCache(etcdClient, logger, prefixes)
+	}
+	if size, _ := strconv.Atoi(os.Getenv("VALUE_CACHE_SIZE")); size > 0 {
+		ttl := 30 * time.Second
+		if v := os.Getenv("VALUE_CACHE_TTL"); v != "" {
+			if ttl, err = time.ParseDuration(v); err != nil {
+				logger.Fatal("Invalid VALUE_CACHE_TTL:", zap.Error(err))
+			}
+		}
+		valueCache = api.NewValueCache(etcdClient, logger, size, ttl)
+	}
+	if v := os.Getenv("KEYSPACE_SAMPLE_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			logger.Fatal("Invalid KEYSPACE_SAMPLE_INTERVAL:", zap.Error(err))
+		}
+		depth := 2
+		if d, _ := strconv.Atoi(os.Getenv("KEYSPACE_SAMPLE_DEPTH")); d > 0 {
+			depth = d
+		}
+		growthSampler = api.NewGrowthSampler(etcdClient, logger, interval, depth)
+	}
+	var trashRetention, auditRetention time.Duration
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		if trashRetention, err = time.ParseDuration(v); err != nil || trashRetention <= 0 {
+			logger.Fatal("Invalid TRASH_RETENTION:", zap.Error(err))
+		}
+		trash = api.NewTrash(etcdClient, logger, trashRetention)
+	}
+	if v := os.Getenv("AUDIT_RETENTION"); v != "" {
+		if auditRetention, err = time.ParseDuration(v); err != nil || auditRetention <= 0 {
+			logger.Fatal("Invalid AUDIT_RETENTION:", zap.Error(err))
+		}
+		auditLog = api.NewAuditLog(etcdClient, logger, auditRetention)
+	}
+	if identityTrust.Proxies, err = api.ParseTrustedProxies(splitList(os.Getenv("TRUSTED_PROXIES"))); err != nil {
+		logger.Fatal("Invalid TRUSTED_PROXIES:", zap.Error(err))
+	}
+	identityTrust.Secret = os.Getenv("PROXY_SECRET")
+	approvers := splitList(os.Getenv("PROPOSAL_APPROVERS"))
+	if len(approvers) > 0 {
+		if len(identityTrust.Proxies) == 0 && identityTrust.Secret == "" {
+			logger.Warn("PROPOSAL_APPROVERS is set without TRUSTED_PROXIES or PROXY_SECRET, so proposals cannot be reviewed")
+		}
+		proposals = api.NewProposals(etcdClient, auditLog, logger, approvers, identityTrust)
+	}
+	if entries := splitList(os.Getenv("ENVIRONMENTS")); len(entries) > 0 {
+		if environments, err = api.ParseEnvironments(entries); err != nil {
+			logger.Fatal("Invalid ENVIRONMENTS:", zap.Error(err))
+		}
+		for _, env := range environments {
+			client, err := env.Client(etcdClient, etcdTuning)
+			if err != nil {
+				logger.Fatal("Cannot connect to environment "+env.Name+":", zap.Error(err))
+			}
+			clock := revisionClock
+			if len(env.Endpoints) > 0 {
+				// Environments sharing etcdClient inherit its guards.
+				guardClient(client)
+				clock = api.NewRevisionClock(client, logger)
+			}
+			environmentDeps[env.Name] = newClientDeps(client, clock, trashRetention, auditRetention, approvers)
+		}
+	}
+	if entries := splitList(os.Getenv("CLUSTERS")); len(entries) > 0 {
+		if clusters, err = api.ParseClusters(entries); err != nil {
+			logger.Fatal("Invalid CLUSTERS:", zap.Error(err))
+		}
+		for _, cluster := range clusters {
+			client, err := cluster.Client(etcdTuning)
+			if err != nil {
+				logger.Fatal("Cannot connect to cluster "+cluster.Name+":", zap.Error(err))
+			}
+			guardClient(client)
+			clusterDeps[cluster.Name] = newClientDeps(client, api.NewRevisionClock(client, logger), trashRetention, auditRetention, approvers)
+		}
+	}
+	if v := os.Getenv("CLUSTER_REGISTRY_KEY"); v != "" {
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			logger.Fatal("Invalid CLUSTER_REGISTRY_KEY:", zap.Error(err))
+		}
+		serve := func(ctx context.Context, cluster api.Cluster) (api.EnvironmentStore, http.Handler, error) {
+			client, err := cluster.Client(etcdTuning)
+			if err != nil {
+				return api.EnvironmentStore{}, nil, err
+			}
+			guardClient(client)
+			deps := newClientDeps(client, api.NewRevisionClock(client, logger), trashRetention, auditRetention, approvers)
+			runClientDeps(ctx, deps)
+			go func() {
+				<-ctx.Done()
+				client.Close()
+			}()
+			return api.EnvironmentStore{Client: client, Audit: deps.audit}, clusterEngine(deps), nil
+		}
+		if clusterRegistry, err = api.NewClusterRegistry(etcdClient, key, clusterHandlers, serve, logger); err != nil {
+			logger.Fatal("Invalid CLUSTER_REGISTRY_KEY:", zap.Error(err))
+		}
+	}
+	rewriter = api.NewRewriter(etcdClient, auditLog, logger)
+	jobs = api.NewJobs(etcdClient, logger)
+	if bucket := os.Getenv("EXPORT_S3_BUCKET"); bucket != "" {
+		artifacts = api.NewS3Artifacts(api.S3Config{
+			Bucket:       bucket,
+			Region:       envOrDefault("EXPORT_S3_REGION", "us-east-1"),
+			Endpoint:     os.Getenv("EXPORT_S3_ENDPOINT"),
+			Prefix:       os.Getenv("EXPORT_S3_PREFIX"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		})
+	} else {
+		dir := envOrDefault("EXPORT_DIR", filepath.Join(os.TempDir(), "etcd-gateway-exports"))
+		if artifacts, err = api.NewDiskArtifacts(dir); err != nil {
+			logger.Fatal("Cannot create EXPORT_DIR:", zap.Error(err))
+		}
+	}
+
+	if repo := os.Getenv("GITOPS_REPO"); repo != "" {
+		cfg := api.GitOpsConfig{
+			Repo:     repo,
+			Branch:   envOrDefault("GITOPS_BRANCH", "main"),
+			Path:     os.Getenv("GITOPS_PATH"),
+			Prefix:   os.Getenv("GITOPS_PREFIX"),
+			Dir:      envOrDefault("GITOPS_DIR", filepath.Join(os.TempDir(), "etcd-gateway-gitops")),
+			Interval: time.Minute,
+			Prune:    os.Getenv("GITOPS_PRUNE") == "true",
+		}
+		if !strings.HasPrefix(cfg.Prefix, "/") || !strings.HasSuffix(cfg.Prefix, "/") {
+			logger.Fatal("GITOPS_PREFIX must start and end with /")
+		}
+		if v := os.Getenv("GITOPS_INTERVAL"); v != "" {
+			if cfg.Interval, err = time.ParseDuration(v); err != nil || cfg.Interval <= 0 {
+				logger.Fatal("Invalid GITOPS_INTERVAL:", zap.Error(err))
+			}
+		}
+		gitopsSyncer = api.NewGitOpsSyncer(etcdClient, auditLog, logger, cfg)
+	}
+	if repo := os.Getenv("GIT_EXPORT_REPO"); repo != "" {
+		cfg := api.GitExportConfig{
+			Repo:     repo,
+			Branch:   envOrDefault("GIT_EXPORT_BRANCH", "main"),
+			Dir:      envOrDefault("GIT_EXPORT_DIR", filepath.Join(os.TempDir(), "etcd-gateway-export")),
+			Prefixes: splitList(os.Getenv("GIT_EXPORT_PREFIXES")),
+		}
+		for _, prefix := range cfg.Prefixes {
+			if !strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") {
+				logger.Fatal("GIT_EXPORT_PREFIXES entries must start and end with /")
+			}
+		}
+		if len(cfg.Prefixes) == 0 {
+			logger.Fatal("GIT_EXPORT_PREFIXES is required with GIT_EXPORT_REPO")
+		}
+		gitExporter = api.NewGitExporter(etcdClient, auditLog, logger, cfg)
+	}
+	if entries := splitList(os.Getenv("K8S_CONFIGMAP_SYNC")); len(entries) > 0 {
+		mappings, err := api.ParseKubeMappings(entries)
+		if err != nil {
+			logger.Fatal("Invalid K8S_CONFIGMAP_SYNC:", zap.Error(err))
+		}
+		configMapSync, err = api.NewConfigMapSyncer(etcdClient, auditLog, logger, mappings,
+			os.Getenv("K8S_CONFIGMAP_REVERSE") == "true", kubeSyncInterval())
+		if err != nil {
+			logger.Fatal("Cannot configure ConfigMap sync:", zap.Error(err))
+		}
+	}
+	if entries := splitList(os.Getenv("K8S_SECRET_SYNC")); len(entries) > 0 {
+		mappings, err := api.ParseKubeMappings(entries)
+		if err != nil {
+			logger.Fatal("Invalid K8S_SECRET_SYNC:", zap.Error(err))
+		}
+		secretSync, err = api.NewSecretSyncer(etcdClient, auditLog, logger, mappings,
+			os.Getenv("K8S_SECRET_REVERSE") == "true", kubeSyncInterval())
+		if err != nil {
+			logger.Fatal("Cannot configure Secret sync:", zap.Error(err))
+		}
+	}
+	if entries := splitList(os.Getenv("SLACK_WEBHOOKS")); len(entries) > 0 {
+		throttle, err := time.ParseDuration(envOrDefault("SLACK_THROTTLE", "1m"))
+		if err != nil || throttle < 0 {
+			logger.Fatal("Invalid SLACK_THROTTLE:", zap.Error(err))
+		}
+		targets, err := api.ParseSlackTargets(entries, throttle)
+		if err != nil {
+			logger.Fatal("Invalid SLACK_WEBHOOKS:", zap.Error(err))
+		}
+		slackNotifier = api.NewSlackNotifier(etcdClient, auditLog, logger, targets)
+	}
+	if prefixes := splitList(os.Getenv("EXPIRY_PREFIXES")); len(prefixes) > 0 {
+		warn, err := time.ParseDuration(envOrDefault("EXPIRY_WARN", "30s"))
+		if err != nil || warn <= 0 {
+			logger.Fatal("Invalid EXPIRY_WARN:", zap.Error(err))
+		}
+		if expiry, err = api.NewExpiryNotifier(etcdClient, logger, prefixes, warn, splitList(os.Getenv("EXPIRY_WEBHOOKS"))); err != nil {
+			logger.Fatal("Invalid EXPIRY_WEBHOOKS:", zap.Error(err))
+		}
+	}
+	if prefixes := splitList(os.Getenv("MANAGED_LEASE_PREFIXES")); len(prefixes) > 0 || os.Getenv("MANAGED_LEASES") == "true" {
+		leaseKeeper = api.NewLeaseKeeper(etcdClient, logger, prefixes)
+	}
+	if entries := splitList(os.Getenv("EMAIL_NOTIFY")); len(entries) > 0 {
+		targets, err := api.ParseEmailTargets(entries)
+		if err != nil {
+			logger.Fatal("Invalid EMAIL_NOTIFY:", zap.Error(err))
+		}
+		cfg := api.SMTPConfig{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			From:     os.Getenv("SMTP_FROM"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+		if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+			logger.Fatal("SMTP_ADDR must be host:port with EMAIL_NOTIFY")
+		}
+		if cfg.From == "" {
+			logger.Fatal("SMTP_FROM is required with EMAIL_NOTIFY")
+		}
+		emailNotifier = api.NewEmailNotifier(etcdClient, auditLog, logger, cfg, targets)
+	}
+	source, _ := os.Hostname()
+	var alerters []api.Alerter
+	if key := os.Getenv("ALERT_PAGERDUTY_ROUTING_KEY"); key != "" {
+		alerters = append(alerters, api.NewPagerDutyAlerter(key, source))
+	}
+	if key := os.Getenv("ALERT_OPSGENIE_API_KEY"); key != "" {
+		alerters = append(alerters, api.NewOpsgenieAlerter(envOrDefault("ALERT_OPSGENIE_URL", "https://api.opsgenie.com"), key, source))
+	}
+	if len(alerters) > 0 {
+		interval, err := time.ParseDuration(envOrDefault("ALERT_INTERVAL", "30s"))
+		if err != nil || interval <= 0 {
+			logger.Fatal("Invalid ALERT_INTERVAL:", zap.Error(err))
+		}
+		threshold, err := strconv.Atoi(envOrDefault("ALERT_FAILURE_THRESHOLD", "3"))
+		if err != nil || threshold <= 0 {
+			logger.Fatal("Invalid ALERT_FAILURE_THRESHOLD:", zap.Error(err))
+		}
+		alarmMonitor = api.NewAlarmMonitor(etcdClient, logger, alerters, interval, threshold)
+	}
+	var tasks []api.ScheduledTask
+	if interval, ok := scheduleInterval("SCHEDULE_COMPACTION"); ok {
+		policy := api.CompactionPolicy{Mode: envOrDefault("SCHEDULE_COMPACTION_MODE", api.CompactionRevision)}
+		switch policy.Mode {
+		case api.CompactionRevision:
+			retain, err := strconv.ParseInt(envOrDefault("SCHEDULE_COMPACTION_RETAIN", "10000"), 10, 64)
+			if err != nil || retain <= 0 {
+				logger.Fatal("Invalid SCHEDULE_COMPACTION_RETAIN:", zap.Error(err))
+			}
+			policy.Retain = retain
+		case api.CompactionPeriodic:
+			retention, err := time.ParseDuration(envOrDefault("SCHEDULE_COMPACTION_RETENTION", "1h"))
+			if err != nil || retention <= 0 {
+				logger.Fatal("Invalid SCHEDULE_COMPACTION_RETENTION:", zap.Error(err))
+			}
+			policy.Retention = retention
+		default:
+			logger.Fatal("Invalid SCHEDULE_COMPACTION_MODE: expected revision or periodic")
+		}
+		tasks = append(tasks, api.CompactionTask(etcdClient, logger, interval, policy))
+	}
+	if interval, ok := scheduleInterval("SCHEDULE_DEFRAG"); ok {
+		tasks = append(tasks, api.DefragTask(etcdClient, jobs, interval))
+	}
+	if interval, ok := scheduleInterval("SCHEDULE_BACKUP"); ok {
+		dir := os.Getenv("SCHEDULE_BACKUP_DIR")
+		if dir == "" {
+			logger.Fatal("SCHEDULE_BACKUP_DIR is required with SCHEDULE_BACKUP")
+		}
+		keep, err := strconv.Atoi(envOrDefault("SCHEDULE_BACKUP_KEEP", "7"))
+		if err != nil || keep <= 0 {
+			logger.Fatal("Invalid SCHEDULE_BACKUP_KEEP:", zap.Error(err))
+		}
+		daily, err := strconv.Atoi(envOrDefault("SCHEDULE_BACKUP_KEEP_DAILY", "0"))
+		if err != nil || daily < 0 {
+			logger.Fatal("Invalid SCHEDULE_BACKUP_KEEP_DAILY:", zap.Error(err))
+		}
+		weekly, err := strconv.Atoi(envOrDefault("SCHEDULE_BACKUP_KEEP_WEEKLY", "0"))
+		if err != nil || weekly < 0 {
+			logger.Fatal("Invalid SCHEDULE_BACKUP_KEEP_WEEKLY:", zap.Error(err))
+		}
+		retention := api.BackupRetention{Last: keep, Daily: daily, Weekly: weekly}
+		backups = api.NewBackups(dir, retention, logger)
+		tasks = append(tasks, backups.Task(etcdClient, interval))
+	}
+	if interval, ok := scheduleInterval("SCHEDULE_TRASH_PURGE"); ok {
+		if trash == nil {
+			logger.Fatal("SCHEDULE_TRASH_PURGE requires TRASH_RETENTION")
+		}
+		tasks = append(tasks, api.TrashPurgeTask(trash, interval))
+	}
+	if interval, ok := scheduleInterval("SCHEDULE_LEASE_CLEANUP"); ok {
+		tasks = append(tasks, api.LeaseCleanupTask(etcdClient, logger, interval))
+	}
+	if len(tasks) > 0 {
+		scheduler = api.NewScheduler(etcdClient, logger, tasks)
+	}
+	if endpoints := splitList(os.Getenv("MIRROR_ENDPOINTS")); len(endpoints) > 0 {
+		prefixes := splitList(os.Getenv("MIRROR_PREFIXES"))
+		if len(prefixes) == 0 {
+			prefixes = []string{"/"}
+		}
+		mirror, err = api.NewMirror(etcdClient, logger, api.MirrorConfig{
+			Endpoints:  endpoints,
+			Username:   os.Getenv("MIRROR_USERNAME"),
+			Password:   os.Getenv("MIRROR_PASSWORD"),
+			Prefixes:   prefixes,
+			DestPrefix: os.Getenv("MIRROR_DEST_PREFIX"),
+			Tuning:     etcdTuning,
+		})
+		if err != nil {
+			logger.Fatal("Invalid MIRROR_ENDPOINTS:", zap.Error(err))
+		}
+	}
+}
+
+// scheduleInterval parses the interval of an optional maintenance task.
+func scheduleInterval(key string) (time.Duration, bool) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, false
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval <= 0 {
+		logger.Fatal("Invalid "+key+":", zap.Error(err))
+	}
+	return interval, true
+}
+
+// kubeSyncInterval is how often Kubernetes objects are polled for edits.
+func kubeSyncInterval() time.Duration {
+	interval, err := time.ParseDuration(envOrDefault("K8S_SYNC_INTERVAL", "30s"))
+	if err != nil || interval <= 0 {
+		logger.Fatal("Invalid K8S_SYNC_INTERVAL:", zap.Error(err))
+	}
+	return interval
+}
+
+// envDuration parses the environment variable key as a positive duration,
+// or returns def when it is unset.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		logger.Fatal("Invalid "+key+":", zap.Error(err))
+	}
+	return d
+}
+
+// envInt parses the environment variable key as a positive integer, or
+// returns def when it is unset.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		logger.Fatal("Invalid "+key+":", zap.String("value", v))
+	}
+	return n
+}
+
+// etcdTuningFromEnv reads the ETCD_* connection parameters, shared by every
+// client the gateway creates. Unset ones keep the etcd client's defaults.
+func etcdTuningFromEnv() api.EtcdTuning {
+	tuning := api.EtcdTuning{
+		DialTimeout:         envDuration("ETCD_DIAL_TIMEOUT", 5*time.Second),
+		PermitWithoutStream: os.Getenv("ETCD_KEEPALIVE_WITHOUT_STREAM") == "true",
+		MaxCallSendMsgSize:  envInt("ETCD_MAX_SEND_MSG_SIZE", 0),
+		MaxCallRecvMsgSize:  envInt("ETCD_MAX_RECV_MSG_SIZE", 0),
+		WindowSize:          int32(envInt("ETCD_WINDOW_SIZE", 0)),
+	}
+	if os.Getenv("ETCD_KEEPALIVE_TIME") != "" {
+		tuning.KeepAliveTime = envDuration("ETCD_KEEPALIVE_TIME", 0)
+		tuning.KeepAliveTimeout = envDuration("ETCD_KEEPALIVE_TIMEOUT", 20*time.Second)
+	}
+	if os.Getenv("ETCD_AUTO_SYNC_INTERVAL") != "" {
+		tuning.AutoSyncInterval = envDuration("ETCD_AUTO_SYNC_INTERVAL", 0)
+	}
+	if os.Getenv("ETCD_BACKOFF_MAX_DELAY") != "" {
+		tuning.BackoffMaxDelay = envDuration("ETCD_BACKOFF_MAX_DELAY", 0)
+	}
+	return tuning
+}
+
+// guardClient installs the KV guards on a client before anything uses it,
+// so no route, background component or protocol can bypass them. Denied
+// keys are refused first, and validation webhooks see values as mutated.
+// Injected faults, when enabled, go beneath them all, where etcd would be.
+func guardClient(client *clientv3.Client) {
+	if faults != nil {
+		faults.Install(client)
+	}
+	if len(validationWebhooks) > 0 {
+		client.KV = api.NewValidatingKV(client.KV, validationWebhooks, validationTimeout, os.Getenv("VALIDATION_FAIL_OPEN") == "true", logger)
+	}
+	if len(mutationRules) > 0 {
+		client.KV = api.NewMutatingKV(client.KV, mutationRules)
+	}
+	api.DenyPrefixes(client, deniedPrefixes)
+}
+
+// envOrDefault returns the environment variable key, or def when it is unset.
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// splitList parses a comma separated environment variable, ignoring empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// newClientDeps creates the components an environment or cluster keeps for
+// its own client, mirroring those enabled for the gateway's cluster.
+func newClientDeps(client *clientv3.Client, clock *api.RevisionClock, trashRetention, auditRetention time.Duration, approvers []string) apiDeps {
+	deps := apiDeps{client: client, clock: clock, jobs: api.NewJobs(client, logger)}
+	if trashRetention > 0 {
+		deps.trash = api.NewTrash(client, logger, trashRetention)
+	}
+	if auditRetention > 0 {
+		deps.audit = api.NewAuditLog(client, logger, auditRetention)
+	}
+	if len(approvers) > 0 {
+		deps.proposals = api.NewProposals(client, deps.audit, logger, approvers, identityTrust)
+	}
+	return deps
+}
+
+// runClientDeps starts the background work of components from newClientDeps.
+func runClientDeps(ctx context.Context, deps apiDeps) {
+	go deps.jobs.Run(ctx)
+	if deps.clock != revisionClock {
+		go deps.clock.Run(ctx)
+	}
+	if deps.trash != nil {
+		go deps.trash.Run(ctx)
+	}
+	if deps.audit != nil {
+		go deps.audit.Run(ctx)
 	}
 }
 
 func main() {
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go etcdMonitor.Run(bgCtx)
+	go revisionClock.Run(bgCtx)
+	go rewriter.Run(bgCtx)
+	go jobs.Run(bgCtx)
+	if gitopsSyncer != nil {
+		go gitopsSyncer.Run(bgCtx)
+	}
+	if gitExporter != nil {
+		go gitExporter.Run(bgCtx)
+	}
+	if configMapSync != nil {
+		go configMapSync.Run(bgCtx)
+	}
+	if secretSync != nil {
+		go secretSync.Run(bgCtx)
+	}
+	if errorReporter != nil {
+		go errorReporter.Run(bgCtx)
+	}
+	if slackNotifier != nil {
+		go slackNotifier.Run(bgCtx)
+	}
+	if expiry != nil {
+		go expiry.Run(bgCtx)
+	}
+	if leaseKeeper != nil {
+		go leaseKeeper.Run(bgCtx)
+	}
+	if emailNotifier != nil {
+		go emailNotifier.Run(bgCtx)
+	}
+	if alarmMonitor != nil {
+		go alarmMonitor.Run(bgCtx)
+	}
+	if mirror != nil {
+		go mirror.Run(bgCtx)
+	}
+	if scheduler != nil {
+		go scheduler.Run(bgCtx)
+	}
+	if valueIndex != nil {
+		go valueIndex.Run(bgCtx)
+	}
+	if keyspaceCache != nil {
+		go keyspaceCache.Run(bgCtx)
+	}
+	if valueCache != nil {
+		go valueCache.Run(bgCtx)
+	}
+	if growthSampler != nil {
+		go growthSampler.Run(bgCtx)
+	}
+	// With SCHEDULE_TRASH_PURGE the leading replica purges on that schedule
+	// instead.
+	if trash != nil && os.Getenv("SCHEDULE_TRASH_PURGE") == "" {
+		go trash.Run(bgCtx)
+	}
+	if auditLog != nil {
+		go auditLog.Run(bgCtx)
+	}
+	for _, deps := range environmentDeps {
+		runClientDeps(bgCtx, deps)
+	}
+	for _, deps := range clusterDeps {
+		runClientDeps(bgCtx, deps)
+	}
+
 	// Create a new router
 	router := gin.New()
 
 	// Middlewares
+	router.Use(api.AccessLogMiddleware(accessLogger, accessLogConfig))
 	router.Use(gin.Recovery())
-	router.Use(gin.Logger())
-	router.Use(ZapLoggingMiddleware(logger))
+	router.Use(api.ErrorReportingMiddleware(errorReporter))
+	if slowRequestThreshold > 0 {
+		router.Use(api.SlowRequestMiddleware(slowRequestThreshold, logger))
+	}
+	router.Use(api.CompressionMiddleware("/api/v1/jobs/:id/artifact"))
+	router.Use(api.TimeoutMiddleware(timeouts))
+	router.Use(api.KVGuardMiddleware())
+	router.Use(identityTrust.Middleware())
+	if len(cachePolicies) > 0 {
+		router.Use(api.CacheControlMiddleware(cachePolicies))
+	}
 
 	if os.Getenv("APP_ENV") == "production" {
 		router.Use(corsMiddlewareForProduction())
@@ -58,6 +716,10 @@ func main() {
 	}
 
 	setupRoutes(router, logger)
+	// Registered clusters yield to those from CLUSTERS, added by setupRoutes.
+	if clusterRegistry != nil {
+		go clusterRegistry.Run(bgCtx)
+	}
 
 	srv := &http.Server{
 		Addr:    ":8080",
@@ -70,6 +732,62 @@ func main() {
 		}
 	}()
 
+	// The gRPC API is only served when asked for, to callers the fronting
+	// proxy authenticated as for the HTTP API.
+	var grpcServer *grpc.Server
+	if grpcAddr := os.Getenv("GRPC_ADDR"); grpcAddr != "" {
+		if len(identityTrust.Proxies) == 0 && identityTrust.Secret == "" {
+			logger.Fatal("GRPC_ADDR requires TRUSTED_PROXIES or PROXY_SECRET")
+		}
+		grpcServer = grpc.NewServer(
+			grpc.ChainUnaryInterceptor(ZapUnaryInterceptor(logger), identityTrust.UnaryInterceptor),
+			grpc.ChainStreamInterceptor(ZapStreamInterceptor(logger), identityTrust.StreamInterceptor),
+		)
+		gatewaypb.RegisterGatewayServer(grpcServer, api.NewGRPCServer(etcdClient, auditLog, timeouts, logger))
+
+		go func() {
+			lis, err := net.Listen("tcp", grpcAddr)
+			if err != nil {
+				logger.Fatal("grpc listen:", zap.Error(err))
+			}
+			if err := grpcServer.Serve(lis); err != nil {
+				logger.Fatal("grpc serve:", zap.Error(err))
+			}
+		}()
+	}
+
+	// The native etcd API is served on its own port, since etcd clients
+	// cannot share one with the gateway's gRPC service.
+	var proxyServer *grpc.Server
+	if proxyAddr := os.Getenv("ETCD_PROXY_ADDR"); proxyAddr != "" {
+		// Native clients bypass the fronting proxy, so they authenticate to
+		// the gateway itself.
+		tokens, err := api.ParseProxyTokens(splitList(os.Getenv("ETCD_PROXY_TOKENS")))
+		if err != nil {
+			logger.Fatal("Invalid ETCD_PROXY_TOKENS:", zap.Error(err))
+		}
+		if len(tokens) == 0 {
+			logger.Fatal("ETCD_PROXY_ADDR requires ETCD_PROXY_TOKENS")
+		}
+		rate := envInt("ETCD_PROXY_RATE_LIMIT", 0)
+		auth := api.NewProxyAuth(tokens, rate, envInt("ETCD_PROXY_RATE_BURST", rate))
+		proxyServer = grpc.NewServer(
+			grpc.ChainUnaryInterceptor(ZapUnaryInterceptor(logger), auth.UnaryInterceptor),
+			grpc.ChainStreamInterceptor(ZapStreamInterceptor(logger), auth.StreamInterceptor),
+		)
+		api.NewEtcdProxy(etcdClient, auditLog, timeouts, logger).Register(proxyServer)
+
+		go func() {
+			lis, err := net.Listen("tcp", proxyAddr)
+			if err != nil {
+				logger.Fatal("etcd proxy listen:", zap.Error(err))
+			}
+			if err := proxyServer.Serve(lis); err != nil {
+				logger.Fatal("etcd proxy serve:", zap.Error(err))
+			}
+		}()
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -77,6 +795,13 @@ func main() {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	if proxyServer != nil {
+		// Watches and keep-alives never end by themselves.
+		proxyServer.Stop()
+	}
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Fatal("Server forced to shutdown:", zap.Error(err))
 	}
@@ -84,19 +809,6 @@ func main() {
 	logger.Info("Server exiting")
 }
 
-func setupRoutes(router *gin.Engine, logger *zap.Logger) {
-	router.GET("/health", healthCheckHandler)
-	router.GET("/api/keys", api.FetchKeysHandler(etcdClient))
-	router.GET("/api/value/*key", api.FetchValueForKeyHandler(etcdClient, logger))
-
-	if os.Getenv("APP_ENV") != "production" {
-		router.GET("/", func(c *gin.Context) {
-			c.String(http.StatusOK, "Development root endpoint.")
-		})
-	}
-
-}
-
 func healthCheckHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status": "healthy",
@@ -124,18 +836,30 @@ func corsMiddlewareForProduction() gin.HandlerFunc {
 	})
 }
 
-func ZapLoggingMiddleware(logger *zap.Logger) gin.HandlerFunc {
-	return func(c *gin.Context) {
+// ZapUnaryInterceptor logs completed unary calls.
+func ZapUnaryInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		t := time.Now()
+		resp, err := handler(ctx, req)
+		logger.Info("RPC completed",
+			zap.String("method", info.FullMethod),
+			zap.Duration("latency", time.Since(t)),
+			zap.String("code", status.Code(err).String()),
+		)
+		return resp, err
+	}
+}
 
-		c.Next()
-
-		latency := time.Since(t)
-		logger.Info("Request completed",
-			zap.String("method", c.Request.Method),
-			zap.String("path", c.Request.URL.Path),
-			zap.Duration("latency", latency),
-			zap.Int("status", c.Writer.Status()),
+// ZapStreamInterceptor logs completed streaming calls.
+func ZapStreamInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		t := time.Now()
+		err := handler(srv, ss)
+		logger.Info("RPC completed",
+			zap.String("method", info.FullMethod),
+			zap.Duration("latency", time.Since(t)),
+			zap.String("code", status.Code(err).String()),
 		)
+		return err
 	}
 }
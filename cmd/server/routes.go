@@ -0,0 +1,240 @@
+package main
+
+import (
+	"etcd-gateway/internal/api"
+	"expvar"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// legacyAPISunset is when the unversioned /api aliases are scheduled for removal.
+const legacyAPISunset = "Wed, 30 Jun 2027 00:00:00 GMT"
+
+func setupRoutes(router *gin.Engine, logger *zap.Logger) {
+	schema, err := api.NewGraphQLSchema(etcdClient, timeouts, logger)
+	if err != nil {
+		logger.Fatal("Cannot build GraphQL schema:", zap.Error(err))
+	}
+
+	router.GET("/health", healthCheckHandler)
+	router.GET("/ready", api.ReadinessHandler(etcdMonitor))
+
+	// Versioned REST API. A future v2 gets its own group and setup function.
+	envStores := environmentStores()
+	defaults := apiDeps{
+		client:        etcdClient,
+		clock:         revisionClock,
+		keyspaceCache: keyspaceCache,
+		valueCache:    valueCache,
+		valueIndex:    valueIndex,
+		trash:         trash,
+		audit:         auditLog,
+		proposals:     proposals,
+		jobs:          jobs,
+		environments:  envStores,
+	}
+	envHandlers := make(map[string]http.Handler, len(environments))
+	for _, env := range environments {
+		deps := environmentDeps[env.Name]
+		deps.environments = envStores
+		engine := gin.New()
+		engine.Use(api.TimeoutMiddleware(timeouts), identityTrust.Middleware())
+		setupAPIv1Routes(engine.Group("/api/v1"), deps, logger)
+		envHandlers[env.Name] = engine
+	}
+	for _, cluster := range clusters {
+		deps := clusterDeps[cluster.Name]
+		clusterHandlers.Add(cluster, api.EnvironmentStore{Client: deps.client, Audit: deps.audit}, clusterEngine(deps))
+	}
+	setupAPIv1Routes(router.Group("/api/v1", api.ClusterMiddleware(clusterHandlers), api.EnvironmentMiddleware(envHandlers)), defaults, logger)
+	router.Any("/api/v1/env/:env/*path", api.EnvironmentPathHandler(envHandlers))
+	router.GET("/api/v1/environments", api.EnvironmentsHandler(environments))
+	router.Any("/api/v1/clusters/:cluster/*path", api.ClusterPathHandler(clusterHandlers))
+	router.GET("/api/v1/clusters", api.ClustersHandler(clusterHandlers))
+	router.POST("/api/v1/promote", api.PromoteHandler(api.EnvironmentStore{Client: etcdClient, Audit: auditLog}, envStores, clusterHandlers, logger))
+	setupLegacyAPIRoutes(router.Group("/api", deprecatedAPIMiddleware("/api", "/api/v1")), logger)
+	setupAdminRoutes(router.Group("/admin"), logger)
+
+	// Consul KV compatibility layer
+	router.GET("/v1/kv/*key", api.ConsulGetHandler(etcdClient, logger))
+	router.PUT("/v1/kv/*key", api.ConsulPutHandler(etcdClient, auditLog, logger))
+	router.DELETE("/v1/kv/*key", api.ConsulDeleteHandler(etcdClient, auditLog, logger))
+
+	// etcd v2 API compatibility shim
+	router.GET("/v2/keys/*key", api.V2GetHandler(etcdClient, logger))
+	router.PUT("/v2/keys/*key", api.V2PutHandler(etcdClient, auditLog, logger))
+	router.DELETE("/v2/keys/*key", api.V2DeleteHandler(etcdClient, auditLog, logger))
+
+	router.GET("/graphql", api.GraphQLHandler(schema, logger))
+	router.POST("/graphql", api.GraphQLHandler(schema, logger))
+
+	router.GET("/openapi.json", api.OpenAPIHandler())
+	router.GET("/docs", api.SwaggerUIHandler())
+	router.GET("/ui/*path", api.UIHandler())
+
+	if os.Getenv("APP_ENV") != "production" {
+		router.GET("/", func(c *gin.Context) {
+			c.String(http.StatusOK, "Development root endpoint.")
+		})
+	}
+
+}
+
+// apiDeps are the stores one instance of the v1 API is served from. Each
+// environment gets its own; optional caches and indexes stay nil outside the
+// default keyspace.
+type apiDeps struct {
+	client        *clientv3.Client
+	clock         *api.RevisionClock
+	keyspaceCache *api.KeyspaceCache
+	valueCache    *api.ValueCache
+	valueIndex    *api.ValueIndex
+	trash         *api.Trash
+	audit         *api.AuditLog
+	proposals     *api.Proposals
+	jobs          *api.Jobs
+	environments  map[string]api.EnvironmentStore
+}
+
+// setupAPIv1Routes registers the v1 REST API on group.
+func setupAPIv1Routes(group *gin.RouterGroup, d apiDeps, logger *zap.Logger) {
+	group.GET("/keys", api.FetchKeysHandler(d.client, d.keyspaceCache, logger))
+	group.GET("/value/*key", api.RecentKeysMiddleware(d.client, identityTrust, logger), api.FetchValueForKeyHandler(d.client, d.valueCache, d.keyspaceCache, d.clock, logger))
+	group.HEAD("/value/*key", api.ValueHeadHandler(d.client, d.keyspaceCache, d.clock, logger))
+	group.DELETE("/value/*key", api.DeleteValueHandler(d.client, d.trash, d.audit, logger))
+	group.GET("/meta/*key", api.FetchMetaHandler(d.client, d.clock, logger))
+	group.GET("/children/*prefix", api.FetchChildrenHandler(d.client, d.keyspaceCache, logger))
+	group.GET("/count", api.CountKeysHandler(d.client, logger))
+	group.GET("/range", api.RangeHandler(d.client, d.clock, logger))
+	group.POST("/batch/get", api.BatchGetHandler(d.client, d.clock, logger))
+	group.POST("/batch/put", api.BatchPutHandler(d.client, d.audit, logger))
+	group.POST("/batch/delete", api.BatchDeleteHandler(d.client, d.trash, d.audit, logger))
+	group.GET("/search", api.SearchHandler(d.client, d.keyspaceCache, d.clock, logger))
+	group.GET("/search/values", api.ValueSearchHandler(d.valueIndex))
+	group.GET("/changes", api.ChangesHandler(d.client, d.clock, logger))
+	group.GET("/stats", api.StatsHandler(d.client, logger))
+	group.GET("/dashboard", api.DashboardHandler(d.client, d.clock, logger))
+	group.GET("/backups", api.BackupListHandler(backups, logger))
+	group.GET("/mirror", api.MirrorStatusHandler(mirror, logger))
+	group.POST("/mirror/resync", api.MirrorResyncHandler(mirror, logger))
+	group.GET("/trash", api.TrashListHandler(d.trash, logger))
+	group.POST("/trash/restore", api.TrashRestoreHandler(d.trash, d.audit, logger))
+	group.GET("/audit", api.AuditListHandler(d.audit, logger))
+	group.POST("/undo/:id", api.UndoHandler(d.audit, logger))
+	group.POST("/copy", api.CopyHandler(d.client, d.audit, logger))
+	group.POST("/move", api.MoveHandler(d.client, d.audit, logger))
+	group.GET("/render/*key", api.RenderHandler(d.client, logger))
+	group.GET("/diff/prefixes", api.PrefixDiffHandler(d.client, d.environments, clusterHandlers, logger))
+	group.POST("/plan", api.PlanHandler(d.client, logger))
+	group.POST("/apply/:id", api.ApplyPlanHandler(d.client, d.audit, logger))
+	group.GET("/annotations", api.AnnotationSearchHandler(d.client, logger))
+	group.GET("/annotations/*key", api.AnnotationGetHandler(d.client, logger))
+	group.PUT("/annotations/*key", api.AnnotationPutHandler(d.client, logger))
+	group.DELETE("/annotations/*key", api.AnnotationDeleteHandler(d.client, logger))
+	group.GET("/me/bookmarks", api.BookmarkListHandler(d.client, identityTrust, logger))
+	group.PUT("/me/bookmarks/*key", api.BookmarkPutHandler(d.client, identityTrust, logger))
+	group.DELETE("/me/bookmarks/*key", api.BookmarkDeleteHandler(d.client, identityTrust, logger))
+	group.GET("/me/recent", api.RecentKeysHandler(d.client, identityTrust, logger))
+	group.DELETE("/me/recent", api.RecentKeysClearHandler(d.client, identityTrust, logger))
+	group.GET("/edit-lock/*key", api.EditLockHandler(d.client, logger))
+	group.POST("/edit-lock/*key", api.EditLockAcquireHandler(d.client, logger))
+	group.PUT("/edit-lock/*key", api.EditLockHeartbeatHandler(d.client, logger))
+	group.DELETE("/edit-lock/*key", api.EditLockReleaseHandler(d.client, logger))
+	group.POST("/leases", api.LeaseGrantHandler(d.client, d.audit, logger))
+	group.GET("/leases/expiry", api.ExpiryEventsHandler(expiry))
+	group.GET("/leases/overview", api.LeaseOverviewHandler(d.client, logger))
+	group.GET("/leases/managed", api.ManagedLeasesHandler(leaseKeeper, logger))
+	group.GET("/leases/:id", api.LeaseHandler(d.client, logger))
+	group.POST("/leases/:id/keepalive", api.LeaseKeepAliveHandler(d.client, logger))
+	group.DELETE("/leases/:id", api.LeaseRevokeHandler(d.client, logger))
+	group.PUT("/leases/:id/managed", api.ManagedLeaseMarkHandler(leaseKeeper, logger))
+	group.DELETE("/leases/:id/managed", api.ManagedLeaseUnmarkHandler(leaseKeeper, logger))
+	group.GET("/tags", api.TagListHandler(d.client, logger))
+	group.POST("/tags", api.TagCreateHandler(d.client, logger))
+	group.DELETE("/tags/:name", api.TagDeleteHandler(d.client, logger))
+	group.GET("/tags/:name/values", api.TagValuesHandler(d.client, logger))
+	group.GET("/tags/:name/diff", api.TagDiffHandler(d.client, logger))
+	group.POST("/tags/:name/rollback", api.TagRollbackHandler(d.client, d.audit, logger))
+	group.GET("/flags", api.FlagListHandler(d.client, logger))
+	group.POST("/flags/evaluate", api.FlagEvaluateHandler(d.client, logger))
+	group.GET("/flags/:name", api.FlagGetHandler(d.client, logger))
+	group.PUT("/flags/:name", api.FlagPutHandler(d.client, d.audit, logger))
+	group.DELETE("/flags/:name", api.FlagDeleteHandler(d.client, d.audit, logger))
+	group.GET("/proposals", api.ProposalListHandler(d.proposals, logger))
+	group.POST("/proposals", api.ProposalSubmitHandler(d.proposals, logger))
+	group.GET("/proposals/:id", api.ProposalHandler(d.proposals, logger))
+	group.POST("/proposals/:id/approve", api.ProposalReviewHandler(d.proposals, true, logger))
+	group.POST("/proposals/:id/reject", api.ProposalReviewHandler(d.proposals, false, logger))
+	group.POST("/proposals/:id/apply", api.ProposalApplyHandler(d.proposals, logger))
+	group.GET("/jobs", api.JobListHandler(d.jobs, logger))
+	group.GET("/jobs/:id", api.JobHandler(d.jobs, logger))
+	group.POST("/jobs/:id/cancel", api.JobCancelHandler(d.jobs, logger))
+	group.GET("/jobs/:id/artifact", api.JobArtifactHandler(d.jobs, artifacts, logger))
+	group.POST("/jobs/consul-import", api.ConsulImportJobHandler(d.client, d.jobs, d.audit, logger))
+	group.POST("/jobs/export", api.ExportJobHandler(d.client, d.jobs, artifacts, logger))
+	group.POST("/jobs/import", api.ImportJobHandler(d.client, d.jobs, d.audit, logger))
+	group.POST("/jobs/restore", api.RestoreJobHandler(d.client, d.jobs, d.audit, logger))
+	group.POST("/jobs/zookeeper-import", api.ZooKeeperImportJobHandler(d.client, d.jobs, d.audit, logger))
+}
+
+// setupLegacyAPIRoutes keeps the original unversioned paths working as
+// aliases of their v1 equivalents. New endpoints are only added to v1.
+func setupLegacyAPIRoutes(group *gin.RouterGroup, logger *zap.Logger) {
+	group.GET("/keys", api.FetchKeysHandler(etcdClient, keyspaceCache, logger))
+	group.GET("/value/*key", api.FetchValueForKeyHandler(etcdClient, valueCache, keyspaceCache, revisionClock, logger))
+}
+
+// setupAdminRoutes registers operator-facing reports and tools on group.
+func setupAdminRoutes(group *gin.RouterGroup, logger *zap.Logger) {
+	group.GET("/reports/keyspace", api.KeyspaceReportHandler(growthSampler))
+	group.GET("/rewrites", api.RewriteListHandler(rewriter, logger))
+	group.POST("/rewrites", api.RewriteHandler(rewriter, logger))
+	group.GET("/rewrites/:id", api.RewriteJobHandler(rewriter, false, logger))
+	group.POST("/rewrites/:id/resume", api.RewriteJobHandler(rewriter, true, logger))
+	group.GET("/gitops", api.GitOpsStatusHandler(gitopsSyncer))
+	group.POST("/gitops/sync", api.GitOpsSyncHandler(gitopsSyncer))
+	group.PUT("/clusters/:name", api.ClusterPutHandler(clusterRegistry, logger))
+	group.DELETE("/clusters/:name", api.ClusterDeleteHandler(clusterRegistry, logger))
+	group.GET("/nospace", api.NoSpaceHandler(etcdClient, logger))
+	group.POST("/nospace/:step", api.NoSpaceStepHandler(etcdClient, logger))
+	group.GET("/loglevel", api.LogLevelHandler(logLevel))
+	group.PUT("/loglevel", api.LogLevelPutHandler(logLevel, logger))
+	group.GET("/faults", api.FaultsHandler(faults))
+	group.PUT("/faults", api.FaultsPutHandler(faults, logger))
+	group.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+}
+
+// environmentStores returns the client and audit log of each environment.
+func environmentStores() map[string]api.EnvironmentStore {
+	stores := make(map[string]api.EnvironmentStore, len(environments))
+	for _, env := range environments {
+		deps := environmentDeps[env.Name]
+		stores[env.Name] = api.EnvironmentStore{Client: deps.client, Audit: deps.audit}
+	}
+	return stores
+}
+
+// clusterEngine serves the v1 routes for a cluster other than the gateway's.
+func clusterEngine(deps apiDeps) http.Handler {
+	deps.environments = environmentStores()
+	engine := gin.New()
+	engine.Use(api.TimeoutMiddleware(timeouts), identityTrust.Middleware())
+	setupAPIv1Routes(engine.Group("/api/v1"), deps, logger)
+	return engine
+}
+
+// deprecatedAPIMiddleware marks responses served from a deprecated path prefix
+// and points clients at the equivalent path under successor.
+func deprecatedAPIMiddleware(prefix, successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Sunset", legacyAPISunset)
+		c.Header("Link", "<"+successor+strings.TrimPrefix(c.Request.URL.Path, prefix)+">; rel=\"successor-version\"")
+		c.Next()
+	}
+}
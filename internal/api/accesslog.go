@@ -0,0 +1,121 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	mrand "math/rand"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// requestIDHeader carries the request ID, taken from the client when it
+// sends a usable one and returned on every response.
+const requestIDHeader = "X-Request-Id"
+
+// requestIDKey is the gin context key holding the request ID.
+const requestIDKey = "requestID"
+
+// maxRequestIDLength bounds client-supplied request IDs.
+const maxRequestIDLength = 128
+
+// AccessLogConfig says which successful requests are logged. Requests
+// failing with a 4xx or 5xx status, or taking SlowThreshold or longer when
+// it is positive, are always logged.
+type AccessLogConfig struct {
+	// SampleRate is the fraction of other requests logged, from 0 to 1.
+	SampleRate float64
+	// Suppress lists paths, such as health checks, never logged when they
+	// succeed.
+	Suppress []string
+	// SlowThreshold is the latency at which a request is always logged.
+	SlowThreshold time.Duration
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether a client-supplied request ID is short and
+// printable, so it cannot forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID AccessLogMiddleware gave the request.
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// AccessLogMiddleware assigns each request an ID and writes one structured
+// access log entry per request. Entries for sampled requests carry the
+// sample rate so counts can be scaled back up.
+func AccessLogMiddleware(logger *zap.Logger, cfg AccessLogConfig) gin.HandlerFunc {
+	suppress := make(map[string]bool, len(cfg.Suppress))
+	for _, path := range cfg.Suppress {
+		suppress[path] = true
+	}
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+
+		c.Next()
+
+		latency := time.Since(start)
+		status := c.Writer.Status()
+		sampleRate := 1.0
+		if status < 400 && (cfg.SlowThreshold <= 0 || latency < cfg.SlowThreshold) {
+			if suppress[c.Request.URL.Path] {
+				return
+			}
+			sampleRate = cfg.SampleRate
+			if sampleRate < 1 && mrand.Float64() >= sampleRate {
+				return
+			}
+		}
+		bytesIn, bytesOut := c.Request.ContentLength, c.Writer.Size()
+		if bytesIn < 0 {
+			bytesIn = 0
+		}
+		if bytesOut < 0 {
+			bytesOut = 0
+		}
+		fields := []zap.Field{
+			zap.String("requestId", id),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("route", c.FullPath()),
+			zap.Int("status", status),
+			zap.Duration("latency", latency),
+			zap.Int64("bytesIn", bytesIn),
+			zap.Int("bytesOut", bytesOut),
+			zap.String("clientIp", c.ClientIP()),
+			zap.String("user", requestUser(c)),
+			zap.String("userAgent", c.Request.UserAgent()),
+		}
+		if sampleRate < 1 {
+			fields = append(fields, zap.Float64("sampleRate", sampleRate))
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, zap.String("errors", c.Errors.String()))
+		}
+		logger.Info("Request completed", fields...)
+	}
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// Alert is a condition the gateway raises with an incident service. Key
+// identifies the condition, so every replica raising it opens one incident.
+type Alert struct {
+	Key      string
+	Summary  string
+	Details  string
+	Critical bool
+}
+
+// Alerter raises and clears incidents for alerts.
+type Alerter interface {
+	Trigger(ctx context.Context, alert Alert) error
+	Resolve(ctx context.Context, alert Alert) error
+}
+
+// postJSON sends body to url and fails on a non-2xx response.
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, body interface{}) error {
+	return postJSONResponse(ctx, client, url, header, body, nil)
+}
+
+// postJSONResponse is postJSON decoding the response body into out, unless
+// out is nil.
+func postJSONResponse(ctx context.Context, client *http.Client, url string, header http.Header, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("POST %s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+	}
+	return nil
+}
+
+// pagerDutyEventsURL is the PagerDuty Events API v2 endpoint.
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyAlerter sends alerts to a PagerDuty service integration.
+type PagerDutyAlerter struct {
+	routingKey string
+	source     string
+	http       *http.Client
+}
+
+// NewPagerDutyAlerter creates an alerter for the integration's routing key.
+// source names this gateway in incidents.
+func NewPagerDutyAlerter(routingKey, source string) *PagerDutyAlerter {
+	return &PagerDutyAlerter{routingKey: routingKey, source: source, http: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *PagerDutyAlerter) send(ctx context.Context, action string, alert Alert) error {
+	event := map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": action,
+		"dedup_key":    alert.Key,
+	}
+	if action == "trigger" {
+		severity := "error"
+		if alert.Critical {
+			severity = "critical"
+		}
+		event["payload"] = map[string]interface{}{
+			"summary":        alert.Summary,
+			"source":         p.source,
+			"severity":       severity,
+			"component":      "etcd",
+			"custom_details": map[string]string{"details": alert.Details},
+		}
+	}
+	return postJSON(ctx, p.http, pagerDutyEventsURL, nil, event)
+}
+
+// Trigger opens or updates the incident for alert.
+func (p *PagerDutyAlerter) Trigger(ctx context.Context, alert Alert) error {
+	return p.send(ctx, "trigger", alert)
+}
+
+// Resolve resolves the incident for alert.
+func (p *PagerDutyAlerter) Resolve(ctx context.Context, alert Alert) error {
+	return p.send(ctx, "resolve", alert)
+}
+
+// OpsgenieAlerter sends alerts to the Opsgenie Alert API.
+type OpsgenieAlerter struct {
+	base   string
+	apiKey string
+	source string
+	http   *http.Client
+}
+
+// NewOpsgenieAlerter creates an alerter using an API integration key. base
+// is the API URL, e.g. https://api.eu.opsgenie.com for EU accounts.
+func NewOpsgenieAlerter(base, apiKey, source string) *OpsgenieAlerter {
+	return &OpsgenieAlerter{base: strings.TrimSuffix(base, "/"), apiKey: apiKey, source: source, http: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (o *OpsgenieAlerter) header() http.Header {
+	return http.Header{"Authorization": {"GenieKey " + o.apiKey}}
+}
+
+// Trigger creates the alert; Opsgenie deduplicates it by alias.
+func (o *OpsgenieAlerter) Trigger(ctx context.Context, alert Alert) error {
+	priority := "P2"
+	if alert.Critical {
+		priority = "P1"
+	}
+	return postJSON(ctx, o.http, o.base+"/v2/alerts", o.header(), map[string]interface{}{
+		"message":     alert.Summary,
+		"alias":       alert.Key,
+		"description": alert.Details,
+		"source":      o.source,
+		"priority":    priority,
+		"tags":        []string{"etcd"},
+	})
+}
+
+// Resolve closes the alert.
+func (o *OpsgenieAlerter) Resolve(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, o.http, o.base+"/v2/alerts/"+url.PathEscape(alert.Key)+"/close?identifierType=alias", o.header(),
+		map[string]string{"source": o.source})
+}
+
+// AlarmMonitor polls the cluster and raises alerts for etcd alarms, a
+// cluster without a leader, and the gateway failing to reach etcd for
+// several polls in a row. Alerts are resolved when the condition clears.
+type AlarmMonitor struct {
+	client    *clientv3.Client
+	logger    *zap.Logger
+	alerters  []Alerter
+	interval  time.Duration
+	threshold int
+
+	failures int
+	active   map[string]Alert
+}
+
+// NewAlarmMonitor creates a monitor polling every interval; call Run to
+// start it. threshold is how many polls in a row must fail to reach etcd
+// before that is raised.
+func NewAlarmMonitor(client *clientv3.Client, logger *zap.Logger, alerters []Alerter, interval time.Duration, threshold int) *AlarmMonitor {
+	return &AlarmMonitor{client: client, logger: logger, alerters: alerters, interval: interval, threshold: threshold, active: make(map[string]Alert)}
+}
+
+// Run polls until ctx is cancelled.
+func (m *AlarmMonitor) Run(ctx context.Context) {
+	tick := time.NewTicker(m.interval)
+	defer tick.Stop()
+	for {
+		m.poll(ctx)
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+const alertUnreachable = "etcd-gateway-unreachable"
+
+// poll checks the cluster once and reconciles the active alerts with what
+// it found. When etcd cannot be reached, alarm and leader alerts keep their
+// last known state.
+func (m *AlarmMonitor) poll(ctx context.Context) {
+	checkCtx, cancel := context.WithTimeout(ctx, m.interval)
+	found, err := m.check(checkCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		m.failures++
+		m.logger.Warn("Cannot check etcd for alarms", zap.Int("failures", m.failures), zap.Error(err))
+		found = make(map[string]Alert)
+		for key, alert := range m.active {
+			if key != alertUnreachable {
+				found[key] = alert
+			}
+		}
+		if m.failures >= m.threshold {
+			found[alertUnreachable] = Alert{
+				Key:      alertUnreachable,
+				Summary:  "etcd gateway cannot reach etcd",
+				Details:  fmt.Sprintf("%d checks in a row failed, the last with: %v", m.failures, err),
+				Critical: true,
+			}
+		}
+	} else {
+		m.failures = 0
+	}
+
+	// An alert whose notification fails stays in its old state, so it is
+	// retried on the next poll.
+	for key, alert := range found {
+		if _, ok := m.active[key]; !ok && m.notify(ctx, alert, true) {
+			m.active[key] = alert
+		}
+	}
+	for key, alert := range m.active {
+		if _, ok := found[key]; !ok && m.notify(ctx, alert, false) {
+			delete(m.active, key)
+		}
+	}
+}
+
+// check returns the alerts the cluster currently warrants, or an error if
+// no endpoint could be asked.
+func (m *AlarmMonitor) check(ctx context.Context) (map[string]Alert, error) {
+	found := make(map[string]Alert)
+	var reached bool
+	var lastErr error
+	for _, ep := range m.client.Endpoints() {
+		status, err := m.client.Status(ctx, ep)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reached = true
+		if status.Leader == 0 {
+			key := "etcd-no-leader-" + ep
+			found[key] = Alert{
+				Key:      key,
+				Summary:  "etcd member " + ep + " has no leader",
+				Details:  "The member reports no raft leader; writes will fail until an election completes.",
+				Critical: true,
+			}
+		}
+	}
+	if !reached {
+		return nil, lastErr
+	}
+
+	alarms, err := m.client.AlarmList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range alarms.Alarms {
+		member := strconv.FormatUint(a.MemberID, 16)
+		key := "etcd-alarm-" + strings.ToLower(a.Alarm.String()) + "-" + member
+		found[key] = Alert{
+			Key:      key,
+			Summary:  fmt.Sprintf("etcd alarm %s on member %s", a.Alarm, member),
+			Details:  "etcd raised the alarm and rejects writes until it is disarmed.",
+			Critical: true,
+		}
+	}
+	return found, nil
+}
+
+// notify triggers or resolves alert with every alerter, reporting whether
+// all of them accepted it.
+func (m *AlarmMonitor) notify(ctx context.Context, alert Alert, trigger bool) bool {
+	ok := true
+	for _, alerter := range m.alerters {
+		var err error
+		if trigger {
+			err = alerter.Trigger(ctx, alert)
+		} else {
+			err = alerter.Resolve(ctx, alert)
+		}
+		if err != nil {
+			m.logger.Warn("Cannot send alert", zap.String("alert", alert.Key), zap.Error(err))
+			ok = false
+		}
+	}
+	if ok {
+		m.logger.Info("Alert state changed", zap.String("alert", alert.Key), zap.Bool("firing", trigger))
+	}
+	return ok
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// annotationPrefix holds annotations, each stored at the prefix followed by
+// the exact key it describes.
+const annotationPrefix = "/.annotations/"
+
+// defaultAnnotationSearchLimit caps annotation search results.
+const defaultAnnotationSearchLimit = 100
+
+// annotationMatches reports whether any field of a contains the lower case
+// term.
+func annotationMatches(a Annotation, term string) bool {
+	if strings.Contains(strings.ToLower(a.Description), term) || strings.Contains(strings.ToLower(a.Owner), term) {
+		return true
+	}
+	for _, link := range a.Links {
+		if strings.Contains(strings.ToLower(link), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeAnnotation returns the annotation in kv, or nil if kv is nil or unreadable.
+func decodeAnnotation(kv *mvccpb.KeyValue) *Annotation {
+	if kv == nil {
+		return nil
+	}
+	var a Annotation
+	if err := json.Unmarshal(kv.Value, &a); err != nil {
+		return nil
+	}
+	return &a
+}
+
+// getAnnotation reads the annotation of key, or nil if it has none.
+func getAnnotation(ctx context.Context, client clientv3.KV, key string) (*Annotation, error) {
+	resp, err := client.Get(ctx, annotationPrefix+key)
+	if err != nil || len(resp.Kvs) == 0 {
+		return nil, err
+	}
+	return decodeAnnotation(resp.Kvs[0]), nil
+}
+
+// AnnotationGetHandler returns a key's annotation.
+func AnnotationGetHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Key is required"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		a, err := getAnnotation(ctx, client, key)
+		if err != nil {
+			logger.Error("Error fetching annotation from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if a == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Annotation not found"})
+			return
+		}
+		c.JSON(http.StatusOK, a)
+	}
+}
+
+// AnnotationPutHandler sets a key's annotation. The key need not exist yet.
+func AnnotationPutHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Key is required"})
+			return
+		}
+		var a Annotation
+		if err := c.ShouldBindJSON(&a); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		a.UpdatedBy = requestActor(c)
+		a.UpdatedAt = time.Now()
+		data, err := json.Marshal(a)
+		if err != nil {
+			logger.Error("Error encoding annotation", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		if _, err := client.Put(ctx, annotationPrefix+key, string(data)); err != nil {
+			logger.Error("Error writing annotation to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, a)
+	}
+}
+
+// AnnotationDeleteHandler removes a key's annotation.
+func AnnotationDeleteHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Delete(ctx, annotationPrefix+key)
+		if err != nil {
+			logger.Error("Error deleting annotation from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if resp.Deleted == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Annotation not found"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// AnnotationSearchHandler finds annotated keys under ?prefix whose
+// annotation contains ?q (case-insensitively), or whose owner is ?owner.
+func AnnotationSearchHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		term := strings.ToLower(c.Query("q"))
+		owner := c.Query("owner")
+		limit := defaultAnnotationSearchLimit
+		if s := c.Query("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		results := []AnnotatedKey{}
+		truncated := false
+		_, err := scanPrefix(ctx, client, annotationPrefix+c.Query("prefix"), func(kv *mvccpb.KeyValue) {
+			a := decodeAnnotation(kv)
+			if a == nil || owner != "" && a.Owner != owner || term != "" && !annotationMatches(*a, term) {
+				return
+			}
+			if len(results) == limit {
+				truncated = true
+				return
+			}
+			results = append(results, AnnotatedKey{Key: strings.TrimPrefix(string(kv.Key), annotationPrefix), Annotation: *a})
+		})
+		if err != nil {
+			logger.Error("Error searching annotations in etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"annotations": results, "truncated": truncated})
+	}
+}
@@ -0,0 +1,481 @@
+// Package apitest provides fakes for unit testing the handlers of package
+// api without etcd: an in-memory keyspace with its watches and leases to
+// construct them with and a way to serve them single requests.
+//
+//	store := apitest.NewStore()
+//	store.KV().Put(ctx, "/app/x", "1")
+//	handler := api.FetchValueForKeyHandler(store.KV(), nil, nil, nil, zap.NewNop())
+//	rec := apitest.Serve("/value/*key", httptest.NewRequest("GET", "/value//app/x", nil), handler)
+package apitest
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+)
+
+// Store is an in-memory etcd keyspace with etcd's revisions, history and
+// compaction. It serves etcd's KV, Watch and Lease gRPC services, so the
+// clients from KV, Watcher and Lease encode every option the way real ones
+// do, and handlers cannot tell it apart from etcd. Leases never expire, and
+// puts do not check that the lease they name exists, but revoking a lease
+// deletes the keys attached to it.
+type Store struct {
+	mu        sync.Mutex
+	revision  int64
+	compacted int64
+	// history holds every version of each key, oldest first; deletions are
+	// versions with Version 0.
+	history map[string][]*mvccpb.KeyValue
+	keys    []string
+	// events holds every change since the compaction revision, in order,
+	// for watches starting in the past.
+	events  []*mvccpb.Event
+	watches []*watch
+	// leases holds the granted TTL of each lease.
+	leases    map[int64]int64
+	lastLease int64
+}
+
+// NewStore returns an empty keyspace at revision 1, like a new etcd cluster.
+func NewStore() *Store {
+	return &Store{revision: 1, history: make(map[string][]*mvccpb.KeyValue), leases: make(map[int64]int64)}
+}
+
+// KV returns a client of the store, to construct handlers with.
+func (s *Store) KV() clientv3.KV {
+	return clientv3.NewKVFromKVClient(s, nil)
+}
+
+// Client returns a client whose KV, Watcher and Lease are the store's, for
+// constructors taking more than one of them. Its other APIs are missing.
+func (s *Store) Client() *clientv3.Client {
+	client := clientv3.NewCtxClient(context.Background())
+	client.KV, client.Watcher, client.Lease = s.KV(), s.Watcher(), s.Lease()
+	return client
+}
+
+// Revision returns the store's current revision.
+func (s *Store) Revision() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revision
+}
+
+func (s *Store) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: s.revision}
+}
+
+// at returns key as of rev, or nil if it did not exist then.
+func (s *Store) at(key string, rev int64) *mvccpb.KeyValue {
+	versions := s.history[key]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].ModRevision <= rev {
+			if versions[i].Version == 0 {
+				return nil
+			}
+			return versions[i]
+		}
+	}
+	return nil
+}
+
+// inRange returns the keys ever written in [key, end), in order. An empty
+// end is the single key and "\x00" every key from key on.
+func (s *Store) inRange(key, end []byte) []string {
+	if len(end) == 0 {
+		if _, ok := s.history[string(key)]; ok {
+			return []string{string(key)}
+		}
+		return nil
+	}
+	var keys []string
+	for _, k := range s.keys[sort.SearchStrings(s.keys, string(key)):] {
+		if !(len(end) == 1 && end[0] == 0) && bytes.Compare([]byte(k), end) >= 0 {
+			break
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// checkRevision returns the error etcd answers reads or compactions at rev
+// with, if any.
+func (s *Store) checkRevision(rev int64) error {
+	if rev > s.revision {
+		return rpctypes.ErrGRPCFutureRev
+	}
+	if rev > 0 && rev < s.compacted {
+		return rpctypes.ErrGRPCCompacted
+	}
+	return nil
+}
+
+func (s *Store) Range(ctx context.Context, req *pb.RangeRequest, _ ...grpc.CallOption) (*pb.RangeResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkRevision(req.Revision); err != nil {
+		return nil, err
+	}
+	return s.rangeAt(req, s.revision), nil
+}
+
+// rangeAt serves req as of rev when it does not ask for a revision itself.
+func (s *Store) rangeAt(req *pb.RangeRequest, rev int64) *pb.RangeResponse {
+	if req.Revision > 0 {
+		rev = req.Revision
+	}
+	var kvs []*mvccpb.KeyValue
+	for _, key := range s.inRange(req.Key, req.RangeEnd) {
+		kv := s.at(key, rev)
+		if kv == nil ||
+			(req.MinModRevision > 0 && kv.ModRevision < req.MinModRevision) ||
+			(req.MaxModRevision > 0 && kv.ModRevision > req.MaxModRevision) ||
+			(req.MinCreateRevision > 0 && kv.CreateRevision < req.MinCreateRevision) ||
+			(req.MaxCreateRevision > 0 && kv.CreateRevision > req.MaxCreateRevision) {
+			continue
+		}
+		copied := *kv
+		if req.KeysOnly {
+			copied.Value = nil
+		}
+		kvs = append(kvs, &copied)
+	}
+	resp := &pb.RangeResponse{Header: s.header(), Count: int64(len(kvs))}
+	if req.CountOnly {
+		return resp
+	}
+	sortKVs(kvs, req.SortTarget, req.SortOrder)
+	if req.Limit > 0 && int64(len(kvs)) > req.Limit {
+		kvs, resp.More = kvs[:req.Limit], true
+	}
+	resp.Kvs = kvs
+	return resp
+}
+
+// sortKVs sorts kvs as etcd does: by key unless asked otherwise, ascending
+// when a target but no order is given.
+func sortKVs(kvs []*mvccpb.KeyValue, target pb.RangeRequest_SortTarget, order pb.RangeRequest_SortOrder) {
+	if order == pb.RangeRequest_NONE {
+		if target == pb.RangeRequest_KEY {
+			return
+		}
+		order = pb.RangeRequest_ASCEND
+	}
+	less := func(a, b *mvccpb.KeyValue) bool {
+		switch target {
+		case pb.RangeRequest_VERSION:
+			return a.Version < b.Version
+		case pb.RangeRequest_CREATE:
+			return a.CreateRevision < b.CreateRevision
+		case pb.RangeRequest_MOD:
+			return a.ModRevision < b.ModRevision
+		case pb.RangeRequest_VALUE:
+			return bytes.Compare(a.Value, b.Value) < 0
+		}
+		return bytes.Compare(a.Key, b.Key) < 0
+	}
+	sort.SliceStable(kvs, func(i, j int) bool {
+		if order == pb.RangeRequest_DESCEND {
+			return less(kvs[j], kvs[i])
+		}
+		return less(kvs[i], kvs[j])
+	})
+}
+
+func (s *Store) Put(ctx context.Context, req *pb.PutRequest, _ ...grpc.CallOption) (*pb.PutResponse, error) {
+	resp, err := s.Txn(ctx, &pb.TxnRequest{Success: []*pb.RequestOp{{Request: &pb.RequestOp_RequestPut{RequestPut: req}}}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Responses[0].GetResponsePut(), nil
+}
+
+func (s *Store) DeleteRange(ctx context.Context, req *pb.DeleteRangeRequest, _ ...grpc.CallOption) (*pb.DeleteRangeResponse, error) {
+	resp, err := s.Txn(ctx, &pb.TxnRequest{Success: []*pb.RequestOp{{Request: &pb.RequestOp_RequestDeleteRange{RequestDeleteRange: req}}}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Responses[0].GetResponseDeleteRange(), nil
+}
+
+// Txn applies a transaction atomically. All its writes share the revision
+// after the current one, which only becomes current if it wrote anything.
+func (s *Store) Txn(ctx context.Context, req *pb.TxnRequest, _ ...grpc.CallOption) (*pb.TxnResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := &write{store: s, revision: s.revision + 1}
+	resp, err := w.txn(req)
+	if err != nil {
+		// Drop the versions the failed transaction added.
+		for key, versions := range s.history {
+			for len(versions) > 0 && versions[len(versions)-1].ModRevision == w.revision {
+				versions = versions[:len(versions)-1]
+			}
+			if len(versions) == 0 {
+				delete(s.history, key)
+				s.keys = removeKey(s.keys, key)
+			} else {
+				s.history[key] = versions
+			}
+		}
+		return nil, err
+	}
+	s.commit(w)
+	setHeaders(resp, s.header())
+	return resp, nil
+}
+
+// commit makes w's revision current if it wrote anything, and sends its
+// changes to the watches.
+func (s *Store) commit(w *write) {
+	if !w.wrote {
+		return
+	}
+	s.revision = w.revision
+	s.events = append(s.events, w.events...)
+	for _, watch := range s.watches {
+		watch.send(s.header(), w.events)
+	}
+}
+
+func removeKey(keys []string, key string) []string {
+	i := sort.SearchStrings(keys, key)
+	if i < len(keys) && keys[i] == key {
+		return append(keys[:i], keys[i+1:]...)
+	}
+	return keys
+}
+
+// setHeaders gives resp and every response within it header.
+func setHeaders(resp *pb.TxnResponse, header *pb.ResponseHeader) {
+	resp.Header = header
+	for _, op := range resp.Responses {
+		switch r := op.Response.(type) {
+		case *pb.ResponseOp_ResponseRange:
+			r.ResponseRange.Header = header
+		case *pb.ResponseOp_ResponsePut:
+			r.ResponsePut.Header = header
+		case *pb.ResponseOp_ResponseDeleteRange:
+			r.ResponseDeleteRange.Header = header
+		case *pb.ResponseOp_ResponseTxn:
+			setHeaders(r.ResponseTxn, header)
+		}
+	}
+}
+
+// write is a transaction being applied at revision. Reads within it see
+// its earlier writes, as in etcd.
+type write struct {
+	store    *Store
+	revision int64
+	wrote    bool
+	events   []*mvccpb.Event
+}
+
+func (w *write) txn(req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	succeeded := true
+	for _, cmp := range req.Compare {
+		if !w.compare(cmp) {
+			succeeded = false
+			break
+		}
+	}
+	ops := req.Success
+	if !succeeded {
+		ops = req.Failure
+	}
+	resp := &pb.TxnResponse{Succeeded: succeeded}
+	for _, op := range ops {
+		var result *pb.ResponseOp
+		switch r := op.Request.(type) {
+		case *pb.RequestOp_RequestRange:
+			if err := w.store.checkRevision(r.RequestRange.Revision); err != nil {
+				return nil, err
+			}
+			result = &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: w.store.rangeAt(r.RequestRange, w.revision)}}
+		case *pb.RequestOp_RequestPut:
+			put, err := w.put(r.RequestPut)
+			if err != nil {
+				return nil, err
+			}
+			result = &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: put}}
+		case *pb.RequestOp_RequestDeleteRange:
+			result = &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: w.deleteRange(r.RequestDeleteRange)}}
+		case *pb.RequestOp_RequestTxn:
+			nested, err := w.txn(r.RequestTxn)
+			if err != nil {
+				return nil, err
+			}
+			result = &pb.ResponseOp{Response: &pb.ResponseOp_ResponseTxn{ResponseTxn: nested}}
+		}
+		resp.Responses = append(resp.Responses, result)
+	}
+	return resp, nil
+}
+
+// compare evaluates cmp against every key in its range, as of the writes
+// so far. Values of missing keys never match; their other targets are 0.
+func (w *write) compare(cmp *pb.Compare) bool {
+	keys := w.store.inRange(cmp.Key, cmp.RangeEnd)
+	var kvs []*mvccpb.KeyValue
+	for _, key := range keys {
+		if kv := w.store.at(key, w.revision); kv != nil {
+			kvs = append(kvs, kv)
+		}
+	}
+	if len(kvs) == 0 {
+		if cmp.Target == pb.Compare_VALUE {
+			return false
+		}
+		kvs = []*mvccpb.KeyValue{{}}
+	}
+	for _, kv := range kvs {
+		var c int
+		switch cmp.Target {
+		case pb.Compare_VERSION:
+			c = compareInt(kv.Version, cmp.GetVersion())
+		case pb.Compare_CREATE:
+			c = compareInt(kv.CreateRevision, cmp.GetCreateRevision())
+		case pb.Compare_MOD:
+			c = compareInt(kv.ModRevision, cmp.GetModRevision())
+		case pb.Compare_VALUE:
+			c = bytes.Compare(kv.Value, cmp.GetValue())
+		case pb.Compare_LEASE:
+			c = compareInt(kv.Lease, cmp.GetLease())
+		}
+		var ok bool
+		switch cmp.Result {
+		case pb.Compare_EQUAL:
+			ok = c == 0
+		case pb.Compare_NOT_EQUAL:
+			ok = c != 0
+		case pb.Compare_GREATER:
+			ok = c > 0
+		case pb.Compare_LESS:
+			ok = c < 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// record adds kv as the newest version of its key.
+func (w *write) record(kv *mvccpb.KeyValue) {
+	key := string(kv.Key)
+	ev := &mvccpb.Event{Type: mvccpb.PUT, Kv: kv}
+	if kv.Version == 0 {
+		ev.Type = mvccpb.DELETE
+	}
+	if versions := w.store.history[key]; len(versions) > 0 && versions[len(versions)-1].Version != 0 {
+		ev.PrevKv = versions[len(versions)-1]
+	}
+	w.events = append(w.events, ev)
+	if _, ok := w.store.history[key]; !ok {
+		i := sort.SearchStrings(w.store.keys, key)
+		w.store.keys = append(w.store.keys, "")
+		copy(w.store.keys[i+1:], w.store.keys[i:])
+		w.store.keys[i] = key
+	}
+	w.store.history[key] = append(w.store.history[key], kv)
+	w.wrote = true
+}
+
+func (w *write) put(req *pb.PutRequest) (*pb.PutResponse, error) {
+	prev := w.store.at(string(req.Key), w.revision)
+	if prev == nil && (req.IgnoreValue || req.IgnoreLease) {
+		return nil, rpctypes.ErrGRPCKeyNotFound
+	}
+	kv := &mvccpb.KeyValue{Key: req.Key, Value: req.Value, Lease: req.Lease, CreateRevision: w.revision, ModRevision: w.revision, Version: 1}
+	if prev != nil {
+		kv.CreateRevision, kv.Version = prev.CreateRevision, prev.Version+1
+		if req.IgnoreValue {
+			kv.Value = prev.Value
+		}
+		if req.IgnoreLease {
+			kv.Lease = prev.Lease
+		}
+	}
+	w.record(kv)
+	resp := &pb.PutResponse{}
+	if req.PrevKv {
+		resp.PrevKv = prev
+	}
+	return resp, nil
+}
+
+func (w *write) deleteRange(req *pb.DeleteRangeRequest) *pb.DeleteRangeResponse {
+	resp := &pb.DeleteRangeResponse{}
+	for _, key := range w.store.inRange(req.Key, req.RangeEnd) {
+		prev := w.store.at(key, w.revision)
+		if prev == nil {
+			continue
+		}
+		w.record(&mvccpb.KeyValue{Key: prev.Key, ModRevision: w.revision})
+		resp.Deleted++
+		if req.PrevKv {
+			resp.PrevKvs = append(resp.PrevKvs, prev)
+		}
+	}
+	return resp
+}
+
+// Compact discards the history before req.Revision. Reads at earlier
+// revisions then fail with ErrCompacted.
+func (s *Store) Compact(ctx context.Context, req *pb.CompactionRequest, _ ...grpc.CallOption) (*pb.CompactionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Revision <= s.compacted {
+		return nil, rpctypes.ErrGRPCCompacted
+	}
+	if req.Revision > s.revision {
+		return nil, rpctypes.ErrGRPCFutureRev
+	}
+	for key, versions := range s.history {
+		// Keep the version current at the compaction revision, unless it
+		// is a deletion.
+		keep := 0
+		for i, kv := range versions {
+			if kv.ModRevision <= req.Revision {
+				keep = i
+			}
+		}
+		if versions[keep].ModRevision <= req.Revision && versions[keep].Version == 0 {
+			keep++
+		}
+		if versions = versions[keep:]; len(versions) == 0 {
+			delete(s.history, key)
+			s.keys = removeKey(s.keys, key)
+		} else {
+			s.history[key] = versions
+		}
+	}
+	events := s.events[:0]
+	for _, ev := range s.events {
+		if ev.Kv.ModRevision >= req.Revision {
+			events = append(events, ev)
+		}
+	}
+	s.events = events
+	s.compacted = req.Revision
+	return &pb.CompactionResponse{Header: s.header()}, nil
+}
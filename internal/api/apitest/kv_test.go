@@ -0,0 +1,234 @@
+package apitest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func keys(resp *clientv3.GetResponse) []string {
+	keys := []string{}
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+func TestStorePutGet(t *testing.T) {
+	store := NewStore()
+	kv := store.KV()
+	ctx := context.Background()
+
+	if _, err := kv.Put(ctx, "/a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	put, err := kv.Put(ctx, "/a", "2", clientv3.WithPrevKV())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if put.PrevKv == nil || string(put.PrevKv.Value) != "1" {
+		t.Errorf("got previous %v, want value 1", put.PrevKv)
+	}
+	if put.Header.Revision != 3 || store.Revision() != 3 {
+		t.Errorf("got revision %d, store at %d, want 3", put.Header.Revision, store.Revision())
+	}
+
+	resp, err := kv.Get(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := resp.Kvs[0]
+	if string(got.Value) != "2" || got.CreateRevision != 2 || got.ModRevision != 3 || got.Version != 2 {
+		t.Errorf("got %v", got)
+	}
+
+	// Earlier revisions read the history.
+	resp, err = kv.Get(ctx, "/a", clientv3.WithRev(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Kvs[0].Value) != "1" {
+		t.Errorf("got %q at revision 2, want 1", resp.Kvs[0].Value)
+	}
+	if _, err := kv.Get(ctx, "/a", clientv3.WithRev(4)); err != rpctypes.ErrFutureRev {
+		t.Errorf("got error %v reading a future revision", err)
+	}
+}
+
+func TestStoreRanges(t *testing.T) {
+	kv := NewStore().KV()
+	ctx := context.Background()
+	for _, p := range [][2]string{{"/b", "2"}, {"/a/y", "3"}, {"/a/x", "1"}, {"/c", "0"}} {
+		if _, err := kv.Put(ctx, p[0], p[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		opts      []clientv3.OpOption
+		want      []string
+		wantCount int64
+		wantMore  bool
+	}{
+		{"prefix", "/a/", []clientv3.OpOption{clientv3.WithPrefix()}, []string{"/a/x", "/a/y"}, 2, false},
+		{"from key", "/b", []clientv3.OpOption{clientv3.WithFromKey()}, []string{"/b", "/c"}, 2, false},
+		{"range", "/a/y", []clientv3.OpOption{clientv3.WithRange("/c")}, []string{"/a/y", "/b"}, 2, false},
+		{"limit", "/", []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithLimit(3)}, []string{"/a/x", "/a/y", "/b"}, 4, true},
+		{"descending", "/", []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend), clientv3.WithLimit(2)}, []string{"/c", "/b"}, 4, true},
+		{"by value", "/", []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByValue, clientv3.SortAscend)}, []string{"/c", "/a/x", "/b", "/a/y"}, 4, false},
+		{"count only", "/", []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithCountOnly()}, []string{}, 4, false},
+		{"missing", "/d", nil, []string{}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := kv.Get(ctx, tt.key, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := keys(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got keys %v, want %v", got, tt.want)
+			}
+			if resp.Count != tt.wantCount || resp.More != tt.wantMore {
+				t.Errorf("got count %d, more %v, want %d, %v", resp.Count, resp.More, tt.wantCount, tt.wantMore)
+			}
+		})
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	store := NewStore()
+	kv := store.KV()
+	ctx := context.Background()
+	for _, key := range []string{"/a/x", "/a/y", "/b"} {
+		if _, err := kv.Put(ctx, key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := kv.Delete(ctx, "/a/", clientv3.WithPrefix(), clientv3.WithPrevKV())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Deleted != 2 || len(resp.PrevKvs) != 2 {
+		t.Errorf("deleted %d, previous %v", resp.Deleted, resp.PrevKvs)
+	}
+	if store.Revision() != 5 {
+		t.Errorf("store at revision %d, want 5", store.Revision())
+	}
+
+	// Deleting nothing does not advance the revision.
+	if resp, err = kv.Delete(ctx, "/a/x"); err != nil || resp.Deleted != 0 {
+		t.Fatalf("deleted %v, error %v", resp, err)
+	}
+	if store.Revision() != 5 {
+		t.Errorf("store at revision %d after deleting nothing", store.Revision())
+	}
+
+	// Recreated keys start a new life.
+	if _, err := kv.Put(ctx, "/a/x", "w"); err != nil {
+		t.Fatal(err)
+	}
+	get, _ := kv.Get(ctx, "/a/x")
+	if got := get.Kvs[0]; got.CreateRevision != 6 || got.Version != 1 {
+		t.Errorf("got %v", got)
+	}
+	get, _ = kv.Get(ctx, "/a/", clientv3.WithPrefix(), clientv3.WithRev(4))
+	if got := keys(get); !reflect.DeepEqual(got, []string{"/a/x", "/a/y"}) {
+		t.Errorf("got %v before the delete", got)
+	}
+}
+
+func TestStoreTxn(t *testing.T) {
+	store := NewStore()
+	kv := store.KV()
+	ctx := context.Background()
+	if _, err := kv.Put(ctx, "/a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cmp  clientv3.Cmp
+		want bool
+	}{
+		{"value", clientv3.Compare(clientv3.Value("/a"), "=", "1"), true},
+		{"other value", clientv3.Compare(clientv3.Value("/a"), "=", "2"), false},
+		{"missing value", clientv3.Compare(clientv3.Value("/b"), "=", ""), false},
+		{"mod revision", clientv3.Compare(clientv3.ModRevision("/a"), "=", 2), true},
+		{"absent", clientv3.Compare(clientv3.CreateRevision("/b"), "=", 0), true},
+		{"version", clientv3.Compare(clientv3.Version("/a"), ">", 0), true},
+		{"empty prefix", clientv3.Compare(clientv3.CreateRevision("/b/"), "=", 0).WithPrefix(), true},
+		{"nonempty prefix", clientv3.Compare(clientv3.CreateRevision("/"), "=", 0).WithPrefix(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := kv.Txn(ctx).If(tt.cmp).Then(clientv3.OpGet("/a")).Else(clientv3.OpGet("/b")).Commit()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Succeeded != tt.want {
+				t.Errorf("got succeeded %v, want %v", resp.Succeeded, tt.want)
+			}
+		})
+	}
+
+	// Writes of a transaction share a revision, and its reads see them.
+	resp, err := kv.Txn(ctx).Then(
+		clientv3.OpPut("/b", "2"),
+		clientv3.OpPut("/c", "3"),
+		clientv3.OpGet("/", clientv3.WithPrefix()),
+	).Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys((*clientv3.GetResponse)(resp.Responses[2].GetResponseRange())); !reflect.DeepEqual(got, []string{"/a", "/b", "/c"}) {
+		t.Errorf("read %v within the transaction", got)
+	}
+	if resp.Header.Revision != 3 || store.Revision() != 3 {
+		t.Errorf("got revision %d, store at %d, want 3", resp.Header.Revision, store.Revision())
+	}
+
+	// Failed transactions leave nothing behind.
+	_, err = kv.Txn(ctx).Then(clientv3.OpPut("/d", "4"), clientv3.OpPut("/e", "", clientv3.WithIgnoreValue())).Commit()
+	if err != rpctypes.ErrKeyNotFound {
+		t.Errorf("got error %v, want ErrKeyNotFound", err)
+	}
+	if get, _ := kv.Get(ctx, "/d"); len(get.Kvs) != 0 || store.Revision() != 3 {
+		t.Errorf("failed transaction wrote %v, store at %d", get.Kvs, store.Revision())
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	store := NewStore()
+	kv := store.KV()
+	ctx := context.Background()
+	kv.Put(ctx, "/a", "1")
+	kv.Put(ctx, "/a", "2")
+	kv.Put(ctx, "/b", "1")
+	kv.Delete(ctx, "/b")
+
+	if _, err := kv.Compact(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Get(ctx, "/a", clientv3.WithRev(3)); err != rpctypes.ErrCompacted {
+		t.Errorf("got error %v reading a compacted revision", err)
+	}
+	resp, err := kv.Get(ctx, "/", clientv3.WithPrefix(), clientv3.WithRev(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(resp); !reflect.DeepEqual(got, []string{"/a", "/b"}) {
+		t.Errorf("got %v at the compaction revision", got)
+	}
+	if resp, _ = kv.Get(ctx, "/", clientv3.WithPrefix()); !reflect.DeepEqual(keys(resp), []string{"/a"}) {
+		t.Errorf("got %v", keys(resp))
+	}
+	if _, err := kv.Compact(ctx, 4); err != rpctypes.ErrCompacted {
+		t.Errorf("got error %v compacting again", err)
+	}
+}
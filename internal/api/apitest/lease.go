@@ -0,0 +1,127 @@
+package apitest
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+)
+
+// Lease returns a lease client of the store.
+func (s *Store) Lease() clientv3.Lease {
+	return clientv3.NewLeaseFromLeaseClient(s, clientv3.NewCtxClient(context.Background()), time.Second)
+}
+
+// leaseKeys returns the keys attached to lease id, in order.
+func (s *Store) leaseKeys(id int64) [][]byte {
+	var keys [][]byte
+	for _, key := range s.keys {
+		if kv := s.at(key, s.revision); kv != nil && kv.Lease == id {
+			keys = append(keys, kv.Key)
+		}
+	}
+	return keys
+}
+
+// LeaseGrant grants a lease with the ID asked for, or else the one after
+// the last granted.
+func (s *Store) LeaseGrant(ctx context.Context, req *pb.LeaseGrantRequest, _ ...grpc.CallOption) (*pb.LeaseGrantResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := req.ID
+	if id == 0 {
+		s.lastLease++
+		id = s.lastLease
+	}
+	if _, ok := s.leases[id]; ok {
+		return nil, rpctypes.ErrGRPCLeaseExist
+	}
+	s.leases[id] = req.TTL
+	return &pb.LeaseGrantResponse{Header: s.header(), ID: id, TTL: req.TTL}, nil
+}
+
+// LeaseRevoke revokes a lease, deleting its keys in one revision.
+func (s *Store) LeaseRevoke(ctx context.Context, req *pb.LeaseRevokeRequest, _ ...grpc.CallOption) (*pb.LeaseRevokeResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.leases[req.ID]; !ok {
+		return nil, rpctypes.ErrGRPCLeaseNotFound
+	}
+	w := &write{store: s, revision: s.revision + 1}
+	for _, key := range s.leaseKeys(req.ID) {
+		w.deleteRange(&pb.DeleteRangeRequest{Key: key})
+	}
+	s.commit(w)
+	delete(s.leases, req.ID)
+	return &pb.LeaseRevokeResponse{Header: s.header()}, nil
+}
+
+// LeaseTimeToLive answers with the granted TTL as the remaining one, and
+// -1 for leases that do not exist, as etcd does for expired ones.
+func (s *Store) LeaseTimeToLive(ctx context.Context, req *pb.LeaseTimeToLiveRequest, _ ...grpc.CallOption) (*pb.LeaseTimeToLiveResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ttl, ok := s.leases[req.ID]
+	if !ok {
+		return &pb.LeaseTimeToLiveResponse{Header: s.header(), ID: req.ID, TTL: -1}, nil
+	}
+	resp := &pb.LeaseTimeToLiveResponse{Header: s.header(), ID: req.ID, TTL: ttl, GrantedTTL: ttl}
+	if req.Keys {
+		resp.Keys = s.leaseKeys(req.ID)
+	}
+	return resp, nil
+}
+
+func (s *Store) LeaseLeases(ctx context.Context, req *pb.LeaseLeasesRequest, _ ...grpc.CallOption) (*pb.LeaseLeasesResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &pb.LeaseLeasesResponse{Header: s.header()}
+	for id := range s.leases {
+		resp.Leases = append(resp.Leases, &pb.LeaseStatus{ID: id})
+	}
+	sort.Slice(resp.Leases, func(i, j int) bool { return resp.Leases[i].ID < resp.Leases[j].ID })
+	return resp, nil
+}
+
+func (s *Store) LeaseKeepAlive(ctx context.Context, _ ...grpc.CallOption) (pb.Lease_LeaseKeepAliveClient, error) {
+	return &keepAliveStream{store: s, ctx: ctx, resps: make(chan *pb.LeaseKeepAliveResponse, 16)}, nil
+}
+
+// keepAliveStream answers each keepalive with the lease's granted TTL, or
+// 0 if it does not exist.
+type keepAliveStream struct {
+	grpc.ClientStream
+	store *Store
+	ctx   context.Context
+	resps chan *pb.LeaseKeepAliveResponse
+}
+
+func (ks *keepAliveStream) Send(req *pb.LeaseKeepAliveRequest) error {
+	s := ks.store
+	s.mu.Lock()
+	resp := &pb.LeaseKeepAliveResponse{Header: s.header(), ID: req.ID, TTL: s.leases[req.ID]}
+	s.mu.Unlock()
+	select {
+	case ks.resps <- resp:
+		return nil
+	case <-ks.ctx.Done():
+		return ks.ctx.Err()
+	}
+}
+
+func (ks *keepAliveStream) Recv() (*pb.LeaseKeepAliveResponse, error) {
+	select {
+	case resp := <-ks.resps:
+		return resp, nil
+	case <-ks.ctx.Done():
+		return nil, ks.ctx.Err()
+	}
+}
+
+func (ks *keepAliveStream) CloseSend() error {
+	return nil
+}
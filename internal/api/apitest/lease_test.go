@@ -0,0 +1,55 @@
+package apitest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestStoreLease(t *testing.T) {
+	store := NewStore()
+	kv, lease := store.KV(), store.Lease()
+	defer lease.Close()
+	ctx := context.Background()
+
+	grant, err := lease.Grant(ctx, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Put(ctx, "/a", "1", clientv3.WithLease(grant.ID))
+	kv.Put(ctx, "/b", "1")
+	ttl, err := lease.TimeToLive(ctx, grant.ID, clientv3.WithAttachedKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl.TTL != 30 || ttl.GrantedTTL != 30 || len(ttl.Keys) != 1 || string(ttl.Keys[0]) != "/a" {
+		t.Errorf("got %+v", ttl)
+	}
+	if ka, err := lease.KeepAliveOnce(ctx, grant.ID); err != nil || ka.TTL != 30 {
+		t.Errorf("got keepalive %+v, error %v", ka, err)
+	}
+	leases, err := lease.Leases(ctx)
+	if err != nil || len(leases.Leases) != 1 || leases.Leases[0].ID != grant.ID {
+		t.Errorf("got leases %+v, error %v", leases, err)
+	}
+
+	// Revoking deletes the attached keys.
+	if _, err := lease.Revoke(ctx, grant.ID); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := kv.Get(ctx, "/", clientv3.WithPrefix()); !reflect.DeepEqual(keys(resp), []string{"/b"}) {
+		t.Errorf("got %v after revoking", keys(resp))
+	}
+	if ttl, err := lease.TimeToLive(ctx, grant.ID); err != nil || ttl.TTL != -1 {
+		t.Errorf("got %+v, error %v for a revoked lease", ttl, err)
+	}
+	if _, err := lease.KeepAliveOnce(ctx, grant.ID); err != rpctypes.ErrLeaseNotFound {
+		t.Errorf("got error %v keeping a revoked lease alive", err)
+	}
+	if _, err := lease.Revoke(ctx, grant.ID); err != rpctypes.ErrLeaseNotFound {
+		t.Errorf("got error %v revoking again", err)
+	}
+}
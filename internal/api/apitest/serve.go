@@ -0,0 +1,20 @@
+package apitest
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Serve serves req with handlers registered at route, such as
+// "/value/*key", and returns the recorded response. Handlers get the default
+// timeouts, as if no TimeoutMiddleware ran.
+func Serve(route string, req *http.Request, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Handle(req.Method, route, handlers...)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,158 @@
+package apitest
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+)
+
+// Watcher returns a watch client of the store. Watches see every change
+// committed after they are created, and those since their start revision;
+// they are never sent progress notifications unless they ask.
+func (s *Store) Watcher() clientv3.Watcher {
+	return clientv3.NewWatchFromWatchClient(s, clientv3.NewCtxClient(context.Background()))
+}
+
+func (s *Store) Watch(ctx context.Context, _ ...grpc.CallOption) (pb.Watch_WatchClient, error) {
+	return &watchStream{store: s, ctx: ctx, pending: make(chan struct{}, 1)}, nil
+}
+
+// watchStream is one client's stream of watches. Responses queue without
+// bound, so that writes never wait for watchers to read them.
+type watchStream struct {
+	grpc.ClientStream
+	store  *Store
+	ctx    context.Context
+	nextID int64
+
+	mu      sync.Mutex
+	queue   []*pb.WatchResponse
+	pending chan struct{}
+}
+
+// watch is a watch created on a stream.
+type watch struct {
+	stream *watchStream
+	id     int64
+	req    *pb.WatchCreateRequest
+}
+
+func (ws *watchStream) Send(req *pb.WatchRequest) error {
+	s := ws.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r := req.RequestUnion.(type) {
+	case *pb.WatchRequest_CreateRequest:
+		w := &watch{stream: ws, id: ws.nextID, req: r.CreateRequest}
+		ws.nextID++
+		ws.enqueue(&pb.WatchResponse{Header: s.header(), WatchId: w.id, Created: true})
+		start := w.req.StartRevision
+		if start > 0 && start < s.compacted {
+			ws.enqueue(&pb.WatchResponse{Header: s.header(), WatchId: w.id, Canceled: true, CompactRevision: s.compacted})
+			return nil
+		}
+		if start > 0 {
+			var past []*mvccpb.Event
+			for _, ev := range s.events {
+				if ev.Kv.ModRevision >= start {
+					past = append(past, ev)
+				}
+			}
+			w.send(s.header(), past)
+		}
+		s.watches = append(s.watches, w)
+	case *pb.WatchRequest_CancelRequest:
+		for i, w := range s.watches {
+			if w.stream == ws && w.id == r.CancelRequest.WatchId {
+				s.watches = append(s.watches[:i], s.watches[i+1:]...)
+				ws.enqueue(&pb.WatchResponse{Header: s.header(), WatchId: w.id, Canceled: true})
+				break
+			}
+		}
+	case *pb.WatchRequest_ProgressRequest:
+		ws.enqueue(&pb.WatchResponse{Header: s.header(), WatchId: clientv3.InvalidWatchID})
+	}
+	return nil
+}
+
+func (ws *watchStream) Recv() (*pb.WatchResponse, error) {
+	for {
+		ws.mu.Lock()
+		if len(ws.queue) > 0 {
+			resp := ws.queue[0]
+			ws.queue = ws.queue[1:]
+			ws.mu.Unlock()
+			return resp, nil
+		}
+		ws.mu.Unlock()
+		select {
+		case <-ws.pending:
+		case <-ws.ctx.Done():
+			ws.close()
+			return nil, ws.ctx.Err()
+		}
+	}
+}
+
+func (ws *watchStream) enqueue(resp *pb.WatchResponse) {
+	ws.mu.Lock()
+	ws.queue = append(ws.queue, resp)
+	ws.mu.Unlock()
+	select {
+	case ws.pending <- struct{}{}:
+	default:
+	}
+}
+
+// close removes the stream's watches from the store.
+func (ws *watchStream) close() {
+	s := ws.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watches := s.watches[:0]
+	for _, w := range s.watches {
+		if w.stream != ws {
+			watches = append(watches, w)
+		}
+	}
+	s.watches = watches
+}
+
+// send sends the events the watch is for, if any, in one response.
+func (w *watch) send(header *pb.ResponseHeader, events []*mvccpb.Event) {
+	var matched []*mvccpb.Event
+	for _, ev := range events {
+		if !w.matches(ev) {
+			continue
+		}
+		if !w.req.PrevKv {
+			copied := *ev
+			copied.PrevKv = nil
+			ev = &copied
+		}
+		matched = append(matched, ev)
+	}
+	if len(matched) > 0 {
+		w.stream.enqueue(&pb.WatchResponse{Header: header, WatchId: w.id, Events: matched})
+	}
+}
+
+// matches reports whether ev is of a key in the watch's range and not of
+// a type it filters out.
+func (w *watch) matches(ev *mvccpb.Event) bool {
+	for _, filter := range w.req.Filters {
+		if filter == pb.WatchCreateRequest_NOPUT && ev.Type == mvccpb.PUT || filter == pb.WatchCreateRequest_NODELETE && ev.Type == mvccpb.DELETE {
+			return false
+		}
+	}
+	key, start, end := ev.Kv.Key, w.req.Key, w.req.RangeEnd
+	if len(end) == 0 {
+		return bytes.Equal(key, start)
+	}
+	return bytes.Compare(key, start) >= 0 && (len(end) == 1 && end[0] == 0 || bytes.Compare(key, end) < 0)
+}
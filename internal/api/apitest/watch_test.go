@@ -0,0 +1,90 @@
+package apitest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// nextEvents returns the events of the next response on wch, as
+// "PUT /a=1" or "DELETE /a".
+func nextEvents(t *testing.T, wch clientv3.WatchChan) []string {
+	t.Helper()
+	select {
+	case wresp, ok := <-wch:
+		if !ok {
+			t.Fatal("watch closed")
+		}
+		if err := wresp.Err(); err != nil {
+			t.Fatal(err)
+		}
+		events := []string{}
+		for _, ev := range wresp.Events {
+			s := fmt.Sprintf("%s %s", ev.Type, ev.Kv.Key)
+			if ev.Type == clientv3.EventTypePut {
+				s += "=" + string(ev.Kv.Value)
+			}
+			if ev.PrevKv != nil {
+				s += " was " + string(ev.PrevKv.Value)
+			}
+			events = append(events, s)
+		}
+		return events
+	case <-time.After(5 * time.Second):
+		t.Fatal("no watch response")
+		return nil
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	store := NewStore()
+	kv := store.KV()
+	watcher := store.Watcher()
+	defer watcher.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kv.Put(ctx, "/a/x", "1")
+	prefix := watcher.Watch(ctx, "/a/", clientv3.WithPrefix(), clientv3.WithPrevKV())
+	past := watcher.Watch(ctx, "/a/x", clientv3.WithRev(2))
+	if got := nextEvents(t, past); !reflect.DeepEqual(got, []string{"PUT /a/x=1"}) {
+		t.Errorf("got past events %v", got)
+	}
+
+	kv.Put(ctx, "/b", "1")
+	kv.Txn(ctx).Then(clientv3.OpPut("/a/x", "2"), clientv3.OpPut("/a/y", "1")).Commit()
+	if got, want := nextEvents(t, prefix), []string{"PUT /a/x=2 was 1", "PUT /a/y=1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := nextEvents(t, past); !reflect.DeepEqual(got, []string{"PUT /a/x=2"}) {
+		t.Errorf("got %v without previous values", got)
+	}
+	kv.Delete(ctx, "/a/", clientv3.WithPrefix())
+	if got, want := nextEvents(t, prefix), []string{"DELETE /a/x was 2", "DELETE /a/y was 1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Progress requests answer with the current revision.
+	if err := watcher.RequestProgress(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case wresp := <-prefix:
+		if !wresp.IsProgressNotify() || wresp.Header.Revision != store.Revision() {
+			t.Errorf("got %+v, want progress at %d", wresp, store.Revision())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no progress notification")
+	}
+
+	// Watches starting before the compaction revision are cancelled.
+	kv.Compact(ctx, store.Revision())
+	wresp := <-watcher.Watch(ctx, "/a/", clientv3.WithPrefix(), clientv3.WithRev(2))
+	if wresp.CompactRevision != store.Revision() {
+		t.Errorf("got %+v, want compaction at %d", wresp, store.Revision())
+	}
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// s3PresignExpiry is how long a download link to an S3 artifact is valid.
+const s3PresignExpiry = 15 * time.Minute
+
+var errArtifactNotFound = errors.New("artifact not found")
+
+// ArtifactStore keeps files produced by jobs until they are downloaded.
+type ArtifactStore interface {
+	// Save moves the finished file at path into the store as name.
+	Save(ctx context.Context, name, path string) error
+	// Serve responds with the artifact, honoring Range requests so that
+	// interrupted downloads can resume.
+	Serve(c *gin.Context, name string) error
+}
+
+// DiskArtifacts stores artifacts in a local directory. They can only be
+// downloaded from the replica that produced them; use S3 with several
+// replicas.
+type DiskArtifacts struct {
+	dir string
+}
+
+// NewDiskArtifacts creates a store in dir, creating it if needed.
+func NewDiskArtifacts(dir string) (*DiskArtifacts, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DiskArtifacts{dir: dir}, nil
+}
+
+// Save moves path into the store and removes artifacts older than the job
+// retention period, whose jobs are gone.
+func (d *DiskArtifacts) Save(ctx context.Context, name, path string) error {
+	if err := moveFile(path, filepath.Join(d.dir, name)); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > jobRetention {
+			os.Remove(filepath.Join(d.dir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// moveFile renames src to dst, copying when they are on different devices.
+func moveFile(src, dst string) error {
+	if os.Rename(src, dst) == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Serve sends the file. http.ServeContent handles Range and If-Range, and
+// the modification time serves as the validator for resumed downloads.
+func (d *DiskArtifacts) Serve(c *gin.Context, name string) error {
+	f, err := os.Open(filepath.Join(d.dir, name))
+	if os.IsNotExist(err) {
+		return errArtifactNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), f)
+	return nil
+}
+
+// S3Config locates the bucket artifacts are stored in. Endpoint is only
+// needed for S3-compatible stores such as MinIO, which are addressed
+// path-style.
+type S3Config struct {
+	Bucket       string
+	Region       string
+	Endpoint     string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// S3Artifacts stores artifacts in an S3 bucket. Downloads are redirected to
+// a presigned URL, so S3 serves Range requests itself. Expire old artifacts
+// with a bucket lifecycle rule.
+type S3Artifacts struct {
+	cfg  S3Config
+	http *http.Client
+}
+
+// NewS3Artifacts creates a store for cfg.
+func NewS3Artifacts(cfg S3Config) *S3Artifacts {
+	return &S3Artifacts{cfg: cfg, http: &http.Client{Timeout: 30 * time.Minute}}
+}
+
+// objectURL returns the URL of the object for name.
+func (s *S3Artifacts) objectURL(name string) *url.URL {
+	key := s3Escape(s.cfg.Prefix + name)
+	if s.cfg.Endpoint != "" {
+		u, _ := url.Parse(strings.TrimSuffix(s.cfg.Endpoint, "/"))
+		u.Path += "/" + s.cfg.Bucket + "/" + key
+		u.RawPath = u.Path
+		return u
+	}
+	return &url.URL{Scheme: "https", Host: s.cfg.Bucket + ".s3." + s.cfg.Region + ".amazonaws.com", Path: "/" + key, RawPath: "/" + key}
+}
+
+// Save uploads path with a single PUT, which S3 accepts up to 5 GiB.
+func (s *S3Artifacts) Save(ctx context.Context, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	defer os.Remove(path)
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	u := s.objectURL(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	s.sign(req, u, time.Now())
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 PUT %s: %s: %s", name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Serve redirects to a presigned download URL.
+func (s *S3Artifacts) Serve(c *gin.Context, name string) error {
+	c.Redirect(http.StatusTemporaryRedirect, s.presign(s.objectURL(name), time.Now(), s3PresignExpiry))
+	return nil
+}
+
+// s3Escape percent-encodes a key as SigV4 requires, leaving "/" alone.
+func s3Escape(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signature computes the SigV4 signature of a canonical request.
+func (s *S3Artifacts) signature(now time.Time, canonical string) (scope, sig string) {
+	date := now.UTC().Format("20060102")
+	scope = date + "/" + s.cfg.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + now.UTC().Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// sign adds SigV4 authorization headers to req. The body is not hashed.
+func (s *S3Artifacts) sign(req *http.Request, u *url.URL, now time.Time) {
+	headers := map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": "UNSIGNED-PAYLOAD",
+		"x-amz-date":           now.UTC().Format("20060102T150405Z"),
+	}
+	if s.cfg.SessionToken != "" {
+		headers["x-amz-security-token"] = s.cfg.SessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+		if name != "host" {
+			req.Header.Set(name, headers[name])
+		}
+	}
+	signed := strings.Join(names, ";")
+	canonical := req.Method + "\n" + u.EscapedPath() + "\n\n" + canonicalHeaders.String() + "\n" + signed + "\nUNSIGNED-PAYLOAD"
+	scope, sig := s.signature(now, canonical)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+sig)
+}
+
+// presign returns a GET URL for u valid for expiry.
+func (s *S3Artifacts) presign(u *url.URL, now time.Time, expiry time.Duration) string {
+	date := now.UTC().Format("20060102")
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.cfg.AccessKey + "/" + date + "/" + s.cfg.Region + "/s3/aws4_request"},
+		"X-Amz-Date":          {now.UTC().Format("20060102T150405Z")},
+		"X-Amz-Expires":       {fmt.Sprint(int(expiry.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if s.cfg.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+	// url.Values.Encode sorts by key but encodes spaces as "+", which SigV4
+	// does not accept.
+	encoded := strings.ReplaceAll(query.Encode(), "+", "%20")
+	canonical := "GET\n" + u.EscapedPath() + "\n" + encoded + "\nhost:" + u.Host + "\n\nhost\nUNSIGNED-PAYLOAD"
+	_, sig := s.signature(now, canonical)
+	signedURL := *u
+	signedURL.RawQuery = encoded + "&X-Amz-Signature=" + sig
+	return signedURL.String()
+}
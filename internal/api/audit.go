@@ -0,0 +1,349 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/peer"
+)
+
+// auditPrefix holds the audit log. Like the trash, entries are named by time
+// so they list oldest first and expire with a single range delete.
+const auditPrefix = "/.audit/"
+
+// actorHeader names the user authenticated by the fronting proxy.
+const actorHeader = "X-Forwarded-User"
+
+var errAuditNotFound = errors.New("audit entry not found")
+
+// auditSeq disambiguates entries recorded within the same nanosecond.
+var auditSeq uint32
+
+// putChange describes a put at revision that replaced prev, if any.
+func putChange(key string, prev *mvccpb.KeyValue, revision int64) AuditChange {
+	change := AuditChange{Key: key, Revision: revision}
+	if prev != nil {
+		before := toKeyValue(prev, nil)
+		change.Before = &before
+	}
+	return change
+}
+
+// deleteChanges describes the deletion of prevKvs.
+func deleteChanges(prevKvs []*mvccpb.KeyValue) []AuditChange {
+	changes := make([]AuditChange, len(prevKvs))
+	for i, kv := range prevKvs {
+		before := toKeyValue(kv, nil)
+		changes[i] = AuditChange{Key: string(kv.Key), Before: &before, Deleted: true}
+	}
+	return changes
+}
+
+// requestActor identifies who made an HTTP request: the user
+// IdentityTrust.Middleware found the fronting proxy authenticated, otherwise
+// the client address.
+func requestActor(c *gin.Context) string {
+	if actor := c.GetString(actorKey); actor != "" {
+		return actor
+	}
+	return c.RemoteIP()
+}
+
+// rpcActor is the gRPC counterpart of requestActor, preferring the user
+// the call authenticated as.
+func rpcActor(ctx context.Context) string {
+	if user, ok := rpcUser(ctx); ok {
+		return user
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// AuditLog stores a record of gateway writes in etcd, with before-images so
+// that individual writes can be undone. Entries older than the retention
+// period are purged.
+type AuditLog struct {
+	client    clientv3.KV
+	logger    *zap.Logger
+	retention time.Duration
+	// now dates entries and the purge cutoff.
+	now func() time.Time
+
+	mu          sync.RWMutex
+	subscribers []func(AuditEntry)
+}
+
+// NewAuditLog creates an audit log; call Run to start purging expired entries.
+func NewAuditLog(client clientv3.KV, logger *zap.Logger, retention time.Duration) *AuditLog {
+	return &AuditLog{client: client, logger: logger, retention: retention, now: time.Now}
+}
+
+// Subscribe registers fn to be called with every entry recorded by this
+// gateway. Subscribe is a no-op on a nil log.
+func (a *AuditLog) Subscribe(fn func(AuditEntry)) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.subscribers = append(a.subscribers, fn)
+}
+
+// Record stores an entry for a write that has already been applied. Failures
+// are logged rather than returned, since the write cannot be taken back.
+// Record is a no-op on a nil log or an entry without changes.
+func (a *AuditLog) Record(ctx context.Context, actor, action string, changes []AuditChange) {
+	if a == nil || len(changes) == 0 {
+		return
+	}
+	now := a.now()
+	entry := AuditEntry{
+		ID:      fmt.Sprintf("%019d-%04x", now.UnixNano(), uint16(atomic.AddUint32(&auditSeq, 1))),
+		Time:    now,
+		Actor:   actor,
+		Action:  action,
+		Changes: changes,
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		_, err = a.client.Put(ctx, auditPrefix+entry.ID, string(data))
+	}
+	if err != nil {
+		a.logger.Warn("Cannot record audit entry", zap.String("action", action), zap.String("key", changes[0].Key), zap.Error(err))
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, fn := range a.subscribers {
+		fn(entry)
+	}
+}
+
+// Get returns a single entry.
+func (a *AuditLog) Get(ctx context.Context, id string) (AuditEntry, error) {
+	var entry AuditEntry
+	resp, err := a.client.Get(ctx, auditPrefix+id)
+	if err != nil {
+		return entry, err
+	}
+	if len(resp.Kvs) == 0 {
+		return entry, errAuditNotFound
+	}
+	err = json.Unmarshal(resp.Kvs[0].Value, &entry)
+	return entry, err
+}
+
+// List returns up to limit entries that changed a key under prefix, newest
+// first, starting after the entry from names, if any. Entries are read a
+// page at a time from from's revision, and the token to continue from is
+// returned when there may be more.
+func (a *AuditLog) List(ctx context.Context, prefix string, limit int, from continueToken) ([]AuditEntry, string, error) {
+	// Keys are times, so descending key order is newest first and the range
+	// ends, exclusively, at the last entry seen.
+	end := clientv3.GetPrefixRangeEnd(auditPrefix)
+	if from.Key != "" {
+		end = from.Key
+	}
+	revision := from.Revision
+	entries := []AuditEntry{}
+	for {
+		opts := []clientv3.OpOption{
+			clientv3.WithRange(end),
+			clientv3.WithLimit(int64(limit)),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend),
+		}
+		if revision > 0 {
+			opts = append(opts, clientv3.WithRev(revision))
+		}
+		resp, err := a.client.Get(ctx, auditPrefix, opts...)
+		if err != nil {
+			return nil, "", err
+		}
+		if revision == 0 {
+			revision = resp.Header.Revision
+		}
+		for i, kv := range resp.Kvs {
+			end = string(kv.Key)
+			var entry AuditEntry
+			if err := json.Unmarshal(kv.Value, &entry); err != nil {
+				return nil, "", err
+			}
+			for _, change := range entry.Changes {
+				if strings.HasPrefix(change.Key, prefix) {
+					entries = append(entries, entry)
+					break
+				}
+			}
+			if len(entries) == limit {
+				if i == len(resp.Kvs)-1 && !resp.More {
+					return entries, "", nil
+				}
+				return entries, encodeContinueToken(continueToken{Key: end, Revision: revision}), nil
+			}
+		}
+		if !resp.More {
+			return entries, "", nil
+		}
+	}
+}
+
+// Undo reverts the changes of an entry, restoring before-images and deleting
+// keys the write created. Keys changed again since the entry are left alone
+// and reported as a conflict unless force is set. Changes are applied in
+// transactions of up to maxTxnOps keys.
+func (a *AuditLog) Undo(ctx context.Context, entry AuditEntry, force bool) ([]AuditChange, error) {
+	var undone []AuditChange
+	for start := 0; start < len(entry.Changes); start += maxTxnOps {
+		end := start + maxTxnOps
+		if end > len(entry.Changes) {
+			end = len(entry.Changes)
+		}
+		var cmps []clientv3.Cmp
+		var ops []clientv3.Op
+		for _, change := range entry.Changes[start:end] {
+			switch {
+			case force:
+			case change.Deleted:
+				cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(change.Key), "=", 0))
+			default:
+				cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(change.Key), "=", change.Revision))
+			}
+			if change.Before != nil {
+				ops = append(ops, clientv3.OpPut(change.Key, change.Before.Value, clientv3.WithPrevKV()))
+			} else {
+				ops = append(ops, clientv3.OpDelete(change.Key, clientv3.WithPrevKV()))
+			}
+		}
+		txn, err := guardedTxn(a.client.Txn(ctx), cmps, ops...)
+		if err != nil {
+			return undone, err
+		}
+		for i, op := range txn.Responses {
+			key := entry.Changes[start+i].Key
+			if put := op.GetResponsePut(); put != nil {
+				undone = append(undone, putChange(key, put.PrevKv, txn.Header.Revision))
+			} else {
+				undone = append(undone, deleteChanges(op.GetResponseDeleteRange().PrevKvs)...)
+			}
+		}
+	}
+	return undone, nil
+}
+
+// Run purges expired entries until ctx is cancelled.
+func (a *AuditLog) Run(ctx context.Context) {
+	interval := a.retention / 10
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cutoff := auditPrefix + fmt.Sprintf("%019d", a.now().Add(-a.retention).UnixNano())
+		if _, err := a.client.Delete(ctx, auditPrefix, clientv3.WithRange(cutoff)); err != nil && ctx.Err() == nil {
+			a.logger.Warn("Cannot purge audit log", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// AuditListHandler lists recent writes, optionally only those touching keys
+// under ?prefix=, newest first. Pages of ?limit= entries are continued with
+// the continue token of the previous page.
+func AuditListHandler(audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if audit == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Audit log is not enabled"})
+			return
+		}
+		limit := defaultSearchLimit
+		if s := c.Query("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+		}
+		var from continueToken
+		if s := c.Query("continue"); s != "" {
+			var err error
+			if from, err = decodeContinueToken(s); err != nil || !strings.HasPrefix(from.Key, auditPrefix) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid continue token"})
+				return
+			}
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		entries, next, err := audit.List(ctx, c.Query("prefix"), limit, from)
+		if errors.Is(err, rpctypes.ErrCompacted) {
+			c.JSON(http.StatusGone, gin.H{"error": "Continue token expired, restart the listing"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error listing audit log", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		body := gin.H{"entries": entries}
+		if next != "" {
+			body["continue"] = next
+		}
+		c.JSON(http.StatusOK, body)
+	}
+}
+
+// UndoHandler reverts the write recorded by audit entry :id. With ?force=true
+// keys changed since are reverted too. The undo is itself audited.
+func UndoHandler(audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if audit == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Audit log is not enabled"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		entry, err := audit.Get(ctx, c.Param("id"))
+		if errors.Is(err, errAuditNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Audit entry not found"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error reading audit log", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		undone, err := audit.Undo(ctx, entry, c.Query("force") == "true")
+		audit.Record(ctx, requestActor(c), "undo:"+entry.ID, undone)
+		if errors.Is(err, errConflict) {
+			conflictResponse(c, err, gin.H{"error": "Keys have changed since this write, use force to undo anyway", "undone": len(undone)})
+			return
+		}
+		if err != nil {
+			logger.Error("Error undoing write", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"undone": entry.ID, "changes": undone})
+	}
+}
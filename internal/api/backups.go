@@ -0,0 +1,294 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// backupTimeFormat is the timestamp in snapshot names, which sort by time.
+const backupTimeFormat = "20060102T150405Z"
+
+// BackupRetention says which verified snapshots to keep: the newest Last,
+// the newest of each of the newest Daily days, and the newest of each of
+// the newest Weekly ISO weeks. A snapshot kept by any rule is kept.
+type BackupRetention struct {
+	Last   int
+	Daily  int
+	Weekly int
+}
+
+// Backups keeps etcd snapshots in a directory. Each snapshot is verified
+// once written and the outcome saved next to it as <name>.json.
+type Backups struct {
+	dir       string
+	retention BackupRetention
+	logger    *zap.Logger
+}
+
+// NewBackups creates a Backups for dir.
+func NewBackups(dir string, retention BackupRetention, logger *zap.Logger) *Backups {
+	return &Backups{dir: dir, retention: retention, logger: logger}
+}
+
+// Task writes a snapshot on the leading replica, verifies it and any
+// snapshots not yet verified, and prunes by the retention policy.
+func (b *Backups) Task(client *clientv3.Client, interval time.Duration) ScheduledTask {
+	return ScheduledTask{Name: "backup", Interval: interval, Run: func(ctx context.Context) error {
+		name, err := b.save(ctx, client)
+		if err != nil {
+			return err
+		}
+		points, err := b.List()
+		if err != nil {
+			return err
+		}
+		var failed error
+		for i, point := range points {
+			if point.VerifiedAt != nil {
+				continue
+			}
+			points[i] = b.verify(point)
+			if !points[i].Verified {
+				b.logger.Warn("Backup failed verification", zap.String("backup", point.Name), zap.String("error", points[i].Error))
+				if point.Name == name {
+					failed = fmt.Errorf("backup %s failed verification: %s", name, points[i].Error)
+				}
+			}
+		}
+		if err := b.prune(points, name); err != nil {
+			return err
+		}
+		return failed
+	}}
+}
+
+// save streams a snapshot to a temporary file and renames it into place,
+// returning its name.
+func (b *Backups) save(ctx context.Context, client *clientv3.Client) (string, error) {
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return "", err
+	}
+	snapshot, err := client.Snapshot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer snapshot.Close()
+	name := "etcd-" + time.Now().UTC().Format(backupTimeFormat) + ".db"
+	f, err := os.CreateTemp(b.dir, ".partial-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, snapshot); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return name, os.Rename(f.Name(), filepath.Join(b.dir, name))
+}
+
+// List returns the snapshots in the directory, newest first.
+func (b *Backups) List() ([]RestorePoint, error) {
+	matches, err := filepath.Glob(filepath.Join(b.dir, "etcd-*.db"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+	points := make([]RestorePoint, 0, len(matches))
+	for _, file := range matches {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		point := RestorePoint{}
+		if data, err := os.ReadFile(file + ".json"); err == nil {
+			json.Unmarshal(data, &point)
+		}
+		point.Name = filepath.Base(file)
+		point.Size = info.Size()
+		t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(point.Name, "etcd-"), ".db"))
+		if err != nil {
+			t = info.ModTime()
+		}
+		point.Time = t.UTC()
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// verify checks the checksum etcd appends to snapshots, then opens the
+// database as a restore would, checking its pages and decoding every key.
+// The outcome is saved next to the snapshot.
+func (b *Backups) verify(point RestorePoint) RestorePoint {
+	file := filepath.Join(b.dir, point.Name)
+	sum, err := checkSnapshotHash(file)
+	if err == nil {
+		point.SHA256 = sum
+		point.Revision, point.Keys, err = checkSnapshot(file)
+	}
+	now := time.Now().UTC()
+	point.Verified, point.VerifiedAt, point.Error = err == nil, &now, ""
+	if err != nil {
+		point.Error = err.Error()
+	}
+	if data, err := json.Marshal(point); err == nil {
+		if err := writeFileAtomic(file+".json", data); err != nil {
+			b.logger.Warn("Cannot save backup verification", zap.String("backup", point.Name), zap.Error(err))
+		}
+	}
+	return point
+}
+
+// checkSnapshotHash compares the SHA-256 etcd appends to a snapshot with
+// the database before it, returning the SHA-256 of the whole file.
+func checkSnapshotHash(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Size() < sha256.Size {
+		return "", errors.New("snapshot is truncated")
+	}
+	db, whole := sha256.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(db, whole), io.LimitReader(f, info.Size()-sha256.Size)); err != nil {
+		return "", err
+	}
+	trailer := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(f, trailer); err != nil {
+		return "", err
+	}
+	whole.Write(trailer)
+	if !bytes.Equal(db.Sum(nil), trailer) {
+		return "", errors.New("snapshot checksum does not match")
+	}
+	return hex.EncodeToString(whole.Sum(nil)), nil
+}
+
+// checkSnapshot runs bolt's consistency check on a snapshot and replays its
+// keys, returning its revision and number of live keys.
+func checkSnapshot(file string) (int64, int64, error) {
+	db, err := openSnapshot(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+	var revision int64
+	live := make(map[string]bool)
+	err = db.View(func(tx *bolt.Tx) error {
+		var corrupt error
+		// Drain the channel so the check finishes before the transaction.
+		for err := range tx.Check() {
+			if corrupt == nil {
+				corrupt = fmt.Errorf("corrupt database: %w", err)
+			}
+		}
+		if corrupt != nil {
+			return corrupt
+		}
+		return replaySnapshot(tx, func(rev int64, kv *mvccpb.KeyValue, deleted bool) {
+			revision = rev
+			if deleted {
+				delete(live, string(kv.Key))
+			} else {
+				live[string(kv.Key)] = true
+			}
+		})
+	})
+	return revision, int64(len(live)), err
+}
+
+// prune removes the snapshots the retention policy does not keep. Only
+// verified snapshots count; failed ones are removed, except the one just
+// written, which is kept for inspection until the next run.
+func (b *Backups) prune(points []RestorePoint, latest string) error {
+	keep := make(map[string]bool)
+	days, weeks := make(map[string]bool), make(map[string]bool)
+	last := 0
+	for _, point := range points {
+		if !point.Verified {
+			continue
+		}
+		if last < b.retention.Last {
+			keep[point.Name] = true
+			last++
+		}
+		if day := point.Time.Format("2006-01-02"); len(days) < b.retention.Daily && !days[day] {
+			keep[point.Name], days[day] = true, true
+		}
+		year, week := point.Time.ISOWeek()
+		if key := fmt.Sprintf("%d-W%02d", year, week); len(weeks) < b.retention.Weekly && !weeks[key] {
+			keep[point.Name], weeks[key] = true, true
+		}
+	}
+	for _, point := range points {
+		if keep[point.Name] || point.Name == latest {
+			continue
+		}
+		file := filepath.Join(b.dir, point.Name)
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+		os.Remove(file + ".json")
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to name.
+func writeFileAtomic(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".partial-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// BackupListHandler lists the snapshots on this replica, newest first,
+// with their verification results.
+func BackupListHandler(backups *Backups, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if backups == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Backups are not enabled"})
+			return
+		}
+		points, err := backups.List()
+		if err != nil {
+			logger.Error("Error listing backups", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, points)
+	}
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// maxTxnOps is etcd's default --max-txn-ops; larger transactions are rejected by the server.
+const maxTxnOps = 128
+
+// BatchGetHandler reads every requested key and prefix in a single etcd
+// transaction, so the results are consistent with one another.
+func BatchGetHandler(client clientv3.KV, clock *RevisionClock, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BatchGetRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		total := len(req.Keys) + len(req.Prefixes)
+		if total == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one key or prefix is required"})
+			return
+		}
+		if total > maxTxnOps {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Too many keys and prefixes in one batch", "max": maxTxnOps})
+			return
+		}
+
+		ops := make([]clientv3.Op, 0, total)
+		for _, key := range req.Keys {
+			ops = append(ops, clientv3.OpGet(key))
+		}
+		for _, prefix := range req.Prefixes {
+			ops = append(ops, clientv3.OpGet(prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend)))
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		kvs := []KeyValue{}
+		missing := []string{}
+		for i, r := range resp.Responses {
+			rr := r.GetResponseRange()
+			if i < len(req.Keys) && len(rr.Kvs) == 0 {
+				missing = append(missing, req.Keys[i])
+				continue
+			}
+			for _, kv := range rr.Kvs {
+				kvs = append(kvs, toKeyValue(kv, clock))
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"kvs": kvs, "missing": missing, "revision": resp.Header.Revision})
+	}
+}
+
+// BatchPutHandler writes a set of keys in one transaction, so either all of
+// them change or none do. Batches larger than one transaction are written in
+// chunks of maxTxnOps keys, in the order given: when a chunk fails, the
+// written count says how many leading keys were committed before it, and
+// none after it are.
+func BatchPutHandler(client clientv3.KV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BatchPutRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if len(req.KVs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one key is required"})
+			return
+		}
+		seen := make(map[string]bool, len(req.KVs))
+		for _, kv := range req.KVs {
+			switch {
+			case !strings.HasPrefix(kv.Key, "/"):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Keys must start with /"})
+				return
+			case reservedPrefix(kv.Key):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Keys must not be the root or under a gateway prefix", "key": kv.Key})
+				return
+			case seen[kv.Key]:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Keys must not repeat", "key": kv.Key})
+				return
+			}
+			seen[kv.Key] = true
+		}
+
+		ctx, cancel := longRequestContext(c, 30*time.Second)
+		defer cancel()
+
+		var changes []AuditChange
+		var rev int64
+		var err error
+		chunks := 0
+		for start := 0; start < len(req.KVs) && err == nil; start += maxTxnOps {
+			end := start + maxTxnOps
+			if end > len(req.KVs) {
+				end = len(req.KVs)
+			}
+			var cmps []clientv3.Cmp
+			ops := make([]clientv3.Op, 0, end-start)
+			for _, kv := range req.KVs[start:end] {
+				if kv.ModRevision != nil {
+					cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(kv.Key), "=", *kv.ModRevision))
+				}
+				ops = append(ops, clientv3.OpPut(kv.Key, kv.Value, clientv3.WithPrevKV()))
+			}
+			var txn *clientv3.TxnResponse
+			if txn, err = guardedTxn(client.Txn(ctx), cmps, ops...); err == nil {
+				for i, r := range txn.Responses {
+					changes = append(changes, putChange(req.KVs[start+i].Key, r.GetResponsePut().PrevKv, txn.Header.Revision))
+				}
+				rev = txn.Header.Revision
+				chunks++
+			}
+		}
+		audit.Record(ctx, requestActor(c), "batch", changes)
+		switch {
+		case errors.Is(err, errConflict):
+			conflictResponse(c, err, gin.H{"error": "Keys have changed since they were read", "written": len(changes)})
+		case err != nil:
+			logger.Error("Error writing keys to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error", "written": len(changes)})
+		default:
+			c.JSON(http.StatusOK, gin.H{"written": len(changes), "revision": rev, "transactions": chunks})
+		}
+	}
+}
+
+// Statuses of the keys of a batch delete.
+const (
+	batchDeleted  = "deleted"
+	batchNotFound = "notFound"
+)
+
+// BatchDeleteHandler deletes a list of keys, not prefixes, in one
+// transaction and reports for each whether it was deleted or not found.
+// When a trash is configured the keys are moved there instead, which takes
+// two operations per key and so halves the batch size.
+func BatchDeleteHandler(client clientv3.KV, trash *Trash, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BatchDeleteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if len(req.Keys) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one key is required"})
+			return
+		}
+		limit := maxTxnOps
+		if trash != nil {
+			limit = maxTxnOps / 2
+		}
+		if len(req.Keys) > limit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Too many keys in one batch", "max": limit})
+			return
+		}
+		seen := make(map[string]bool, len(req.Keys))
+		for _, key := range req.Keys {
+			switch {
+			case !strings.HasPrefix(key, "/"):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Keys must start with /"})
+				return
+			case reservedPrefix(key):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Keys must not be the root or under a gateway prefix", "key": key})
+				return
+			case seen[key]:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Keys must not repeat", "key": key})
+				return
+			}
+			seen[key] = true
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		var deleted []*mvccpb.KeyValue
+		var err error
+		if trash != nil {
+			deleted, err = trash.DeleteKeys(ctx, req.Keys)
+		} else {
+			ops := make([]clientv3.Op, len(req.Keys))
+			for i, key := range req.Keys {
+				ops[i] = clientv3.OpDelete(key, clientv3.WithPrevKV())
+			}
+			var txn *clientv3.TxnResponse
+			if txn, err = client.Txn(ctx).Then(ops...).Commit(); err == nil {
+				for _, r := range txn.Responses {
+					deleted = append(deleted, r.GetResponseDeleteRange().PrevKvs...)
+				}
+			}
+		}
+		if errors.Is(err, errConflict) {
+			conflictResponse(c, err, gin.H{"error": "Keys were modified during the delete, retry"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error deleting keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		audit.Record(ctx, requestActor(c), "delete", deleteChanges(deleted))
+
+		found := make(map[string]bool, len(deleted))
+		for _, kv := range deleted {
+			found[string(kv.Key)] = true
+		}
+		results := make([]BatchDeleteResult, len(req.Keys))
+		for i, key := range req.Keys {
+			results[i] = BatchDeleteResult{Key: key, Status: batchNotFound}
+			if found[key] {
+				results[i].Status = batchDeleted
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"results": results, "deleted": len(deleted), "trashed": trash != nil})
+	}
+}
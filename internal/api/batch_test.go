@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"etcd-gateway/internal/api/apitest"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+func TestBatchPut(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		// wantKeys is every key afterwards, with its value.
+		wantKeys map[string]string
+	}{
+		{"writes", `{"kvs":[{"key":"/a","value":"2"},{"key":"/b","value":"3"}]}`, 200, map[string]string{"/a": "2", "/b": "3"}},
+		{"matching revision", `{"kvs":[{"key":"/a","value":"2","modRevision":2},{"key":"/b","value":"3"}]}`, 200, map[string]string{"/a": "2", "/b": "3"}},
+		{"stale revision", `{"kvs":[{"key":"/a","value":"2","modRevision":1},{"key":"/b","value":"3"}]}`, 409, map[string]string{"/a": "1"}},
+		{"empty", `{"kvs":[]}`, 400, map[string]string{"/a": "1"}},
+		{"relative key", `{"kvs":[{"key":"a","value":"2"}]}`, 400, map[string]string{"/a": "1"}},
+		{"reserved key", `{"kvs":[{"key":"/b","value":"3"},{"key":"/.trash/x","value":"2"}]}`, 400, map[string]string{"/a": "1"}},
+		{"repeated key", `{"kvs":[{"key":"/a","value":"2"},{"key":"/a","value":"3"}]}`, 400, map[string]string{"/a": "1"}},
+		{"invalid body", `[`, 400, map[string]string{"/a": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := apitest.NewStore()
+			if _, err := store.KV().Put(context.Background(), "/a", "1"); err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest("POST", "/batch/put", strings.NewReader(tt.body))
+			rec := apitest.Serve("/batch/put", req, BatchPutHandler(store.KV(), nil, zap.NewNop()))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			resp, err := store.KV().Get(context.Background(), "/", clientv3.WithPrefix())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, kv := range resp.Kvs {
+				got[string(kv.Key)] = string(kv.Value)
+			}
+			if !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("got keys %v, want %v", got, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestBatchGet(t *testing.T) {
+	store := apitest.NewStore()
+	for _, key := range []string{"/a", "/p/x", "/p/y"} {
+		if _, err := store.KV().Put(context.Background(), key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name        string
+		body        string
+		wantCode    int
+		wantKeys    []string
+		wantMissing []string
+	}{
+		{"keys and prefixes", `{"keys":["/a","/b"],"prefixes":["/p/"]}`, 200, []string{"/a", "/p/x", "/p/y"}, []string{"/b"}},
+		{"empty prefix", `{"prefixes":["/q/"]}`, 200, []string{}, []string{}},
+		{"nothing", `{}`, 400, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/batch/get", strings.NewReader(tt.body))
+			rec := apitest.Serve("/batch/get", req, BatchGetHandler(store.KV(), nil, zap.NewNop()))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != 200 {
+				return
+			}
+			var resp struct {
+				KVs      []KeyValue `json:"kvs"`
+				Missing  []string   `json:"missing"`
+				Revision int64      `json:"revision"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			keys := []string{}
+			for _, kv := range resp.KVs {
+				keys = append(keys, kv.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.wantKeys) || !reflect.DeepEqual(resp.Missing, tt.wantMissing) {
+				t.Errorf("got keys %v missing %v, want %v missing %v", keys, resp.Missing, tt.wantKeys, tt.wantMissing)
+			}
+			if resp.Revision != store.Revision() {
+				t.Errorf("got revision %d, want %d", resp.Revision, store.Revision())
+			}
+		})
+	}
+}
+
+func TestBatchDelete(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		trash       bool
+		wantCode    int
+		wantResults []BatchDeleteResult
+		wantLeft    []string
+	}{
+		{"deletes", `{"keys":["/a","/missing"]}`, false, 200, []BatchDeleteResult{{Key: "/a", Status: batchDeleted}, {Key: "/missing", Status: batchNotFound}}, []string{"/b"}},
+		{"trashes", `{"keys":["/a","/b"]}`, true, 200, []BatchDeleteResult{{Key: "/a", Status: batchDeleted}, {Key: "/b", Status: batchDeleted}}, []string{}},
+		{"reserved key", `{"keys":["/a","/.audit/x"]}`, false, 400, nil, []string{"/a", "/b"}},
+		{"root", `{"keys":["/"]}`, false, 400, nil, []string{"/a", "/b"}},
+		{"repeated key", `{"keys":["/a","/a"]}`, false, 400, nil, []string{"/a", "/b"}},
+		{"no keys", `{"keys":[]}`, false, 400, nil, []string{"/a", "/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := apitest.NewStore()
+			kv := store.KV()
+			for _, key := range []string{"/a", "/b"} {
+				if _, err := kv.Put(context.Background(), key, "v"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var trash *Trash
+			if tt.trash {
+				trash = NewTrash(kv, zap.NewNop(), time.Hour)
+			}
+			req := httptest.NewRequest("POST", "/batch/delete", strings.NewReader(tt.body))
+			rec := apitest.Serve("/batch/delete", req, BatchDeleteHandler(kv, trash, nil, zap.NewNop()))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantResults != nil {
+				var resp struct{ Results []BatchDeleteResult }
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if !reflect.DeepEqual(resp.Results, tt.wantResults) {
+					t.Errorf("got results %+v, want %+v", resp.Results, tt.wantResults)
+				}
+			}
+			// The keys left, leaving out trash entries.
+			left := []string{}
+			resp, _ := kv.Get(context.Background(), "/", clientv3.WithPrefix())
+			for _, kv := range resp.Kvs {
+				if !strings.HasPrefix(string(kv.Key), trashPrefix) {
+					left = append(left, string(kv.Key))
+				}
+			}
+			if !reflect.DeepEqual(left, tt.wantLeft) {
+				t.Errorf("left %v, want %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// cacheProgressInterval is how often KeyspaceCache asks etcd to confirm
+	// that its watch is current.
+	cacheProgressInterval = 5 * time.Second
+	// cacheStaleAfter is how long KeyspaceCache goes unconfirmed before it
+	// is considered cut off from etcd.
+	cacheStaleAfter = 3 * cacheProgressInterval
+)
+
+// KeyspaceCache mirrors the keys under a set of prefixes in memory. It is
+// loaded in one transaction and kept current by a single watch spanning all
+// of the prefixes, so reads served from it are consistent at Revision and
+// keep working through etcd outages, marked with X-Data-Staleness.
+type KeyspaceCache struct {
+	client   WatchKV
+	logger   *zap.Logger
+	prefixes []string
+
+	mu       sync.RWMutex
+	kvs      map[string]*mvccpb.KeyValue
+	keys     []string // sorted
+	revision int64
+	ready    bool
+	// syncedAt is when etcd last confirmed the cache was current: when it
+	// was loaded, or the watch last answered.
+	syncedAt time.Time
+}
+
+// NewKeyspaceCache creates a cache of prefixes; call Run to populate it.
+func NewKeyspaceCache(client WatchKV, logger *zap.Logger, prefixes []string) *KeyspaceCache {
+	return &KeyspaceCache{client: client, logger: logger, prefixes: prefixes}
+}
+
+// covers reports whether every key beginning with prefix lies in a cached prefix.
+func (kc *KeyspaceCache) covers(prefix string) bool {
+	for _, p := range kc.prefixes {
+		if strings.HasPrefix(prefix, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Range returns the cached keys beginning with prefix in key order and the
+// revision they reflect. ok is false when the cache is disabled, not yet
+// loaded, or does not cover prefix; callers then read from etcd instead.
+func (kc *KeyspaceCache) Range(prefix string) (kvs []*mvccpb.KeyValue, revision int64, ok bool) {
+	if kc == nil || !kc.covers(prefix) {
+		return nil, 0, false
+	}
+	kc.mu.RLock()
+	defer kc.mu.RUnlock()
+	if !kc.ready {
+		return nil, 0, false
+	}
+	i := sort.SearchStrings(kc.keys, prefix)
+	for ; i < len(kc.keys) && strings.HasPrefix(kc.keys[i], prefix); i++ {
+		kvs = append(kvs, kc.kvs[kc.keys[i]])
+	}
+	return kvs, kc.revision, true
+}
+
+// Get returns the cached key and the revision it reflects. ok is false
+// when the cache is disabled, not yet loaded, or does not cover key.
+func (kc *KeyspaceCache) Get(key string) (kv *mvccpb.KeyValue, revision int64, ok bool) {
+	if kc == nil || !kc.covers(key) {
+		return nil, 0, false
+	}
+	kc.mu.RLock()
+	defer kc.mu.RUnlock()
+	if !kc.ready {
+		return nil, 0, false
+	}
+	return kc.kvs[key], kc.revision, true
+}
+
+// Staleness returns how long it has been since etcd last confirmed the
+// cache was current. Beyond cacheStaleAfter, etcd is taken to be
+// unreachable.
+func (kc *KeyspaceCache) Staleness() time.Duration {
+	kc.mu.RLock()
+	defer kc.mu.RUnlock()
+	return time.Since(kc.syncedAt)
+}
+
+// setStaleness marks a response served from cache with how many seconds
+// it may be behind etcd, if the cache is cut off or always is true.
+func setStaleness(c *gin.Context, cache *KeyspaceCache, always bool) {
+	if staleness := cache.Staleness(); always || staleness > cacheStaleAfter {
+		c.Header("X-Data-Staleness", strconv.Itoa(int(staleness.Seconds())))
+	}
+}
+
+// staleGet reads key from keyspace in place of etcd when etcd is
+// unreachable: err from etcd says so or, before trying etcd, the cache has
+// gone unconfirmed long enough that etcd would only time out. The response
+// is marked with X-Data-Staleness. kv is nil if the key did not exist.
+func staleGet(c *gin.Context, keyspace *KeyspaceCache, key string, err error) (kv *mvccpb.KeyValue, revision int64, ok bool) {
+	kv, revision, ok = keyspace.Get(key)
+	if !ok {
+		return nil, 0, false
+	}
+	if err != nil && !etcdUnreachable(err) || err == nil && keyspace.Staleness() <= cacheStaleAfter {
+		return nil, 0, false
+	}
+	setStaleness(c, keyspace, true)
+	return kv, revision, true
+}
+
+// etcdUnreachable reports whether err is etcd not answering, rather than
+// answering with an error, so a cached answer is better than none.
+func etcdUnreachable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	code := status.Code(err)
+	return code == codes.Unavailable || code == codes.DeadlineExceeded
+}
+
+// Run loads and watches the cached prefixes until ctx is cancelled,
+// reloading whenever the watch fails (e.g. after compaction).
+func (kc *KeyspaceCache) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		rev, err := kc.load(ctx)
+		if err != nil {
+			kc.logger.Warn("Keyspace cache cannot load", zap.Error(err))
+			time.Sleep(time.Second)
+			continue
+		}
+		kc.watch(ctx, rev)
+	}
+}
+
+func (kc *KeyspaceCache) load(ctx context.Context) (int64, error) {
+	ops := make([]clientv3.Op, len(kc.prefixes))
+	for i, p := range kc.prefixes {
+		ops[i] = clientv3.OpGet(p, clientv3.WithPrefix())
+	}
+	resp, err := kc.client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	kvs := make(map[string]*mvccpb.KeyValue)
+	for _, r := range resp.Responses {
+		for _, kv := range r.GetResponseRange().Kvs {
+			kvs[string(kv.Key)] = kv
+		}
+	}
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kc.mu.Lock()
+	kc.kvs, kc.keys, kc.revision, kc.ready = kvs, keys, resp.Header.Revision, true
+	kc.syncedAt = time.Now()
+	kc.mu.Unlock()
+	kc.logger.Info("Keyspace cache loaded", zap.Int("keys", len(keys)), zap.Int64("revision", resp.Header.Revision))
+	return resp.Header.Revision, nil
+}
+
+// watch follows one watch over the smallest range spanning every cached
+// prefix, ignoring events for keys between them.
+func (kc *KeyspaceCache) watch(ctx context.Context, rev int64) {
+	start, end := kc.prefixes[0], clientv3.GetPrefixRangeEnd(kc.prefixes[0])
+	for _, p := range kc.prefixes[1:] {
+		if p < start {
+			start = p
+		}
+		if e := clientv3.GetPrefixRangeEnd(p); e > end {
+			end = e
+		}
+	}
+
+	// Progress requests go to the watches opened with the same context.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wch := kc.client.Watch(ctx, start, clientv3.WithRange(end), clientv3.WithRev(rev+1), clientv3.WithProgressNotify())
+	go func() {
+		tick := time.NewTicker(cacheProgressInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				// Unanswered while etcd is unreachable, which leaves
+				// syncedAt behind.
+				kc.client.RequestProgress(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for wresp := range wch {
+		if err := wresp.Err(); err != nil {
+			kc.logger.Warn("Keyspace cache watch failed", zap.Error(err))
+			return
+		}
+		kc.mu.Lock()
+		for _, ev := range wresp.Events {
+			key := string(ev.Kv.Key)
+			if !kc.covers(key) {
+				continue
+			}
+			i := sort.SearchStrings(kc.keys, key)
+			exists := i < len(kc.keys) && kc.keys[i] == key
+			switch {
+			case ev.Type == clientv3.EventTypeDelete && exists:
+				kc.keys = append(kc.keys[:i], kc.keys[i+1:]...)
+				delete(kc.kvs, key)
+			case ev.Type == clientv3.EventTypePut:
+				if !exists {
+					kc.keys = append(kc.keys, "")
+					copy(kc.keys[i+1:], kc.keys[i:])
+					kc.keys[i] = key
+				}
+				kc.kvs[key] = ev.Kv
+			}
+		}
+		kc.revision = wresp.Header.Revision
+		kc.syncedAt = time.Now()
+		kc.mu.Unlock()
+	}
+}
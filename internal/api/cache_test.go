@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"etcd-gateway/internal/api/apitest"
+	"go.uber.org/zap"
+)
+
+// waitFor fails the test unless cond becomes true within a few seconds,
+// for state that a watch updates in the background.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKeyspaceCache(t *testing.T) {
+	store := apitest.NewStore()
+	client := store.Client()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client.Put(ctx, "/app/a", "1")
+	client.Put(ctx, "/app/b", "1")
+	client.Put(ctx, "/other/a", "1")
+
+	cache := NewKeyspaceCache(client, zap.NewNop(), []string{"/app/", "/db/"})
+	if _, _, ok := cache.Range("/app/"); ok {
+		t.Fatal("Range answered before the cache was loaded")
+	}
+	go cache.Run(ctx)
+	waitFor(t, "the cache to load", func() bool {
+		_, _, ok := cache.Range("/app/")
+		return ok
+	})
+	if kvs, _, _ := cache.Range("/app/"); len(kvs) != 2 {
+		t.Errorf("got %d keys under /app/, want 2", len(kvs))
+	}
+	if _, _, ok := cache.Get("/other/a"); ok {
+		t.Error("Get answered for a key outside the cached prefixes")
+	}
+
+	client.Put(ctx, "/db/a", "2")
+	client.Put(ctx, "/other/b", "2")
+	client.Delete(ctx, "/app/a")
+	resp, _ := client.Put(ctx, "/app/b", "2")
+	waitFor(t, "the cache to see the writes", func() bool {
+		kv, _, _ := cache.Get("/app/b")
+		return kv != nil && kv.ModRevision == resp.Header.Revision
+	})
+	if kv, _, _ := cache.Get("/db/a"); kv == nil || string(kv.Value) != "2" {
+		t.Errorf("got /db/a %v, want value 2", kv)
+	}
+	if kv, _, _ := cache.Get("/app/a"); kv != nil {
+		t.Errorf("got deleted key /app/a %v", kv)
+	}
+	if kvs, _, _ := cache.Range("/"); kvs != nil {
+		t.Errorf("Range of an uncached prefix returned %d keys", len(kvs))
+	}
+}
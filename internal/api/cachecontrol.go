@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CachePolicy is the Cache-Control header of a route's successful
+// responses, as "METHOD /path/:param", or with Prefix only of those for
+// keys under it.
+type CachePolicy struct {
+	Route  string
+	Prefix string
+	Value  string
+}
+
+// ParseCachePolicies parses "METHOD /path[ /prefix]=directives" entries,
+// where directives are separated by spaces or semicolons since entries are
+// separated by commas, e.g. "GET /api/v1/value/*key /config/=public;max-age=10".
+func ParseCachePolicies(entries []string) ([]CachePolicy, error) {
+	policies := make([]CachePolicy, 0, len(entries))
+	for _, entry := range entries {
+		target, value, ok := strings.Cut(entry, "=")
+		fields := strings.Fields(target)
+		directives := strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ' ' })
+		if !ok || len(fields) < 2 || len(fields) > 3 || !strings.HasPrefix(fields[1], "/") || len(directives) == 0 {
+			return nil, errors.New("invalid cache policy " + strconv.Quote(entry) + ", expected METHOD /path[ /prefix]=directives")
+		}
+		policy := CachePolicy{Route: strings.ToUpper(fields[0]) + " " + fields[1], Value: strings.Join(directives, ", ")}
+		if len(fields) == 3 {
+			if !strings.HasPrefix(fields[2], "/") {
+				return nil, errors.New("invalid prefix in cache policy " + strconv.Quote(entry))
+			}
+			policy.Prefix = fields[2]
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// requestKey is the key or prefix a request is for, as its handler reads it.
+func requestKey(c *gin.Context) string {
+	if key := c.Param("key"); key != "" {
+		return strings.TrimPrefix(key, "/")
+	}
+	if prefix := c.Param("prefix"); prefix != "" {
+		return prefix
+	}
+	return c.Query("prefix")
+}
+
+// cachePolicyFor returns the Cache-Control of the policy for the request's
+// route with the longest prefix the request's key is under, if any.
+func cachePolicyFor(c *gin.Context, policies []CachePolicy) (string, bool) {
+	method := c.Request.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	route := method + " " + c.FullPath()
+	var best *CachePolicy
+	for i, policy := range policies {
+		if policy.Route != route || (best != nil && len(policy.Prefix) <= len(best.Prefix)) {
+			continue
+		}
+		if policy.Prefix != "" && !strings.HasPrefix(requestKey(c), policy.Prefix) {
+			continue
+		}
+		best = &policies[i]
+	}
+	if best == nil {
+		return "", false
+	}
+	return best.Value, true
+}
+
+// cacheControlWriter sets Cache-Control on a successful or not modified
+// response, unless the handler set its own.
+type cacheControlWriter struct {
+	gin.ResponseWriter
+	value   string
+	applied bool
+}
+
+func (w *cacheControlWriter) apply(code int) {
+	if w.applied {
+		return
+	}
+	w.applied = true
+	if code < http.StatusBadRequest && w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", w.value)
+	}
+}
+
+func (w *cacheControlWriter) WriteHeader(code int) {
+	w.apply(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheControlWriter) Write(b []byte) (int, error) {
+	w.apply(w.Status())
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheControlWriter) WriteString(s string) (int, error) {
+	w.apply(w.Status())
+	return w.ResponseWriter.WriteString(s)
+}
+
+// CacheControlMiddleware tells browsers and proxies how long they may cache
+// responses, as the operator's policies say. Errors are left uncached.
+func CacheControlMiddleware(policies []CachePolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value, ok := cachePolicyFor(c, policies); ok {
+			c.Writer = &cacheControlWriter{ResponseWriter: c.Writer, value: value}
+		}
+		c.Next()
+	}
+}
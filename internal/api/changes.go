@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// defaultChangesLimit caps the events returned by one changes request.
+const defaultChangesLimit = 1000
+
+// ChangesHandler returns the events under ?prefix= after revision ?since=, so
+// a client that already holds the state at since can catch up without
+// re-reading the subtree. Clients continue from the returned revision; once
+// since has been compacted the answer is 410 and they must re-read in full.
+func ChangesHandler(client *clientv3.Client, clock *RevisionClock, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		prefix := c.DefaultQuery("prefix", "/")
+		since, err := strconv.ParseInt(c.Query("since"), 10, 64)
+		if err != nil || since < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since revision"})
+			return
+		}
+		limit := defaultChangesLimit
+		if s := c.Query("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		// Replay up to the revision current at the time of the request.
+		head, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		current := head.Header.Revision
+		events := []ChangeEvent{}
+		if since >= current {
+			c.JSON(http.StatusOK, gin.H{"events": events, "revision": current, "more": false})
+			return
+		}
+
+		// Watch the whole keyspace rather than just the prefix: the event at
+		// current is then guaranteed to arrive and marks the end of the replay,
+		// whereas a quiet prefix would give no sign the watch had caught up.
+		wch := client.Watch(ctx, "", clientv3.WithPrefix(), clientv3.WithRev(since+1))
+		for wresp := range wch {
+			if err := wresp.Err(); err != nil {
+				if errors.Is(err, rpctypes.ErrCompacted) {
+					c.JSON(http.StatusGone, gin.H{"error": "Revision has been compacted, re-read the prefix", "compactRevision": wresp.CompactRevision})
+					return
+				}
+				logger.Error("Error watching keys in etcd", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+				return
+			}
+			caughtUp := false
+			for _, ev := range wresp.Events {
+				if ev.Kv.ModRevision > current {
+					caughtUp = true
+					break
+				}
+				caughtUp = ev.Kv.ModRevision == current
+				if !strings.HasPrefix(string(ev.Kv.Key), prefix) {
+					continue
+				}
+				// Events of one transaction share a revision, so only stop
+				// between revisions to keep resumption from the last one exact.
+				if len(events) >= limit && ev.Kv.ModRevision != events[len(events)-1].Revision {
+					c.JSON(http.StatusOK, gin.H{"events": events, "revision": events[len(events)-1].Revision, "more": true})
+					return
+				}
+				events = append(events, ChangeEvent{Type: ev.Type.String(), Kv: toKeyValue(ev.Kv, clock), Revision: ev.Kv.ModRevision})
+			}
+			if caughtUp {
+				c.JSON(http.StatusOK, gin.H{"events": events, "revision": current, "more": false})
+				return
+			}
+		}
+		logger.Error("Error watching keys in etcd", zap.Error(ctx.Err()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+	}
+}
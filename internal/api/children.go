@@ -0,0 +1,134 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// childrenOf groups the keys beneath dir, which must be in key order, into
+// dir's immediate children.
+func childrenOf(dir string, kvs []*mvccpb.KeyValue) []*ChildNode {
+	children := []*ChildNode{}
+	index := make(map[string]*ChildNode)
+	grandchildren := make(map[string]map[string]bool)
+	for _, kv := range kvs {
+		rest := strings.TrimPrefix(string(kv.Key), dir)
+		name, below, nested := strings.Cut(rest, "/")
+		child, ok := index[name]
+		if !ok {
+			child = &ChildNode{ID: dir + name, Name: name}
+			index[name] = child
+			grandchildren[name] = make(map[string]bool)
+			children = append(children, child)
+		}
+		if !nested {
+			child.HasValue = true
+			continue
+		}
+		grandchild, _, _ := strings.Cut(below, "/")
+		grandchildren[name][grandchild] = true
+	}
+
+	for _, child := range children {
+		child.ChildCount = len(grandchildren[child.Name])
+		child.IsLeaf = child.ChildCount == 0
+	}
+	return children
+}
+
+// sortChildren orders children, which are in key order, naturally if asked.
+func sortChildren(children []*ChildNode, natural bool) []*ChildNode {
+	if natural {
+		sort.SliceStable(children, func(i, j int) bool { return naturalSegmentLess(children[i].Name, children[j].Name) })
+	}
+	return children
+}
+
+// FetchChildrenHandler returns only the immediate children of the node at
+// *prefix, so tree views can be loaded lazily one level at a time, with only
+// the ?fields= asked for. With ?sortBy=natural numbers within names order by
+// value. Cached prefixes are served from cache.
+func FetchChildrenHandler(client clientv3.KV, cache *KeyspaceCache, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parent := strings.TrimSuffix(c.Param("prefix"), "/")
+		dir := parent + "/"
+		fields, err := parseFields(c, ChildNode{})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var natural bool
+		switch c.DefaultQuery("sortBy", "key") {
+		case "key":
+		case "natural":
+			natural = true
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sortBy, expected key or natural"})
+			return
+		}
+
+		if kvs, _, ok := cache.Range(parent); ok {
+			setStaleness(c, cache, false)
+			if notModified(c, kvsETag(c, kvs)) {
+				return
+			}
+			var below []*mvccpb.KeyValue
+			exists := parent == ""
+			for _, kv := range kvs {
+				switch key := string(kv.Key); {
+				case key == parent:
+					exists = true
+				case strings.HasPrefix(key, dir):
+					below = append(below, kv)
+				}
+			}
+			if len(below) == 0 && !exists {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+				return
+			}
+			sparseJSON(c, http.StatusOK, fields, sortChildren(childrenOf(dir, below), natural))
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		etag, err := prefixETag(ctx, c, client, parent)
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if notModified(c, etag) {
+			return
+		}
+
+		resp, err := client.Get(ctx, dir, clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		if len(resp.Kvs) == 0 && parent != "" {
+			exists, err := client.Get(ctx, parent, clientv3.WithCountOnly())
+			if err != nil {
+				logger.Error("Error fetching key from etcd", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+				return
+			}
+			if exists.Count == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+				return
+			}
+		}
+
+		sparseJSON(c, http.StatusOK, fields, sortChildren(childrenOf(dir, resp.Kvs), natural))
+	}
+}
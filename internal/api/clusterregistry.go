@@ -0,0 +1,322 @@
+package api
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// clusterRegistryPrefix holds the encrypted profiles of registered clusters.
+const clusterRegistryPrefix = "/.clusters/"
+
+// errStaticCluster is returned when changing a cluster configured with CLUSTERS.
+var errStaticCluster = errors.New("cluster is configured with CLUSTERS")
+
+// profileCluster returns the registered cluster named name with profile p.
+func profileCluster(p ClusterProfile, name string) Cluster {
+	return Cluster{
+		Name:       name,
+		Endpoints:  p.Endpoints,
+		Username:   p.Username,
+		Password:   p.Password,
+		TLS:        p.TLS || p.CACert != "" || p.Cert != "" || p.Key != "",
+		CACert:     p.CACert,
+		Cert:       p.Cert,
+		Key:        p.Key,
+		Registered: true,
+	}
+}
+
+// ClusterServeFunc connects to a registered cluster and returns its client
+// and audit log and the handler serving it. ctx is cancelled when the
+// cluster is changed or removed.
+type ClusterServeFunc func(ctx context.Context, cluster Cluster) (EnvironmentStore, http.Handler, error)
+
+// registeredCluster is a registered cluster being served.
+type registeredCluster struct {
+	revision int64
+	cancel   context.CancelFunc
+}
+
+// ClusterRegistry keeps cluster profiles registered at runtime in etcd,
+// encrypted with AES-GCM, and serves them from clusters on every replica.
+type ClusterRegistry struct {
+	client   WatchKV
+	aead     cipher.AEAD
+	clusters *Clusters
+	serve    ClusterServeFunc
+	logger   *zap.Logger
+
+	mu      sync.Mutex
+	ctx     context.Context
+	serving map[string]registeredCluster
+}
+
+// NewClusterRegistry creates a registry encrypting profiles with key, which
+// must be 32 bytes. Call Run to serve the registered clusters.
+func NewClusterRegistry(client WatchKV, key []byte, clusters *Clusters, serve ClusterServeFunc, logger *zap.Logger) (*ClusterRegistry, error) {
+	if len(key) != 32 {
+		return nil, errors.New("cluster registry key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &ClusterRegistry{
+		client:   client,
+		aead:     aead,
+		clusters: clusters,
+		serve:    serve,
+		logger:   logger,
+		serving:  make(map[string]registeredCluster),
+	}, nil
+}
+
+// seal encrypts a profile, binding it to the cluster's name so it cannot be
+// copied to another.
+func (r *ClusterRegistry) seal(name string, profile ClusterProfile) (string, error) {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, r.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(r.aead.Seal(nonce, nonce, data, []byte(name))), nil
+}
+
+// open decrypts a profile sealed for name.
+func (r *ClusterRegistry) open(name, value string) (ClusterProfile, error) {
+	var profile ClusterProfile
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(data) < r.aead.NonceSize() {
+		return profile, errors.New("malformed cluster profile")
+	}
+	n := r.aead.NonceSize()
+	plain, err := r.aead.Open(nil, data[:n], data[n:], []byte(name))
+	if err != nil {
+		return profile, errors.New("cannot decrypt cluster profile, was the key changed?")
+	}
+	return profile, json.Unmarshal(plain, &profile)
+}
+
+// Run serves the registered clusters, following changes made on any
+// replica, until ctx is cancelled.
+func (r *ClusterRegistry) Run(ctx context.Context) {
+	r.mu.Lock()
+	r.ctx = ctx
+	r.mu.Unlock()
+	defer r.stopAll()
+	for ctx.Err() == nil {
+		resp, err := r.client.Get(ctx, clusterRegistryPrefix, clientv3.WithPrefix())
+		if err != nil {
+			r.logger.Warn("Cluster registry cannot load clusters", zap.Error(err))
+			time.Sleep(time.Second)
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, kv := range resp.Kvs {
+			name := strings.TrimPrefix(string(kv.Key), clusterRegistryPrefix)
+			seen[name] = true
+			r.apply(name, string(kv.Value), kv.ModRevision)
+		}
+		r.mu.Lock()
+		var gone []string
+		for name := range r.serving {
+			if !seen[name] {
+				gone = append(gone, name)
+			}
+		}
+		r.mu.Unlock()
+		for _, name := range gone {
+			r.remove(name)
+		}
+
+		for wresp := range r.client.Watch(ctx, clusterRegistryPrefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1)) {
+			if err := wresp.Err(); err != nil {
+				r.logger.Warn("Cluster registry watch failed", zap.Error(err))
+				break
+			}
+			for _, ev := range wresp.Events {
+				name := strings.TrimPrefix(string(ev.Kv.Key), clusterRegistryPrefix)
+				if ev.Type == clientv3.EventTypeDelete {
+					r.remove(name)
+				} else {
+					r.apply(name, string(ev.Kv.Value), ev.Kv.ModRevision)
+				}
+			}
+		}
+	}
+}
+
+// apply serves the profile stored for name at revision, replacing an older
+// one. Clusters configured with CLUSTERS take precedence.
+func (r *ClusterRegistry) apply(name, value string, revision int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ctx == nil || r.ctx.Err() != nil {
+		return
+	}
+	if current, ok := r.serving[name]; ok && current.revision >= revision {
+		return
+	}
+	if existing, ok := r.clusters.Get(name); ok && !existing.Registered {
+		r.logger.Warn("Ignoring registered cluster configured with CLUSTERS", zap.String("cluster", name))
+		return
+	}
+	profile, err := r.open(name, value)
+	if err != nil {
+		r.logger.Error("Cannot read registered cluster", zap.String("cluster", name), zap.Error(err))
+		return
+	}
+	clusterCtx, cancel := context.WithCancel(r.ctx)
+	store, h, err := r.serve(clusterCtx, profileCluster(profile, name))
+	if err != nil {
+		cancel()
+		r.logger.Error("Cannot serve registered cluster", zap.String("cluster", name), zap.Error(err))
+		return
+	}
+	if current, ok := r.serving[name]; ok {
+		current.cancel()
+	}
+	r.serving[name] = registeredCluster{revision: revision, cancel: cancel}
+	r.clusters.Add(profileCluster(profile, name), store, h)
+	r.logger.Info("Serving registered cluster", zap.String("cluster", name), zap.Strings("endpoints", profile.Endpoints))
+}
+
+// remove stops serving a registered cluster.
+func (r *ClusterRegistry) remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, ok := r.serving[name]
+	if !ok {
+		return
+	}
+	current.cancel()
+	delete(r.serving, name)
+	r.clusters.Remove(name)
+	r.logger.Info("Stopped serving registered cluster", zap.String("cluster", name))
+}
+
+func (r *ClusterRegistry) stopAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, current := range r.serving {
+		current.cancel()
+		r.clusters.Remove(name)
+	}
+	r.serving = make(map[string]registeredCluster)
+}
+
+// Put registers or replaces a cluster's profile and serves it on this
+// replica without waiting for the watch.
+func (r *ClusterRegistry) Put(ctx context.Context, name string, profile ClusterProfile) error {
+	if existing, ok := r.clusters.Get(name); ok && !existing.Registered {
+		return errStaticCluster
+	}
+	value, err := r.seal(name, profile)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Put(ctx, clusterRegistryPrefix+name, value)
+	if err != nil {
+		return err
+	}
+	r.apply(name, value, resp.Header.Revision)
+	return nil
+}
+
+// Delete removes a registered cluster, returning false if there was none.
+func (r *ClusterRegistry) Delete(ctx context.Context, name string) (bool, error) {
+	if existing, ok := r.clusters.Get(name); ok && !existing.Registered {
+		return false, errStaticCluster
+	}
+	resp, err := r.client.Delete(ctx, clusterRegistryPrefix+name)
+	if err != nil {
+		return false, err
+	}
+	r.remove(name)
+	return resp.Deleted > 0, nil
+}
+
+// ClusterPutHandler registers a cluster or replaces its profile. The profile
+// is replaced whole, so credentials must be sent again on every update.
+func ClusterPutHandler(registry *ClusterRegistry, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if registry == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cluster registry is not enabled"})
+			return
+		}
+		name := c.Param("name")
+		var profile ClusterProfile
+		if err := c.ShouldBindJSON(&profile); err != nil || len(profile.Endpoints) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		cluster := profileCluster(profile, name)
+		if _, err := cluster.tlsConfig(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid TLS material: " + err.Error()})
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		err := registry.Put(ctx, name, profile)
+		if errors.Is(err, errStaticCluster) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Cluster is configured with CLUSTERS"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error registering cluster", zap.String("cluster", name), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, cluster)
+	}
+}
+
+// ClusterDeleteHandler removes a registered cluster.
+func ClusterDeleteHandler(registry *ClusterRegistry, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if registry == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cluster registry is not enabled"})
+			return
+		}
+		name := c.Param("name")
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		deleted, err := registry.Delete(ctx, name)
+		if errors.Is(err, errStaticCluster) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Cluster is configured with CLUSTERS"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error removing cluster", zap.String("cluster", name), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if !deleted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cluster not found"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
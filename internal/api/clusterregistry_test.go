@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	"go.uber.org/zap"
+)
+
+func TestClusterRegistryReplicas(t *testing.T) {
+	store := apitest.NewStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serve := func(ctx context.Context, cluster Cluster) (EnvironmentStore, http.Handler, error) {
+		return EnvironmentStore{}, http.NotFoundHandler(), nil
+	}
+	// replica starts a registry on the shared store, as a gateway replica
+	// configured with a prod cluster would.
+	replica := func(key []byte) (*ClusterRegistry, *Clusters) {
+		clusters := NewClusters()
+		clusters.Add(Cluster{Name: "prod", Endpoints: []string{"prod:2379"}}, EnvironmentStore{}, http.NotFoundHandler())
+		registry, err := NewClusterRegistry(store.Client(), key, clusters, serve, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		go registry.Run(ctx)
+		waitFor(t, "the registry to start", func() bool {
+			registry.mu.Lock()
+			defer registry.mu.Unlock()
+			return registry.ctx != nil
+		})
+		return registry, clusters
+	}
+	key := bytes.Repeat([]byte{1}, 32)
+	registry, clusters := replica(key)
+	_, other := replica(key)
+	_, rekeyed := replica(bytes.Repeat([]byte{2}, 32))
+
+	if err := registry.Put(ctx, "staging", ClusterProfile{Endpoints: []string{"staging:2379"}}); err != nil {
+		t.Fatal(err)
+	}
+	if cluster, ok := clusters.Get("staging"); !ok || !cluster.Registered {
+		t.Errorf("got %+v, %v, want staging served at once", cluster, ok)
+	}
+	waitFor(t, "the other replica to serve staging", func() bool {
+		cluster, ok := other.Get("staging")
+		return ok && cluster.Endpoints[0] == "staging:2379"
+	})
+	if _, ok := rekeyed.Get("staging"); ok {
+		t.Error("a replica with another key served staging")
+	}
+
+	if err := registry.Put(ctx, "prod", ClusterProfile{Endpoints: []string{"evil:2379"}}); err != errStaticCluster {
+		t.Errorf("got error %v replacing a cluster configured with CLUSTERS", err)
+	}
+	store.KV().Put(ctx, clusterRegistryPrefix+"prod", "forged")
+	if deleted, err := registry.Delete(ctx, "staging"); err != nil || !deleted {
+		t.Fatalf("got %v, %v deleting staging", deleted, err)
+	}
+	waitFor(t, "the other replica to stop serving staging", func() bool {
+		_, ok := other.Get("staging")
+		return !ok
+	})
+	if cluster, _ := other.Get("prod"); cluster.Endpoints[0] != "prod:2379" || cluster.Registered {
+		t.Errorf("got prod %+v, want the one configured with CLUSTERS", cluster)
+	}
+}
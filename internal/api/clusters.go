@@ -0,0 +1,210 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+)
+
+// clusterHeader selects a cluster for a request to an unprefixed path.
+const clusterHeader = "X-Cluster"
+
+// Cluster is a named etcd cluster fronted by the gateway besides its own.
+// Registered clusters were added through the cluster registry rather than
+// CLUSTERS. Secrets and TLS material are never serialized.
+type Cluster struct {
+	Name       string   `json:"name"`
+	Endpoints  []string `json:"endpoints"`
+	Username   string   `json:"username,omitempty"`
+	Password   string   `json:"-"`
+	TLS        bool     `json:"tls,omitempty"`
+	CACert     string   `json:"-"`
+	Cert       string   `json:"-"`
+	Key        string   `json:"-"`
+	Registered bool     `json:"registered,omitempty"`
+}
+
+// ParseClusters parses "name=host:port;host:port" entries.
+func ParseClusters(entries []string) ([]Cluster, error) {
+	var clusters []Cluster
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name, target, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || target == "" || strings.Contains(name, "/") {
+			return nil, errors.New("invalid cluster " + strconv.Quote(entry) + ", expected name=host:port;...")
+		}
+		if seen[name] {
+			return nil, errors.New("duplicate cluster " + strconv.Quote(name))
+		}
+		seen[name] = true
+		clusters = append(clusters, Cluster{Name: name, Endpoints: strings.Split(target, ";")})
+	}
+	return clusters, nil
+}
+
+// tlsConfig returns the TLS settings for connecting to the cluster, or nil
+// for plaintext. Without a CA bundle the system roots are trusted.
+func (cl Cluster) tlsConfig() (*tls.Config, error) {
+	if !cl.TLS {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cl.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cl.CACert)) {
+			return nil, errors.New("no certificates in CA bundle")
+		}
+		cfg.RootCAs = pool
+	}
+	if cl.Cert != "" || cl.Key != "" {
+		cert, err := tls.X509KeyPair([]byte(cl.Cert), []byte(cl.Key))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Client connects to the cluster as tuning says.
+func (cl Cluster) Client(tuning EtcdTuning) (*clientv3.Client, error) {
+	tlsCfg, err := cl.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg := tuning.Config(cl.Endpoints, grpc.WithChainUnaryInterceptor(EtcdTimingInterceptor))
+	cfg.Username, cfg.Password, cfg.TLS = cl.Username, cl.Password, tlsCfg
+	return clientv3.New(cfg)
+}
+
+// Clusters holds the named clusters and the handler serving each. Every
+// handler is the same set of API routes bound to that cluster's client.
+type Clusters struct {
+	mu       sync.RWMutex
+	clusters map[string]Cluster
+	stores   map[string]EnvironmentStore
+	handlers map[string]http.Handler
+}
+
+// NewClusters creates an empty Clusters.
+func NewClusters() *Clusters {
+	return &Clusters{
+		clusters: make(map[string]Cluster),
+		stores:   make(map[string]EnvironmentStore),
+		handlers: make(map[string]http.Handler),
+	}
+}
+
+// Add serves cluster from h, with store its client and audit log, replacing
+// any cluster of the same name.
+func (cs *Clusters) Add(cluster Cluster, store EnvironmentStore, h http.Handler) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.clusters[cluster.Name] = cluster
+	cs.stores[cluster.Name] = store
+	cs.handlers[cluster.Name] = h
+}
+
+// Remove stops serving the named cluster.
+func (cs *Clusters) Remove(name string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.clusters, name)
+	delete(cs.stores, name)
+	delete(cs.handlers, name)
+}
+
+// Get returns the named cluster.
+func (cs *Clusters) Get(name string) (Cluster, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	cluster, ok := cs.clusters[name]
+	return cluster, ok
+}
+
+// Store returns the client and audit log of the named cluster.
+func (cs *Clusters) Store(name string) (EnvironmentStore, bool) {
+	if cs == nil {
+		return EnvironmentStore{}, false
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	store, ok := cs.stores[name]
+	return store, ok
+}
+
+// handler returns the handler serving the named cluster.
+func (cs *Clusters) handler(name string) (http.Handler, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	h, ok := cs.handlers[name]
+	return h, ok
+}
+
+// List returns the clusters sorted by name.
+func (cs *Clusters) List() []Cluster {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	list := make([]Cluster, 0, len(cs.clusters))
+	for _, cluster := range cs.clusters {
+		list = append(list, cluster)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// ClusterMiddleware serves requests carrying an X-Cluster header from that
+// cluster's handler instead of the default one. It runs before
+// EnvironmentMiddleware, which only applies to the gateway's own cluster.
+func ClusterMiddleware(clusters *Clusters) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.GetHeader(clusterHeader)
+		if name == "" {
+			c.Next()
+			return
+		}
+		h, ok := clusters.handler(name)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Unknown cluster"})
+			return
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
+
+// ClusterPathHandler serves /api/v1/clusters/:cluster/*path from that
+// cluster's handler as if the request had been made to /api/v1/*path.
+func ClusterPathHandler(clusters *Clusters) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("cluster")
+		h, ok := clusters.handler(name)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown cluster"})
+			return
+		}
+		segment := "/clusters/" + name
+		u := *c.Request.URL
+		u.Path = strings.Replace(u.Path, segment, "", 1)
+		u.RawPath = strings.Replace(u.RawPath, segment, "", 1)
+		req := c.Request.WithContext(c.Request.Context())
+		req.URL = &u
+		h.ServeHTTP(c.Writer, req)
+	}
+}
+
+// ClustersHandler lists the configured clusters.
+func ClustersHandler(clusters *Clusters) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, clusters.List())
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// gunzipBody returns rec's body, decompressing it if it is gzip encoded.
+func gunzipBody(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	if contentEncoding(rec) != "gzip" {
+		return rec.Body.String()
+	}
+	r, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// contentEncoding is the Content-Encoding rec's headers were sent with,
+// rather than any set after.
+func contentEncoding(rec *httptest.ResponseRecorder) string {
+	return rec.Result().Header.Get("Content-Encoding")
+}
+
+func TestCompressionFlush(t *testing.T) {
+	tests := []struct {
+		name         string
+		handler      gin.HandlerFunc
+		wantEncoding string
+		wantBody     string
+	}{
+		{"flush then write", func(c *gin.Context) {
+			c.Writer.Flush()
+			c.Writer.WriteString("hello")
+		}, "gzip", "hello"},
+		{"write, flush and write", func(c *gin.Context) {
+			c.Writer.WriteString("hel")
+			c.Writer.Flush()
+			c.Writer.WriteString("lo")
+		}, "gzip", "hello"},
+		{"event stream", func(c *gin.Context) {
+			c.Header("Content-Type", "text/event-stream")
+			c.Writer.Flush()
+			c.Writer.WriteString("data: hello\n\n")
+		}, "", "data: hello\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := apitest.Serve("/", req, CompressionMiddleware(), tt.handler)
+			if got := contentEncoding(rec); got != tt.wantEncoding {
+				t.Fatalf("got encoding %q, want %q", got, tt.wantEncoding)
+			}
+			if got := gunzipBody(t, rec); got != tt.wantBody {
+				t.Errorf("got body %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCompressionStreamedTree(t *testing.T) {
+	// Streamed listings flush after every node.
+	store := apitest.NewStore()
+	for _, key := range []string{"/a/x", "/a/y", "/b"} {
+		if _, err := store.KV().Put(context.Background(), key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := FetchKeysHandler(store.KV(), nil, zap.NewNop())
+	want := apitest.Serve("/keys", httptest.NewRequest("GET", "/keys?prefix=/&format=ndjson", nil), handler).Body.String()
+
+	req := httptest.NewRequest("GET", "/keys?prefix=/&format=ndjson", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := apitest.Serve("/keys", req, CompressionMiddleware(), handler)
+	if got := contentEncoding(rec); got != "gzip" {
+		t.Fatalf("got encoding %q", got)
+	}
+	if got := gunzipBody(t, rec); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ConflictError is returned for conditional writes whose guards failed,
+// with the keys that no longer match as of Revision. It is errConflict.
+type ConflictError struct {
+	Revision  int64
+	Conflicts []KeyConflict
+}
+
+func (e *ConflictError) Error() string {
+	return errConflict.Error()
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == errConflict
+}
+
+// guardedTxn commits a transaction running ops if every key compared by cmps
+// is at its expected revision, and otherwise returns a ConflictError. The
+// compared keys are read in the same transaction when it fails, so the
+// conflicts reported are those that made it fail.
+func guardedTxn(txn clientv3.Txn, cmps []clientv3.Cmp, ops ...clientv3.Op) (*clientv3.TxnResponse, error) {
+	reads := make([]clientv3.Op, len(cmps))
+	for i, cmp := range cmps {
+		reads[i] = clientv3.OpGet(string(cmp.Key))
+	}
+	resp, err := txn.If(cmps...).Then(ops...).Else(reads...).Commit()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Succeeded {
+		return resp, nil
+	}
+	conflict := &ConflictError{Revision: resp.Header.Revision}
+	for i, cmp := range cmps {
+		c := KeyConflict{Key: string(cmp.Key)}
+		switch cmp.Target {
+		case pb.Compare_MOD:
+			c.ExpectedRevision = (*pb.Compare)(&cmp).GetModRevision()
+		case pb.Compare_CREATE:
+			// Creation guards only ever require the key to be absent.
+		default:
+			continue
+		}
+		if kvs := resp.Responses[i].GetResponseRange().Kvs; len(kvs) > 0 {
+			value := string(kvs[0].Value)
+			c.ModRevision, c.Value = kvs[0].ModRevision, &value
+		}
+		if c.ModRevision != c.ExpectedRevision {
+			conflict.Conflicts = append(conflict.Conflicts, c)
+		}
+	}
+	return nil, conflict
+}
+
+// conflictResponse answers 409 with body, adding the conflicting keys and
+// the revision they were read at when err carries them.
+func conflictResponse(c *gin.Context, err error, body gin.H) {
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		body["revision"] = conflict.Revision
+		body["conflicts"] = conflict.Conflicts
+	}
+	c.JSON(http.StatusConflict, body)
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// ConsulKVPair mirrors the JSON shape of an entry returned by Consul's KV API.
+// Value is a []byte so that it is base64 encoded, as Consul clients expect.
+type ConsulKVPair struct {
+	LockIndex   uint64
+	Key         string
+	Flags       uint64
+	Value       []byte
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// consulKey maps a Consul key (which has no leading slash) onto the etcd keyspace.
+func consulKey(c *gin.Context) string {
+	return "/" + strings.TrimPrefix(c.Param("key"), "/")
+}
+
+func toConsulKVPair(kv *mvccpb.KeyValue) ConsulKVPair {
+	return ConsulKVPair{
+		Key:         strings.TrimPrefix(string(kv.Key), "/"),
+		Value:       kv.Value,
+		CreateIndex: uint64(kv.CreateRevision),
+		ModifyIndex: uint64(kv.ModRevision),
+	}
+}
+
+// ConsulGetHandler implements GET /v1/kv/*key, including the ?recurse, ?raw and ?keys modes.
+func ConsulGetHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := consulKey(c)
+		_, recurse := c.GetQuery("recurse")
+		_, keysOnly := c.GetQuery("keys")
+		_, raw := c.GetQuery("raw")
+
+		var opts []clientv3.OpOption
+		if recurse || keysOnly {
+			opts = append(opts, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+		}
+		if keysOnly {
+			opts = append(opts, clientv3.WithKeysOnly())
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Get(ctx, key, opts...)
+		if err != nil {
+			logger.Error("Error fetching key from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		c.Header("X-Consul-Index", strconv.FormatInt(resp.Header.Revision, 10))
+		if len(resp.Kvs) == 0 {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case keysOnly:
+			separator := c.Query("separator")
+			prefix := strings.TrimPrefix(key, "/")
+			keys := make([]string, 0, len(resp.Kvs))
+			seen := make(map[string]bool)
+			for _, kv := range resp.Kvs {
+				k := strings.TrimPrefix(string(kv.Key), "/")
+				if separator != "" {
+					rest := strings.TrimPrefix(k, prefix)
+					if i := strings.Index(rest, separator); i >= 0 {
+						k = prefix + rest[:i+len(separator)]
+					}
+				}
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+			c.JSON(http.StatusOK, keys)
+		case raw:
+			c.Data(http.StatusOK, "application/octet-stream", resp.Kvs[0].Value)
+		default:
+			pairs := make([]ConsulKVPair, len(resp.Kvs))
+			for i, kv := range resp.Kvs {
+				pairs[i] = toConsulKVPair(kv)
+			}
+			c.JSON(http.StatusOK, pairs)
+		}
+	}
+}
+
+// ConsulPutHandler implements PUT /v1/kv/*key. With ?cas=0 the key is only
+// created if it does not exist; any other index must match the key's ModRevision.
+// The gateway's own keys are refused.
+func ConsulPutHandler(client clientv3.KV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := consulKey(c)
+		if reservedWrite(key, false) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Key is reserved by the gateway"})
+			return
+		}
+		value, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot read request body"})
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		put := clientv3.OpPut(key, string(value), clientv3.WithPrevKV())
+		if cas, ok := c.GetQuery("cas"); ok {
+			index, err := strconv.ParseInt(cas, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cas index"})
+				return
+			}
+			resp, err := client.Txn(ctx).If(clientv3.Compare(clientv3.ModRevision(key), "=", index)).Then(put).Commit()
+			if err != nil {
+				logger.Error("Error writing key to etcd", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+				return
+			}
+			if resp.Succeeded {
+				audit.Record(ctx, requestActor(c), "put", []AuditChange{putChange(key, resp.Responses[0].GetResponsePut().PrevKv, resp.Header.Revision)})
+			}
+			c.JSON(http.StatusOK, resp.Succeeded)
+			return
+		}
+
+		resp, err := client.Do(ctx, put)
+		if err != nil {
+			logger.Error("Error writing key to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		audit.Record(ctx, requestActor(c), "put", []AuditChange{putChange(key, resp.Put().PrevKv, resp.Put().Header.Revision)})
+		c.JSON(http.StatusOK, true)
+	}
+}
+
+// ConsulDeleteHandler implements DELETE /v1/kv/*key, honoring ?recurse and ?cas.
+// Deletes reaching the gateway's own keys are refused.
+func ConsulDeleteHandler(client clientv3.KV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := consulKey(c)
+		_, recurse := c.GetQuery("recurse")
+		if reservedWrite(key, recurse) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Key is reserved by the gateway"})
+			return
+		}
+
+		opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+		if recurse {
+			opts = append(opts, clientv3.WithPrefix())
+		}
+		del := clientv3.OpDelete(key, opts...)
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		if cas, ok := c.GetQuery("cas"); ok {
+			index, err := strconv.ParseInt(cas, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cas index"})
+				return
+			}
+			resp, err := client.Txn(ctx).If(clientv3.Compare(clientv3.ModRevision(key), "=", index)).Then(del).Commit()
+			if err != nil {
+				logger.Error("Error deleting key from etcd", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+				return
+			}
+			if resp.Succeeded {
+				audit.Record(ctx, requestActor(c), "delete", deleteChanges(resp.Responses[0].GetResponseDeleteRange().PrevKvs))
+			}
+			c.JSON(http.StatusOK, resp.Succeeded)
+			return
+		}
+
+		resp, err := client.Do(ctx, del)
+		if err != nil {
+			logger.Error("Error deleting key from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		audit.Record(ctx, requestActor(c), "delete", deleteChanges(resp.Del().PrevKvs))
+		c.JSON(http.StatusOK, true)
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+func TestConsulReservedKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		wantCode int
+		wantKeys []string
+	}{
+		{"put", "PUT", "/v1/kv/app/b", 200, []string{"/.proposals/p", "/app/a", "/app/b"}},
+		{"put reserved", "PUT", "/v1/kv/.proposals/q", 403, []string{"/.proposals/p", "/app/a"}},
+		{"put root", "PUT", "/v1/kv/", 403, []string{"/.proposals/p", "/app/a"}},
+		{"delete", "DELETE", "/v1/kv/app/a", 200, []string{"/.proposals/p"}},
+		{"delete prefix", "DELETE", "/v1/kv/app/?recurse", 200, []string{"/.proposals/p"}},
+		{"delete reserved", "DELETE", "/v1/kv/.proposals/p", 403, []string{"/.proposals/p", "/app/a"}},
+		{"delete reserved prefix", "DELETE", "/v1/kv/.pro?recurse", 403, []string{"/.proposals/p", "/app/a"}},
+		{"delete everything", "DELETE", "/v1/kv/?recurse", 403, []string{"/.proposals/p", "/app/a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := apitest.NewStore()
+			kv := store.KV()
+			for _, key := range []string{"/app/a", "/.proposals/p"} {
+				if _, err := kv.Put(context.Background(), key, "v"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			handler := ConsulPutHandler(kv, nil, zap.NewNop())
+			if tt.method == "DELETE" {
+				handler = ConsulDeleteHandler(kv, nil, zap.NewNop())
+			}
+			rec := apitest.Serve("/v1/kv/*key", httptest.NewRequest(tt.method, tt.url, strings.NewReader("x")), handler)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			resp, err := kv.Get(context.Background(), "/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := keysOf(resp); !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("got keys %v, want %v", got, tt.wantKeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// consulPreviewKeys caps the keys listed in a dry run's result.
+const consulPreviewKeys = 100
+
+// consulKV is a key as the Consul KV API returns it; Value is base64.
+type consulKV struct {
+	Key   string `json:"Key"`
+	Value []byte `json:"Value"`
+}
+
+// consulSource reads keys from a Consul agent.
+type consulSource struct {
+	base       *url.URL
+	token      string
+	datacenter string
+	http       *http.Client
+}
+
+// get fetches /v1/kv/key with query into out.
+func (s *consulSource) get(ctx context.Context, key string, query url.Values, out interface{}) error {
+	u := *s.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/kv/" + key
+	if s.datacenter != "" {
+		query.Set("dc", s.datacenter)
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("consul GET %s: %s: %s", key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// list returns the names of the keys under source.
+func (s *consulSource) list(ctx context.Context, source string) ([]string, error) {
+	var names []string
+	err := s.get(ctx, source, url.Values{"keys": {""}}, &names)
+	return names, err
+}
+
+// read reads the keys named under source one top-level folder at a time,
+// adding each folder's keys to progress. Folder placeholders, keys ending in
+// "/", are left out since etcd has no folders. Consul flags are not carried
+// over.
+func (s *consulSource) read(ctx context.Context, source string, names []string, progress *JobProgress) ([]consulKV, error) {
+	groups := make(map[string]int)
+	var order []string
+	for _, name := range names {
+		group := strings.TrimPrefix(name, source)
+		if i := strings.IndexByte(group, '/'); i >= 0 {
+			group = group[:i]
+		}
+		if _, ok := groups[group]; !ok {
+			order = append(order, group)
+		}
+		groups[group]++
+	}
+	var kvs []consulKV
+	for _, group := range order {
+		var batch []consulKV
+		if err := s.get(ctx, source+group, url.Values{"recurse": {""}}, &batch); err != nil {
+			return nil, err
+		}
+		// A recursive read of "a" also returns "ab"; keep the group's own.
+		for _, kv := range batch {
+			rest := strings.TrimPrefix(kv.Key, source+group)
+			if (rest == "" || rest[0] == '/') && !strings.HasSuffix(kv.Key, "/") {
+				kvs = append(kvs, kv)
+			}
+		}
+		progress.Add(int64(groups[group]))
+	}
+	return kvs, nil
+}
+
+// importConsul copies the keys under req.Source in Consul to etcd, or with
+// req.DryRun reports what it would change.
+func importConsul(ctx context.Context, client clientv3.KV, audit *AuditLog, actor string, source *consulSource, req ConsulImportRequest, progress *JobProgress) (ConsulImportResult, error) {
+	result := ConsulImportResult{DryRun: req.DryRun}
+	names, err := source.list(ctx, req.Source)
+	if err != nil {
+		return result, err
+	}
+	// Each key is counted once read and again once written.
+	if req.DryRun {
+		progress.SetTotal(int64(len(names)))
+	} else {
+		progress.SetTotal(int64(2 * len(names)))
+	}
+	kvs, err := source.read(ctx, req.Source, names, progress)
+	if err != nil {
+		return result, err
+	}
+	keys := make([]DumpedKey, len(kvs))
+	for i, kv := range kvs {
+		keys[i] = DumpedKey{Key: req.Prefix + strings.TrimPrefix(strings.TrimPrefix(kv.Key, req.Source), "/"), Value: string(kv.Value)}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	result.Keys = int64(len(keys))
+	progress.Logf("Read %d keys from Consul under %q", len(keys), req.Source)
+
+	if req.DryRun {
+		for start := 0; start < len(keys); start += maxTxnOps {
+			end := start + maxTxnOps
+			if end > len(keys) {
+				end = len(keys)
+			}
+			existing, err := getKeys(ctx, client, dumpedKeyNames(keys[start:end]))
+			if err != nil {
+				return result, err
+			}
+			for _, k := range keys[start:end] {
+				kv, ok := existing[k.Key]
+				switch {
+				case !ok:
+					if len(result.Create) < consulPreviewKeys {
+						result.Create = append(result.Create, k.Key)
+					}
+				case string(kv.Value) == k.Value:
+					result.Unchanged++
+				default:
+					if len(result.Update) < consulPreviewKeys {
+						result.Update = append(result.Update, k.Key)
+					}
+				}
+			}
+		}
+		return result, nil
+	}
+
+	progress.SetTotal(int64(len(names) + len(keys)))
+	loaded, err := loadKeys(ctx, client, audit, actor, "consul-import", keys, req.Conflict, progress)
+	result.Imported, result.Skipped, result.Revision = loaded.Restored, loaded.Skipped, loaded.Revision
+	if err != nil {
+		return result, err
+	}
+	progress.Logf("Imported %d keys, skipped %d that already existed", result.Imported, result.Skipped)
+	return result, nil
+}
+
+// ConsulImportJobHandler starts a job migrating a Consul KV tree into etcd
+// and returns it immediately. The Consul token is only held in memory.
+func ConsulImportJobHandler(client clientv3.KV, jobs *Jobs, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ConsulImportRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		base, err := url.Parse(req.Address)
+		if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Address must be an http or https URL"})
+			return
+		}
+		if !strings.HasPrefix(req.Prefix, "/") || !strings.HasSuffix(req.Prefix, "/") || strings.HasPrefix(req.Prefix, "/.") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Prefix must start and end with / and be outside reserved prefixes"})
+			return
+		}
+		if req.Conflict == "" {
+			req.Conflict = conflictSkip
+		}
+		if req.Conflict != conflictSkip && req.Conflict != conflictOverwrite && req.Conflict != conflictFail {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Conflict must be skip, overwrite or fail"})
+			return
+		}
+		req.Source = strings.TrimPrefix(req.Source, "/")
+		source := &consulSource{base: base, token: req.Token, datacenter: req.Datacenter, http: &http.Client{Timeout: time.Minute}}
+
+		actor := requestActor(c)
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		job, err := jobs.Start(ctx, "consul-import", actor, func(ctx context.Context, progress *JobProgress) (interface{}, error) {
+			return importConsul(ctx, client, audit, actor, source, req, progress)
+		})
+		if err != nil {
+			logger.Error("Error starting Consul import job", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusAccepted, job)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// validateCopy checks both paths are absolute and the trees do not overlap.
+func validateCopy(r CopyRequest) error {
+	if !strings.HasPrefix(r.Source, "/") || !strings.HasPrefix(r.Destination, "/") {
+		return errors.New("Source and destination must start with /")
+	}
+	if strings.HasSuffix(r.Source, "/") != strings.HasSuffix(r.Destination, "/") {
+		return errors.New("Source and destination must both be prefixes or both be keys")
+	}
+	if r.Source == r.Destination || strings.HasSuffix(r.Source, "/") &&
+		(strings.HasPrefix(r.Source, r.Destination) || strings.HasPrefix(r.Destination, r.Source)) {
+		return errors.New("Source and destination must not overlap")
+	}
+	return nil
+}
+
+// rebase moves key from under source to under destination.
+func rebase(key, source, destination string) string {
+	return destination + strings.TrimPrefix(key, source)
+}
+
+// readSource returns the key, or every key under the prefix, named by source.
+func readSource(ctx context.Context, client clientv3.KV, source string) ([]*mvccpb.KeyValue, int64, error) {
+	if !strings.HasSuffix(source, "/") {
+		resp, err := client.Get(ctx, source)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Kvs, resp.Header.Revision, nil
+	}
+	var kvs []*mvccpb.KeyValue
+	rev, err := scanPrefix(ctx, client, source, func(kv *mvccpb.KeyValue) {
+		kvs = append(kvs, kv)
+	})
+	return kvs, rev, err
+}
+
+// copyKeys writes kvs under destination in transactions of up to maxTxnOps
+// keys. Unless overwrite is set each transaction requires its destination
+// keys to be absent, and a ConflictError is returned on the first collision.
+func copyKeys(ctx context.Context, client clientv3.KV, kvs []*mvccpb.KeyValue, source, destination string, overwrite bool) ([]AuditChange, error) {
+	var changes []AuditChange
+	for start := 0; start < len(kvs); start += maxTxnOps {
+		end := start + maxTxnOps
+		if end > len(kvs) {
+			end = len(kvs)
+		}
+		var cmps []clientv3.Cmp
+		var ops []clientv3.Op
+		for _, kv := range kvs[start:end] {
+			dest := rebase(string(kv.Key), source, destination)
+			if !overwrite {
+				cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(dest), "=", 0))
+			}
+			ops = append(ops, clientv3.OpPut(dest, string(kv.Value), clientv3.WithPrevKV()))
+		}
+		txn, err := guardedTxn(client.Txn(ctx), cmps, ops...)
+		if err != nil {
+			return changes, err
+		}
+		for i, r := range txn.Responses {
+			dest := rebase(string(kvs[start+i].Key), source, destination)
+			changes = append(changes, putChange(dest, r.GetResponsePut().PrevKv, txn.Header.Revision))
+		}
+	}
+	return changes, nil
+}
+
+// CopyHandler copies a key or subtree to a new location, reading the source
+// at a single revision. Trees larger than one transaction are copied in
+// chunks, so a failure part way through leaves a partial copy.
+func CopyHandler(client clientv3.KV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CopyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if err := validateCopy(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := longRequestContext(c, 30*time.Second)
+		defer cancel()
+
+		kvs, rev, err := readSource(ctx, client, req.Source)
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if len(kvs) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Source not found"})
+			return
+		}
+
+		changes, err := copyKeys(ctx, client, kvs, req.Source, req.Destination, req.Overwrite)
+		audit.Record(ctx, requestActor(c), "copy", changes)
+		if errors.Is(err, errConflict) {
+			conflictResponse(c, err, gin.H{"error": "Destination keys already exist, set overwrite to replace them", "copied": len(changes)})
+			return
+		}
+		if err != nil {
+			logger.Error("Error writing keys to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error", "copied": len(changes)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"copied": len(changes), "sourceRevision": rev})
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// CountKeysHandler returns the number of keys under ?prefix= without fetching them.
+func CountKeysHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		prefix := c.DefaultQuery("prefix", "/")
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		resp, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			logger.Error("Error counting keys in etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"prefix": prefix, "count": resp.Count, "revision": resp.Header.Revision})
+	}
+}
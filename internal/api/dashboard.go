@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// dashboardRecent is how many recently modified keys the dashboard lists.
+const dashboardRecent = 10
+
+// endpointHealth asks every endpoint the client knows for its status.
+func endpointHealth(ctx context.Context, client *clientv3.Client) []EndpointHealth {
+	endpoints := client.Endpoints()
+	health := make([]EndpointHealth, len(endpoints))
+	for i, ep := range endpoints {
+		health[i].Endpoint = ep
+		status, err := client.Status(ctx, ep)
+		if err != nil {
+			health[i].Error = err.Error()
+			continue
+		}
+		health[i].Healthy = len(status.Errors) == 0
+		health[i].Version = status.Version
+		health[i].Leader = status.Leader == status.Header.MemberId
+		health[i].DBSize = status.DbSize
+		health[i].RaftIndex = status.RaftIndex
+		if len(status.Errors) > 0 {
+			health[i].Error = status.Errors[0]
+		}
+	}
+	return health
+}
+
+// DashboardHandler returns cluster health, active alarms, key counts per
+// top-level prefix and the most recently modified keys outside the gateway's
+// reserved prefixes. Counts come from one keys-only scan of the keyspace.
+func DashboardHandler(client *clientv3.Client, clock *RevisionClock, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := longRequestContext(c, 10*time.Second)
+		defer cancel()
+
+		d := Dashboard{Alarms: []Alarm{}, PrefixCounts: make(map[string]int64)}
+		d.Endpoints = endpointHealth(ctx, client)
+
+		alarms, err := client.AlarmList(ctx)
+		if err != nil {
+			logger.Error("Error listing etcd alarms", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		for _, a := range alarms.Alarms {
+			d.Alarms = append(d.Alarms, Alarm{MemberID: strconv.FormatUint(a.MemberID, 16), Alarm: a.Alarm.String()})
+		}
+
+		// recent is kept sorted newest first.
+		var recent []*mvccpb.KeyValue
+		d.Revision, err = scanPrefixAt(ctx, client, "", 0, func(kv *mvccpb.KeyValue) {
+			d.TotalKeys++
+			d.PrefixCounts[groupPrefix(string(kv.Key), 1)]++
+			if strings.HasPrefix(string(kv.Key), "/.") {
+				return
+			}
+			if len(recent) == dashboardRecent && kv.ModRevision <= recent[len(recent)-1].ModRevision {
+				return
+			}
+			i := sort.Search(len(recent), func(i int) bool { return recent[i].ModRevision < kv.ModRevision })
+			recent = append(recent, nil)
+			copy(recent[i+1:], recent[i:])
+			recent[i] = kv
+			if len(recent) > dashboardRecent {
+				recent = recent[:dashboardRecent]
+			}
+		}, clientv3.WithKeysOnly())
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		// The scan is keys-only, so read the recent keys again for their
+		// value sizes. A key deleted in between is reported as scanned.
+		keys := make([]string, len(recent))
+		for i, kv := range recent {
+			keys[i] = string(kv.Key)
+		}
+		current, err := getKeys(ctx, client, keys)
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		d.RecentlyChanged = make([]KeyMeta, len(recent))
+		for i, kv := range recent {
+			if cur, ok := current[keys[i]]; ok && cur.ModRevision == kv.ModRevision {
+				kv = cur
+			}
+			d.RecentlyChanged[i] = toKeyMeta(kv, clock)
+		}
+		c.JSON(http.StatusOK, d)
+	}
+}
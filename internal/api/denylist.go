@@ -0,0 +1,452 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ErrKeyDenied is returned for operations on keys under a denied prefix.
+var ErrKeyDenied = errors.New("key is under a denied prefix")
+
+// ParseDeniedPrefixes validates the prefixes the gateway refuses to serve.
+func ParseDeniedPrefixes(prefixes []string) ([]string, error) {
+	for _, prefix := range prefixes {
+		if prefix == "" || prefix == "/" {
+			return nil, errors.New("invalid denied prefix " + strconv.Quote(prefix) + ", it would deny every key")
+		}
+	}
+	return prefixes, nil
+}
+
+// denyList is a set of denied key prefixes.
+type denyList []string
+
+// contains reports whether key is under a denied prefix.
+func (d denyList) contains(key []byte) bool {
+	for _, prefix := range d {
+		if bytes.HasPrefix(key, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether every key in [key, end) is under one denied
+// prefix. An empty end is the single key.
+func (d denyList) covers(key, end []byte) bool {
+	if len(end) == 0 {
+		return d.contains(key)
+	}
+	for _, prefix := range d {
+		pend := []byte(clientv3.GetPrefixRangeEnd(prefix))
+		if bytes.HasPrefix(key, []byte(prefix)) && len(pend) > 0 && end[0] != 0 && bytes.Compare(end, pend) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps reports whether any key in [key, end) is under a denied prefix.
+func (d denyList) overlaps(key, end []byte) bool {
+	for _, prefix := range d {
+		if rangeOverlapsPrefix(key, end, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns ErrKeyDenied if op would read or write a denied key, other
+// than reads of ranges that only partly overlap, which are filtered instead.
+func (d denyList) check(ctx context.Context, op clientv3.Op) error {
+	key, end := op.KeyBytes(), op.RangeBytes()
+	switch {
+	case op.IsTxn():
+		cmps, thenOps, elseOps := op.Txn()
+		if err := d.checkTxn(ctx, cmps, thenOps, elseOps); err != nil {
+			return err
+		}
+	case op.IsGet():
+		if d.covers(key, end) {
+			return denied(ctx)
+		}
+	default:
+		if d.overlaps(key, end) {
+			return denied(ctx)
+		}
+	}
+	return nil
+}
+
+// checkTxn returns ErrKeyDenied if a transaction compares or writes a
+// denied key, or reads only denied keys. Its other reads are filtered.
+func (d denyList) checkTxn(ctx context.Context, cmps []clientv3.Cmp, thenOps, elseOps []clientv3.Op) error {
+	for _, cmp := range cmps {
+		if d.overlaps(cmp.Key, cmp.RangeEnd) {
+			return denied(ctx)
+		}
+	}
+	for _, ops := range [][]clientv3.Op{thenOps, elseOps} {
+		for _, op := range ops {
+			if err := d.check(ctx, op); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// filtered reports whether op is a read of a range partly denied.
+func (d denyList) filtered(op clientv3.Op) bool {
+	return op.IsGet() && d.overlaps(op.KeyBytes(), op.RangeBytes())
+}
+
+// expand returns op with each filtered read replaced by a transaction that
+// also counts the denied keys in its range, so that counts can leave them
+// out too. collapse undoes it in the response.
+func (d denyList) expand(op clientv3.Op) clientv3.Op {
+	switch {
+	case op.IsTxn():
+		cmps, thenOps, elseOps := op.Txn()
+		return clientv3.OpTxn(cmps, d.expandAll(thenOps), d.expandAll(elseOps))
+	case d.filtered(op):
+		return clientv3.OpTxn(nil, append([]clientv3.Op{op}, d.deniedCounts(op)...), nil)
+	}
+	return op
+}
+
+func (d denyList) expandAll(ops []clientv3.Op) []clientv3.Op {
+	out := make([]clientv3.Op, len(ops))
+	for i, op := range ops {
+		out[i] = d.expand(op)
+	}
+	return out
+}
+
+// deniedCounts returns reads counting the keys of each denied prefix within
+// the range get reads, as get would see them.
+func (d denyList) deniedCounts(get clientv3.Op) []clientv3.Op {
+	key, end := get.KeyBytes(), get.RangeBytes()
+	var counts []clientv3.Op
+	for i, prefix := range d {
+		if !rangeOverlapsPrefix(key, end, prefix) || d.nested(i) {
+			continue
+		}
+		start := key
+		if bytes.Compare([]byte(prefix), start) > 0 {
+			start = []byte(prefix)
+		}
+		stop := []byte(clientv3.GetPrefixRangeEnd(prefix))
+		if len(stop) == 0 {
+			stop = []byte{0}
+		}
+		if !(len(end) == 1 && end[0] == 0) && (stop[0] == 0 || bytes.Compare(end, stop) < 0) {
+			stop = end
+		}
+		opts := []clientv3.OpOption{
+			clientv3.WithRange(string(stop)),
+			clientv3.WithCountOnly(),
+			clientv3.WithRev(get.Rev()),
+			clientv3.WithMinModRev(get.MinModRev()),
+			clientv3.WithMaxModRev(get.MaxModRev()),
+			clientv3.WithMinCreateRev(get.MinCreateRev()),
+			clientv3.WithMaxCreateRev(get.MaxCreateRev()),
+		}
+		if get.IsSerializable() {
+			opts = append(opts, clientv3.WithSerializable())
+		}
+		counts = append(counts, clientv3.OpGet(string(start), opts...))
+	}
+	return counts
+}
+
+// nested reports whether the ith prefix is within another, so that its keys
+// are counted with that one's.
+func (d denyList) nested(i int) bool {
+	for j, other := range d {
+		if j != i && bytes.HasPrefix([]byte(d[i]), []byte(other)) && (len(other) < len(d[i]) || j < i) {
+			return true
+		}
+	}
+	return false
+}
+
+// collapse turns resp, the response to expand(op), into the response to op
+// with denied keys filtered out.
+func (d denyList) collapse(ctx context.Context, op clientv3.Op, resp *pb.ResponseOp) {
+	switch {
+	case op.IsTxn():
+		_, thenOps, elseOps := op.Txn()
+		d.collapseTxn(ctx, thenOps, elseOps, resp.GetResponseTxn())
+	case d.filtered(op):
+		txn := resp.GetResponseTxn()
+		get := txn.Responses[0].GetResponseRange()
+		for _, count := range txn.Responses[1:] {
+			get.Count -= count.GetResponseRange().Count
+		}
+		get.Kvs = d.filter(ctx, get.Kvs)
+		if get.Header == nil {
+			get.Header = txn.Header
+		}
+		resp.Response = &pb.ResponseOp_ResponseRange{ResponseRange: get}
+	}
+}
+
+// collapseTxn collapses the responses of the branch of a transaction that
+// ran.
+func (d denyList) collapseTxn(ctx context.Context, thenOps, elseOps []clientv3.Op, resp *pb.TxnResponse) {
+	ops := elseOps
+	if resp.Succeeded {
+		ops = thenOps
+	}
+	for i, op := range ops {
+		d.collapse(ctx, op, resp.Responses[i])
+	}
+}
+
+// filter removes denied keys from those read, after recording where to
+// continue reading after them in ctx's read cursor.
+func (d denyList) filter(ctx context.Context, kvs []*mvccpb.KeyValue) []*mvccpb.KeyValue {
+	if cursor, ok := ctx.Value(readCursorKey{}).(*readCursor); ok && len(kvs) > 0 {
+		cursor.after = d.after(kvs[len(kvs)-1].Key)
+	}
+	out := kvs[:0]
+	for _, kv := range kvs {
+		if !d.contains(kv.Key) {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// after returns the first key after key that may not be denied: the end of
+// the outermost denied prefix key is under, if any.
+func (d denyList) after(key []byte) string {
+	var outer string
+	for _, prefix := range d {
+		if bytes.HasPrefix(key, []byte(prefix)) && (outer == "" || len(prefix) < len(outer)) {
+			outer = prefix
+		}
+	}
+	if end := clientv3.GetPrefixRangeEnd(outer); outer != "" && end != "" {
+		return end
+	}
+	return string(key) + "\x00"
+}
+
+type readCursorKey struct{}
+
+// readCursor is where to continue after the keys etcd returned to a read,
+// before denied keys were filtered out. Listings read in batches continue
+// from it, since a batch's last key, or all of its keys, may have been
+// filtered.
+type readCursor struct {
+	after string
+}
+
+// withReadCursor returns ctx, reads with which record their last key in the
+// returned cursor.
+func withReadCursor(ctx context.Context) (context.Context, *readCursor) {
+	cursor := &readCursor{}
+	return context.WithValue(ctx, readCursorKey{}, cursor), cursor
+}
+
+// next returns the key to read from after resp, the last response read.
+func (rc *readCursor) next(resp *clientv3.GetResponse) string {
+	next := rc.after
+	rc.after = ""
+	if n := len(resp.Kvs); n > 0 && string(resp.Kvs[n-1].Key)+"\x00" > next {
+		next = string(resp.Kvs[n-1].Key) + "\x00"
+	}
+	return next
+}
+
+// denied refuses the request ctx belongs to with 403.
+func denied(ctx context.Context) error {
+	return refuse(ctx, http.StatusForbidden, "Key is denied", ErrKeyDenied)
+}
+
+// denyKV is a KV refusing operations on denied keys.
+type denyKV struct {
+	clientv3.KV
+	deny denyList
+}
+
+// NewDenyKV wraps kv to refuse reads and writes of keys under prefixes with
+// ErrKeyDenied. Reads of ranges partly under them omit the denied keys, from
+// counts too, including reads within transactions.
+func NewDenyKV(kv clientv3.KV, prefixes []string) clientv3.KV {
+	return &denyKV{KV: kv, deny: prefixes}
+}
+
+func (kv *denyKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	resp, err := kv.Do(ctx, clientv3.OpGet(key, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Get(), nil
+}
+
+func (kv *denyKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	resp, err := kv.Do(ctx, clientv3.OpPut(key, val, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Put(), nil
+}
+
+func (kv *denyKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	resp, err := kv.Do(ctx, clientv3.OpDelete(key, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Del(), nil
+}
+
+func (kv *denyKV) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	if err := kv.deny.check(ctx, op); err != nil {
+		return clientv3.OpResponse{}, err
+	}
+	if !op.IsTxn() && !kv.deny.filtered(op) {
+		return kv.KV.Do(ctx, op)
+	}
+	resp, err := kv.KV.Do(ctx, kv.deny.expand(op))
+	if err != nil {
+		return resp, err
+	}
+	txn := (*pb.TxnResponse)(resp.Txn())
+	if op.IsTxn() {
+		_, thenOps, elseOps := op.Txn()
+		kv.deny.collapseTxn(ctx, thenOps, elseOps, txn)
+		return resp, nil
+	}
+	get := &pb.ResponseOp{Response: &pb.ResponseOp_ResponseTxn{ResponseTxn: txn}}
+	kv.deny.collapse(ctx, op, get)
+	return (*clientv3.GetResponse)(get.GetResponseRange()).OpResponse(), nil
+}
+
+func (kv *denyKV) Txn(ctx context.Context) clientv3.Txn {
+	return &denyTxn{kv: kv, ctx: ctx}
+}
+
+// denyTxn is a transaction checked, and its reads filtered, as Do does.
+type denyTxn struct {
+	kv               *denyKV
+	ctx              context.Context
+	cmps             []clientv3.Cmp
+	thenOps, elseOps []clientv3.Op
+}
+
+func (txn *denyTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	txn.cmps = append(txn.cmps, cs...)
+	return txn
+}
+
+func (txn *denyTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	txn.thenOps = append(txn.thenOps, ops...)
+	return txn
+}
+
+func (txn *denyTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	txn.elseOps = append(txn.elseOps, ops...)
+	return txn
+}
+
+func (txn *denyTxn) Commit() (*clientv3.TxnResponse, error) {
+	d := txn.kv.deny
+	if err := d.checkTxn(txn.ctx, txn.cmps, txn.thenOps, txn.elseOps); err != nil {
+		return nil, err
+	}
+	resp, err := txn.kv.KV.Txn(txn.ctx).If(txn.cmps...).Then(d.expandAll(txn.thenOps)...).Else(d.expandAll(txn.elseOps)...).Commit()
+	if err != nil {
+		return nil, err
+	}
+	d.collapseTxn(txn.ctx, txn.thenOps, txn.elseOps, (*pb.TxnResponse)(resp))
+	return resp, nil
+}
+
+// denyWatcher is a Watcher dropping events for denied keys.
+type denyWatcher struct {
+	clientv3.Watcher
+	deny denyList
+}
+
+// NewDenyWatcher wraps w to drop events for keys under prefixes.
+func NewDenyWatcher(w clientv3.Watcher, prefixes []string) clientv3.Watcher {
+	return &denyWatcher{Watcher: w, deny: prefixes}
+}
+
+func (w *denyWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	op := clientv3.OpGet(key, opts...)
+	in := w.Watcher.Watch(ctx, key, opts...)
+	if !w.deny.overlaps(op.KeyBytes(), op.RangeBytes()) {
+		return in
+	}
+	out := make(chan clientv3.WatchResponse)
+	go func() {
+		defer close(out)
+		for wresp := range in {
+			events := wresp.Events[:0]
+			for _, ev := range wresp.Events {
+				if !w.deny.contains(ev.Kv.Key) {
+					events = append(events, ev)
+				}
+			}
+			// Responses without events, such as creation notices, still pass.
+			if len(events) == 0 && len(wresp.Events) > 0 {
+				continue
+			}
+			wresp.Events = events
+			select {
+			case out <- wresp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// denyLease is a Lease omitting denied keys from those attached to leases.
+type denyLease struct {
+	clientv3.Lease
+	deny denyList
+}
+
+// NewDenyLease wraps l to omit keys under prefixes from lease TTL responses.
+func NewDenyLease(l clientv3.Lease, prefixes []string) clientv3.Lease {
+	return &denyLease{Lease: l, deny: prefixes}
+}
+
+func (l *denyLease) TimeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error) {
+	resp, err := l.Lease.TimeToLive(ctx, id, opts...)
+	if err != nil {
+		return nil, err
+	}
+	keys := resp.Keys[:0]
+	for _, key := range resp.Keys {
+		if !l.deny.contains(key) {
+			keys = append(keys, key)
+		}
+	}
+	resp.Keys = keys
+	return resp, nil
+}
+
+// DenyPrefixes makes client refuse to serve keys under prefixes, whoever
+// asks, as namespace does for its prefix.
+func DenyPrefixes(client *clientv3.Client, prefixes []string) {
+	if len(prefixes) == 0 {
+		return
+	}
+	client.KV = NewDenyKV(client.KV, prefixes)
+	client.Watcher = NewDenyWatcher(client.Watcher, prefixes)
+	client.Lease = NewDenyLease(client.Lease, prefixes)
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+func deniedStore(t *testing.T, keys ...string) clientv3.KV {
+	t.Helper()
+	store := apitest.NewStore()
+	for _, key := range keys {
+		if _, err := store.KV().Put(context.Background(), key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewDenyKV(store.KV(), []string{"/app/secrets/", "/app/secrets/db/", "/other/"})
+}
+
+func keysOf(resp *clientv3.GetResponse) []string {
+	keys := []string{}
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+func TestDenyKVReads(t *testing.T) {
+	kv := deniedStore(t, "/app/a", "/app/secrets/x", "/app/secrets/db/y", "/app/z", "/other/o")
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		key       string
+		opts      []clientv3.OpOption
+		wantKeys  []string
+		wantCount int64
+		wantErr   error
+	}{
+		{"allowed key", "/app/a", nil, []string{"/app/a"}, 1, nil},
+		{"denied key", "/app/secrets/x", nil, nil, 0, ErrKeyDenied},
+		{"denied prefix", "/app/secrets/", []clientv3.OpOption{clientv3.WithPrefix()}, nil, 0, ErrKeyDenied},
+		{"partly denied prefix", "/app/", []clientv3.OpOption{clientv3.WithPrefix()}, []string{"/app/a", "/app/z"}, 2, nil},
+		{"partly denied count", "/app/", []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithCountOnly()}, []string{}, 2, nil},
+		{"every key", "\x00", []clientv3.OpOption{clientv3.WithFromKey()}, []string{"/app/a", "/app/z"}, 2, nil},
+		{"limited", "/app/", []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithLimit(1)}, []string{"/app/a"}, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := kv.Get(ctx, tt.key, tt.opts...)
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := keysOf(resp); !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("got keys %v, want %v", got, tt.wantKeys)
+			}
+			if resp.Count != tt.wantCount {
+				t.Errorf("got count %d, want %d", resp.Count, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestDenyKVTxn(t *testing.T) {
+	kv := deniedStore(t, "/app/a", "/app/secrets/x", "/app/z")
+	ctx := context.Background()
+
+	// Reads within transactions are filtered as plain reads are.
+	resp, err := kv.Txn(ctx).Then(
+		clientv3.OpGet("/app/", clientv3.WithPrefix(), clientv3.WithCountOnly()),
+		clientv3.OpGet("/app/", clientv3.WithPrefix()),
+		clientv3.OpTxn(nil, []clientv3.Op{clientv3.OpGet("/app/", clientv3.WithPrefix())}, nil),
+	).Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := resp.Responses[0].GetResponseRange().Count; count != 2 {
+		t.Errorf("got count %d, want 2", count)
+	}
+	for _, r := range []*clientv3.GetResponse{
+		(*clientv3.GetResponse)(resp.Responses[1].GetResponseRange()),
+		(*clientv3.GetResponse)(resp.Responses[2].GetResponseTxn().Responses[0].GetResponseRange()),
+	} {
+		if got := keysOf(r); !reflect.DeepEqual(got, []string{"/app/a", "/app/z"}) {
+			t.Errorf("got keys %v", got)
+		}
+	}
+
+	refused := []struct {
+		name string
+		txn  clientv3.Txn
+	}{
+		{"compare", kv.Txn(ctx).If(clientv3.Compare(clientv3.Version("/app/secrets/x"), "=", 1))},
+		{"write", kv.Txn(ctx).Then(clientv3.OpPut("/app/secrets/x", "w"))},
+		{"nested delete", kv.Txn(ctx).Then(clientv3.OpTxn(nil, []clientv3.Op{clientv3.OpDelete("/app/", clientv3.WithPrefix())}, nil))},
+		{"denied read", kv.Txn(ctx).Then(clientv3.OpGet("/app/secrets/x"))},
+	}
+	for _, tt := range refused {
+		if _, err := tt.txn.Commit(); err != ErrKeyDenied {
+			t.Errorf("%s: got error %v, want ErrKeyDenied", tt.name, err)
+		}
+	}
+}
+
+func TestDenyKVListings(t *testing.T) {
+	// More denied keys than a streamed batch, between allowed ones.
+	keys := []string{"/app/a", "/app/z"}
+	for i := 0; i < streamBatchSize+1; i++ {
+		keys = append(keys, fmt.Sprintf("/app/secrets/%04d", i))
+	}
+	kv := deniedStore(t, keys...)
+
+	rec := apitest.Serve("/keys", httptest.NewRequest("GET", "/keys?prefix=/app/&flat=true&keysOnly=true", nil), FetchKeysHandler(kv, nil, zap.NewNop()))
+	if rec.Code != 200 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var flat []FlatKey
+	json.Unmarshal(rec.Body.Bytes(), &flat)
+	if len(flat) != 2 || flat[0].Key != "/app/a" || flat[1].Key != "/app/z" {
+		t.Errorf("streamed %s", rec.Body)
+	}
+
+	var listed []string
+	cont := ""
+	for page := 0; page < 5; page++ {
+		url := "/keys?prefix=/app/&flat=true&limit=1"
+		if cont != "" {
+			url += "&continue=" + cont
+		}
+		rec := apitest.Serve("/keys", httptest.NewRequest("GET", url, nil), FetchKeysHandler(kv, nil, zap.NewNop()))
+		var resp FlatKeysPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 200 {
+			t.Fatalf("page %d: status %d: %s", page, rec.Code, rec.Body)
+		}
+		for _, key := range resp.Keys {
+			listed = append(listed, key.Key)
+		}
+		if cont = resp.Continue; cont == "" {
+			break
+		}
+	}
+	if !reflect.DeepEqual(listed, []string{"/app/a", "/app/z"}) {
+		t.Errorf("paged %v", listed)
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// maxDiffCells bounds the work lineDiff does; larger values are reported as
+// a full replacement.
+const maxDiffCells = 1 << 20
+
+// lineDiff returns a minimal line diff of a and b, each line prefixed with
+// " ", "-" or "+".
+func lineDiff(a, b string) []string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(x)*len(y) > maxDiffCells {
+		out := make([]string, 0, len(x)+len(y))
+		for _, line := range x {
+			out = append(out, "-"+line)
+		}
+		for _, line := range y {
+			out = append(out, "+"+line)
+		}
+		return out
+	}
+	// lcs[i][j] is the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			out = append(out, " "+x[i])
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+x[i])
+			i++
+		default:
+			out = append(out, "+"+y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		out = append(out, "-"+x[i])
+	}
+	for ; j < len(y); j++ {
+		out = append(out, "+"+y[j])
+	}
+	return out
+}
+
+// diffPrefixes reads both prefixes, each at its revision or the current one
+// if 0, and compares them.
+func diffPrefixes(ctx context.Context, leftClient, rightClient *clientv3.Client, left, right string, leftRev, rightRev int64) (PrefixDiff, error) {
+	d := PrefixDiff{Left: left, Right: right, OnlyLeft: []string{}, OnlyRight: []string{}, Changed: []KeyDiff{}}
+	leftKvs := make(map[string]*mvccpb.KeyValue)
+	rev, err := scanPrefixAt(ctx, leftClient, left, leftRev, func(kv *mvccpb.KeyValue) {
+		leftKvs[strings.TrimPrefix(string(kv.Key), left)] = kv
+	})
+	if err != nil {
+		return d, err
+	}
+	d.LeftRevision = rev
+	seen := make(map[string]bool)
+	rev, err = scanPrefixAt(ctx, rightClient, right, rightRev, func(kv *mvccpb.KeyValue) {
+		key := strings.TrimPrefix(string(kv.Key), right)
+		seen[key] = true
+		l, ok := leftKvs[key]
+		switch {
+		case !ok:
+			d.OnlyRight = append(d.OnlyRight, key)
+		case string(l.Value) == string(kv.Value):
+			d.Identical++
+		default:
+			d.Changed = append(d.Changed, KeyDiff{
+				Key:   key,
+				Left:  string(l.Value),
+				Right: string(kv.Value),
+				Diff:  lineDiff(string(l.Value), string(kv.Value)),
+			})
+		}
+	})
+	if err != nil {
+		return d, err
+	}
+	d.RightRevision = rev
+	for key := range leftKvs {
+		if !seen[key] {
+			d.OnlyLeft = append(d.OnlyLeft, key)
+		}
+	}
+	sort.Strings(d.OnlyLeft)
+	return d, nil
+}
+
+// PrefixDiffHandler compares the subtrees under ?left and ?right. Either side
+// can be read from another environment with ?leftEnv or ?rightEnv, or from
+// another cluster with ?leftCluster or ?rightCluster.
+func PrefixDiffHandler(client *clientv3.Client, stores map[string]EnvironmentStore, clusters *Clusters, logger *zap.Logger) gin.HandlerFunc {
+	side := func(c *gin.Context, param string) (*clientv3.Client, string, bool) {
+		prefix := c.Query(param)
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, "", false
+		}
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		name, cluster := c.Query(param+"Env"), c.Query(param+"Cluster")
+		switch {
+		case name != "" && cluster != "":
+			return nil, "", false
+		case cluster != "":
+			store, ok := clusters.Store(cluster)
+			return store.Client, prefix, ok
+		case name != "":
+			store, ok := stores[name]
+			return store.Client, prefix, ok
+		}
+		return client, prefix, true
+	}
+	return func(c *gin.Context) {
+		leftClient, left, ok := side(c, "left")
+		rightClient, right, ok2 := side(c, "right")
+		if !ok || !ok2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Left and right must be prefixes starting with / in a known environment or cluster"})
+			return
+		}
+
+		ctx, cancel := longRequestContext(c, 30*time.Second)
+		defer cancel()
+
+		d, err := diffPrefixes(ctx, leftClient, rightClient, left, right, 0, 0)
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, d)
+	}
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// editLockPrefix holds the edit locks, under the key being edited.
+const editLockPrefix = "/.editlocks/"
+
+// editLockTokenHeader carries the token of the edit lock to renew or release.
+const editLockTokenHeader = "X-Edit-Lock-Token"
+
+const (
+	// defaultEditLockTTL is how long an edit lock lasts without a heartbeat.
+	defaultEditLockTTL = 60 * time.Second
+	minEditLockTTL     = 5 * time.Second
+	maxEditLockTTL     = 5 * time.Minute
+)
+
+// editLockRecord is an edit lock as stored, with the token its holder
+// renews and releases it with and the lease it expires with.
+type editLockRecord struct {
+	EditLock
+	Token string `json:"token"`
+	Lease int64  `json:"lease"`
+}
+
+func editLockKey(c *gin.Context) (string, string) {
+	key := c.Param("key")
+	return key, editLockPrefix + strings.TrimPrefix(key, "/")
+}
+
+// editLockOf reads the edit lock on key, returning nil if there is none.
+func editLockOf(c *gin.Context, client clientv3.KV, lockKey string) (*editLockRecord, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	resp, err := client.Get(ctx, lockKey)
+	if err != nil || len(resp.Kvs) == 0 {
+		return nil, err
+	}
+	var lock editLockRecord
+	if err := json.Unmarshal(resp.Kvs[0].Value, &lock); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// EditLockHandler returns who is editing a key, or 404 if no one is.
+func EditLockHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, lockKey := editLockKey(c)
+		lock, err := editLockOf(c, client, lockKey)
+		if err != nil {
+			logger.Error("Error fetching edit lock from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if lock == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Key is not being edited"})
+			return
+		}
+		c.JSON(http.StatusOK, lock.EditLock)
+	}
+}
+
+// EditLockAcquireHandler takes the edit lock on a key for the caller,
+// returning the token to renew and release it with. If someone else holds
+// it, it answers 409 with their lock, unless force is set, which takes it
+// over, e.g. when the holder's browser tab was closed without releasing it.
+func EditLockAcquireHandler(client *clientv3.Client, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req EditLockRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+				return
+			}
+		}
+		ttl := defaultEditLockTTL
+		if req.TTL != 0 {
+			ttl = time.Duration(req.TTL) * time.Second
+			if ttl < minEditLockTTL || ttl > maxEditLockTTL {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "TTL must be between 5 and 300 seconds"})
+				return
+			}
+		}
+		key, lockKey := editLockKey(c)
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		lease, err := client.Grant(ctx, int64(ttl/time.Second))
+		if err != nil {
+			logger.Error("Error granting lease", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		token := make([]byte, 16)
+		rand.Read(token)
+		lock := editLockRecord{
+			EditLock: EditLock{Key: key, Holder: requestActor(c), AcquiredAt: time.Now().UTC(), TTL: lease.TTL},
+			Token:    hex.EncodeToString(token),
+			Lease:    int64(lease.ID),
+		}
+		data, err := json.Marshal(lock)
+		if err != nil {
+			logger.Error("Error encoding edit lock", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		put := clientv3.OpPut(lockKey, string(data), clientv3.WithLease(lease.ID))
+		txn := client.Txn(ctx)
+		if c.Query("force") != "true" {
+			txn = txn.If(clientv3.Compare(clientv3.CreateRevision(lockKey), "=", 0))
+		}
+		resp, err := txn.Then(put).Else(clientv3.OpGet(lockKey)).Commit()
+		if err != nil {
+			client.Revoke(ctx, lease.ID)
+			logger.Error("Error writing edit lock to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if !resp.Succeeded {
+			client.Revoke(ctx, lease.ID)
+			var holder editLockRecord
+			if kvs := resp.Responses[0].GetResponseRange().Kvs; len(kvs) > 0 {
+				json.Unmarshal(kvs[0].Value, &holder)
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "Key is being edited by " + holder.Holder, "lock": holder.EditLock})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"lock": lock.EditLock, "token": lock.Token})
+	}
+}
+
+// heldEditLock returns the caller's edit lock on the key, answering 404 if
+// there is none and 409 if someone else holds it.
+func heldEditLock(c *gin.Context, client clientv3.KV, logger *zap.Logger) *editLockRecord {
+	_, lockKey := editLockKey(c)
+	lock, err := editLockOf(c, client, lockKey)
+	if err != nil {
+		logger.Error("Error fetching edit lock from etcd", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return nil
+	}
+	if lock == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Edit lock expired or released"})
+		return nil
+	}
+	if lock.Token != c.GetHeader(editLockTokenHeader) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Key is being edited by " + lock.Holder, "lock": lock.EditLock})
+		return nil
+	}
+	return lock
+}
+
+// EditLockHeartbeatHandler renews the caller's edit lock, identified by the
+// X-Edit-Lock-Token header, for another TTL.
+func EditLockHeartbeatHandler(client *clientv3.Client, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lock := heldEditLock(c, client, logger)
+		if lock == nil {
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.KeepAliveOnce(ctx, clientv3.LeaseID(lock.Lease))
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Edit lock expired or released"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error renewing edit lock lease", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		lock.TTL = resp.TTL
+		c.JSON(http.StatusOK, lock.EditLock)
+	}
+}
+
+// EditLockReleaseHandler releases the caller's edit lock, identified by the
+// X-Edit-Lock-Token header.
+func EditLockReleaseHandler(client *clientv3.Client, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lock := heldEditLock(c, client, logger)
+		if lock == nil {
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		if _, err := client.Revoke(ctx, clientv3.LeaseID(lock.Lease)); err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			logger.Error("Error revoking edit lock lease", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,359 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// emailMaxChanges is how many changes an immediate email describes.
+	emailMaxChanges = 20
+	// emailMaxDigestKeys is how many keys a digest lists; the rest are counted.
+	emailMaxDigestKeys = 500
+	// emailSnippetLines and emailSnippetWidth bound each change's diff.
+	emailSnippetLines = 40
+	emailSnippetWidth = 200
+)
+
+// SMTPConfig is the server notification emails are sent through. STARTTLS
+// is used when the server offers it; Username enables PLAIN authentication.
+type SMTPConfig struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// EmailTarget emails changes to keys under Prefix to To. A zero Digest sends
+// an email per write; otherwise changes are summarized every Digest (an
+// hour or a day), on UTC boundaries.
+type EmailTarget struct {
+	Prefix string
+	To     []string
+	Digest time.Duration
+}
+
+// emailDigestPeriods are the digest modes an EmailTarget can name.
+var emailDigestPeriods = map[string]time.Duration{
+	"immediate": 0,
+	"hourly":    time.Hour,
+	"daily":     24 * time.Hour,
+}
+
+// ParseEmailTargets parses "/prefix/=address;address[;mode]" entries, where
+// mode is immediate (the default), hourly or daily.
+func ParseEmailTargets(entries []string) ([]EmailTarget, error) {
+	var targets []EmailTarget
+	for _, entry := range entries {
+		prefix, rest, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") {
+			return nil, errors.New("invalid target " + strconv.Quote(entry) + ", expected /prefix/=address;address[;hourly|daily]")
+		}
+		target := EmailTarget{Prefix: prefix}
+		for _, item := range strings.Split(rest, ";") {
+			if period, ok := emailDigestPeriods[item]; ok {
+				target.Digest = period
+				continue
+			}
+			addr, err := mail.ParseAddress(item)
+			if err != nil {
+				return nil, errors.New("invalid address " + strconv.Quote(item) + " in target for " + prefix)
+			}
+			target.To = append(target.To, addr.Address)
+		}
+		if len(target.To) == 0 {
+			return nil, errors.New("no addresses in target for " + prefix)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// digestEntry summarizes the changes to one key over a digest period.
+type digestEntry struct {
+	changes  int
+	created  bool
+	deleted  bool
+	revision int64
+	actors   []string
+}
+
+type emailQueue struct {
+	EmailTarget
+	// pending holds changes for immediate targets.
+	pending []KeyChange
+	// digest and due are used by digest targets; due is zero while the
+	// digest is empty.
+	digest  map[string]*digestEntry
+	missed  []KeyChange
+	dropped int
+	due     time.Time
+}
+
+// EmailNotifier emails the changes to watched prefixes, either as they
+// happen or as periodic digests.
+type EmailNotifier struct {
+	feed   *changeFeed
+	logger *zap.Logger
+	smtp   SMTPConfig
+	queues []*emailQueue
+}
+
+// NewEmailNotifier creates a notifier; call Run to start sending. audit may
+// be nil, in which case no change names its writer.
+func NewEmailNotifier(client *clientv3.Client, audit *AuditLog, logger *zap.Logger, cfg SMTPConfig, targets []EmailTarget) *EmailNotifier {
+	n := &EmailNotifier{logger: logger, smtp: cfg}
+	prefixes := make([]string, len(targets))
+	for i, target := range targets {
+		prefixes[i] = target.Prefix
+		n.queues = append(n.queues, &emailQueue{EmailTarget: target, digest: make(map[string]*digestEntry)})
+	}
+	n.feed = newChangeFeed(client, audit, logger, "email", prefixes)
+	return n
+}
+
+// Run sends notifications until ctx is cancelled. Digests pending at that
+// point are not sent.
+func (n *EmailNotifier) Run(ctx context.Context) {
+	changes := make(chan KeyChange)
+	go n.feed.run(ctx, changes)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case change := <-changes:
+			for _, q := range n.queues {
+				if change.under(q.Prefix) {
+					q.add(change)
+				}
+			}
+		case now := <-tick.C:
+			for _, q := range n.queues {
+				switch {
+				case q.Digest == 0 && len(q.pending) > 0:
+					n.sendChanges(q)
+					q.pending = nil
+				case q.Digest > 0 && !q.due.IsZero() && !now.Before(q.due):
+					n.sendDigest(q)
+					q.digest, q.missed, q.dropped, q.due = make(map[string]*digestEntry), nil, 0, time.Time{}
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (q *emailQueue) add(change KeyChange) {
+	if q.Digest == 0 {
+		q.pending = append(q.pending, change)
+		return
+	}
+	if q.due.IsZero() {
+		q.due = change.Time.UTC().Truncate(q.Digest).Add(q.Digest)
+	}
+	if change.MissedFrom > 0 {
+		q.missed = append(q.missed, change)
+		return
+	}
+	entry, ok := q.digest[change.Key]
+	if !ok {
+		if len(q.digest) >= emailMaxDigestKeys {
+			q.dropped++
+			return
+		}
+		entry = &digestEntry{created: change.Created}
+		q.digest[change.Key] = entry
+	}
+	entry.changes++
+	entry.deleted = change.Deleted
+	entry.revision = change.Revision
+	actor := changeActor(change)
+	for _, a := range entry.actors {
+		if a == actor {
+			return
+		}
+	}
+	entry.actors = append(entry.actors, actor)
+}
+
+func changeActor(change KeyChange) string {
+	if change.Actor == "" {
+		return "someone outside the gateway"
+	}
+	return change.Actor
+}
+
+func changeVerb(change KeyChange) string {
+	switch {
+	case change.Deleted:
+		return "deleted"
+	case change.Created:
+		return "created"
+	}
+	return "updated"
+}
+
+// sendChanges emails pending changes, one email per revision.
+func (n *EmailNotifier) sendChanges(q *emailQueue) {
+	for start := 0; start < len(q.pending); {
+		end := start + 1
+		for end < len(q.pending) && q.pending[end].Revision == q.pending[start].Revision {
+			end++
+		}
+		changes := q.pending[start:end]
+		start = end
+
+		first := changes[0]
+		if first.MissedFrom > 0 {
+			n.send(q, "Missed changes under "+q.Prefix, missedChanges(q.Prefix, first))
+			continue
+		}
+		subject := fmt.Sprintf("%s %s %s", changeActor(first), changeVerb(first), first.Key)
+		if len(changes) > 1 {
+			subject = fmt.Sprintf("%s changed %d keys under %s", changeActor(first), len(changes), q.Prefix)
+		}
+		var b strings.Builder
+		for i, change := range changes {
+			if i == emailMaxChanges {
+				fmt.Fprintf(&b, "...and %d more\n", len(changes)-i)
+				break
+			}
+			fmt.Fprintf(&b, "%s %s %s at revision %d\n", changeActor(change), changeVerb(change), change.Key, change.Revision)
+			if !change.Deleted {
+				for _, line := range diffSnippet(change.Before, change.After, emailSnippetLines, emailSnippetWidth) {
+					b.WriteString("    " + line + "\n")
+				}
+			}
+			b.WriteString("\n")
+		}
+		n.send(q, subject, b.String())
+	}
+}
+
+// missedChanges describes a notice of changes compacted before they could
+// be notified.
+func missedChanges(prefix string, change KeyChange) string {
+	return fmt.Sprintf("Changes after revision %d up to %d were compacted before they could be notified; check %s for its current state.\n",
+		change.MissedFrom, change.Revision, prefix)
+}
+
+// sendDigest emails the summary of the period that just ended.
+func (n *EmailNotifier) sendDigest(q *emailQueue) {
+	keys := make([]string, 0, len(q.digest))
+	total := q.dropped
+	for key, entry := range q.digest {
+		keys = append(keys, key)
+		total += entry.changes
+	}
+	sort.Strings(keys)
+
+	period := "Hourly"
+	if q.Digest >= 24*time.Hour {
+		period = "Daily"
+	}
+	end := q.due
+	var b strings.Builder
+	fmt.Fprintf(&b, "Changes under %s from %s to %s:\n\n", q.Prefix,
+		end.Add(-q.Digest).Format(time.RFC3339), end.Format(time.RFC3339))
+	for _, key := range keys {
+		entry := q.digest[key]
+		state := "updated"
+		switch {
+		case entry.deleted:
+			state = "deleted"
+		case entry.created:
+			state = "created"
+		}
+		times := "once"
+		if entry.changes > 1 {
+			times = fmt.Sprintf("%d times", entry.changes)
+		}
+		fmt.Fprintf(&b, "%s: %s, changed %s by %s (last at revision %d)\n",
+			key, state, times, strings.Join(entry.actors, ", "), entry.revision)
+	}
+	if q.dropped > 0 {
+		fmt.Fprintf(&b, "\n...and %d changes to other keys\n", q.dropped)
+	}
+	for _, change := range q.missed {
+		b.WriteString("\n" + missedChanges(q.Prefix, change))
+	}
+	n.send(q, fmt.Sprintf("%s digest for %s: %d changes", period, q.Prefix, total), b.String())
+}
+
+func (n *EmailNotifier) send(q *emailQueue, subject, body string) {
+	if err := n.sendMail(q.To, subject, body); err != nil {
+		n.logger.Warn("Cannot send email notification", zap.String("prefix", q.Prefix), zap.Error(err))
+	}
+}
+
+// sendMail delivers a plain text message. It is written out rather than
+// using smtp.SendMail so the connection can have a deadline.
+func (n *EmailNotifier) sendMail(to []string, subject, body string) error {
+	conn, err := net.DialTimeout("tcp", n.smtp.Addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
+		conn.Close()
+		return err
+	}
+	host, _, _ := net.SplitHostPort(n.smtp.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.smtp.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.smtp.Username, n.smtp.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.smtp.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.smtp.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	// Q-encoding also keeps newlines in keys out of the header.
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[etcd-gateway] "+subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/namespace"
+	"google.golang.org/grpc"
+)
+
+// environmentHeader selects an environment for a request to an unprefixed path.
+const environmentHeader = "X-Environment"
+
+// Environment maps a logical environment such as "prod" onto either a key
+// prefix in the gateway's own cluster or a separate cluster.
+type Environment struct {
+	Name      string   `json:"name"`
+	Prefix    string   `json:"prefix,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
+}
+
+// ParseEnvironments parses "name=/prefix/" and "name=host:port;host:port"
+// entries. A prefix entry is served from the gateway's own cluster.
+func ParseEnvironments(entries []string) ([]Environment, error) {
+	var envs []Environment
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name, target, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || target == "" || strings.Contains(name, "/") {
+			return nil, errors.New("invalid environment " + strconv.Quote(entry) + ", expected name=/prefix/ or name=host:port;...")
+		}
+		if seen[name] {
+			return nil, errors.New("duplicate environment " + strconv.Quote(name))
+		}
+		seen[name] = true
+		env := Environment{Name: name}
+		if strings.HasPrefix(target, "/") {
+			env.Prefix = strings.TrimSuffix(target, "/")
+			if env.Prefix == "" {
+				return nil, errors.New("environment " + strconv.Quote(name) + " cannot use the root prefix")
+			}
+		} else {
+			env.Endpoints = strings.Split(target, ";")
+		}
+		envs = append(envs, env)
+	}
+	return envs, nil
+}
+
+// Client returns a client for env. Prefix environments share base's
+// connection with keys transparently rooted under the prefix, so "/app/x"
+// in environment "dev" is stored as "/env/dev/app/x". Others connect as
+// tuning says.
+func (env Environment) Client(base *clientv3.Client, tuning EtcdTuning) (*clientv3.Client, error) {
+	if len(env.Endpoints) > 0 {
+		return clientv3.New(tuning.Config(env.Endpoints, grpc.WithChainUnaryInterceptor(EtcdTimingInterceptor)))
+	}
+	client := *base
+	client.KV = namespace.NewKV(base.KV, env.Prefix)
+	client.Watcher = namespace.NewWatcher(base.Watcher, env.Prefix)
+	client.Lease = namespace.NewLease(base.Lease, env.Prefix)
+	return &client, nil
+}
+
+// EnvironmentMiddleware serves requests carrying an X-Environment header from
+// that environment's handler instead of the default one.
+func EnvironmentMiddleware(handlers map[string]http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.GetHeader(environmentHeader)
+		if name == "" {
+			c.Next()
+			return
+		}
+		h, ok := handlers[name]
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Unknown environment"})
+			return
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
+
+// EnvironmentPathHandler serves /api/v1/env/:env/*path from that environment's
+// handler as if the request had been made to /api/v1/*path.
+func EnvironmentPathHandler(handlers map[string]http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("env")
+		h, ok := handlers[name]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown environment"})
+			return
+		}
+		segment := "/env/" + name
+		u := *c.Request.URL
+		u.Path = strings.Replace(u.Path, segment, "", 1)
+		u.RawPath = strings.Replace(u.RawPath, segment, "", 1)
+		req := c.Request.WithContext(c.Request.Context())
+		req.URL = &u
+		h.ServeHTTP(c.Writer, req)
+	}
+}
+
+// EnvironmentsHandler lists the configured environments.
+func EnvironmentsHandler(envs []Environment) gin.HandlerFunc {
+	if envs == nil {
+		envs = []Environment{}
+	}
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, envs)
+	}
+}
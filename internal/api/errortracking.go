@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// errorQueueSize bounds the events waiting to be sent; more are dropped so
+// an error storm cannot build up memory or slow requests down.
+const errorQueueSize = 100
+
+// sentryStripHeaders are request headers never sent with an event.
+var sentryStripHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+// sentryFrame is a stack frame in Sentry's event format.
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// sentryException is an exception in Sentry's event format.
+type sentryException struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Stacktrace *struct {
+		Frames []sentryFrame `json:"frames"`
+	} `json:"stacktrace,omitempty"`
+}
+
+// sentryEvent is the subset of Sentry's event format the gateway sends.
+type sentryEvent struct {
+	EventID     string `json:"event_id"`
+	Timestamp   string `json:"timestamp"`
+	Level       string `json:"level"`
+	Platform    string `json:"platform"`
+	Logger      string `json:"logger"`
+	ServerName  string `json:"server_name,omitempty"`
+	Release     string `json:"release,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Exception   *struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception,omitempty"`
+	Request struct {
+		URL         string            `json:"url"`
+		Method      string            `json:"method"`
+		QueryString string            `json:"query_string,omitempty"`
+		Headers     map[string]string `json:"headers,omitempty"`
+	} `json:"request"`
+	User *struct {
+		Username  string `json:"username,omitempty"`
+		IPAddress string `json:"ip_address,omitempty"`
+	} `json:"user,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// ErrorReporter sends panics and server errors to Sentry, or any service
+// accepting Sentry's store API, in the background.
+type ErrorReporter struct {
+	storeURL    string
+	auth        string
+	release     string
+	environment string
+	serverName  string
+	logger      *zap.Logger
+	http        *http.Client
+	events      chan sentryEvent
+}
+
+// NewErrorReporter creates a reporter for the project named by dsn, of the
+// form "https://publicKey@host/projectID". Events are tagged with release,
+// which defaults to the VCS revision the binary was built from, and
+// environment. Call Run to start sending.
+func NewErrorReporter(dsn, release, environment string, logger *zap.Logger) (*ErrorReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("invalid DSN, expected https://publicKey@host/projectID")
+	}
+	path, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndexByte(project, '/'); i >= 0 {
+		path, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return nil, errors.New("invalid DSN, missing project ID")
+	}
+	if release == "" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range info.Settings {
+				if s.Key == "vcs.revision" {
+					release = s.Value
+				}
+			}
+		}
+	}
+	hostname, _ := os.Hostname()
+	return &ErrorReporter{
+		storeURL:    u.Scheme + "://" + u.Host + path + "/api/" + project + "/store/",
+		auth:        "Sentry sentry_version=7, sentry_client=etcd-gateway, sentry_key=" + u.User.Username(),
+		release:     release,
+		environment: environment,
+		serverName:  hostname,
+		logger:      logger,
+		http:        &http.Client{Timeout: 10 * time.Second},
+		events:      make(chan sentryEvent, errorQueueSize),
+	}, nil
+}
+
+// Run sends captured events until ctx is cancelled.
+func (r *ErrorReporter) Run(ctx context.Context) {
+	header := http.Header{"X-Sentry-Auth": {r.auth}}
+	for {
+		select {
+		case event := <-r.events:
+			if err := postJSON(ctx, r.http, r.storeURL, header, event); err != nil {
+				r.logger.Warn("Cannot report error", zap.String("eventId", event.EventID), zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// capture queues an event about the request, dropping it if the queue is
+// full.
+func (r *ErrorReporter) capture(c *gin.Context, level string, status int, exception sentryException) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "etcd-gateway",
+		ServerName:  r.serverName,
+		Release:     r.release,
+		Environment: r.environment,
+		Tags: map[string]string{
+			"method":    c.Request.Method,
+			"route":     c.FullPath(),
+			"status":    fmt.Sprint(status),
+			"requestId": requestID(c),
+		},
+	}
+	event.Exception = &struct {
+		Values []sentryException `json:"values"`
+	}{Values: []sentryException{exception}}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	event.Request.URL = scheme + "://" + c.Request.Host + c.Request.URL.Path
+	event.Request.Method = c.Request.Method
+	event.Request.QueryString = c.Request.URL.RawQuery
+	event.Request.Headers = make(map[string]string, len(c.Request.Header))
+	for k, v := range c.Request.Header {
+		if !sentryStripHeaders[k] {
+			event.Request.Headers[k] = strings.Join(v, ", ")
+		}
+	}
+	event.User = &struct {
+		Username  string `json:"username,omitempty"`
+		IPAddress string `json:"ip_address,omitempty"`
+	}{Username: requestUser(c), IPAddress: c.ClientIP()}
+
+	select {
+	case r.events <- event:
+	default:
+		r.logger.Warn("Error report queue is full, dropping event", zap.String("route", c.FullPath()))
+	}
+}
+
+// panicStack returns the stack of the goroutine recovering from a panic,
+// oldest frame first as Sentry expects, without the runtime's panic frames.
+func panicStack() []sentryFrame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var stack []sentryFrame
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			module, function := frame.Function, frame.Function
+			if i := strings.LastIndexByte(module, '/'); i >= 0 {
+				if j := strings.IndexByte(module[i:], '.'); j >= 0 {
+					module, function = module[:i+j], module[i+j+1:]
+				}
+			} else if j := strings.IndexByte(module, '.'); j >= 0 {
+				module, function = module[:j], module[j+1:]
+			}
+			stack = append(stack, sentryFrame{
+				Function: function,
+				Module:   module,
+				Filename: frame.File[strings.LastIndexByte(frame.File, '/')+1:],
+				AbsPath:  frame.File,
+				Lineno:   frame.Line,
+				InApp:    module == "main" || strings.HasPrefix(module, "etcd-gateway/"),
+			})
+		}
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return stack
+}
+
+// ErrorReportingMiddleware reports panics, with their stack, and responses
+// with a 5xx status to reporter. A panic is re-raised for gin.Recovery,
+// which must run before this middleware, to answer. reporter may be nil, in
+// which case nothing is reported.
+func ErrorReportingMiddleware(reporter *ErrorReporter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if reporter == nil {
+			c.Next()
+			return
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(p)
+				}
+				exception := sentryException{Type: fmt.Sprintf("%T", p), Value: fmt.Sprint(p)}
+				exception.Stacktrace = &struct {
+					Frames []sentryFrame `json:"frames"`
+				}{Frames: panicStack()}
+				reporter.capture(c, "fatal", http.StatusInternalServerError, exception)
+				panic(p)
+			}
+		}()
+
+		c.Next()
+
+		if status := c.Writer.Status(); status >= 500 {
+			exception := sentryException{Type: "HTTP " + fmt.Sprint(status), Value: http.StatusText(status)}
+			if len(c.Errors) > 0 {
+				exception.Value = c.Errors.String()
+			}
+			reporter.capture(c, "error", status, exception)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// prefixETag fingerprints the keys under prefix from the highest ModRevision
+// beneath it and the number of keys: every put raises the former and every
+// delete lowers the latter, so the pair changes whenever the subtree does.
+// The request's query string is mixed in because it selects the representation.
+func prefixETag(ctx context.Context, c *gin.Context, client clientv3.KV, prefix string) (string, error) {
+	resp, err := client.Txn(ctx).Then(
+		clientv3.OpGet(prefix, clientv3.WithPrefix(), clientv3.WithCountOnly()),
+		clientv3.OpGet(prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithLimit(1),
+			clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortDescend)),
+	).Commit()
+	if err != nil {
+		return "", err
+	}
+
+	count := resp.Responses[0].GetResponseRange().Count
+	var modRevision int64
+	if kvs := resp.Responses[1].GetResponseRange().Kvs; len(kvs) > 0 {
+		modRevision = kvs[0].ModRevision
+	}
+	return formatPrefixETag(c, modRevision, count), nil
+}
+
+// kvsETag computes the same fingerprint as prefixETag from keys already in hand.
+func kvsETag(c *gin.Context, kvs []*mvccpb.KeyValue) string {
+	var modRevision int64
+	for _, kv := range kvs {
+		if kv.ModRevision > modRevision {
+			modRevision = kv.ModRevision
+		}
+	}
+	return formatPrefixETag(c, modRevision, int64(len(kvs)))
+}
+
+func formatPrefixETag(c *gin.Context, modRevision, count int64) string {
+	h := fnv.New32a()
+	h.Write([]byte(c.Request.URL.Path + "?" + c.Request.URL.RawQuery))
+	return fmt.Sprintf(`W/"%d-%d-%x"`, modRevision, count, h.Sum32())
+}
+
+// keyETag is the strong ETag of a single key's value.
+func keyETag(modRevision int64) string {
+	return fmt.Sprintf(`"%d"`, modRevision)
+}
+
+// notModified sets the ETag header and, if the request's If-None-Match
+// matches it, answers 304 and reports true. Comparison is weak, as RFC 9110
+// requires for If-None-Match.
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	inm := c.GetHeader("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+)
+
+// EtcdTuning is how the gateway's etcd clients connect. Zero fields keep
+// the etcd client's defaults, which assume a cluster on the local network;
+// deployments reaching etcd over a WAN raise the timeouts and windows.
+type EtcdTuning struct {
+	DialTimeout time.Duration
+	// KeepAliveTime and KeepAliveTimeout ping idle connections so dead
+	// ones are noticed, with PermitWithoutStream even with no call or
+	// watch in flight.
+	KeepAliveTime       time.Duration
+	KeepAliveTimeout    time.Duration
+	PermitWithoutStream bool
+	MaxCallSendMsgSize  int
+	MaxCallRecvMsgSize  int
+	// AutoSyncInterval, when set, replaces the configured endpoints with
+	// the cluster's members at that interval.
+	AutoSyncInterval time.Duration
+	// BackoffMaxDelay caps gRPC's reconnect backoff, two minutes by
+	// default.
+	BackoffMaxDelay time.Duration
+	// WindowSize is the initial HTTP/2 flow control window of streams and
+	// connections, which bounds throughput over high latency links.
+	WindowSize int32
+}
+
+// Config returns a client config for endpoints connecting as t says, with
+// the extra dial options.
+func (t EtcdTuning) Config(endpoints []string, opts ...grpc.DialOption) clientv3.Config {
+	if t.BackoffMaxDelay > 0 {
+		params := grpc.ConnectParams{Backoff: backoff.DefaultConfig, MinConnectTimeout: 20 * time.Second}
+		params.Backoff.MaxDelay = t.BackoffMaxDelay
+		opts = append(opts, grpc.WithConnectParams(params))
+	}
+	if t.WindowSize > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(t.WindowSize), grpc.WithInitialConnWindowSize(t.WindowSize))
+	}
+	return clientv3.Config{
+		Endpoints:            endpoints,
+		DialTimeout:          t.DialTimeout,
+		DialKeepAliveTime:    t.KeepAliveTime,
+		DialKeepAliveTimeout: t.KeepAliveTimeout,
+		PermitWithoutStream:  t.PermitWithoutStream,
+		MaxCallSendMsgSize:   t.MaxCallSendMsgSize,
+		MaxCallRecvMsgSize:   t.MaxCallRecvMsgSize,
+		AutoSyncInterval:     t.AutoSyncInterval,
+		DialOptions:          opts,
+	}
+}
+
+// WatchKV is the part of an etcd client that reads, writes and watches
+// keys.
+type WatchKV interface {
+	clientv3.KV
+	clientv3.Watcher
+}
+
+// LeaseKV is the part of an etcd client that writes keys and grants the
+// leases they are attached to.
+type LeaseKV interface {
+	clientv3.KV
+	clientv3.Lease
+}
@@ -0,0 +1,529 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// EtcdProxy serves etcd's own KV, Watch and Lease gRPC services, so etcd
+// clients can use the gateway as if it were etcd. Reads, writes and watches
+// go through the gateway's guarded client, so denied keys, validation and
+// mutation apply as they do to the HTTP API, and writes are audited. Writes
+// to the gateway's own keys and compaction are refused. Leases are passed
+// through to etcd unchanged. Callers are authenticated by ProxyAuth.
+type EtcdProxy struct {
+	client   *clientv3.Client
+	leases   pb.LeaseClient
+	audit    *AuditLog
+	timeouts Timeouts
+	logger   *zap.Logger
+}
+
+// NewEtcdProxy creates a proxy to client's cluster. Writes are recorded in
+// audit, which may be nil.
+func NewEtcdProxy(client *clientv3.Client, audit *AuditLog, timeouts Timeouts, logger *zap.Logger) *EtcdProxy {
+	return &EtcdProxy{client: client, leases: clientv3.RetryLeaseClient(client), audit: audit, timeouts: timeouts, logger: logger}
+}
+
+// Register adds the proxied services to s.
+func (p *EtcdProxy) Register(s *grpc.Server) {
+	pb.RegisterKVServer(s, p)
+	pb.RegisterWatchServer(s, p)
+	pb.RegisterLeaseServer(s, p)
+}
+
+// proxyError is the status for a failed proxied call. etcd's own errors are
+// returned as etcd returned them, so clients see the errors they expect;
+// refusals by the KV guards are reported as by GRPCServer.
+func proxyError(ctx context.Context, err error) error {
+	var etcdErr rpctypes.EtcdError
+	if errors.As(err, &etcdErr) {
+		return status.Error(etcdErr.Code(), etcdErr.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return rpcEtcdError(ctx, err)
+}
+
+// errReservedKey refuses writes to the gateway's own keys, such as the
+// audit log and trash, which native clients must not rewrite.
+var errReservedKey = status.Error(codes.PermissionDenied, "key is reserved by the gateway")
+
+// reservedRange reports whether [key, end) includes any of the gateway's own
+// keys. An empty end is the single key.
+func reservedRange(key, end []byte) bool {
+	return rangeOverlapsPrefix(key, end, "/.")
+}
+
+// txnWritesReserved reports whether r, or a transaction nested in it, may
+// write a reserved key.
+func txnWritesReserved(r *pb.TxnRequest) bool {
+	for _, reqs := range [][]*pb.RequestOp{r.Success, r.Failure} {
+		for _, req := range reqs {
+			switch op := req.Request.(type) {
+			case *pb.RequestOp_RequestPut:
+				if reservedRange(op.RequestPut.Key, nil) {
+					return true
+				}
+			case *pb.RequestOp_RequestDeleteRange:
+				if reservedRange(op.RequestDeleteRange.Key, op.RequestDeleteRange.RangeEnd) {
+					return true
+				}
+			case *pb.RequestOp_RequestTxn:
+				if txnWritesReserved(op.RequestTxn) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// rangeOp is the clientv3 equivalent of r.
+func rangeOp(r *pb.RangeRequest) clientv3.Op {
+	opts := []clientv3.OpOption{
+		clientv3.WithRev(r.Revision),
+		clientv3.WithLimit(r.Limit),
+		clientv3.WithSort(clientv3.SortTarget(r.SortTarget), clientv3.SortOrder(r.SortOrder)),
+		clientv3.WithMinModRev(r.MinModRevision),
+		clientv3.WithMaxModRev(r.MaxModRevision),
+		clientv3.WithMinCreateRev(r.MinCreateRevision),
+		clientv3.WithMaxCreateRev(r.MaxCreateRevision),
+	}
+	if len(r.RangeEnd) > 0 {
+		opts = append(opts, clientv3.WithRange(string(r.RangeEnd)))
+	}
+	if r.Serializable {
+		opts = append(opts, clientv3.WithSerializable())
+	}
+	if r.KeysOnly {
+		opts = append(opts, clientv3.WithKeysOnly())
+	}
+	if r.CountOnly {
+		opts = append(opts, clientv3.WithCountOnly())
+	}
+	return clientv3.OpGet(string(r.Key), opts...)
+}
+
+// putOp is the clientv3 equivalent of r. It always asks for the previous
+// key, for the audit log.
+func putOp(r *pb.PutRequest) clientv3.Op {
+	opts := []clientv3.OpOption{clientv3.WithLease(clientv3.LeaseID(r.Lease)), clientv3.WithPrevKV()}
+	if r.IgnoreValue {
+		opts = append(opts, clientv3.WithIgnoreValue())
+	}
+	if r.IgnoreLease {
+		opts = append(opts, clientv3.WithIgnoreLease())
+	}
+	return clientv3.OpPut(string(r.Key), string(r.Value), opts...)
+}
+
+// deleteOp is the clientv3 equivalent of r. It always asks for the deleted
+// keys, for the audit log.
+func deleteOp(r *pb.DeleteRangeRequest) clientv3.Op {
+	opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+	if len(r.RangeEnd) > 0 {
+		opts = append(opts, clientv3.WithRange(string(r.RangeEnd)))
+	}
+	return clientv3.OpDelete(string(r.Key), opts...)
+}
+
+// txnOp is the clientv3 equivalent of r, including nested transactions.
+func txnOp(r *pb.TxnRequest) clientv3.Op {
+	cmps := make([]clientv3.Cmp, len(r.Compare))
+	for i, cmp := range r.Compare {
+		cmps[i] = clientv3.Cmp(*cmp)
+	}
+	ops := func(reqs []*pb.RequestOp) []clientv3.Op {
+		out := make([]clientv3.Op, len(reqs))
+		for i, req := range reqs {
+			switch op := req.Request.(type) {
+			case *pb.RequestOp_RequestRange:
+				out[i] = rangeOp(op.RequestRange)
+			case *pb.RequestOp_RequestPut:
+				out[i] = putOp(op.RequestPut)
+			case *pb.RequestOp_RequestDeleteRange:
+				out[i] = deleteOp(op.RequestDeleteRange)
+			case *pb.RequestOp_RequestTxn:
+				out[i] = txnOp(op.RequestTxn)
+			}
+		}
+		return out
+	}
+	return clientv3.OpTxn(cmps, ops(r.Success), ops(r.Failure))
+}
+
+// txnChanges describes the writes a committed transaction made, removing
+// the previous keys its requests did not ask for from resp.
+func txnChanges(r *pb.TxnRequest, resp *pb.TxnResponse, revision int64) []AuditChange {
+	reqs := r.Failure
+	if resp.Succeeded {
+		reqs = r.Success
+	}
+	var changes []AuditChange
+	for i, op := range resp.Responses {
+		switch op := op.Response.(type) {
+		case *pb.ResponseOp_ResponsePut:
+			req := reqs[i].GetRequestPut()
+			changes = append(changes, putChange(string(req.Key), op.ResponsePut.PrevKv, revision))
+			if !req.PrevKv {
+				op.ResponsePut.PrevKv = nil
+			}
+		case *pb.ResponseOp_ResponseDeleteRange:
+			changes = append(changes, deleteChanges(op.ResponseDeleteRange.PrevKvs)...)
+			if !reqs[i].GetRequestDeleteRange().PrevKv {
+				op.ResponseDeleteRange.PrevKvs = nil
+			}
+		case *pb.ResponseOp_ResponseTxn:
+			changes = append(changes, txnChanges(reqs[i].GetRequestTxn(), op.ResponseTxn, revision)...)
+		}
+	}
+	return changes
+}
+
+func (p *EtcdProxy) Range(ctx context.Context, r *pb.RangeRequest) (*pb.RangeResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+	resp, err := p.client.Do(ctx, rangeOp(r))
+	if err != nil {
+		return nil, proxyError(ctx, err)
+	}
+	return (*pb.RangeResponse)(resp.Get()), nil
+}
+
+func (p *EtcdProxy) Put(ctx context.Context, r *pb.PutRequest) (*pb.PutResponse, error) {
+	if reservedRange(r.Key, nil) {
+		return nil, errReservedKey
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+	resp, err := p.client.Do(ctx, putOp(r))
+	if err != nil {
+		p.logger.Error("Error writing key to etcd", zap.Error(err))
+		return nil, proxyError(ctx, err)
+	}
+	put := (*pb.PutResponse)(resp.Put())
+	p.audit.Record(ctx, rpcActor(ctx), "put", []AuditChange{putChange(string(r.Key), put.PrevKv, put.Header.Revision)})
+	if !r.PrevKv {
+		put.PrevKv = nil
+	}
+	return put, nil
+}
+
+func (p *EtcdProxy) DeleteRange(ctx context.Context, r *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	if reservedRange(r.Key, r.RangeEnd) {
+		return nil, errReservedKey
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+	resp, err := p.client.Do(ctx, deleteOp(r))
+	if err != nil {
+		p.logger.Error("Error deleting keys from etcd", zap.Error(err))
+		return nil, proxyError(ctx, err)
+	}
+	del := (*pb.DeleteRangeResponse)(resp.Del())
+	p.audit.Record(ctx, rpcActor(ctx), "delete", deleteChanges(del.PrevKvs))
+	if !r.PrevKv {
+		del.PrevKvs = nil
+	}
+	return del, nil
+}
+
+func (p *EtcdProxy) Txn(ctx context.Context, r *pb.TxnRequest) (*pb.TxnResponse, error) {
+	if txnWritesReserved(r) {
+		return nil, errReservedKey
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+	resp, err := p.client.Do(ctx, txnOp(r))
+	if err != nil {
+		p.logger.Error("Error committing transaction to etcd", zap.Error(err))
+		return nil, proxyError(ctx, err)
+	}
+	txn := (*pb.TxnResponse)(resp.Txn())
+	p.audit.Record(ctx, rpcActor(ctx), "txn", txnChanges(r, txn, txn.Header.Revision))
+	return txn, nil
+}
+
+// Compact is refused: compaction destroys the history that undo, audit
+// diffs and watch resumption rely on, so it is left to etcd's operators.
+func (p *EtcdProxy) Compact(ctx context.Context, r *pb.CompactionRequest) (*pb.CompactionResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "compaction is not available through the gateway")
+}
+
+// watchStreamKey is the metadata giving each proxied watch stream its own
+// stream to etcd; clientv3 shares one between watches with equal metadata.
+const watchStreamKey = "x-gateway-watch-stream"
+
+// proxyWatches are the watches created on one Watch stream, each forwarded
+// from its own clientv3 watch under the ID the stream knows it by.
+type proxyWatches struct {
+	stream pb.Watch_WatchServer
+	fail   context.CancelFunc
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	cancels map[int64]context.CancelFunc
+	nextID  int64
+}
+
+func (ws *proxyWatches) send(resp *pb.WatchResponse) {
+	ws.sendMu.Lock()
+	defer ws.sendMu.Unlock()
+	if err := ws.stream.Send(resp); err != nil {
+		ws.fail()
+	}
+}
+
+// create starts the watch r asks for, answering with its ID once etcd has
+// created it. IDs the stream is already using are refused, as etcd does.
+func (ws *proxyWatches) create(ctx context.Context, watcher clientv3.Watcher, r *pb.WatchCreateRequest) {
+	ws.mu.Lock()
+	id := r.WatchId
+	if id == clientv3.AutoWatchID {
+		for ws.cancels[ws.nextID] != nil {
+			ws.nextID++
+		}
+		id = ws.nextID
+		ws.nextID++
+	} else if ws.cancels[id] != nil {
+		ws.mu.Unlock()
+		ws.send(&pb.WatchResponse{WatchId: id, Created: true, Canceled: true, CancelReason: "mvcc: duplicate watch ID provided on the WatchStream"})
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	ws.cancels[id] = cancel
+	ws.mu.Unlock()
+
+	opts := []clientv3.OpOption{clientv3.WithRev(r.StartRevision), clientv3.WithCreatedNotify()}
+	if len(r.RangeEnd) > 0 {
+		opts = append(opts, clientv3.WithRange(string(r.RangeEnd)))
+	}
+	if r.ProgressNotify {
+		opts = append(opts, clientv3.WithProgressNotify())
+	}
+	if r.PrevKv {
+		opts = append(opts, clientv3.WithPrevKV())
+	}
+	for _, filter := range r.Filters {
+		switch filter {
+		case pb.WatchCreateRequest_NOPUT:
+			opts = append(opts, clientv3.WithFilterPut())
+		case pb.WatchCreateRequest_NODELETE:
+			opts = append(opts, clientv3.WithFilterDelete())
+		}
+	}
+	go ws.forward(ctx, id, watcher.Watch(ctx, string(r.Key), opts...))
+}
+
+// forward sends the responses of watch id until it is canceled.
+func (ws *proxyWatches) forward(ctx context.Context, id int64, wch clientv3.WatchChan) {
+	for wresp := range wch {
+		if ctx.Err() != nil {
+			return
+		}
+		header := wresp.Header
+		resp := &pb.WatchResponse{Header: &header, WatchId: id, Created: wresp.Created, CompactRevision: wresp.CompactRevision}
+		for _, ev := range wresp.Events {
+			resp.Events = append(resp.Events, (*mvccpb.Event)(ev))
+		}
+		if err := wresp.Err(); err != nil {
+			resp.Canceled, resp.CancelReason = true, err.Error()
+		}
+		ws.send(resp)
+		if resp.Canceled {
+			ws.remove(id)
+			return
+		}
+	}
+	if ctx.Err() == nil {
+		// The gateway's client was closed.
+		ws.send(&pb.WatchResponse{WatchId: id, Canceled: true, CancelReason: "watch closed"})
+		ws.remove(id)
+	}
+}
+
+// cancel stops watch id, answering as etcd does.
+func (ws *proxyWatches) cancel(id int64) {
+	ws.mu.Lock()
+	cancel := ws.cancels[id]
+	ws.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	ws.remove(id)
+	ws.send(&pb.WatchResponse{WatchId: id, Canceled: true})
+}
+
+func (ws *proxyWatches) remove(id int64) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if cancel := ws.cancels[id]; cancel != nil {
+		cancel()
+		delete(ws.cancels, id)
+	}
+}
+
+// watchStreamSeq tells apart the etcd watch streams of proxied streams.
+var watchStreamSeq uint64
+
+// Watch serves a stream of watches. Watches are created through the
+// gateway's guarded Watcher, so events for denied keys are dropped. Each
+// proxied stream has its own stream to etcd, which the client's metadata
+// such as require-leader is passed on to, so progress requests and
+// notifications only concern its own watches.
+func (p *EtcdProxy) Watch(stream pb.Watch_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	md := metadata.Pairs(watchStreamKey, strconv.FormatUint(atomic.AddUint64(&watchStreamSeq, 1), 10))
+	if in, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := in.Get(rpctypes.MetadataRequireLeaderKey); len(v) > 0 {
+			md.Set(rpctypes.MetadataRequireLeaderKey, v...)
+		}
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+	ws := &proxyWatches{stream: stream, fail: cancel, cancels: map[int64]context.CancelFunc{}}
+
+	reqs := make(chan *pb.WatchRequest)
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case req := <-reqs:
+			switch r := req.RequestUnion.(type) {
+			case *pb.WatchRequest_CreateRequest:
+				ws.create(ctx, p.client, r.CreateRequest)
+			case *pb.WatchRequest_CancelRequest:
+				ws.cancel(r.CancelRequest.WatchId)
+			case *pb.WatchRequest_ProgressRequest:
+				if err := p.client.RequestProgress(ctx); err != nil {
+					p.logger.Warn("Cannot request watch progress from etcd", zap.Error(err))
+				}
+			}
+		case err := <-errc:
+			if err != io.EOF {
+				return err
+			}
+			// The client sends no more requests, but its watches go on.
+			<-ctx.Done()
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (p *EtcdProxy) LeaseGrant(ctx context.Context, r *pb.LeaseGrantRequest) (*pb.LeaseGrantResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+	resp, err := p.leases.LeaseGrant(ctx, r)
+	if err != nil {
+		return nil, proxyError(ctx, err)
+	}
+	return resp, nil
+}
+
+func (p *EtcdProxy) LeaseRevoke(ctx context.Context, r *pb.LeaseRevokeRequest) (*pb.LeaseRevokeResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+	resp, err := p.leases.LeaseRevoke(ctx, r)
+	if err != nil {
+		return nil, proxyError(ctx, err)
+	}
+	return resp, nil
+}
+
+func (p *EtcdProxy) LeaseTimeToLive(ctx context.Context, r *pb.LeaseTimeToLiveRequest) (*pb.LeaseTimeToLiveResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+	resp, err := p.leases.LeaseTimeToLive(ctx, r)
+	if err != nil {
+		return nil, proxyError(ctx, err)
+	}
+	return resp, nil
+}
+
+func (p *EtcdProxy) LeaseLeases(ctx context.Context, r *pb.LeaseLeasesRequest) (*pb.LeaseLeasesResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+	resp, err := p.leases.LeaseLeases(ctx, r)
+	if err != nil {
+		return nil, proxyError(ctx, err)
+	}
+	return resp, nil
+}
+
+// LeaseKeepAlive relays a keep-alive stream to etcd and its responses back.
+func (p *EtcdProxy) LeaseKeepAlive(stream pb.Lease_LeaseKeepAliveServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	upstream, err := p.leases.LeaseKeepAlive(ctx)
+	if err != nil {
+		return proxyError(ctx, err)
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				// Let etcd answer what was sent before ending the stream.
+				upstream.CloseSend()
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			if err := upstream.Send(req); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			resp, err := upstream.Recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			if err := stream.Send(resp); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	if err := <-errc; err != io.EOF {
+		return proxyError(ctx, err)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	expiryExpiring = "expiring"
+	expiryExpired  = "expired"
+
+	// expiryQueueSize bounds the batches of events waiting to be posted to
+	// webhooks; more are dropped.
+	expiryQueueSize = 100
+	// expirySubscriberBuffer is how many events a slow stream may fall
+	// behind by before it misses some.
+	expirySubscriberBuffer = 64
+)
+
+// trackedLease is a lease keys under the watched prefixes are attached to.
+type trackedLease struct {
+	keys map[string]bool
+	// deadline is when the lease expires unless kept alive, or zero until
+	// it is first checked.
+	deadline time.Time
+	warned   bool
+}
+
+// ExpiryNotifier warns when keys attached to leases under a set of prefixes
+// are about to expire, and reports them when they have, to streaming
+// clients and webhooks. Each replica tracks leases and posts on its own.
+type ExpiryNotifier struct {
+	client   *clientv3.Client
+	logger   *zap.Logger
+	prefixes []string
+	warn     time.Duration
+	webhooks []string
+	http     *http.Client
+	queue    chan []ExpiryEvent
+
+	mu          sync.Mutex
+	subscribers map[chan ExpiryEvent]bool
+}
+
+// NewExpiryNotifier creates a notifier warning warn before leases of keys
+// under prefixes expire, and posting events to webhooks as
+// {"events": [...]}. Call Run to start tracking.
+func NewExpiryNotifier(client *clientv3.Client, logger *zap.Logger, prefixes []string, warn time.Duration, webhooks []string) (*ExpiryNotifier, error) {
+	for _, webhook := range webhooks {
+		if u, err := url.Parse(webhook); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, errors.New("invalid webhook URL " + strconv.Quote(webhook))
+		}
+	}
+	return &ExpiryNotifier{
+		client:      client,
+		logger:      logger,
+		prefixes:    coveringPrefixes(prefixes),
+		warn:        warn,
+		webhooks:    webhooks,
+		http:        &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan []ExpiryEvent, expiryQueueSize),
+		subscribers: make(map[chan ExpiryEvent]bool),
+	}, nil
+}
+
+// Subscribe returns a channel receiving every event from now on, and a
+// function to stop receiving them. Events a subscriber is too slow for are
+// dropped.
+func (n *ExpiryNotifier) Subscribe() (<-chan ExpiryEvent, func()) {
+	ch := make(chan ExpiryEvent, expirySubscriberBuffer)
+	n.mu.Lock()
+	n.subscribers[ch] = true
+	n.mu.Unlock()
+	return ch, func() {
+		n.mu.Lock()
+		delete(n.subscribers, ch)
+		n.mu.Unlock()
+	}
+}
+
+func (n *ExpiryNotifier) publish(events []ExpiryEvent) {
+	if len(events) == 0 {
+		return
+	}
+	n.mu.Lock()
+	for ch := range n.subscribers {
+		for _, event := range events {
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+	n.mu.Unlock()
+	if len(n.webhooks) == 0 {
+		return
+	}
+	select {
+	case n.queue <- events:
+	default:
+		n.logger.Warn("Expiry webhook queue is full, dropping events", zap.Int("events", len(events)))
+	}
+}
+
+// Run tracks leased keys and sends events until ctx is cancelled.
+func (n *ExpiryNotifier) Run(ctx context.Context) {
+	if len(n.webhooks) > 0 {
+		go n.deliver(ctx)
+	}
+	for ctx.Err() == nil {
+		err := n.track(ctx)
+		if ctx.Err() == nil {
+			n.logger.Warn("Lease expiry tracking failed", zap.Error(err))
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// deliver posts queued events to every webhook until ctx is cancelled.
+func (n *ExpiryNotifier) deliver(ctx context.Context) {
+	for {
+		select {
+		case events := <-n.queue:
+			for _, webhook := range n.webhooks {
+				if err := postJSON(ctx, n.http, webhook, nil, map[string]interface{}{"events": events}); err != nil {
+					n.logger.Warn("Cannot post expiry events", zap.String("webhook", webhook), zap.Error(err))
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// leaseTracker is the state of one run of track.
+type leaseTracker struct {
+	leases map[clientv3.LeaseID]*trackedLease
+	// keyLease is the lease each tracked key is attached to.
+	keyLease map[string]clientv3.LeaseID
+}
+
+func (t *leaseTracker) attach(key string, lease clientv3.LeaseID) {
+	if t.keyLease[key] == lease {
+		return
+	}
+	t.detach(key)
+	if lease == 0 {
+		return
+	}
+	l := t.leases[lease]
+	if l == nil {
+		l = &trackedLease{keys: make(map[string]bool)}
+		t.leases[lease] = l
+	}
+	l.keys[key] = true
+	t.keyLease[key] = lease
+}
+
+// detach stops tracking key, returning the lease it was attached to.
+func (t *leaseTracker) detach(key string) clientv3.LeaseID {
+	lease, ok := t.keyLease[key]
+	if !ok {
+		return 0
+	}
+	delete(t.keyLease, key)
+	if l := t.leases[lease]; l != nil {
+		delete(l.keys, key)
+		if len(l.keys) == 0 {
+			delete(t.leases, lease)
+		}
+	}
+	return lease
+}
+
+// track loads the leased keys under the prefixes at one revision, then
+// follows changes to them and checks their leases every second, until the
+// watch fails.
+func (n *ExpiryNotifier) track(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	t := &leaseTracker{leases: make(map[clientv3.LeaseID]*trackedLease), keyLease: make(map[string]clientv3.LeaseID)}
+	var rev int64
+	for _, prefix := range n.prefixes {
+		var err error
+		rev, err = scanPrefixAt(ctx, n.client, prefix, rev, func(kv *mvccpb.KeyValue) {
+			t.attach(string(kv.Key), clientv3.LeaseID(kv.Lease))
+		}, clientv3.WithKeysOnly())
+		if err != nil {
+			return err
+		}
+	}
+
+	responses := make(chan clientv3.WatchResponse)
+	failed := make(chan error, len(n.prefixes))
+	for _, prefix := range n.prefixes {
+		go func(prefix string) {
+			for wresp := range n.client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1)) {
+				if err := wresp.Err(); err != nil {
+					failed <- err
+					return
+				}
+				select {
+				case responses <- wresp:
+				case <-ctx.Done():
+					return
+				}
+			}
+			failed <- ctx.Err()
+		}(prefix)
+	}
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case wresp := <-responses:
+			n.publish(n.apply(ctx, t, wresp.Events))
+		case now := <-tick.C:
+			n.publish(n.check(ctx, t, now))
+		case err := <-failed:
+			return err
+		}
+	}
+}
+
+// apply updates the tracked keys from watched events, returning an expired
+// event for each deleted key whose lease no longer exists.
+func (n *ExpiryNotifier) apply(ctx context.Context, t *leaseTracker, events []*clientv3.Event) []ExpiryEvent {
+	deleted := make(map[clientv3.LeaseID][]string)
+	for _, ev := range events {
+		key := string(ev.Kv.Key)
+		if ev.Type == clientv3.EventTypeDelete {
+			if lease := t.detach(key); lease != 0 {
+				deleted[lease] = append(deleted[lease], key)
+			}
+			continue
+		}
+		t.attach(key, clientv3.LeaseID(ev.Kv.Lease))
+	}
+
+	var out []ExpiryEvent
+	now := time.Now().UTC()
+	for lease, keys := range deleted {
+		resp, err := n.client.TimeToLive(ctx, lease)
+		if err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			n.logger.Warn("Cannot check lease of deleted keys", zap.Int64("lease", int64(lease)), zap.Error(err))
+			continue
+		}
+		// Keys deleted while their lease lives on did not expire.
+		if err == nil && resp.TTL != -1 {
+			continue
+		}
+		for _, key := range keys {
+			out = append(out, ExpiryEvent{Type: expiryExpired, Key: key, Lease: formatLeaseID(lease), Time: now})
+		}
+	}
+	return out
+}
+
+// check asks for the remaining TTL of leases that may be within warn of
+// expiring, returning an expiring event for each key of those that are and
+// have not been warned about since they were last kept alive past it.
+func (n *ExpiryNotifier) check(ctx context.Context, t *leaseTracker, now time.Time) []ExpiryEvent {
+	var out []ExpiryEvent
+	for lease, l := range t.leases {
+		due := l.deadline
+		if !l.warned {
+			due = due.Add(-n.warn)
+		}
+		if now.Before(due) {
+			continue
+		}
+		resp, err := n.client.TimeToLive(ctx, lease)
+		if err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			n.logger.Debug("Cannot check lease", zap.Int64("lease", int64(lease)), zap.Error(err))
+			continue
+		}
+		if err != nil || resp.TTL < 0 {
+			// Its keys are being deleted; the watch reports them.
+			l.deadline = now.Add(time.Second)
+			continue
+		}
+		remaining := time.Duration(resp.TTL) * time.Second
+		l.deadline = now.Add(remaining)
+		if remaining > n.warn {
+			l.warned = false
+			continue
+		}
+		if l.warned {
+			continue
+		}
+		l.warned = true
+		for key := range l.keys {
+			out = append(out, ExpiryEvent{Type: expiryExpiring, Key: key, Lease: formatLeaseID(lease), TTL: resp.TTL, Time: now.UTC()})
+		}
+	}
+	return out
+}
+
+// ExpiryEventsHandler streams expiry events, optionally only those for keys
+// under ?prefix=, as server-sent events named after their type, until the
+// client disconnects.
+func ExpiryEventsHandler(notifier *ExpiryNotifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if notifier == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Expiry notifications are not enabled"})
+			return
+		}
+		prefix := c.Query("prefix")
+		events, unsubscribe := notifier.Subscribe()
+		defer unsubscribe()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+		for {
+			select {
+			case event := <-events:
+				if !strings.HasPrefix(event.Key, prefix) {
+					continue
+				}
+				c.SSEvent(event.Type, event)
+				c.Writer.Flush()
+			case <-c.Request.Context().Done():
+				return
+			}
+		}
+	}
+}
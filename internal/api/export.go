@@ -0,0 +1,267 @@
+package api
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// Export formats.
+const (
+	exportJSON  = "json"
+	exportTarGz = "tar.gz"
+)
+
+// archiveValueName is the file in an archive directory holding the value
+// of the key the directory is named after, for keys that are also the
+// parent of other keys.
+const archiveValueName = "__value__"
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// exportPrefix writes every key under prefix, as of one revision, to a
+// temporary file in format and saves it to artifacts. Keys under reserved
+// prefixes are left out, since they cannot be restored.
+func exportPrefix(ctx context.Context, client clientv3.KV, artifacts ArtifactStore, jobID, prefix, format string, progress *JobProgress) (ExportResult, error) {
+	result := ExportResult{Artifact: "export-" + jobID + "." + format, Format: format}
+	count, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return result, err
+	}
+	result.Revision = count.Header.Revision
+	progress.SetTotal(count.Count)
+
+	f, err := os.CreateTemp("", "etcd-gateway-export-*")
+	if err != nil {
+		return result, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(f, hash)}
+	if format == exportTarGz {
+		err = writeArchive(ctx, client, counter, prefix, &result, progress)
+	} else {
+		err = writeJSONExport(ctx, client, counter, prefix, &result, progress)
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Bytes = counter.n
+	result.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	progress.Logf("Exported %d keys at revision %d, %d bytes", result.Keys, result.Revision, result.Bytes)
+	if err := artifacts.Save(ctx, result.Artifact, f.Name()); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// writeJSONExport writes the keys as a JSON object with a key per line.
+func writeJSONExport(ctx context.Context, client clientv3.KV, w io.Writer, prefix string, result *ExportResult, progress *JobProgress) error {
+	buf := bufio.NewWriter(w)
+	header, _ := json.Marshal(prefix)
+	fmt.Fprintf(buf, "{\"prefix\":%s,\"revision\":%d,\"keys\":[", header, result.Revision)
+	var writeErr error
+	_, err := scanPrefixAt(ctx, client, prefix, result.Revision, func(kv *mvccpb.KeyValue) {
+		progress.Add(1)
+		if writeErr != nil || strings.HasPrefix(string(kv.Key), "/.") {
+			return
+		}
+		line, err := json.Marshal(DumpedKey{Key: string(kv.Key), Value: string(kv.Value)})
+		if err != nil {
+			writeErr = err
+			return
+		}
+		if result.Keys > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString("\n")
+		_, writeErr = buf.Write(line)
+		result.Keys++
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err == nil {
+		_, err = buf.WriteString("\n]}\n")
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	return err
+}
+
+// archivePath returns the file path of key in an archive, or false if the
+// key cannot be a path: it has empty, "." or ".." segments, or ends in
+// archiveValueName. Keys that are parents of other keys, and keys ending
+// in "/", are written to archiveValueName in their directory.
+func archivePath(key string, parents map[string]bool) (string, bool) {
+	if !strings.HasPrefix(key, "/") || key == "/" {
+		return "", false
+	}
+	path := key[1:]
+	dir := strings.HasSuffix(path, "/")
+	segments := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", false
+		}
+	}
+	if segments[len(segments)-1] == archiveValueName {
+		return "", false
+	}
+	if dir || parents[key] {
+		return strings.TrimSuffix(path, "/") + "/" + archiveValueName, true
+	}
+	return path, true
+}
+
+// writeArchive writes the keys as a gzipped tar with a file per key. A
+// keys-only pass first finds the keys that are parents of other keys.
+func writeArchive(ctx context.Context, client clientv3.KV, w io.Writer, prefix string, result *ExportResult, progress *JobProgress) error {
+	parents := make(map[string]bool)
+	_, err := scanPrefixAt(ctx, client, prefix, result.Revision, func(kv *mvccpb.KeyValue) {
+		for i := 1; i < len(kv.Key); i++ {
+			if kv.Key[i] == '/' {
+				parents[string(kv.Key[:i])] = true
+			}
+		}
+	}, clientv3.WithKeysOnly())
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	written := make(map[string]bool)
+	var writeErr error
+	var unrepresentable []string
+	_, err = scanPrefixAt(ctx, client, prefix, result.Revision, func(kv *mvccpb.KeyValue) {
+		progress.Add(1)
+		key := string(kv.Key)
+		if writeErr != nil || strings.HasPrefix(key, "/.") {
+			return
+		}
+		path, ok := archivePath(key, parents)
+		if !ok || written[path] {
+			result.Ignored++
+			if len(unrepresentable) < 10 {
+				unrepresentable = append(unrepresentable, key)
+			}
+			return
+		}
+		written[path] = true
+		hdr := &tar.Header{Name: path, Mode: 0o644, Size: int64(len(kv.Value)), ModTime: now, Typeflag: tar.TypeReg}
+		if writeErr = tw.WriteHeader(hdr); writeErr == nil {
+			_, writeErr = tw.Write(kv.Value)
+		}
+		result.Keys++
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if len(unrepresentable) > 0 {
+		progress.Logf("Left out %d keys that cannot be file paths, e.g. %s", result.Ignored, strings.Join(unrepresentable, ", "))
+	}
+	return err
+}
+
+// ExportJobHandler starts a job exporting every key under a prefix and
+// returns it immediately. Download the result from /jobs/:id/artifact.
+func ExportJobHandler(client clientv3.KV, jobs *Jobs, artifacts ArtifactStore, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ExportRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if !strings.HasPrefix(req.Prefix, "/") || strings.HasPrefix(req.Prefix, "/.") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Prefix must start with / and be outside reserved prefixes"})
+			return
+		}
+		if req.Format == "" {
+			req.Format = exportJSON
+		}
+		if req.Format != exportJSON && req.Format != exportTarGz {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json or tar.gz"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		job, err := jobs.Start(ctx, "export", requestActor(c), func(ctx context.Context, progress *JobProgress) (interface{}, error) {
+			return exportPrefix(ctx, client, artifacts, progress.JobID(), req.Prefix, req.Format, progress)
+		})
+		if err != nil {
+			logger.Error("Error starting export job", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusAccepted, job)
+	}
+}
+
+// JobArtifactHandler downloads the file a finished job produced, with
+// support for Range requests.
+func JobArtifactHandler(jobs *Jobs, artifacts ArtifactStore, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		job, err := jobs.Get(ctx, c.Param("id"))
+		if err != nil {
+			jobError(c, err, logger)
+			return
+		}
+		var result struct {
+			Artifact string `json:"artifact"`
+		}
+		if job.Status != jobSucceeded || json.Unmarshal(job.Result, &result) != nil || result.Artifact == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job has no artifact"})
+			return
+		}
+		err = artifacts.Serve(c, result.Artifact)
+		if errors.Is(err, errArtifactNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Artifact is not available on this gateway"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error serving job artifact", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		}
+	}
+}
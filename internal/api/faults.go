@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrFaultInjected is returned for etcd calls Faults made fail. It carries
+// the Unavailable code etcd's own client reports for an unreachable cluster.
+var ErrFaultInjected = status.Error(codes.Unavailable, "etcdserver: injected fault")
+
+// Faults injects latency and errors into etcd calls and drops watches, so
+// client retries and the gateway's degraded paths can be tested. Only
+// binaries built with the faults tag create one.
+type Faults struct {
+	mu     sync.RWMutex
+	config FaultConfig
+}
+
+// validateFaults checks that config's rates are fractions and its delays
+// are not negative.
+func validateFaults(config FaultConfig) error {
+	if config.LatencyMs < 0 || config.JitterMs < 0 {
+		return errors.New("fault latency and jitter cannot be negative")
+	}
+	if config.ErrorRate < 0 || config.ErrorRate > 1 || config.WatchDropRate < 0 || config.WatchDropRate > 1 {
+		return errors.New("fault rates must be between 0 and 1")
+	}
+	return nil
+}
+
+// NewFaults returns Faults injecting config until it is changed with Set.
+func NewFaults(config FaultConfig) (*Faults, error) {
+	if err := validateFaults(config); err != nil {
+		return nil, err
+	}
+	return &Faults{config: config}, nil
+}
+
+// Config returns the faults currently injected.
+func (f *Faults) Config() FaultConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.config
+}
+
+// Set changes the faults injected from the next etcd call on.
+func (f *Faults) Set(config FaultConfig) error {
+	if err := validateFaults(config); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.config = config
+	return nil
+}
+
+// Install wraps client's KV and Watcher to inject the faults. It goes
+// before the KV guards, so they see injected failures as etcd's own.
+func (f *Faults) Install(client *clientv3.Client) {
+	client.KV = &faultyKV{KV: client.KV, faults: f}
+	client.Watcher = &faultyWatcher{Watcher: client.Watcher, faults: f}
+}
+
+// faultsApply reports whether config's faults apply to any key in [key, end).
+func faultsApply(config FaultConfig, key, end []byte) bool {
+	if len(config.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range config.Prefixes {
+		if rangeOverlapsPrefix(key, end, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// faultDelay is how long to hold back a call or watch response.
+func faultDelay(config FaultConfig) time.Duration {
+	d := time.Duration(config.LatencyMs) * time.Millisecond
+	if config.JitterMs > 0 {
+		d += time.Duration(rand.Int63n(config.JitterMs * int64(time.Millisecond)))
+	}
+	return d
+}
+
+// inject delays a call on [key, end) and returns ErrFaultInjected if it
+// should fail, or ctx's error if it ends while waiting.
+func (f *Faults) inject(ctx context.Context, key, end []byte) error {
+	config := f.Config()
+	if !faultsApply(config, key, end) {
+		return nil
+	}
+	if d := faultDelay(config); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if config.ErrorRate > 0 && rand.Float64() < config.ErrorRate {
+		return ErrFaultInjected
+	}
+	return nil
+}
+
+// faultsApplyToTxn reports whether config's faults apply to any key a
+// transaction compares, reads or writes, including in nested transactions.
+func faultsApplyToTxn(config FaultConfig, cmps []clientv3.Cmp, thenOps, elseOps []clientv3.Op) bool {
+	for _, cmp := range cmps {
+		if faultsApply(config, cmp.Key, cmp.RangeEnd) {
+			return true
+		}
+	}
+	for _, ops := range [][]clientv3.Op{thenOps, elseOps} {
+		for _, op := range ops {
+			if op.IsTxn() {
+				cmps, thenOps, elseOps := op.Txn()
+				if faultsApplyToTxn(config, cmps, thenOps, elseOps) {
+					return true
+				}
+			} else if faultsApply(config, op.KeyBytes(), op.RangeBytes()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// injectTxn injects the faults of a transaction once, if they apply to it.
+func (f *Faults) injectTxn(ctx context.Context, cmps []clientv3.Cmp, thenOps, elseOps []clientv3.Op) error {
+	if !faultsApplyToTxn(f.Config(), cmps, thenOps, elseOps) {
+		return nil
+	}
+	// Every key from the start, which any prefix overlaps.
+	return f.inject(ctx, nil, []byte{0})
+}
+
+// faultyKV is a KV injecting faults before each call.
+type faultyKV struct {
+	clientv3.KV
+	faults *Faults
+}
+
+func (kv *faultyKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	resp, err := kv.Do(ctx, clientv3.OpGet(key, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Get(), nil
+}
+
+func (kv *faultyKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	resp, err := kv.Do(ctx, clientv3.OpPut(key, val, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Put(), nil
+}
+
+func (kv *faultyKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	resp, err := kv.Do(ctx, clientv3.OpDelete(key, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Del(), nil
+}
+
+func (kv *faultyKV) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	var err error
+	if op.IsTxn() {
+		cmps, thenOps, elseOps := op.Txn()
+		err = kv.faults.injectTxn(ctx, cmps, thenOps, elseOps)
+	} else {
+		err = kv.faults.inject(ctx, op.KeyBytes(), op.RangeBytes())
+	}
+	if err != nil {
+		return clientv3.OpResponse{}, err
+	}
+	return kv.KV.Do(ctx, op)
+}
+
+func (kv *faultyKV) Txn(ctx context.Context) clientv3.Txn {
+	return &checkedTxn{Txn: kv.KV.Txn(ctx), ctx: ctx, check: kv.faults.injectTxn}
+}
+
+// faultyWatcher is a Watcher delaying responses and closing watches as if
+// their stream was lost.
+type faultyWatcher struct {
+	clientv3.Watcher
+	faults *Faults
+}
+
+func (w *faultyWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	op := clientv3.OpGet(key, opts...)
+	ctx, cancel := context.WithCancel(ctx)
+	in := w.Watcher.Watch(ctx, key, opts...)
+	out := make(chan clientv3.WatchResponse)
+	go func() {
+		defer close(out)
+		defer cancel()
+		for wresp := range in {
+			config := w.faults.Config()
+			if faultsApply(config, op.KeyBytes(), op.RangeBytes()) {
+				if config.WatchDropRate > 0 && rand.Float64() < config.WatchDropRate {
+					return
+				}
+				if d := faultDelay(config); d > 0 {
+					select {
+					case <-time.After(d):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			select {
+			case out <- wresp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// FaultsHandler returns the faults being injected.
+func FaultsHandler(faults *Faults) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if faults == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Fault injection is not enabled"})
+			return
+		}
+		c.JSON(http.StatusOK, faults.Config())
+	}
+}
+
+// FaultsPutHandler changes the faults injected without a restart. Like the
+// log level, it only changes the replica serving the request.
+func FaultsPutHandler(faults *Faults, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if faults == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Fault injection is not enabled"})
+			return
+		}
+		var config FaultConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if err := faults.Set(config); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Warn("Injected faults changed",
+			zap.Int64("latencyMs", config.LatencyMs),
+			zap.Int64("jitterMs", config.JitterMs),
+			zap.Float64("errorRate", config.ErrorRate),
+			zap.Float64("watchDropRate", config.WatchDropRate),
+			zap.Strings("prefixes", config.Prefixes),
+			zap.String("user", requestUser(c)))
+		c.JSON(http.StatusOK, config)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fieldSet is the fields a client asked for with ?fields=, by JSON name, or
+// nil for every field.
+type fieldSet map[string]bool
+
+// parseFields parses ?fields=, a comma separated list of the JSON fields of
+// the response objects, which are like sample.
+func parseFields(c *gin.Context, sample interface{}) (fieldSet, error) {
+	v := c.Query("fields")
+	if v == "" {
+		return nil, nil
+	}
+	known := jsonFields(reflect.TypeOf(sample))
+	fields := fieldSet{}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if !known[name] {
+			return nil, errors.New("Unknown field " + strconv.Quote(name) + " in fields")
+		}
+		fields[name] = true
+	}
+	return fields, nil
+}
+
+// jsonFields returns the JSON names of struct type t's fields.
+func jsonFields(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// has reports whether the client wants the field.
+func (f fieldSet) has(name string) bool {
+	return f == nil || f[name]
+}
+
+// apply returns v, an object or array of objects, keeping only the fields
+// in f, and the same of tree nodes' children.
+func (f fieldSet) apply(v interface{}) (interface{}, error) {
+	if f == nil {
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	f.prune(out)
+	return out, nil
+}
+
+func (f fieldSet) prune(v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			f.prune(item)
+		}
+	case map[string]interface{}:
+		for name, field := range v {
+			if !f[name] {
+				delete(v, name)
+			} else if name == "children" {
+				f.prune(field)
+			}
+		}
+	}
+}
+
+// sparseJSON responds with v, keeping only the fields in f.
+func sparseJSON(c *gin.Context, code int, f fieldSet, v interface{}) {
+	out, err := f.apply(v)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	c.JSON(code, out)
+}
+
+// sparsePageJSON responds with page, a struct listing objects in its items
+// field, by JSON name, keeping only the fields in f of those objects.
+func sparsePageJSON(c *gin.Context, code int, f fieldSet, page interface{}, items string) {
+	if f == nil {
+		c.JSON(code, page)
+		return
+	}
+	b, err := json.Marshal(page)
+	var out map[string]interface{}
+	if err == nil {
+		err = json.Unmarshal(b, &out)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	f.prune(out[items])
+	c.JSON(code, out)
+}
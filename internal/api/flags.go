@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// flagPrefix holds feature flag definitions, one JSON object per flag.
+const flagPrefix = "/.flags/"
+
+// Flag types.
+const (
+	flagBoolean    = "boolean"
+	flagPercentage = "percentage"
+	flagTargeted   = "targeted"
+)
+
+// flagNamePattern is what flag names may contain.
+var flagNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// validateFlag checks the flag's fields are consistent with its type.
+func validateFlag(f Flag) error {
+	switch f.Type {
+	case flagBoolean:
+		if f.Percentage != 0 || len(f.Rules) > 0 {
+			return errors.New("Boolean flags take neither percentage nor rules")
+		}
+	case flagPercentage:
+		if len(f.Rules) > 0 {
+			return errors.New("Percentage flags do not take rules")
+		}
+	case flagTargeted:
+		if len(f.Rules) == 0 {
+			return errors.New("Targeted flags need at least one rule")
+		}
+		for _, rule := range f.Rules {
+			if rule.Attribute == "" || len(rule.Values) == 0 {
+				return errors.New("Rules need an attribute and at least one value")
+			}
+		}
+	default:
+		return fmt.Errorf("Type must be %s, %s or %s", flagBoolean, flagPercentage, flagTargeted)
+	}
+	if f.Percentage < 0 || f.Percentage > 100 {
+		return errors.New("Percentage must be between 0 and 100")
+	}
+	return nil
+}
+
+// flagAttribute returns the named attribute of fc, with "userId" naming the
+// user ID.
+func flagAttribute(fc FlagContext, name string) (string, bool) {
+	if name == "userId" {
+		return fc.UserID, fc.UserID != ""
+	}
+	v, ok := fc.Attributes[name]
+	return v, ok
+}
+
+// rolloutBucket places a user in one of 10000 buckets for a flag. The flag
+// name is mixed in so each flag's rollout covers a different set of users,
+// while raising a percentage only ever adds users.
+func rolloutBucket(flag, userID string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(flag + "\x00" + userID))
+	return h.Sum32() % 10000
+}
+
+// EvaluateFlag resolves flag f for fc.
+func EvaluateFlag(f Flag, fc FlagContext) FlagResult {
+	if !f.Enabled {
+		return FlagResult{Value: false, Reason: "disabled"}
+	}
+	if f.Type == flagBoolean {
+		return FlagResult{Value: true, Reason: "enabled"}
+	}
+	for _, rule := range f.Rules {
+		v, ok := flagAttribute(fc, rule.Attribute)
+		if !ok {
+			continue
+		}
+		for _, want := range rule.Values {
+			if v == want {
+				return FlagResult{Value: true, Reason: "rule"}
+			}
+		}
+	}
+	switch {
+	case f.Percentage >= 100:
+		return FlagResult{Value: true, Reason: "rollout"}
+	case f.Percentage <= 0:
+		return FlagResult{Value: false, Reason: "default"}
+	case fc.UserID == "":
+		return FlagResult{Value: false, Reason: "noUserId"}
+	}
+	return FlagResult{Value: float64(rolloutBucket(f.Name, fc.UserID)) < f.Percentage*100, Reason: "rollout"}
+}
+
+// listFlags returns every flag, ordered by name.
+func listFlags(ctx context.Context, client clientv3.KV) ([]Flag, error) {
+	resp, err := client.Get(ctx, flagPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	flags := make([]Flag, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var flag Flag
+		if err := json.Unmarshal(kv.Value, &flag); err != nil {
+			return nil, fmt.Errorf("flag %s: %w", kv.Key, err)
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+// FlagListHandler lists every feature flag.
+func FlagListHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		flags, err := listFlags(ctx, client)
+		if err != nil {
+			logger.Error("Error fetching flags from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"flags": flags})
+	}
+}
+
+// FlagGetHandler returns one feature flag.
+func FlagGetHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Get(ctx, flagPrefix+c.Param("name"))
+		if err != nil {
+			logger.Error("Error fetching flag from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if len(resp.Kvs) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Flag not found"})
+			return
+		}
+		c.Header("ETag", keyETag(resp.Kvs[0].ModRevision))
+		c.Data(http.StatusOK, "application/json; charset=utf-8", resp.Kvs[0].Value)
+	}
+}
+
+// FlagPutHandler creates or replaces a feature flag.
+func FlagPutHandler(client clientv3.KV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		if !flagNamePattern.MatchString(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Flag names may only contain letters, digits, '.', '_' and '-'"})
+			return
+		}
+		var flag Flag
+		if err := c.ShouldBindJSON(&flag); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if err := validateFlag(flag); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		flag.Name = name
+		flag.UpdatedBy = requestActor(c)
+		flag.UpdatedAt = time.Now()
+		data, err := json.Marshal(flag)
+		if err != nil {
+			logger.Error("Error encoding flag", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Put(ctx, flagPrefix+name, string(data), clientv3.WithPrevKV())
+		if err != nil {
+			logger.Error("Error writing flag to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		audit.Record(ctx, flag.UpdatedBy, "flag", []AuditChange{putChange(flagPrefix+name, resp.PrevKv, resp.Header.Revision)})
+		c.Header("ETag", keyETag(resp.Header.Revision))
+		status := http.StatusOK
+		if resp.PrevKv == nil {
+			status = http.StatusCreated
+		}
+		c.JSON(status, flag)
+	}
+}
+
+// FlagDeleteHandler deletes a feature flag.
+func FlagDeleteHandler(client clientv3.KV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Delete(ctx, flagPrefix+c.Param("name"), clientv3.WithPrevKV())
+		if err != nil {
+			logger.Error("Error deleting flag from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if resp.Deleted == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Flag not found"})
+			return
+		}
+		audit.Record(ctx, requestActor(c), "flag", deleteChanges(resp.PrevKvs))
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// FlagEvaluateHandler resolves every flag, or those named in ?flag, for the
+// posted context.
+func FlagEvaluateHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var fc FlagContext
+		if err := c.ShouldBindJSON(&fc); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		wanted := make(map[string]bool)
+		for _, name := range c.QueryArray("flag") {
+			wanted[name] = true
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		flags, err := listFlags(ctx, client)
+		if err != nil {
+			logger.Error("Error fetching flags from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		results := make(map[string]FlagResult, len(flags))
+		for _, flag := range flags {
+			if len(wanted) == 0 || wanted[flag.Name] {
+				results[flag.Name] = EvaluateFlag(flag, fc)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"flags": results})
+	}
+}
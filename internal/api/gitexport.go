@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// gitExportQuiet is how long the exporter waits for further events before
+// committing, so bursts of writes are pushed together.
+const gitExportQuiet = time.Second
+
+// gitExportIdentity authors commits whose writer is unknown, and commits all changes.
+const gitExportIdentity = "etcd-gateway"
+
+// GitExportConfig describes the repository that etcd changes are committed to.
+type GitExportConfig struct {
+	Repo     string
+	Branch   string
+	Dir      string
+	Prefixes []string
+}
+
+// GitExporter commits the state of the configured prefixes to a Git
+// repository, one file per key, with a commit per etcd revision. Commits for
+// writes made through this gateway are authored by the audited actor.
+type GitExporter struct {
+	client *clientv3.Client
+	logger *zap.Logger
+	cfg    GitExportConfig
+
+	mu     sync.Mutex
+	actors map[string]string
+}
+
+// NewGitExporter creates an exporter; call Run to start exporting. audit may
+// be nil, in which case every commit is authored by the gateway.
+func NewGitExporter(client *clientv3.Client, audit *AuditLog, logger *zap.Logger, cfg GitExportConfig) *GitExporter {
+	e := &GitExporter{client: client, logger: logger, cfg: cfg, actors: make(map[string]string)}
+	audit.Subscribe(e.noteActor)
+	return e
+}
+
+func (e *GitExporter) noteActor(entry AuditEntry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, change := range entry.Changes {
+		e.actors[change.Key] = entry.Actor
+	}
+}
+
+// author returns the git author for a revision touching keys.
+func (e *GitExporter) author(keys []string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	actor := gitExportIdentity
+	for _, key := range keys {
+		if a, ok := e.actors[key]; ok {
+			actor = a
+			delete(e.actors, key)
+		}
+	}
+	return fmt.Sprintf("%s <%s@%s>", actor, actor, gitExportIdentity)
+}
+
+// filePath maps a key onto the working copy, refusing keys that would
+// escape it or land in .git.
+func (e *GitExporter) filePath(key string) (string, bool) {
+	clean := path.Clean("/" + key)
+	if clean != key || clean == "/" || strings.Contains(clean+"/", "/.git/") {
+		return "", false
+	}
+	return filepath.Join(e.cfg.Dir, filepath.FromSlash(clean)), true
+}
+
+func (e *GitExporter) writeKey(kv *mvccpb.KeyValue) {
+	file, ok := e.filePath(string(kv.Key))
+	if !ok {
+		e.logger.Warn("Key cannot be exported to git", zap.String("key", string(kv.Key)))
+		return
+	}
+	err := os.MkdirAll(filepath.Dir(file), 0o755)
+	if err == nil {
+		err = os.WriteFile(file, kv.Value, 0o644)
+	}
+	if err != nil {
+		e.logger.Warn("Key cannot be exported to git", zap.String("key", string(kv.Key)), zap.Error(err))
+	}
+}
+
+func (e *GitExporter) removeKey(key string) {
+	if file, ok := e.filePath(key); ok {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			e.logger.Warn("Key cannot be removed from git", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// commit commits the working copy if anything changed.
+func (e *GitExporter) commit(ctx context.Context, author, message string) error {
+	if _, err := runGit(ctx, "-C", e.cfg.Dir, "add", "-A"); err != nil {
+		return err
+	}
+	status, err := runGit(ctx, "-C", e.cfg.Dir, "status", "--porcelain")
+	if err != nil || status == "" {
+		return err
+	}
+	_, err = runGit(ctx, "-C", e.cfg.Dir,
+		"-c", "user.name="+gitExportIdentity, "-c", "user.email="+gitExportIdentity+"@"+gitExportIdentity,
+		"commit", "--quiet", "--author", author, "-m", message)
+	return err
+}
+
+func (e *GitExporter) push(ctx context.Context) {
+	if _, err := runGit(ctx, "-C", e.cfg.Dir, "push", "--quiet", "origin", "HEAD:"+e.cfg.Branch); err != nil {
+		e.logger.Warn("Cannot push git export", zap.Error(err))
+	}
+}
+
+// Run exports until ctx is cancelled. Each time the watch is lost the full
+// state is re-exported, since changes may have been missed.
+func (e *GitExporter) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		rev, err := e.snapshot(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				e.logger.Warn("Git export failed", zap.Error(err))
+				time.Sleep(5 * time.Second)
+			}
+			continue
+		}
+		e.follow(ctx, rev)
+	}
+}
+
+// snapshot replaces the exported prefixes with their current contents and
+// returns the revision exported.
+func (e *GitExporter) snapshot(ctx context.Context) (int64, error) {
+	if _, err := gitCheckout(ctx, e.cfg.Repo, e.cfg.Branch, e.cfg.Dir); err != nil {
+		return 0, err
+	}
+	var rev int64
+	for _, prefix := range e.cfg.Prefixes {
+		if dir, ok := e.filePath(strings.TrimSuffix(prefix, "/")); ok {
+			if err := os.RemoveAll(dir); err != nil {
+				return 0, err
+			}
+		}
+		r, err := scanPrefix(ctx, e.client, prefix, e.writeKey)
+		if err != nil {
+			return 0, err
+		}
+		if r > rev {
+			rev = r
+		}
+	}
+	if err := e.commit(ctx, e.author(nil), fmt.Sprintf("Sync at revision %d", rev)); err != nil {
+		return 0, err
+	}
+	e.push(ctx)
+	return rev, nil
+}
+
+// follow commits changes after rev until the watch fails or ctx is cancelled.
+func (e *GitExporter) follow(ctx context.Context, rev int64) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := make(chan *clientv3.Event)
+	failed := make(chan error, len(e.cfg.Prefixes))
+	for _, prefix := range e.cfg.Prefixes {
+		go func(prefix string) {
+			for wresp := range e.client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1)) {
+				if err := wresp.Err(); err != nil {
+					failed <- err
+					return
+				}
+				for _, ev := range wresp.Events {
+					select {
+					case events <- ev:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			failed <- ctx.Err()
+		}(prefix)
+	}
+
+	var pending []*clientv3.Event
+	quiet := time.NewTimer(gitExportQuiet)
+	defer quiet.Stop()
+	for {
+		select {
+		case ev := <-events:
+			pending = append(pending, ev)
+			quiet.Reset(gitExportQuiet)
+		case <-quiet.C:
+			if len(pending) > 0 {
+				e.flush(ctx, pending)
+				pending = nil
+			}
+		case err := <-failed:
+			if ctx.Err() == nil {
+				e.logger.Warn("Git export watch failed", zap.Error(err))
+			}
+			return
+		}
+	}
+}
+
+// flush commits pending events, one commit per revision, then pushes.
+func (e *GitExporter) flush(ctx context.Context, pending []*clientv3.Event) {
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].Kv.ModRevision < pending[j].Kv.ModRevision })
+	for start := 0; start < len(pending); {
+		rev := pending[start].Kv.ModRevision
+		end := start
+		var keys []string
+		for ; end < len(pending) && pending[end].Kv.ModRevision == rev; end++ {
+			ev := pending[end]
+			keys = append(keys, string(ev.Kv.Key))
+			if ev.Type == clientv3.EventTypeDelete {
+				e.removeKey(string(ev.Kv.Key))
+			} else {
+				e.writeKey(ev.Kv)
+			}
+		}
+		message := fmt.Sprintf("Update %s at revision %d", keys[0], rev)
+		if len(keys) > 1 {
+			message = fmt.Sprintf("Update %d keys at revision %d", len(keys), rev)
+		}
+		if err := e.commit(ctx, e.author(keys), message); err != nil {
+			e.logger.Warn("Cannot commit git export", zap.Int64("revision", rev), zap.Error(err))
+		}
+		start = end
+	}
+	e.push(ctx)
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// GitOpsConfig describes the repository reconciled into etcd. Every file
+// under Path becomes the key Prefix + its path relative to Path, holding the
+// file's contents.
+type GitOpsConfig struct {
+	Repo     string
+	Branch   string
+	Path     string
+	Prefix   string
+	Dir      string
+	Interval time.Duration
+	// Prune deletes keys under Prefix that have no file in the repository.
+	Prune bool
+}
+
+// GitOpsSyncer keeps a prefix in line with a Git repository, syncing on
+// every poll interval and whenever Trigger is called (e.g. from a push webhook).
+type GitOpsSyncer struct {
+	client *clientv3.Client
+	audit  *AuditLog
+	logger *zap.Logger
+	cfg    GitOpsConfig
+
+	trigger chan struct{}
+	mu      sync.RWMutex
+	status  GitOpsStatus
+}
+
+// NewGitOpsSyncer creates a syncer; call Run to start syncing.
+func NewGitOpsSyncer(client *clientv3.Client, audit *AuditLog, logger *zap.Logger, cfg GitOpsConfig) *GitOpsSyncer {
+	return &GitOpsSyncer{
+		client:  client,
+		audit:   audit,
+		logger:  logger,
+		cfg:     cfg,
+		trigger: make(chan struct{}, 1),
+		status:  GitOpsStatus{Repo: cfg.Repo, Branch: cfg.Branch, Prefix: cfg.Prefix},
+	}
+}
+
+// Trigger requests a sync as soon as possible.
+func (g *GitOpsSyncer) Trigger() {
+	select {
+	case g.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the outcome of the most recent sync.
+func (g *GitOpsSyncer) Status() GitOpsStatus {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.status
+}
+
+// Run syncs immediately and then on every interval or trigger until ctx is cancelled.
+func (g *GitOpsSyncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(g.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		status, err := g.sync(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			g.logger.Warn("GitOps sync failed", zap.Error(err))
+			status.LastError = err.Error()
+		}
+		g.mu.Lock()
+		g.status = status
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-g.trigger:
+		}
+	}
+}
+
+// runGit runs git with args and returns its trimmed output. Failures include
+// git's own output, which is where the useful detail is.
+func runGit(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, "git", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitCheckout clones branch of repo into dir, or brings an existing clone up
+// to date, and returns the checked out commit.
+func gitCheckout(ctx context.Context, repo, branch, dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if _, err := runGit(ctx, "clone", "--depth", "1", "--branch", branch, "--single-branch", repo, dir); err != nil {
+			return "", err
+		}
+	} else {
+		if _, err := runGit(ctx, "-C", dir, "fetch", "--depth", "1", "origin", branch); err != nil {
+			return "", err
+		}
+		if _, err := runGit(ctx, "-C", dir, "reset", "--hard", "FETCH_HEAD"); err != nil {
+			return "", err
+		}
+	}
+	return runGit(ctx, "-C", dir, "rev-parse", "HEAD")
+}
+
+// desiredState reads the manifest files into a map of key to value.
+func (g *GitOpsSyncer) desiredState() (map[string]string, error) {
+	root := filepath.Join(g.cfg.Dir, g.cfg.Path)
+	desired := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		desired[g.cfg.Prefix+filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	return desired, err
+}
+
+func (g *GitOpsSyncer) sync(ctx context.Context) (GitOpsStatus, error) {
+	status := GitOpsStatus{Repo: g.cfg.Repo, Branch: g.cfg.Branch, Prefix: g.cfg.Prefix, LastSync: time.Now()}
+	commit, err := gitCheckout(ctx, g.cfg.Repo, g.cfg.Branch, g.cfg.Dir)
+	if err != nil {
+		return status, err
+	}
+	status.Commit = commit
+	desired, err := g.desiredState()
+	if err != nil {
+		return status, err
+	}
+
+	actual := make(map[string]*mvccpb.KeyValue)
+	if _, err := scanPrefix(ctx, g.client, g.cfg.Prefix, func(kv *mvccpb.KeyValue) {
+		actual[string(kv.Key)] = kv
+	}); err != nil {
+		return status, err
+	}
+
+	// Each change is guarded by the key's revision as read, so edits made
+	// in the meantime fail the transaction and are reconsidered next sync.
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	for key, value := range desired {
+		kv, ok := actual[key]
+		switch {
+		case !ok:
+			status.Created++
+		case string(kv.Value) != value:
+			status.Updated++
+		default:
+			continue
+		}
+		var rev int64
+		if ok {
+			rev = kv.ModRevision
+		}
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", rev))
+		ops = append(ops, clientv3.OpPut(key, value, clientv3.WithPrevKV()))
+	}
+	if g.cfg.Prune {
+		for key, kv := range actual {
+			if _, ok := desired[key]; !ok {
+				status.Deleted++
+				cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision))
+				ops = append(ops, clientv3.OpDelete(key, clientv3.WithPrevKV()))
+			}
+		}
+	}
+
+	for start := 0; start < len(ops); start += maxTxnOps {
+		end := start + maxTxnOps
+		if end > len(ops) {
+			end = len(ops)
+		}
+		txn, err := g.client.Txn(ctx).If(cmps[start:end]...).Then(ops[start:end]...).Commit()
+		if err != nil {
+			return status, err
+		}
+		if !txn.Succeeded {
+			return status, errors.New("keys changed during sync, retrying on next sync")
+		}
+		var changes []AuditChange
+		for i, r := range txn.Responses {
+			if put := r.GetResponsePut(); put != nil {
+				key := string(ops[start+i].KeyBytes())
+				changes = append(changes, putChange(key, put.PrevKv, txn.Header.Revision))
+			} else {
+				changes = append(changes, deleteChanges(r.GetResponseDeleteRange().PrevKvs)...)
+			}
+		}
+		g.audit.Record(ctx, "gitops@"+commit, "gitops", changes)
+	}
+	if len(ops) > 0 {
+		g.logger.Info("GitOps sync applied changes", zap.String("commit", commit),
+			zap.Int("created", status.Created), zap.Int("updated", status.Updated), zap.Int("deleted", status.Deleted))
+	}
+	return status, nil
+}
+
+// GitOpsStatusHandler reports the last sync.
+func GitOpsStatusHandler(syncer *GitOpsSyncer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if syncer == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "GitOps sync is not enabled"})
+			return
+		}
+		c.JSON(http.StatusOK, syncer.Status())
+	}
+}
+
+// GitOpsSyncHandler triggers a sync, e.g. from a repository push webhook.
+func GitOpsSyncHandler(syncer *GitOpsSyncer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if syncer == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "GitOps sync is not enabled"})
+			return
+		}
+		syncer.Trigger()
+		c.JSON(http.StatusAccepted, gin.H{"status": "sync requested"})
+	}
+}
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// graphqlRequest is the standard GraphQL-over-HTTP request body.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlWatchEvent is the payload delivered to watch subscribers.
+type graphqlWatchEvent struct {
+	Type     string
+	Kv       *mvccpb.KeyValue
+	Revision int64
+}
+
+// selects reports whether the field being resolved selects the named sub-field,
+// looking through nested selections such as tree children.
+func selects(p graphql.ResolveParams, name string) bool {
+	var walk func(set *ast.SelectionSet) bool
+	walk = func(set *ast.SelectionSet) bool {
+		if set == nil {
+			return false
+		}
+		for _, sel := range set.Selections {
+			switch s := sel.(type) {
+			case *ast.Field:
+				if s.Name.Value == name || walk(s.SelectionSet) {
+					return true
+				}
+			case *ast.InlineFragment:
+				if walk(s.SelectionSet) {
+					return true
+				}
+			case *ast.FragmentSpread:
+				if frag, ok := p.Info.Fragments[s.Name.Value].(*ast.FragmentDefinition); ok && walk(frag.SelectionSet) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, field := range p.Info.FieldASTs {
+		if walk(field.SelectionSet) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewGraphQLSchema builds the GraphQL schema covering keys, values, metadata,
+// subtree queries and watch subscriptions. Queries are bounded by
+// timeouts.Read and establishing a subscription by timeouts.Watch.
+func NewGraphQLSchema(client *clientv3.Client, timeouts Timeouts, logger *zap.Logger) (graphql.Schema, error) {
+	keyType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Key",
+		Fields: graphql.Fields{
+			"key": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return string(p.Source.(*mvccpb.KeyValue).Key), nil
+			}},
+			"value": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return string(p.Source.(*mvccpb.KeyValue).Value), nil
+			}},
+			"createRevision": &graphql.Field{Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*mvccpb.KeyValue).CreateRevision, nil
+			}},
+			"modRevision": &graphql.Field{Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*mvccpb.KeyValue).ModRevision, nil
+			}},
+			"version": &graphql.Field{Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*mvccpb.KeyValue).Version, nil
+			}},
+			"lease": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return fmt.Sprintf("%x", p.Source.(*mvccpb.KeyValue).Lease), nil
+			}},
+		},
+	})
+
+	var treeNodeType *graphql.Object
+	treeNodeType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "TreeNode",
+		Fields: (graphql.FieldsThunk)(func() graphql.Fields {
+			return graphql.Fields{
+				"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"name":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"value":       &graphql.Field{Type: graphql.String},
+				"children":    &graphql.Field{Type: graphql.NewList(treeNodeType)},
+				"hasChildren": &graphql.Field{Type: graphql.Boolean},
+			}
+		}),
+	})
+
+	watchEventType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "WatchEvent",
+		Fields: graphql.Fields{
+			"type":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"kv":       &graphql.Field{Type: keyType},
+			"revision": &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"key": &graphql.Field{
+				Type: keyType,
+				Args: graphql.FieldConfigArgument{
+					"key": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					ctx, cancel := context.WithTimeout(p.Context, timeouts.Read)
+					defer cancel()
+					resp, err := client.Get(ctx, p.Args["key"].(string))
+					if err != nil {
+						logger.Error("Error fetching key from etcd", zap.Error(err))
+						return nil, err
+					}
+					if len(resp.Kvs) == 0 {
+						return nil, nil
+					}
+					return resp.Kvs[0], nil
+				},
+			},
+			"keys": &graphql.Field{
+				Type: graphql.NewList(keyType),
+				Args: graphql.FieldConfigArgument{
+					"prefix": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "/"},
+					"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					opts := []clientv3.OpOption{
+						clientv3.WithPrefix(),
+						clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+						clientv3.WithLimit(int64(p.Args["limit"].(int))),
+					}
+					if !selects(p, "value") {
+						opts = append(opts, clientv3.WithKeysOnly())
+					}
+					ctx, cancel := context.WithTimeout(p.Context, timeouts.Read)
+					defer cancel()
+					resp, err := client.Get(ctx, p.Args["prefix"].(string), opts...)
+					if err != nil {
+						logger.Error("Error fetching keys from etcd", zap.Error(err))
+						return nil, err
+					}
+					return resp.Kvs, nil
+				},
+			},
+			"tree": &graphql.Field{
+				Type: graphql.NewList(treeNodeType),
+				Args: graphql.FieldConfigArgument{
+					"prefix": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "/"},
+					"depth":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if p.Args["depth"].(int) < 0 {
+						return nil, errors.New("invalid depth")
+					}
+					opts := []clientv3.OpOption{
+						clientv3.WithPrefix(),
+						clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+					}
+					if !selects(p, "value") {
+						opts = append(opts, clientv3.WithKeysOnly())
+					}
+					ctx, cancel := context.WithTimeout(p.Context, timeouts.Read)
+					defer cancel()
+					resp, err := client.Get(ctx, p.Args["prefix"].(string), opts...)
+					if err != nil {
+						logger.Error("Error fetching keys from etcd", zap.Error(err))
+						return nil, err
+					}
+
+					root := &TreeNode{Name: "root"}
+					for _, kv := range resp.Kvs {
+						keyParts := strings.Split(string(kv.Key), "/")[1:]
+						insertNode(root, keyParts, string(kv.Value))
+					}
+					// Depth counts levels below the prefix, as for /keys.
+					if depth := treeDepth(p.Args["prefix"].(string), p.Args["depth"].(int)); depth > 0 {
+						truncateTree(root.Children, depth)
+					}
+					return root.Children, nil
+				},
+			},
+		},
+	})
+
+	subscription := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"watch": &graphql.Field{
+				Type: watchEventType,
+				Args: graphql.FieldConfigArgument{
+					"key":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"prefix": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+					var opts []clientv3.OpOption
+					if p.Args["prefix"].(bool) {
+						opts = append(opts, clientv3.WithPrefix())
+					}
+					wch, err := watchCreated(p.Context, client, timeouts.Watch, p.Args["key"].(string), opts...)
+					if err != nil {
+						logger.Error("Error watching key in etcd", zap.Error(err))
+						return nil, err
+					}
+					events := make(chan interface{})
+					go func() {
+						defer close(events)
+						for wresp := range wch {
+							if err := wresp.Err(); err != nil {
+								logger.Error("Error watching key in etcd", zap.Error(err))
+								return
+							}
+							for _, ev := range wresp.Events {
+								select {
+								case events <- &graphqlWatchEvent{Type: ev.Type.String(), Kv: ev.Kv, Revision: wresp.Header.Revision}:
+								case <-p.Context.Done():
+									return
+								}
+							}
+						}
+					}()
+					return events, nil
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Subscription: subscription})
+}
+
+// isSubscription reports whether the operation of query that operationName
+// selects, or its only operation, is a subscription. Documents that do not
+// parse are not; executing them reports why.
+func isSubscription(query, operationName string) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return false
+	}
+	var selected *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" {
+			if selected != nil {
+				// Ambiguous without a name, which execution reports.
+				return false
+			}
+			selected = op
+		} else if op.Name != nil && op.Name.Value == operationName {
+			selected = op
+		}
+	}
+	return selected != nil && selected.Operation == ast.OperationTypeSubscription
+}
+
+// GraphQLHandler serves queries over POST (or GET with ?query=). Subscriptions
+// are delivered as server-sent events, one "next" event per result.
+func GraphQLHandler(schema graphql.Schema, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req graphqlRequest
+		if c.Request.Method == http.MethodGet {
+			req.Query = c.Query("query")
+			req.OperationName = c.Query("operationName")
+			if vars := c.Query("variables"); vars != "" {
+				if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variables"})
+					return
+				}
+			}
+		} else if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		params := graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        c.Request.Context(),
+		}
+
+		if isSubscription(req.Query, req.OperationName) {
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			for result := range graphql.Subscribe(params) {
+				c.SSEvent("next", result)
+				c.Writer.Flush()
+			}
+			c.SSEvent("complete", "")
+			return
+		}
+
+		result := graphql.Do(params)
+		if len(result.Errors) > 0 {
+			logger.Debug("GraphQL request returned errors", zap.Any("errors", result.Errors))
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
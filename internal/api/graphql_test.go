@@ -0,0 +1,47 @@
+package api
+
+import "testing"
+
+func TestIsSubscription(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		operationName string
+		want          bool
+	}{
+		{"query", `{ get(key: "/a") { key } }`, "", false},
+		{"subscription", `subscription { watch(key: "/a") { type } }`, "", true},
+		{"leading comment", "# watch it\nsubscription { watch(key: \"/a\") { type } }", "", true},
+		{"named query mentioning subscription", `query subscriptions { get(key: "/subscription") { key } }`, "", false},
+		{"selected subscription", `query Q { get(key: "/a") { key } } subscription S { watch(key: "/a") { type } }`, "S", true},
+		{"selected query", `query Q { get(key: "/a") { key } } subscription S { watch(key: "/a") { type } }`, "Q", false},
+		{"ambiguous", `query Q { get(key: "/a") { key } } subscription S { watch(key: "/a") { type } }`, "", false},
+		{"unknown operation", `subscription S { watch(key: "/a") { type } }`, "T", false},
+		{"invalid", `subscription {`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSubscription(tt.query, tt.operationName); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTreeDepth(t *testing.T) {
+	tests := []struct {
+		prefix string
+		depth  int
+		want   int
+	}{
+		{"/", 1, 1},
+		{"/registry/", 1, 2},
+		{"/registry/pods/", 2, 4},
+		{"/registry/", 0, 0},
+	}
+	for _, tt := range tests {
+		if got := treeDepth(tt.prefix, tt.depth); got != tt.want {
+			t.Errorf("treeDepth(%q, %d) = %d, want %d", tt.prefix, tt.depth, got, tt.want)
+		}
+	}
+}
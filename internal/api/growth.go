@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxGrowthSamples bounds the sample history kept by a GrowthSampler.
+	maxGrowthSamples = 96
+	// growthTrackedKeys is how many of the largest keys each sample records.
+	growthTrackedKeys = 100
+)
+
+// prefixUsage is the footprint of one prefix in a sample.
+type prefixUsage struct {
+	keys  int64
+	bytes int64
+}
+
+type growthSample struct {
+	at       time.Time
+	revision int64
+	usage    map[string]prefixUsage
+	largest  []KeySize
+}
+
+// GrowthSampler periodically scans the keyspace, recording per-prefix sizes
+// so growth can be reported over time. Prefixes are grouped to depth path
+// segments, e.g. depth 2 groups /team/service/... under /team/service/.
+type GrowthSampler struct {
+	client   *clientv3.Client
+	logger   *zap.Logger
+	interval time.Duration
+	depth    int
+
+	mu      sync.RWMutex
+	samples []growthSample
+}
+
+// NewGrowthSampler creates a sampler; call Run to start sampling.
+func NewGrowthSampler(client *clientv3.Client, logger *zap.Logger, interval time.Duration, depth int) *GrowthSampler {
+	return &GrowthSampler{client: client, logger: logger, interval: interval, depth: depth}
+}
+
+// groupPrefix returns the first depth segments of key, with a trailing slash.
+// Keys shallower than depth are grouped under their parent.
+func groupPrefix(key string, depth int) string {
+	parts := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	if len(parts) > depth {
+		parts = parts[:depth]
+	} else {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) == 0 {
+		return "/"
+	}
+	return "/" + strings.Join(parts, "/") + "/"
+}
+
+// Run takes a sample immediately and then every interval until ctx is cancelled.
+func (gs *GrowthSampler) Run(ctx context.Context) {
+	ticker := time.NewTicker(gs.interval)
+	defer ticker.Stop()
+	for {
+		if err := gs.sample(ctx); err != nil && ctx.Err() == nil {
+			gs.logger.Warn("Keyspace sample failed", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (gs *GrowthSampler) sample(ctx context.Context) error {
+	s := growthSample{at: time.Now(), usage: make(map[string]prefixUsage)}
+	top := topKeySizes{n: growthTrackedKeys}
+	rev, err := scanPrefix(ctx, gs.client, "", func(kv *mvccpb.KeyValue) {
+		prefix := groupPrefix(string(kv.Key), gs.depth)
+		u := s.usage[prefix]
+		u.keys++
+		u.bytes += int64(len(kv.Key) + len(kv.Value))
+		s.usage[prefix] = u
+		top.add(string(kv.Key), len(kv.Value))
+	})
+	if err != nil {
+		return err
+	}
+	s.revision = rev
+	s.largest = top.sorted()
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.samples = append(gs.samples, s)
+	if len(gs.samples) > maxGrowthSamples {
+		gs.samples = gs.samples[len(gs.samples)-maxGrowthSamples:]
+	}
+	return nil
+}
+
+// Report compares the latest sample with the oldest one inside window and
+// returns the top n entries of each list. It returns false until a sample exists.
+func (gs *GrowthSampler) Report(window time.Duration, n int) (KeyspaceReport, bool) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	if len(gs.samples) == 0 {
+		return KeyspaceReport{}, false
+	}
+	latest := gs.samples[len(gs.samples)-1]
+	base := latest
+	for _, s := range gs.samples {
+		if latest.at.Sub(s.at) <= window {
+			base = s
+			break
+		}
+	}
+
+	growth := make([]PrefixGrowth, 0, len(latest.usage))
+	for prefix, u := range latest.usage {
+		b := base.usage[prefix]
+		growth = append(growth, PrefixGrowth{
+			Prefix:      prefix,
+			Keys:        u.keys,
+			Bytes:       u.bytes,
+			GrowthKeys:  u.keys - b.keys,
+			GrowthBytes: u.bytes - b.bytes,
+		})
+	}
+	sort.Slice(growth, func(i, j int) bool {
+		if growth[i].GrowthBytes != growth[j].GrowthBytes {
+			return growth[i].GrowthBytes > growth[j].GrowthBytes
+		}
+		return growth[i].Prefix < growth[j].Prefix
+	})
+	if len(growth) > n {
+		growth = growth[:n]
+	}
+	largest := latest.largest
+	if len(largest) > n {
+		largest = largest[:n]
+	}
+	return KeyspaceReport{
+		SampledAt:      latest.at,
+		Revision:       latest.revision,
+		WindowStart:    base.at,
+		LargestKeys:    largest,
+		FastestGrowing: growth,
+	}, true
+}
+
+// KeyspaceReportHandler serves the sampler's report. ?window= is how far back
+// growth is measured (default 24h) and ?top= how many entries to list.
+func KeyspaceReportHandler(sampler *GrowthSampler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if sampler == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Keyspace sampling is not enabled"})
+			return
+		}
+		window, err := time.ParseDuration(c.DefaultQuery("window", "24h"))
+		if err != nil || window <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid window"})
+			return
+		}
+		top := defaultStatsTop
+		if s := c.Query("top"); s != "" {
+			if top, err = strconv.Atoi(s); err != nil || top <= 0 || top > growthTrackedKeys {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid top"})
+				return
+			}
+		}
+		report, ok := sampler.Report(window, top)
+		if !ok {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No keyspace sample has been taken yet"})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"etcd-gateway/internal/gatewaypb"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer implements the gatewaypb.GatewayServer service on top of etcd.
+type GRPCServer struct {
+	gatewaypb.UnimplementedGatewayServer
+
+	client   WatchKV
+	audit    *AuditLog
+	timeouts Timeouts
+	logger   *zap.Logger
+}
+
+// NewGRPCServer creates a gRPC service backed by the given etcd client.
+// Writes are recorded in audit, which may be nil.
+func NewGRPCServer(client WatchKV, audit *AuditLog, timeouts Timeouts, logger *zap.Logger) *GRPCServer {
+	return &GRPCServer{client: client, audit: audit, timeouts: timeouts, logger: logger}
+}
+
+func toPBKeyValue(kv *mvccpb.KeyValue) *gatewaypb.KeyValue {
+	return &gatewaypb.KeyValue{
+		Key:            string(kv.Key),
+		Value:          string(kv.Value),
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+	}
+}
+
+func toPBTree(nodes []*TreeNode) []*gatewaypb.TreeNode {
+	out := make([]*gatewaypb.TreeNode, len(nodes))
+	for i, n := range nodes {
+		out[i] = &gatewaypb.TreeNode{Id: n.ID, Name: n.Name, Value: n.Value, Children: toPBTree(n.Children)}
+	}
+	return out
+}
+
+// rpcEtcdError is the status for a failed etcd call made with ctx. A passed
+// deadline, whether the caller's or the configured timeout, is reported as
+// DeadlineExceeded rather than an internal error, and refusals by the KV
+// guards by their own codes.
+func rpcEtcdError(ctx context.Context, err error) error {
+	var rejected *WriteRejectedError
+	switch {
+	case errors.Is(err, ErrKeyDenied):
+		return status.Error(codes.PermissionDenied, "key is denied")
+	case errors.As(err, &rejected):
+		return status.Error(codes.FailedPrecondition, rejected.Error())
+	case errors.Is(err, errValidationUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	case context.Canceled:
+		return status.Error(codes.Canceled, "request canceled")
+	}
+	return status.Error(codes.Internal, "internal server error")
+}
+
+// GetValue returns a single key.
+func (s *GRPCServer) GetValue(ctx context.Context, req *gatewaypb.GetValueRequest) (*gatewaypb.GetValueResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, req.Key)
+	if err != nil {
+		s.logger.Error("Error fetching key from etcd", zap.Error(err))
+		return nil, rpcEtcdError(ctx, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, status.Error(codes.NotFound, "key not found")
+	}
+	return &gatewaypb.GetValueResponse{Kv: toPBKeyValue(resp.Kvs[0]), Revision: resp.Header.Revision}, nil
+}
+
+// PutValue writes a single key other than the gateway's own.
+func (s *GRPCServer) PutValue(ctx context.Context, req *gatewaypb.PutValueRequest) (*gatewaypb.PutValueResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	if reservedWrite(req.Key, false) {
+		return nil, errReservedKey
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	resp, err := s.client.Put(ctx, req.Key, req.Value, clientv3.WithPrevKV())
+	if err != nil {
+		s.logger.Error("Error writing key to etcd", zap.Error(err))
+		return nil, rpcEtcdError(ctx, err)
+	}
+	s.audit.Record(ctx, rpcActor(ctx), "put", []AuditChange{putChange(req.Key, resp.PrevKv, resp.Header.Revision)})
+	return &gatewaypb.PutValueResponse{Revision: resp.Header.Revision}, nil
+}
+
+// DeleteValue removes a single key other than the gateway's own.
+func (s *GRPCServer) DeleteValue(ctx context.Context, req *gatewaypb.DeleteValueRequest) (*gatewaypb.DeleteValueResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	if reservedWrite(req.Key, false) {
+		return nil, errReservedKey
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	resp, err := s.client.Delete(ctx, req.Key, clientv3.WithPrevKV())
+	if err != nil {
+		s.logger.Error("Error deleting key from etcd", zap.Error(err))
+		return nil, rpcEtcdError(ctx, err)
+	}
+	s.audit.Record(ctx, rpcActor(ctx), "delete", deleteChanges(resp.PrevKvs))
+	return &gatewaypb.DeleteValueResponse{Deleted: resp.Deleted, Revision: resp.Header.Revision}, nil
+}
+
+// GetTree returns the keys beneath a prefix as the same tree served by /api/keys.
+func (s *GRPCServer) GetTree(ctx context.Context, req *gatewaypb.GetTreeRequest) (*gatewaypb.GetTreeResponse, error) {
+	prefix := req.Prefix
+	if prefix == "" {
+		prefix = "/"
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		s.logger.Error("Error fetching keys from etcd", zap.Error(err))
+		return nil, rpcEtcdError(ctx, err)
+	}
+
+	root := &TreeNode{Name: "root"}
+	for _, kv := range resp.Kvs {
+		keyParts := strings.Split(string(kv.Key), "/")[1:]
+		insertNode(root, keyParts, string(kv.Value))
+	}
+	return &gatewaypb.GetTreeResponse{Nodes: toPBTree(root.Children), Revision: resp.Header.Revision}, nil
+}
+
+// Watch streams changes to a key or prefix until the client disconnects.
+func (s *GRPCServer) Watch(req *gatewaypb.WatchRequest, stream gatewaypb.Gateway_WatchServer) error {
+	if req.Key == "" {
+		return status.Error(codes.InvalidArgument, "key is required")
+	}
+	var opts []clientv3.OpOption
+	if req.Prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	if req.StartRevision > 0 {
+		opts = append(opts, clientv3.WithRev(req.StartRevision))
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	wch, err := watchCreated(ctx, s.client, s.timeouts.Watch, req.Key, opts...)
+	if err != nil {
+		s.logger.Error("Error watching key in etcd", zap.Error(err))
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	for wresp := range wch {
+		if err := wresp.Err(); errors.Is(err, rpctypes.ErrCompacted) {
+			// Retrying would fail the same way; the client must re-read.
+			return status.Errorf(codes.OutOfRange, "revision has been compacted up to %d, re-read the key and watch from the revision read", wresp.CompactRevision)
+		} else if err != nil {
+			s.logger.Error("Error watching key in etcd", zap.Error(err))
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		for _, ev := range wresp.Events {
+			out := &gatewaypb.WatchEvent{Kv: toPBKeyValue(ev.Kv), Revision: wresp.Header.Revision}
+			if ev.Type == clientv3.EventTypeDelete {
+				out.Type = gatewaypb.WatchEvent_DELETE
+			}
+			if err := stream.Send(out); err != nil {
+				return err
+			}
+		}
+	}
+	return stream.Context().Err()
+}
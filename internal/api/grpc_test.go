@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	"etcd-gateway/internal/gatewaypb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchStream is a Gateway_WatchServer collecting events, ending the call
+// once it has want of them.
+type watchStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	cancel context.CancelFunc
+	want   int
+	events []string
+}
+
+func (s *watchStream) Context() context.Context { return s.ctx }
+
+func (s *watchStream) Send(ev *gatewaypb.WatchEvent) error {
+	s.events = append(s.events, ev.Type.String()+" "+ev.Kv.Key+"="+ev.Kv.Value)
+	if len(s.events) == s.want {
+		s.cancel()
+	}
+	return nil
+}
+
+func TestGRPCValues(t *testing.T) {
+	store := apitest.NewStore()
+	s := NewGRPCServer(store.Client(), nil, DefaultTimeouts(), zap.NewNop())
+	ctx := context.Background()
+
+	put, err := s.PutValue(ctx, &gatewaypb.PutValueRequest{Key: "/app/a", Value: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get, err := s.GetValue(ctx, &gatewaypb.GetValueRequest{Key: "/app/a"})
+	if err != nil || get.Kv.Value != "1" || get.Revision != put.Revision {
+		t.Errorf("got %v, error %v", get, err)
+	}
+	tree, err := s.GetTree(ctx, &gatewaypb.GetTreeRequest{Prefix: "/app/"})
+	if err != nil || len(tree.Nodes) != 1 || tree.Nodes[0].Name != "app" {
+		t.Errorf("got tree %v, error %v", tree, err)
+	}
+	del, err := s.DeleteValue(ctx, &gatewaypb.DeleteValueRequest{Key: "/app/a"})
+	if err != nil || del.Deleted != 1 {
+		t.Errorf("got %v, error %v", del, err)
+	}
+	if _, err := s.GetValue(ctx, &gatewaypb.GetValueRequest{Key: "/app/a"}); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v reading a deleted key", err)
+	}
+
+	// Watches from a past revision see the changes since.
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream := &watchStream{ctx: wctx, cancel: cancel, want: 3}
+	go s.PutValue(ctx, &gatewaypb.PutValueRequest{Key: "/app/b", Value: "2"})
+	if err := s.Watch(&gatewaypb.WatchRequest{Key: "/app/", Prefix: true, StartRevision: put.Revision}, stream); err != context.Canceled {
+		t.Errorf("got error %v", err)
+	}
+	if want := []string{"PUT /app/a=1", "DELETE /app/a=", "PUT /app/b=2"}; !reflect.DeepEqual(stream.events, want) {
+		t.Errorf("got events %v, want %v", stream.events, want)
+	}
+
+	// Watches from a compacted revision must re-read.
+	if _, err := store.KV().Compact(ctx, store.Revision()); err != nil {
+		t.Fatal(err)
+	}
+	stream = &watchStream{ctx: ctx}
+	if err := s.Watch(&gatewaypb.WatchRequest{Key: "/app/", Prefix: true, StartRevision: put.Revision}, stream); status.Code(err) != codes.OutOfRange {
+		t.Errorf("got error %v watching a compacted revision", err)
+	}
+}
@@ -2,21 +2,57 @@ package api
 
 import (
 	"context"
-	"log"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.uber.org/zap"
 )
 
-type TreeNode struct {
-	ID       string      `json:"id"`
-	Name     string      `json:"name"`
-	Value    string      `json:"value,omitempty"`
-	Children []*TreeNode `json:"children,omitempty"`
+// truncateTree drops nodes deeper than depth levels below nodes, flagging
+// the nodes whose children were removed with HasChildren. Values of the
+// dropped nodes are not returned.
+func truncateTree(nodes []*TreeNode, depth int) {
+	for _, n := range nodes {
+		if depth <= 1 {
+			n.HasChildren = len(n.Children) > 0
+			n.Children = nil
+			continue
+		}
+		truncateTree(n.Children, depth-1)
+	}
+}
+
+// parseDepth reads ?depth=, returning the absolute tree depth to truncate at,
+// measured from the root and counted from the last complete path segment of
+// prefix, or 0 when no depth was requested.
+func parseDepth(c *gin.Context, prefix string) (int, error) {
+	s := c.Query("depth")
+	if s == "" {
+		return 0, nil
+	}
+	depth, err := strconv.Atoi(s)
+	if err != nil || depth <= 0 {
+		return 0, errors.New("invalid depth")
+	}
+	return treeDepth(prefix, depth), nil
+}
+
+// treeDepth returns the absolute tree depth to truncate at for depth levels
+// below prefix, as parseDepth does, or 0 for a depth of 0.
+func treeDepth(prefix string, depth int) int {
+	if depth == 0 {
+		return 0
+	}
+	return strings.Count(prefix, "/") - 1 + depth
 }
 
 func insertNode(root *TreeNode, parts []string, value string) {
@@ -45,19 +81,154 @@ func insertNode(root *TreeNode, parts []string, value string) {
 	}
 }
 
-// FetchKeysHandler retrieves all keys from etcd.
-func FetchKeysHandler(client *clientv3.Client) gin.HandlerFunc {
+// continueToken pins a paginated listing to a store revision so that every
+// page is read from the same consistent snapshot.
+type continueToken struct {
+	Key      string `json:"k"`
+	Revision int64  `json:"r"`
+}
+
+func encodeContinueToken(t continueToken) string {
+	b, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeContinueToken(s string) (continueToken, error) {
+	var t continueToken
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return t, err
+	}
+	err = json.Unmarshal(b, &t)
+	return t, err
+}
+
+// keysQuery holds the listing options parsed from a /keys request.
+type keysQuery struct {
+	prefix     string
+	depth      int
+	sortTarget clientv3.SortTarget
+	sortOrder  clientv3.SortOrder
+	natural    bool
+	keysOnly   bool
+	flat       bool
+	fields     fieldSet
+	opts       []clientv3.OpOption
+}
+
+var sortTargets = map[string]clientv3.SortTarget{
+	"key":     clientv3.SortByKey,
+	"create":  clientv3.SortByCreateRevision,
+	"mod":     clientv3.SortByModRevision,
+	"version": clientv3.SortByVersion,
+}
+
+var sortOrders = map[string]clientv3.SortOrder{
+	"asc":  clientv3.SortAscend,
+	"desc": clientv3.SortDescend,
+}
+
+func parseKeysQuery(c *gin.Context) (keysQuery, error) {
+	q := keysQuery{prefix: c.DefaultQuery("prefix", "/")}
+	if !strings.HasPrefix(q.prefix, "/") {
+		return q, errors.New("Prefix must start with /")
+	}
+
+	depth, err := parseDepth(c, q.prefix)
+	if err != nil {
+		return q, errors.New("Invalid depth")
+	}
+	q.depth = depth
+
+	var ok bool
+	sortBy := c.DefaultQuery("sortBy", "key")
+	if q.natural = sortBy == "natural"; q.natural {
+		sortBy = "key"
+	}
+	if q.sortTarget, ok = sortTargets[sortBy]; !ok {
+		return q, errors.New("Invalid sortBy, expected key, natural, create, mod or version")
+	}
+	if q.sortOrder, ok = sortOrders[c.DefaultQuery("order", "asc")]; !ok {
+		return q, errors.New("Invalid order, expected asc or desc")
+	}
+
+	var sample interface{} = TreeNode{}
+	if q.flat = c.Query("flat") == "true"; q.flat {
+		sample = FlatKey{}
+	}
+	if q.fields, err = parseFields(c, sample); err != nil {
+		return q, err
+	}
+	// Values not asked for need not be read.
+	if c.Query("keysOnly") == "true" || !q.fields.has("value") {
+		q.keysOnly = true
+		q.opts = append(q.opts, clientv3.WithKeysOnly())
+	}
+	return q, nil
+}
+
+// FetchKeysHandler retrieves all keys from etcd, or only those beneath
+// ?prefix= when given, as a tree or with ?flat=true as a list of FlatKey.
+// When ?limit= is given the listing is paginated and returned as a KeysPage
+// or FlatKeysPage. ?keysOnly=true omits values, and ?sortBy= and ?order=
+// control the order in which keys, and so tree children, appear, with
+// ?sortBy=natural ordering numbers within names by value. ?fields=
+// keeps only the fields asked for, omitting values unless value is one of
+// them. Key-ordered listings are streamed, as NDJSON with ?format=ndjson.
+// Unpaginated listings of cached prefixes are served from cache.
+func FetchKeysHandler(client clientv3.KV, cache *KeyspaceCache, logger *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(c, 5*time.Second)
+		q, err := parseKeysQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if kvs, _, ok := cache.Range(q.prefix); ok && c.Query("limit") == "" {
+			setStaleness(c, cache, false)
+			serveKeysFromCache(c, q, kvs)
+			return
+		}
+
+		ctx, cancel := requestContext(c)
 		defer cancel()
 
-		resp, err := client.Get(ctx, "/", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+		etag, err := prefixETag(ctx, c, client, q.prefix)
 		if err != nil {
-			log.Printf("Error fetching keys from etcd: %v", err)
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 			return
 		}
+		if notModified(c, etag) {
+			return
+		}
 
+		if c.Query("limit") != "" {
+			fetchKeysPage(ctx, c, client, q, logger)
+			return
+		}
+		// In key order each top-level node is complete before the next
+		// begins, which lets the response be streamed.
+		if q.sortTarget == clientv3.SortByKey && q.sortOrder == clientv3.SortAscend && !q.natural {
+			streamKeys(ctx, c, client, q, logger)
+			return
+		}
+
+		opts := append([]clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithSort(q.sortTarget, q.sortOrder)}, q.opts...)
+		resp, err := client.Get(ctx, q.prefix, opts...)
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if q.natural {
+			sortKeyValues(resp.Kvs, q)
+		}
+
+		if q.flat {
+			sparseJSON(c, http.StatusOK, q.fields, flatKeys(resp.Kvs))
+			return
+		}
 		root := &TreeNode{Name: "root"}
 
 		for _, kv := range resp.Kvs {
@@ -65,12 +236,173 @@ func FetchKeysHandler(client *clientv3.Client) gin.HandlerFunc {
 			value := string(kv.Value)
 			insertNode(root, keyParts, value)
 		}
-		c.JSON(http.StatusOK, root.Children)
+		if q.depth > 0 {
+			truncateTree(root.Children, q.depth)
+		}
+		sparseJSON(c, http.StatusOK, q.fields, root.Children)
 	}
 }
 
+// flatKeys lists kvs as a flat listing does.
+func flatKeys(kvs []*mvccpb.KeyValue) []FlatKey {
+	keys := make([]FlatKey, len(kvs))
+	for i, kv := range kvs {
+		keys[i] = FlatKey{Key: string(kv.Key), Value: string(kv.Value), ModRevision: kv.ModRevision}
+	}
+	return keys
+}
+
+// sortKeyValues orders kvs, which are in key order, as q requests.
+func sortKeyValues(kvs []*mvccpb.KeyValue, q keysQuery) {
+	var less func(a, b *mvccpb.KeyValue) bool
+	switch q.sortTarget {
+	case clientv3.SortByCreateRevision:
+		less = func(a, b *mvccpb.KeyValue) bool { return a.CreateRevision < b.CreateRevision }
+	case clientv3.SortByModRevision:
+		less = func(a, b *mvccpb.KeyValue) bool { return a.ModRevision < b.ModRevision }
+	case clientv3.SortByVersion:
+		less = func(a, b *mvccpb.KeyValue) bool { return a.Version < b.Version }
+	default:
+		less = func(a, b *mvccpb.KeyValue) bool { return string(a.Key) < string(b.Key) }
+		if q.natural {
+			less = func(a, b *mvccpb.KeyValue) bool { return naturalLess(string(a.Key), string(b.Key)) }
+		}
+	}
+	if q.sortOrder == clientv3.SortDescend {
+		asc := less
+		less = func(a, b *mvccpb.KeyValue) bool { return asc(b, a) }
+	}
+	sort.SliceStable(kvs, func(i, j int) bool { return less(kvs[i], kvs[j]) })
+}
+
+func serveKeysFromCache(c *gin.Context, q keysQuery, kvs []*mvccpb.KeyValue) {
+	if notModified(c, kvsETag(c, kvs)) {
+		return
+	}
+	sortKeyValues(kvs, q)
+
+	if q.flat {
+		keys := flatKeys(kvs)
+		if q.keysOnly {
+			for i := range keys {
+				keys[i].Value = ""
+			}
+		}
+		if !wantsNDJSON(c) {
+			sparseJSON(c, http.StatusOK, q.fields, keys)
+			return
+		}
+		w := &treeStreamWriter{c: c, ndjson: true, fields: q.fields}
+		for _, key := range keys {
+			if err := w.write(key); err != nil {
+				return
+			}
+		}
+		w.finish()
+		return
+	}
+
+	root := &TreeNode{Name: "root"}
+	for _, kv := range kvs {
+		keyParts := strings.Split(string(kv.Key), "/")[1:]
+		value := string(kv.Value)
+		if q.keysOnly {
+			value = ""
+		}
+		insertNode(root, keyParts, value)
+	}
+	if q.depth > 0 {
+		truncateTree(root.Children, q.depth)
+	}
+
+	if !wantsNDJSON(c) {
+		sparseJSON(c, http.StatusOK, q.fields, root.Children)
+		return
+	}
+	w := &treeStreamWriter{c: c, ndjson: true, fields: q.fields}
+	for _, node := range root.Children {
+		if err := w.write(node); err != nil {
+			return
+		}
+	}
+	w.finish()
+}
+
+func fetchKeysPage(ctx context.Context, c *gin.Context, client clientv3.KV, q keysQuery, logger *zap.Logger) {
+	limit, err := strconv.ParseInt(c.Query("limit"), 10, 64)
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	// Continue tokens resume from the last key seen, so pages must be key ordered.
+	if q.sortTarget != clientv3.SortByKey || q.sortOrder != clientv3.SortAscend || q.natural {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pagination requires ascending key order"})
+		return
+	}
+
+	token := continueToken{Key: q.prefix}
+	if s := c.Query("continue"); s != "" {
+		if token, err = decodeContinueToken(s); err != nil || !strings.HasPrefix(token.Key, q.prefix) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid continue token"})
+			return
+		}
+	}
+
+	opts := append([]clientv3.OpOption{
+		clientv3.WithRange(clientv3.GetPrefixRangeEnd(q.prefix)),
+		clientv3.WithLimit(limit),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+	}, q.opts...)
+	if token.Revision > 0 {
+		opts = append(opts, clientv3.WithRev(token.Revision))
+	}
+
+	ctx, cursor := withReadCursor(ctx)
+	resp, err := client.Get(ctx, token.Key, opts...)
+	if errors.Is(err, rpctypes.ErrCompacted) {
+		c.JSON(http.StatusGone, gin.H{"error": "Continue token expired, restart the listing"})
+		return
+	}
+	if err != nil {
+		logger.Error("Error fetching keys from etcd", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	revision := token.Revision
+	if revision == 0 {
+		revision = resp.Header.Revision
+	}
+
+	var next string
+	if resp.More {
+		next = encodeContinueToken(continueToken{Key: cursor.next(resp), Revision: revision})
+	}
+	if q.flat {
+		page := FlatKeysPage{Keys: flatKeys(resp.Kvs), Continue: next, Revision: revision}
+		sparsePageJSON(c, http.StatusOK, q.fields, page, "keys")
+		return
+	}
+
+	root := &TreeNode{Name: "root"}
+	for _, kv := range resp.Kvs {
+		keyParts := strings.Split(string(kv.Key), "/")[1:]
+		insertNode(root, keyParts, string(kv.Value))
+	}
+	if q.depth > 0 {
+		truncateTree(root.Children, q.depth)
+	}
+	page := KeysPage{Nodes: root.Children, Continue: next, Revision: revision}
+	sparsePageJSON(c, http.StatusOK, q.fields, page, "nodes")
+}
+
 // FetchValueForKeyHandler retrieves the value for a specific key from etcd.
-func FetchValueForKeyHandler(client *clientv3.Client, logger *zap.Logger) gin.HandlerFunc {
+// When the clock knows when the key was last modified, the response carries
+// Last-Modified and modifiedAt. Reads are served from cache when one is
+// configured, unless the request carries Cache-Control: no-cache. While etcd
+// is unreachable, keys under cached prefixes are served from the keyspace
+// cache with X-Data-Staleness.
+func FetchValueForKeyHandler(client clientv3.KV, cache *ValueCache, keyspace *KeyspaceCache, clock *RevisionClock, logger *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		// Retrieve the key with wildcard
@@ -83,26 +415,167 @@ func FetchValueForKeyHandler(client *clientv3.Client, logger *zap.Logger) gin.Ha
 
 		key = strings.TrimPrefix(key, "/")
 
-		// Fetch the value from etcd
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		resp, err := client.Get(ctx, key)
-		if err != nil {
-			logger.Error("Error fetching key from etcd", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-			return
+		var kv *mvccpb.KeyValue
+		var hit, stale bool
+		if cache != nil && !strings.Contains(c.GetHeader("Cache-Control"), "no-cache") {
+			kv, hit = cache.Get(key)
+		}
+		if !hit {
+			kv, _, stale = staleGet(c, keyspace, key, nil)
+		}
+		if hit {
+			c.Header("X-Cache", "HIT")
+		} else if !stale {
+			// Fetch the value from etcd
+			ctx, cancel := requestContext(c)
+			defer cancel()
+			resp, err := client.Get(ctx, key)
+			if err != nil {
+				if kv, _, stale = staleGet(c, keyspace, key, err); !stale {
+					logger.Error("Error fetching key from etcd", zap.Error(err))
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+					return
+				}
+				logger.Warn("Cannot reach etcd, serving key from cache", zap.String("key", key), zap.Error(err))
+			} else if len(resp.Kvs) > 0 {
+				kv = resp.Kvs[0]
+				if cache != nil {
+					cache.Add(key, kv, resp.Header.Revision)
+					c.Header("X-Cache", "MISS")
+				}
+			}
 		}
 
 		// If no keys were found, return a not found error
-		if len(resp.Kvs) == 0 {
+		if kv == nil {
 			logger.Info("Key not found", zap.String("key", key))
 			c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
 			return
 		}
 
-		// Respond with the value for the key
-		kv := resp.Kvs[0]
-		value := string(kv.Value)
-		c.JSON(http.StatusOK, gin.H{"value": value})
+		// Respond with the value for the key. The ETag only covers the
+		// value, so it is not offered when the annotation is included.
+		withAnnotation := c.Query("annotations") == "true"
+		if !withAnnotation && notModified(c, keyETag(kv.ModRevision)) {
+			return
+		}
+		body := Value{Value: string(kv.Value)}
+		if modifiedAt, ok := clock.TimeOf(kv.ModRevision); ok {
+			c.Header("Last-Modified", modifiedAt.UTC().Format(http.TimeFormat))
+			body.ModifiedAt = &modifiedAt
+		}
+		if withAnnotation {
+			ctx, cancel := requestContext(c)
+			defer cancel()
+			annotation, err := getAnnotation(ctx, client, key)
+			if err != nil {
+				logger.Error("Error fetching annotation from etcd", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+				return
+			}
+			body.Annotation = annotation
+		}
+		c.JSON(http.StatusOK, body)
+	}
+}
+
+// ValueHeadHandler answers HEAD requests for a key with 200 or 404 and the
+// key's metadata in headers, for cheap existence checks. Like GET, it falls
+// back to the keyspace cache while etcd is unreachable.
+func ValueHeadHandler(client clientv3.KV, keyspace *KeyspaceCache, clock *RevisionClock, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		if key == "" {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+
+		kv, revision, stale := staleGet(c, keyspace, key, nil)
+		if !stale {
+			ctx, cancel := requestContext(c)
+			defer cancel()
+			resp, err := client.Get(ctx, key)
+			if err != nil {
+				if kv, revision, stale = staleGet(c, keyspace, key, err); !stale {
+					logger.Error("Error fetching key from etcd", zap.Error(err))
+					c.Status(http.StatusInternalServerError)
+					return
+				}
+			} else {
+				revision = resp.Header.Revision
+				if len(resp.Kvs) > 0 {
+					kv = resp.Kvs[0]
+				}
+			}
+		}
+
+		c.Header("X-Etcd-Revision", strconv.FormatInt(revision, 10))
+		if kv == nil {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		c.Header("X-Etcd-Create-Revision", strconv.FormatInt(kv.CreateRevision, 10))
+		c.Header("X-Etcd-Mod-Revision", strconv.FormatInt(kv.ModRevision, 10))
+		c.Header("X-Etcd-Version", strconv.FormatInt(kv.Version, 10))
+		c.Header("X-Value-Size", strconv.Itoa(len(kv.Value)))
+		if modifiedAt, ok := clock.TimeOf(kv.ModRevision); ok {
+			c.Header("Last-Modified", modifiedAt.UTC().Format(http.TimeFormat))
+		}
+		if notModified(c, keyETag(kv.ModRevision)) {
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+}
+
+// DeleteValueHandler deletes a key, or with ?recursive=true every key under
+// it. When a trash is configured the keys are moved there instead. The root
+// and the gateway's own keys cannot be deleted.
+func DeleteValueHandler(client clientv3.KV, trash *Trash, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Key is required"})
+			return
+		}
+		recursive := c.Query("recursive") == "true"
+		if reservedWrite(key, recursive) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Keys must not be the root or under a gateway prefix", "key": key})
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		var deleted []*mvccpb.KeyValue
+		var err error
+		if trash != nil {
+			deleted, err = trash.Delete(ctx, key, recursive)
+		} else {
+			opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+			if recursive {
+				opts = append(opts, clientv3.WithPrefix())
+			}
+			var resp *clientv3.DeleteResponse
+			if resp, err = client.Delete(ctx, key, opts...); err == nil {
+				deleted = resp.PrevKvs
+			}
+		}
+		audit.Record(ctx, requestActor(c), "delete", deleteChanges(deleted))
+		if errors.Is(err, errConflict) {
+			conflictResponse(c, err, gin.H{"error": "Keys were modified during the delete, retry", "deleted": len(deleted)})
+			return
+		}
+		if err != nil {
+			logger.Error("Error deleting key from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if len(deleted) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"deleted": len(deleted), "trashed": trash != nil})
 	}
 }
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	"go.uber.org/zap"
+)
+
+// listPages follows the continue tokens of a paginated flat listing from
+// url, returning the keys of each page and the response that ended it.
+func listPages(t *testing.T, store *apitest.Store, url string, between func()) ([][]string, *httptest.ResponseRecorder) {
+	t.Helper()
+	var pages [][]string
+	cont := ""
+	for {
+		u := url
+		if cont != "" {
+			u += "&continue=" + cont
+		}
+		rec := apitest.Serve("/keys", httptest.NewRequest("GET", u, nil), FetchKeysHandler(store.KV(), nil, zap.NewNop()))
+		if rec.Code != 200 {
+			return pages, rec
+		}
+		var page FlatKeysPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		keys := []string{}
+		for _, key := range page.Keys {
+			keys = append(keys, key.Key)
+		}
+		pages = append(pages, keys)
+		if cont = page.Continue; cont == "" || len(pages) > 10 {
+			return pages, rec
+		}
+		if between != nil {
+			between()
+		}
+	}
+}
+
+func TestFetchKeysPages(t *testing.T) {
+	ctx := context.Background()
+	newStore := func() *apitest.Store {
+		store := apitest.NewStore()
+		for _, key := range []string{"/a/1", "/a/2", "/a/3", "/b/1", "/c"} {
+			if _, err := store.KV().Put(ctx, key, "v"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return store
+	}
+
+	tests := []struct {
+		name      string
+		url       string
+		between   func(store *apitest.Store)
+		wantPages [][]string
+		wantCode  int
+	}{
+		{"pages", "/keys?flat=true&limit=2", nil, [][]string{{"/a/1", "/a/2"}, {"/a/3", "/b/1"}, {"/c"}}, 200},
+		{"exact pages", "/keys?flat=true&limit=5", nil, [][]string{{"/a/1", "/a/2", "/a/3", "/b/1", "/c"}}, 200},
+		{"prefix", "/keys?prefix=/a/&flat=true&limit=2", nil, [][]string{{"/a/1", "/a/2"}, {"/a/3"}}, 200},
+		{"pinned revision", "/keys?flat=true&limit=3", func(store *apitest.Store) {
+			store.KV().Put(ctx, "/b/0", "v")
+			store.KV().Delete(ctx, "/c")
+		}, [][]string{{"/a/1", "/a/2", "/a/3"}, {"/b/1", "/c"}}, 200},
+		{"compacted", "/keys?flat=true&limit=3", func(store *apitest.Store) {
+			store.KV().Put(ctx, "/d", "v")
+			store.KV().Compact(ctx, store.Revision())
+		}, [][]string{{"/a/1", "/a/2", "/a/3"}}, 410},
+		{"invalid limit", "/keys?flat=true&limit=0", nil, nil, 400},
+		{"unordered", "/keys?flat=true&limit=2&sortBy=mod", nil, nil, 400},
+		{"invalid token", "/keys?flat=true&limit=2&continue=x", nil, nil, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStore()
+			var between func()
+			if tt.between != nil {
+				between = func() { tt.between(store) }
+			}
+			pages, rec := listPages(t, store, tt.url, between)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if !reflect.DeepEqual(pages, tt.wantPages) {
+				t.Errorf("got pages %v, want %v", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestFetchKeysPageTokens(t *testing.T) {
+	store := apitest.NewStore()
+	for i := 0; i < 3; i++ {
+		if _, err := store.KV().Put(context.Background(), fmt.Sprintf("/a/%d", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	serve := func(url string) *httptest.ResponseRecorder {
+		return apitest.Serve("/keys", httptest.NewRequest("GET", url, nil), FetchKeysHandler(store.KV(), nil, zap.NewNop()))
+	}
+
+	// Tokens only continue listings of the prefix they came from.
+	rec := serve("/keys?prefix=/a/&limit=1")
+	var page KeysPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || page.Continue == "" {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if rec = serve("/keys?prefix=/b/&limit=1&continue=" + page.Continue); rec.Code != 400 {
+		t.Errorf("got status %d continuing another prefix", rec.Code)
+	}
+
+	// Fields prune the listed keys but not the page.
+	rec = serve("/keys?prefix=/a/&flat=true&limit=2&fields=key")
+	want := fmt.Sprintf(`{"continue":%q,"keys":[{"key":"/a/0"},{"key":"/a/1"}],"revision":%d}`,
+		encodeContinueToken(continueToken{Key: "/a/1\x00", Revision: store.Revision()}), store.Revision())
+	if rec.Body.String() != want {
+		t.Errorf("got %s\nwant %s", rec.Body, want)
+	}
+}
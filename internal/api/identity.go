@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// proxySecretHeader carries the secret shared with the fronting proxy.
+const proxySecretHeader = "X-Gateway-Proxy-Secret"
+
+// actorKey is the gin context key holding the request's actor.
+const actorKey = "actor"
+
+// IdentityTrust says whose X-Forwarded-User to believe: requests from the
+// fronting proxy's addresses, or carrying the secret it shares with the
+// gateway. Anyone else could name any user in the header.
+type IdentityTrust struct {
+	Proxies []*net.IPNet
+	Secret  string
+}
+
+// ParseTrustedProxies parses addresses and CIDR ranges.
+func ParseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New("invalid trusted proxy " + strconv.Quote(entry))
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New("invalid trusted proxy " + strconv.Quote(entry))
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// User returns the user the fronting proxy authenticated the request as, or
+// "" if there is none or the request did not come through the proxy.
+func (t IdentityTrust) User(c *gin.Context) string {
+	user := c.GetHeader(actorHeader)
+	if user == "" || !t.trusted(c.GetHeader(proxySecretHeader), c.Request.RemoteAddr) {
+		return ""
+	}
+	return user
+}
+
+// trusted reports whether a request carrying secret from the peer at
+// remoteAddr came through the fronting proxy.
+func (t IdentityTrust) trusted(secret, remoteAddr string) bool {
+	if t.Secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(t.Secret)) == 1 {
+		return true
+	}
+	// The peer's own address, not one it claims with X-Forwarded-For.
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range t.Proxies {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rpcIdentity is User for gRPC calls, which carry the headers as metadata.
+func (t IdentityTrust) rpcIdentity(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(name string) string {
+		if v := md.Get(name); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	user := first(strings.ToLower(actorHeader))
+	p, ok := peer.FromContext(ctx)
+	if user == "" || !ok || !t.trusted(first(strings.ToLower(proxySecretHeader)), p.Addr.String()) {
+		return ""
+	}
+	return user
+}
+
+// authenticate returns ctx with the user the fronting proxy authenticated
+// the call as.
+func (t IdentityTrust) authenticate(ctx context.Context) (context.Context, error) {
+	user := t.rpcIdentity(ctx)
+	if user == "" {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	return context.WithValue(ctx, rpcUserKey{}, user), nil
+}
+
+// UnaryInterceptor refuses gRPC calls without a user the fronting proxy
+// authenticated, as the HTTP API's reviews do.
+func (t IdentityTrust) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := t.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor is the streaming counterpart of UnaryInterceptor.
+func (t IdentityTrust) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := t.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authedStream{ServerStream: ss, ctx: ctx})
+}
+
+// Actor is User, or the peer's address for requests without a trusted
+// user.
+func (t IdentityTrust) Actor(c *gin.Context) string {
+	if user := t.User(c); user != "" {
+		return user
+	}
+	return c.RemoteIP()
+}
+
+// Middleware records each request's Actor for the audit log and the other
+// records of who made a change.
+func (t IdentityTrust) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(actorKey, t.Actor(c))
+		c.Next()
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	"etcd-gateway/internal/gatewaypb"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestIdentityInterceptor(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust := IdentityTrust{Proxies: proxies, Secret: "s3cret"}
+
+	tests := []struct {
+		name     string
+		addr     string
+		md       []string
+		wantUser string
+	}{
+		{"trusted proxy", "10.1.2.3", []string{"x-forwarded-user", "alice"}, "alice"},
+		{"shared secret", "192.0.2.1", []string{"x-forwarded-user", "alice", "x-gateway-proxy-secret", "s3cret"}, "alice"},
+		{"wrong secret", "192.0.2.1", []string{"x-forwarded-user", "alice", "x-gateway-proxy-secret", "guess"}, ""},
+		{"untrusted peer", "192.0.2.1", []string{"x-forwarded-user", "alice"}, ""},
+		{"forwarded for", "192.0.2.1", []string{"x-forwarded-user", "alice", "x-forwarded-for", "10.1.2.3"}, ""},
+		{"no user", "10.1.2.3", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(tt.addr), Port: 4000}})
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(tt.md...))
+			var user string
+			_, err := trust.UnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				user, _ = rpcUser(ctx)
+				return nil, nil
+			})
+			if tt.wantUser == "" {
+				if status.Code(err) != codes.Unauthenticated {
+					t.Errorf("got error %v, want Unauthenticated", err)
+				}
+				return
+			}
+			if err != nil || user != tt.wantUser {
+				t.Errorf("got user %q, error %v, want %q", user, err, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestIdentityMiddleware(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust := IdentityTrust{Proxies: proxies, Secret: "s3cret"}
+
+	tests := []struct {
+		name      string
+		addr      string
+		headers   []string
+		wantActor string
+	}{
+		{"trusted proxy", "10.1.2.3:4000", []string{actorHeader, "alice"}, "alice"},
+		{"shared secret", "192.0.2.1:4000", []string{actorHeader, "alice", proxySecretHeader, "s3cret"}, "alice"},
+		{"untrusted peer", "192.0.2.1:4000", []string{actorHeader, "alice"}, "192.0.2.1"},
+		{"forwarded for", "192.0.2.1:4000", []string{actorHeader, "alice", "X-Forwarded-For", "10.1.2.3"}, "192.0.2.1"},
+		{"no user", "10.1.2.3:4000", nil, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.addr
+			for i := 0; i < len(tt.headers); i += 2 {
+				req.Header.Set(tt.headers[i], tt.headers[i+1])
+			}
+			var actor string
+			apitest.Serve("/", req, trust.Middleware(), func(c *gin.Context) { actor = requestActor(c) })
+			if actor != tt.wantActor {
+				t.Errorf("got actor %q, want %q", actor, tt.wantActor)
+			}
+		})
+	}
+}
+
+func TestGRPCReservedKeys(t *testing.T) {
+	// Reserved keys are refused before etcd is called.
+	s := NewGRPCServer(nil, nil, DefaultTimeouts(), zap.NewNop())
+	for _, key := range []string{"/", "/.proposals/p"} {
+		if _, err := s.PutValue(context.Background(), &gatewaypb.PutValueRequest{Key: key, Value: "v"}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("put %s: got error %v, want PermissionDenied", key, err)
+		}
+		if _, err := s.DeleteValue(context.Background(), &gatewaypb.DeleteValueRequest{Key: key}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("delete %s: got error %v, want PermissionDenied", key, err)
+		}
+	}
+}
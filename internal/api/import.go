@@ -0,0 +1,238 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// Import formats.
+const (
+	importSnapshot = "snapshot"
+	importJSON     = "json"
+	importTarGz    = "tar.gz"
+)
+
+// openSnapshot opens an etcd snapshot, the bolt database written by
+// `etcdctl snapshot save`, read-only.
+func openSnapshot(file string) (*bolt.DB, error) {
+	db, err := bolt.Open(file, 0o400, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("not an etcd snapshot: %w", err)
+	}
+	return db, nil
+}
+
+// replaySnapshot calls fn with every revision of every key in a snapshot,
+// in revision order, so replaying them leaves the latest values.
+func replaySnapshot(tx *bolt.Tx, fn func(rev int64, kv *mvccpb.KeyValue, deleted bool)) error {
+	bucket := tx.Bucket([]byte("key"))
+	if bucket == nil {
+		return errors.New("not an etcd snapshot: no key bucket")
+	}
+	return bucket.ForEach(func(rev, data []byte) error {
+		var kv mvccpb.KeyValue
+		if err := kv.Unmarshal(data); err != nil {
+			return err
+		}
+		// Revisions are the main revision, "_" and the sub revision, 17
+		// bytes; a trailing "t" marks a deletion.
+		fn(int64(binary.BigEndian.Uint64(rev)), &kv, len(rev) == 18 && rev[17] == 't')
+		return nil
+	})
+}
+
+// readSnapshot reads the live keys from a snapshot.
+func readSnapshot(file string) (map[string]string, error) {
+	db, err := openSnapshot(file)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	keys := make(map[string]string)
+	err = db.View(func(tx *bolt.Tx) error {
+		return replaySnapshot(tx, func(_ int64, kv *mvccpb.KeyValue, deleted bool) {
+			if deleted {
+				delete(keys, string(kv.Key))
+			} else {
+				keys[string(kv.Key)] = string(kv.Value)
+			}
+		})
+	})
+	return keys, err
+}
+
+// readDump reads the keys from the output of `etcdctl get -w json`, which
+// base64-encodes keys and values.
+func readDump(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var dump struct {
+		Kvs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(f).Decode(&dump); err != nil {
+		return nil, fmt.Errorf("not an etcdctl JSON dump: %w", err)
+	}
+	keys := make(map[string]string, len(dump.Kvs))
+	for _, kv := range dump.Kvs {
+		keys[string(kv.Key)] = string(kv.Value)
+	}
+	return keys, nil
+}
+
+// readArchive reads the keys from a tar.gz archive as exports write them:
+// each file holds the value of the key at its path, and archiveValueName
+// files the value of their directory's key. Metadata files that macOS tar
+// adds are ignored.
+func readArchive(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("not a tar.gz archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	keys := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not a tar.gz archive: %w", err)
+		}
+		key := path.Clean("/" + hdr.Name)
+		base := path.Base(key)
+		if hdr.Typeflag != tar.TypeReg || strings.HasPrefix(base, "._") || base == ".DS_Store" {
+			continue
+		}
+		if base == archiveValueName {
+			if key = path.Dir(key); key == "/" {
+				continue
+			}
+		}
+		value, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		keys[key] = string(value)
+	}
+}
+
+// importFile loads the keys in the uploaded file at path under prefix. Keys
+// under strip have it replaced by prefix; without strip, source keys are
+// appended to prefix. Leases are not carried over.
+func importFile(ctx context.Context, client clientv3.KV, audit *AuditLog, actor, path, format, prefix, strip, policy string, progress *JobProgress) (ImportResult, error) {
+	defer os.Remove(path)
+	var result ImportResult
+	read := readDump
+	switch format {
+	case importSnapshot:
+		read = readSnapshot
+	case importTarGz:
+		read = readArchive
+	}
+	source, err := read(path)
+	if err != nil {
+		return result, err
+	}
+	keys := make([]DumpedKey, 0, len(source))
+	for key, value := range source {
+		if !strings.HasPrefix(key, strip) || strings.HasPrefix(key, "/.") {
+			result.Ignored++
+			continue
+		}
+		keys = append(keys, DumpedKey{Key: prefix + strings.TrimPrefix(key[len(strip):], "/"), Value: value})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	progress.SetTotal(int64(len(keys)))
+	progress.Logf("Read %d keys from the %s file, ignoring %d", len(keys), format, result.Ignored)
+
+	loaded, err := loadKeys(ctx, client, audit, actor, "import", keys, policy, progress)
+	result.Imported, result.Skipped, result.Revision = loaded.Restored, loaded.Skipped, loaded.Revision
+	if err != nil {
+		return result, err
+	}
+	progress.Logf("Imported %d keys, skipped %d that already existed", result.Imported, result.Skipped)
+	return result, nil
+}
+
+// ImportJobHandler starts a job loading an etcdctl snapshot or JSON dump, or
+// a tar.gz export, sent as the request body, into a prefix and returns it immediately. The
+// conflict policy for existing keys is skip, overwrite or fail; fail checks
+// every key before writing any.
+func ImportJobHandler(client clientv3.KV, jobs *Jobs, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", importJSON)
+		if format != importSnapshot && format != importJSON && format != importTarGz {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be snapshot, json or tar.gz"})
+			return
+		}
+		policy := c.DefaultQuery("conflict", conflictSkip)
+		if policy != conflictSkip && policy != conflictOverwrite && policy != conflictFail {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Conflict must be skip, overwrite or fail"})
+			return
+		}
+		prefix, strip := c.Query("prefix"), c.Query("strip")
+		if !strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") || strings.HasPrefix(prefix, "/.") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Prefix must start and end with / and be outside reserved prefixes"})
+			return
+		}
+
+		f, err := os.CreateTemp("", "etcd-gateway-import-*")
+		if err != nil {
+			logger.Error("Error creating import file", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		_, err = io.Copy(f, c.Request.Body)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot read request body"})
+			return
+		}
+
+		actor := requestActor(c)
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		job, err := jobs.Start(ctx, "import", actor, func(ctx context.Context, progress *JobProgress) (interface{}, error) {
+			return importFile(ctx, client, audit, actor, f.Name(), format, prefix, strip, policy, progress)
+		})
+		if err != nil {
+			os.Remove(f.Name())
+			logger.Error("Error starting import job", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusAccepted, job)
+	}
+}
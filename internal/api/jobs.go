@@ -0,0 +1,384 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// jobPrefix holds job records; jobCancelPrefix holds cancellation
+	// requests, which the replica running the job watches for.
+	jobPrefix       = "/.jobs/run/"
+	jobCancelPrefix = "/.jobs/cancel/"
+	// jobHeartbeat is how often a running job saves its progress. A job not
+	// saved for jobStaleAfter was lost with the replica running it.
+	jobHeartbeat  = 5 * time.Second
+	jobStaleAfter = 30 * time.Second
+	// jobMaxLogs is how many log lines a job keeps; older ones are dropped.
+	jobMaxLogs = 200
+	// jobRetention is how long finished jobs are kept.
+	jobRetention = 7 * 24 * time.Hour
+)
+
+// Job states.
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+var errJobFinished = errors.New("job has already finished")
+
+var jobSeq uint32
+
+// JobProgress is how a running job reports on itself.
+type JobProgress struct {
+	mu  sync.Mutex
+	job *Job
+}
+
+// JobID returns the ID of the job being run.
+func (p *JobProgress) JobID() string {
+	return p.job.ID
+}
+
+// SetTotal sets how many units of work the job has.
+func (p *JobProgress) SetTotal(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.job.Total = n
+}
+
+// Add records n more units of work done.
+func (p *JobProgress) Add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.job.Done += n
+}
+
+// Logf adds a line to the job's log.
+func (p *JobProgress) Logf(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.job.Logs = append(p.job.Logs, JobLog{Time: time.Now(), Message: fmt.Sprintf(format, args...)})
+	if len(p.job.Logs) > jobMaxLogs {
+		p.job.Logs = p.job.Logs[len(p.job.Logs)-jobMaxLogs:]
+	}
+}
+
+func (p *JobProgress) snapshot() Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job := *p.job
+	job.Logs = append([]JobLog(nil), job.Logs...)
+	return job
+}
+
+// JobFunc does a job's work, reporting through progress. Its result is
+// stored with the job and must marshal to JSON.
+type JobFunc func(ctx context.Context, progress *JobProgress) (interface{}, error)
+
+// Jobs runs long operations in the background and records their progress
+// in etcd, so any replica can report on or cancel them.
+type Jobs struct {
+	client *clientv3.Client
+	logger *zap.Logger
+
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+// NewJobs creates a job runner; call Run to allow jobs to start.
+func NewJobs(client *clientv3.Client, logger *zap.Logger) *Jobs {
+	return &Jobs{client: client, logger: logger}
+}
+
+// Run lets jobs start and purges old ones until ctx is cancelled, which also
+// stops the jobs running here.
+func (j *Jobs) Run(ctx context.Context) {
+	j.mu.Lock()
+	j.ctx = ctx
+	j.mu.Unlock()
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := j.purge(ctx); err != nil && ctx.Err() == nil {
+			j.logger.Warn("Cannot purge old jobs", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge removes jobs created before the retention period. IDs start with
+// the creation time, so they go in one range delete.
+func (j *Jobs) purge(ctx context.Context) error {
+	cutoff := jobPrefix + fmt.Sprintf("%019d", time.Now().Add(-jobRetention).UnixNano())
+	_, err := j.client.Delete(ctx, jobPrefix, clientv3.WithRange(cutoff))
+	return err
+}
+
+// Start records a new job and runs fn in the background.
+func (j *Jobs) Start(ctx context.Context, kind, actor string, fn JobFunc) (Job, error) {
+	j.mu.Lock()
+	runCtx := j.ctx
+	j.mu.Unlock()
+	if runCtx == nil {
+		return Job{}, errors.New("jobs are not running")
+	}
+	job, rev, err := j.create(ctx, kind, actor)
+	if err != nil {
+		return job, err
+	}
+	go j.run(runCtx, job, rev, fn)
+	return job, nil
+}
+
+// Do records a new job and runs fn until it finishes or ctx is cancelled,
+// returning the job as it ended. It is for work already running in the
+// background, such as maintenance tasks, that should be reported as a job.
+func (j *Jobs) Do(ctx context.Context, kind, actor string, fn JobFunc) (Job, error) {
+	job, rev, err := j.create(ctx, kind, actor)
+	if err != nil {
+		return job, err
+	}
+	return j.run(ctx, job, rev, fn), nil
+}
+
+// create records a new running job, returning it and the revision it was
+// created at.
+func (j *Jobs) create(ctx context.Context, kind, actor string) (Job, int64, error) {
+	now := time.Now()
+	job := Job{
+		ID:        fmt.Sprintf("%019d-%04x", now.UnixNano(), uint16(atomic.AddUint32(&jobSeq, 1))),
+		Kind:      kind,
+		Actor:     actor,
+		Status:    jobRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	rev, err := j.save(ctx, job)
+	return job, rev, err
+}
+
+func (j *Jobs) save(ctx context.Context, job Job) (int64, error) {
+	job.UpdatedAt = time.Now()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := j.client.Put(ctx, jobPrefix+job.ID, string(data))
+	if err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
+// run runs fn for job, created at rev, saving its progress every
+// jobHeartbeat and its outcome at the end, which it returns.
+func (j *Jobs) run(parent context.Context, job Job, rev int64, fn JobFunc) Job {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	var cancelled atomic.Bool
+	go func() {
+		for wresp := range j.client.Watch(ctx, jobCancelPrefix+job.ID, clientv3.WithRev(rev)) {
+			for _, ev := range wresp.Events {
+				if ev.Type == clientv3.EventTypePut {
+					cancelled.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	progress := &JobProgress{job: &job}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(jobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := j.save(ctx, progress.snapshot()); err != nil && ctx.Err() == nil {
+					j.logger.Warn("Cannot save job progress", zap.String("job", job.ID), zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	result, err := fn(ctx, progress)
+	// Wait for the heartbeat, so it cannot overwrite the outcome.
+	close(done)
+	<-stopped
+
+	final := progress.snapshot()
+	now := time.Now()
+	final.FinishedAt = &now
+	switch {
+	case cancelled.Load():
+		final.Status = jobCancelled
+	case parent.Err() != nil:
+		final.Status = jobFailed
+		final.Error = "Interrupted by gateway shutdown"
+	case err != nil:
+		final.Status = jobFailed
+		final.Error = err.Error()
+	default:
+		final.Status = jobSucceeded
+		if result != nil {
+			if final.Result, err = json.Marshal(result); err != nil {
+				final.Status, final.Error = jobFailed, err.Error()
+			}
+		}
+	}
+	saveCtx, cancelSave := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelSave()
+	if _, err := j.save(saveCtx, final); err != nil {
+		j.logger.Warn("Cannot save job progress", zap.String("job", job.ID), zap.Error(err))
+	}
+	if _, err := j.client.Delete(saveCtx, jobCancelPrefix+job.ID); err != nil {
+		j.logger.Warn("Cannot clear job cancellation", zap.String("job", job.ID), zap.Error(err))
+	}
+	j.logger.Info("Job finished", zap.String("job", job.ID), zap.String("kind", job.Kind), zap.String("status", final.Status))
+	return final
+}
+
+func decodeJob(data []byte) (Job, error) {
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return job, err
+	}
+	if job.Status == jobRunning && time.Since(job.UpdatedAt) > jobStaleAfter {
+		job.Status = jobFailed
+		job.Error = "Interrupted: the gateway running the job stopped"
+	}
+	return job, nil
+}
+
+// Get returns a job's current state.
+func (j *Jobs) Get(ctx context.Context, id string) (Job, error) {
+	resp, err := j.client.Get(ctx, jobPrefix+id)
+	if err != nil {
+		return Job{}, err
+	}
+	if len(resp.Kvs) == 0 {
+		return Job{}, errJobNotFound
+	}
+	return decodeJob(resp.Kvs[0].Value)
+}
+
+// List returns up to limit jobs, newest first.
+func (j *Jobs) List(ctx context.Context, limit int) ([]Job, error) {
+	resp, err := j.client.Get(ctx, jobPrefix, clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend), clientv3.WithLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		job, err := decodeJob(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		job.Logs = nil
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Cancel asks the replica running a job to stop it.
+func (j *Jobs) Cancel(ctx context.Context, id string) (Job, error) {
+	job, err := j.Get(ctx, id)
+	if err != nil {
+		return job, err
+	}
+	if job.Status != jobRunning {
+		return job, errJobFinished
+	}
+	_, err = j.client.Put(ctx, jobCancelPrefix+id, time.Now().UTC().Format(time.RFC3339))
+	return job, err
+}
+
+// jobError writes the response for an error from Jobs.
+func jobError(c *gin.Context, err error, logger *zap.Logger) {
+	switch {
+	case errors.Is(err, errJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	case errors.Is(err, errJobFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": "Job has already finished"})
+	default:
+		logger.Error("Error reading job from etcd", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+	}
+}
+
+// JobListHandler lists recent jobs, newest first, without their logs.
+func JobListHandler(jobs *Jobs, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := defaultSearchLimit
+		if s := c.Query("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		list, err := jobs.List(ctx, limit)
+		if err != nil {
+			jobError(c, err, logger)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"jobs": list})
+	}
+}
+
+// JobHandler reports a job's progress, logs and result.
+func JobHandler(jobs *Jobs, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		job, err := jobs.Get(ctx, c.Param("id"))
+		if err != nil {
+			jobError(c, err, logger)
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// JobCancelHandler cancels a running job. The job stops shortly after; poll
+// it to see it cancelled.
+func JobCancelHandler(jobs *Jobs, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		job, err := jobs.Cancel(ctx, c.Param("id"))
+		if err != nil {
+			jobError(c, err, logger)
+			return
+		}
+		c.JSON(http.StatusAccepted, job)
+	}
+}
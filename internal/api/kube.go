@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// kubeServiceAccountDir holds the credentials mounted into every pod.
+const kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+var errKubeNotFound = errors.New("kubernetes object not found")
+
+// kubeObjectMeta is the subset of ObjectMeta the gateway reads and writes.
+type kubeObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+}
+
+// kubeClient is a minimal client for the Kubernetes REST API using the pod's
+// service account.
+type kubeClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// newInClusterKubeClient configures a client from the service account and
+// the KUBERNETES_SERVICE_HOST/PORT variables set in every pod.
+func newInClusterKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running inside kubernetes")
+	}
+	token, err := os.ReadFile(kubeServiceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(kubeServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account CA certificate")
+	}
+	return &kubeClient{
+		base:  "https://" + net.JoinHostPort(host, port),
+		token: strings.TrimSpace(string(token)),
+		http: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// do sends body as JSON and decodes the response into out, if given.
+func (k *kubeClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, k.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errKubeNotFound
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("kubernetes %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apply creates or replaces the namespaced object at collection/name (e.g.
+// "configmaps") and decodes the stored object into out.
+func (k *kubeClient) apply(ctx context.Context, namespace, collection, name string, obj, out interface{}) error {
+	path := fmt.Sprintf("/api/v1/namespaces/%s/%s", namespace, collection)
+	err := k.do(ctx, http.MethodPut, path+"/"+name, obj, out)
+	if errors.Is(err, errKubeNotFound) {
+		err = k.do(ctx, http.MethodPost, path, obj, out)
+	}
+	return err
+}
+
+// get reads the namespaced object at collection/name into out.
+func (k *kubeClient) get(ctx context.Context, namespace, collection, name string, out interface{}) error {
+	return k.do(ctx, http.MethodGet, fmt.Sprintf("/api/v1/namespaces/%s/%s/%s", namespace, collection, name), nil, out)
+}
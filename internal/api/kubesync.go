@@ -0,0 +1,336 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// kubeManagedBy labels objects the gateway maintains.
+	kubeManagedBy = "etcd-gateway"
+	// kubeRevisionAnnotation records the etcd revision an object was written from.
+	kubeRevisionAnnotation = "etcd-gateway/revision"
+	// kubeKeySeparator stands in for "/" in data keys, where it is not allowed.
+	kubeKeySeparator = "__"
+	// kubeSyncQuiet is how long to wait for further etcd events before pushing.
+	kubeSyncQuiet = time.Second
+)
+
+// kubeDataKeyPattern is what Kubernetes accepts as a ConfigMap or Secret data key.
+var kubeDataKeyPattern = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// KubeMapping mirrors the keys under Prefix into one object.
+type KubeMapping struct {
+	Prefix    string
+	Namespace string
+	Name      string
+}
+
+// ParseKubeMappings parses "prefix=namespace/name" entries.
+func ParseKubeMappings(entries []string) ([]KubeMapping, error) {
+	var mappings []KubeMapping
+	for _, entry := range entries {
+		prefix, target, ok := strings.Cut(entry, "=")
+		namespace, name, ok2 := strings.Cut(target, "/")
+		if !ok || !ok2 || !strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") || namespace == "" || name == "" {
+			return nil, errors.New("invalid mapping " + strconv.Quote(entry) + ", expected /prefix/=namespace/name")
+		}
+		mappings = append(mappings, KubeMapping{Prefix: prefix, Namespace: namespace, Name: name})
+	}
+	return mappings, nil
+}
+
+// toDataKey maps a key under prefix to a data key, or false if it cannot be
+// represented (including keys that already contain the separator, which
+// would not map back).
+func toDataKey(prefix, key string) (string, bool) {
+	rel := strings.TrimPrefix(key, prefix)
+	if strings.Contains(rel, kubeKeySeparator) {
+		return "", false
+	}
+	dataKey := strings.ReplaceAll(rel, "/", kubeKeySeparator)
+	return dataKey, kubeDataKeyPattern.MatchString(dataKey)
+}
+
+func fromDataKey(prefix, dataKey string) string {
+	return prefix + strings.ReplaceAll(dataKey, kubeKeySeparator, "/")
+}
+
+// kubeConfigMap is the subset of a ConfigMap the gateway reads and writes.
+type kubeConfigMap struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   kubeObjectMeta    `json:"metadata"`
+	Data       map[string]string `json:"data"`
+}
+
+// kubeSecret is the subset of a Secret the gateway reads and writes. Data
+// values are []byte so they are base64 encoded, as the API expects.
+type kubeSecret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   kubeObjectMeta    `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	Data       map[string][]byte `json:"data"`
+}
+
+// kubeKind describes how one kind of object holds key/value data.
+type kubeKind struct {
+	name       string
+	collection string
+	encode     func(meta kubeObjectMeta, data map[string]string) interface{}
+	decode     func(ctx context.Context, kube *kubeClient, namespace, name string) (kubeObjectMeta, map[string]string, error)
+}
+
+var configMapKind = kubeKind{
+	name:       "configmap",
+	collection: "configmaps",
+	encode: func(meta kubeObjectMeta, data map[string]string) interface{} {
+		return kubeConfigMap{APIVersion: "v1", Kind: "ConfigMap", Metadata: meta, Data: data}
+	},
+	decode: func(ctx context.Context, kube *kubeClient, namespace, name string) (kubeObjectMeta, map[string]string, error) {
+		var cm kubeConfigMap
+		err := kube.get(ctx, namespace, "configmaps", name, &cm)
+		return cm.Metadata, cm.Data, err
+	},
+}
+
+var secretKind = kubeKind{
+	name:       "secret",
+	collection: "secrets",
+	encode: func(meta kubeObjectMeta, data map[string]string) interface{} {
+		secret := kubeSecret{APIVersion: "v1", Kind: "Secret", Metadata: meta, Type: "Opaque", Data: make(map[string][]byte, len(data))}
+		for k, v := range data {
+			secret.Data[k] = []byte(v)
+		}
+		return secret
+	},
+	decode: func(ctx context.Context, kube *kubeClient, namespace, name string) (kubeObjectMeta, map[string]string, error) {
+		var secret kubeSecret
+		err := kube.get(ctx, namespace, "secrets", name, &secret)
+		data := make(map[string]string, len(secret.Data))
+		for k, v := range secret.Data {
+			data[k] = string(v)
+		}
+		return secret.Metadata, data, err
+	},
+}
+
+// KubeSyncer mirrors etcd prefixes into ConfigMaps or Secrets. With reverse
+// set, edits made to the objects are written back to etcd, polled every interval.
+type KubeSyncer struct {
+	client   *clientv3.Client
+	kube     *kubeClient
+	kind     kubeKind
+	audit    *AuditLog
+	logger   *zap.Logger
+	mappings []KubeMapping
+	reverse  bool
+	interval time.Duration
+}
+
+// NewConfigMapSyncer creates a ConfigMap syncer using the pod's service
+// account; call Run to start syncing.
+func NewConfigMapSyncer(client *clientv3.Client, audit *AuditLog, logger *zap.Logger, mappings []KubeMapping, reverse bool, interval time.Duration) (*KubeSyncer, error) {
+	return newKubeSyncer(client, configMapKind, audit, logger, mappings, reverse, interval)
+}
+
+// NewSecretSyncer creates a Secret syncer using the pod's service account;
+// call Run to start syncing. Values are only ever sent to the API server and
+// never logged.
+func NewSecretSyncer(client *clientv3.Client, audit *AuditLog, logger *zap.Logger, mappings []KubeMapping, reverse bool, interval time.Duration) (*KubeSyncer, error) {
+	return newKubeSyncer(client, secretKind, audit, logger, mappings, reverse, interval)
+}
+
+func newKubeSyncer(client *clientv3.Client, kind kubeKind, audit *AuditLog, logger *zap.Logger, mappings []KubeMapping, reverse bool, interval time.Duration) (*KubeSyncer, error) {
+	kube, err := newInClusterKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	return &KubeSyncer{
+		client:   client,
+		kube:     kube,
+		kind:     kind,
+		audit:    audit,
+		logger:   logger,
+		mappings: mappings,
+		reverse:  reverse,
+		interval: interval,
+	}, nil
+}
+
+// Run syncs every mapping until ctx is cancelled.
+func (s *KubeSyncer) Run(ctx context.Context) {
+	for _, m := range s.mappings {
+		go s.runMapping(ctx, m)
+	}
+	<-ctx.Done()
+}
+
+func (s *KubeSyncer) runMapping(ctx context.Context, m KubeMapping) {
+	log := s.logger.With(zap.String("prefix", m.Prefix), zap.String(s.kind.name, m.Namespace+"/"+m.Name))
+	for ctx.Err() == nil {
+		rev, resourceVersion, err := s.push(ctx, m)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warn("Kubernetes sync failed", zap.Error(err))
+				time.Sleep(5 * time.Second)
+			}
+			continue
+		}
+		if err := s.follow(ctx, m, rev, resourceVersion); err != nil && ctx.Err() == nil {
+			log.Warn("Kubernetes sync failed", zap.Error(err))
+		}
+	}
+}
+
+// push writes the current contents of the prefix to the object and returns
+// the revision written and the object's resulting resourceVersion.
+func (s *KubeSyncer) push(ctx context.Context, m KubeMapping) (int64, string, error) {
+	data := make(map[string]string)
+	rev, err := scanPrefix(ctx, s.client, m.Prefix, func(kv *mvccpb.KeyValue) {
+		if dataKey, ok := toDataKey(m.Prefix, string(kv.Key)); ok {
+			data[dataKey] = string(kv.Value)
+		} else {
+			s.logger.Debug("Key cannot be represented as a data key", zap.String("key", string(kv.Key)))
+		}
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	obj := s.kind.encode(kubeObjectMeta{
+		Name:        m.Name,
+		Namespace:   m.Namespace,
+		Labels:      map[string]string{"app.kubernetes.io/managed-by": kubeManagedBy},
+		Annotations: map[string]string{kubeRevisionAnnotation: strconv.FormatInt(rev, 10)},
+	}, data)
+	var stored struct {
+		Metadata kubeObjectMeta `json:"metadata"`
+	}
+	if err := s.kube.apply(ctx, m.Namespace, s.kind.collection, m.Name, obj, &stored); err != nil {
+		return 0, "", err
+	}
+	return rev, stored.Metadata.ResourceVersion, nil
+}
+
+// follow pushes again after etcd changes settle and, in reverse mode, pulls
+// edits made in the cluster. It returns when the watch fails or a push is due.
+func (s *KubeSyncer) follow(ctx context.Context, m KubeMapping, rev int64, resourceVersion string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wch := s.client.Watch(ctx, m.Prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+
+	quiet := time.NewTimer(kubeSyncQuiet)
+	quiet.Stop()
+	defer quiet.Stop()
+	var poll <-chan time.Time
+	if s.reverse {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case wresp, ok := <-wch:
+			if !ok {
+				return ctx.Err()
+			}
+			if err := wresp.Err(); err != nil {
+				return err
+			}
+			if len(wresp.Events) > 0 {
+				quiet.Reset(kubeSyncQuiet)
+			}
+		case <-quiet.C:
+			return nil
+		case <-poll:
+			meta, data, err := s.kind.decode(ctx, s.kube, m.Namespace, m.Name)
+			if err != nil {
+				if errors.Is(err, errKubeNotFound) {
+					// Deleted from the cluster: recreate it from etcd.
+					return nil
+				}
+				return err
+			}
+			if meta.ResourceVersion == resourceVersion {
+				continue
+			}
+			if err := s.pull(ctx, m, data); err != nil {
+				return err
+			}
+			resourceVersion = meta.ResourceVersion
+		}
+	}
+}
+
+// pull makes the prefix match data, in one transaction guarded against
+// concurrent etcd changes.
+func (s *KubeSyncer) pull(ctx context.Context, m KubeMapping, data map[string]string) error {
+	resp, err := s.client.Get(ctx, m.Prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	current := make(map[string]*mvccpb.KeyValue, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if _, ok := toDataKey(m.Prefix, string(kv.Key)); ok {
+			current[string(kv.Key)] = kv
+		}
+	}
+
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	for dataKey, value := range data {
+		key := fromDataKey(m.Prefix, dataKey)
+		kv, ok := current[key]
+		if ok && string(kv.Value) == value {
+			continue
+		}
+		var modRev int64
+		if ok {
+			modRev = kv.ModRevision
+		}
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", modRev))
+		ops = append(ops, clientv3.OpPut(key, value, clientv3.WithPrevKV()))
+	}
+	for key, kv := range current {
+		dataKey, _ := toDataKey(m.Prefix, key)
+		if _, ok := data[dataKey]; !ok {
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision))
+			ops = append(ops, clientv3.OpDelete(key, clientv3.WithPrevKV()))
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	if len(ops) > maxTxnOps {
+		return errors.New("too many changes to apply in one transaction")
+	}
+	txn, err := s.client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return err
+	}
+	if !txn.Succeeded {
+		return errConflict
+	}
+	var changes []AuditChange
+	for i, r := range txn.Responses {
+		if put := r.GetResponsePut(); put != nil {
+			changes = append(changes, putChange(string(ops[i].KeyBytes()), put.PrevKv, txn.Header.Revision))
+		} else {
+			changes = append(changes, deleteChanges(r.GetResponseDeleteRange().PrevKvs)...)
+		}
+	}
+	s.audit.Record(ctx, s.kind.name+":"+m.Namespace+"/"+m.Name, s.kind.name+"-sync", changes)
+	return nil
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// The KV guards are clientv3.KV wrappers, installed on a client the way
+// namespace installs its own, that refuse or check etcd calls whoever makes
+// them: handlers, background components and every protocol alike.
+
+type kvRequestKey struct{}
+
+// kvRequest is what KVGuardMiddleware records in a request's context: who
+// made it, for the guards to pass on, and why a guard refused one of its
+// etcd calls, if one did.
+type kvRequest struct {
+	user      string
+	requestID string
+	refusal   atomic.Pointer[kvRefusal]
+}
+
+// kvRefusal is the response to send for a refused etcd call.
+type kvRefusal struct {
+	status  int
+	message string
+}
+
+// kvRequestOf returns what KVGuardMiddleware recorded in ctx, or nil.
+func kvRequestOf(ctx context.Context) *kvRequest {
+	r, _ := ctx.Value(kvRequestKey{}).(*kvRequest)
+	return r
+}
+
+// refuse records that the request ctx belongs to should be answered with
+// status and message, and returns err for the guard to fail the call with.
+func refuse(ctx context.Context, status int, message string, err error) error {
+	if r := kvRequestOf(ctx); r != nil {
+		r.refusal.CompareAndSwap(nil, &kvRefusal{status: status, message: message})
+	}
+	return err
+}
+
+// rangeOverlapsPrefix reports whether any key in [key, end) is under prefix.
+// An empty end is the single key and "\x00" every key from key on.
+func rangeOverlapsPrefix(key, end []byte, prefix string) bool {
+	if len(end) == 0 {
+		return bytes.HasPrefix(key, []byte(prefix))
+	}
+	pend := []byte(clientv3.GetPrefixRangeEnd(prefix))
+	startsBefore := len(pend) == 0 || bytes.Compare(key, pend) < 0
+	endsAfter := (len(end) == 1 && end[0] == 0) || bytes.Compare(end, []byte(prefix)) > 0
+	return startsBefore && endsAfter
+}
+
+// checkedTxn is a transaction passed to check before it is committed.
+type checkedTxn struct {
+	clientv3.Txn
+	ctx              context.Context
+	check            func(ctx context.Context, cmps []clientv3.Cmp, thenOps, elseOps []clientv3.Op) error
+	cmps             []clientv3.Cmp
+	thenOps, elseOps []clientv3.Op
+}
+
+func (txn *checkedTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	txn.cmps = append(txn.cmps, cs...)
+	txn.Txn = txn.Txn.If(cs...)
+	return txn
+}
+
+func (txn *checkedTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	txn.thenOps = append(txn.thenOps, ops...)
+	txn.Txn = txn.Txn.Then(ops...)
+	return txn
+}
+
+func (txn *checkedTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	txn.elseOps = append(txn.elseOps, ops...)
+	txn.Txn = txn.Txn.Else(ops...)
+	return txn
+}
+
+func (txn *checkedTxn) Commit() (*clientv3.TxnResponse, error) {
+	if err := txn.check(txn.ctx, txn.cmps, txn.thenOps, txn.elseOps); err != nil {
+		return nil, err
+	}
+	return txn.Txn.Commit()
+}
+
+// refusalWriter replaces the server error a handler reports after one of
+// its etcd calls was refused with the refusal.
+type refusalWriter struct {
+	gin.ResponseWriter
+	request *kvRequest
+	body    []byte
+}
+
+func (w *refusalWriter) WriteHeader(code int) {
+	if code >= http.StatusInternalServerError {
+		if refusal := w.request.refusal.Load(); refusal != nil {
+			w.body, _ = json.Marshal(gin.H{"error": refusal.message})
+			code = refusal.status
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *refusalWriter) Write(b []byte) (int, error) {
+	if w.body == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	if !w.ResponseWriter.Written() {
+		w.ResponseWriter.Write(w.body)
+	}
+	return len(b), nil
+}
+
+func (w *refusalWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// KVGuardMiddleware records who made a request for the KV guards, and
+// answers requests failing because a guard refused one of their etcd calls
+// as the guard asked, e.g. 403 for a denied key, rather than with the
+// handler's 500.
+func KVGuardMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r := &kvRequest{user: requestUser(c), requestID: requestID(c)}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), kvRequestKey{}, r))
+		c.Writer = &refusalWriter{ResponseWriter: c.Writer, request: r}
+		c.Next()
+	}
+}
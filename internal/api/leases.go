@@ -0,0 +1,264 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// leaseOverviewWorkers is how many leases the overview asks etcd about at
+// once.
+const leaseOverviewWorkers = 8
+
+const (
+	// defaultLeaseTTL is the TTL of leases granted without one.
+	defaultLeaseTTL = 60 * time.Second
+	minLeaseTTL     = 5 * time.Second
+	maxLeaseTTL     = time.Hour
+)
+
+func formatLeaseID(id clientv3.LeaseID) string {
+	return strconv.FormatInt(int64(id), 16)
+}
+
+// leaseParam parses the :id parameter, answering 400 if it is not a lease ID.
+func leaseParam(c *gin.Context) (clientv3.LeaseID, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 16, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lease ID"})
+		return 0, false
+	}
+	return clientv3.LeaseID(id), true
+}
+
+// LeaseGrantHandler grants a lease, optionally writing keys attached to it
+// in the same request, for clients that can only speak HTTP to keep alive
+// with POST /leases/:id/keepalive.
+func LeaseGrantHandler(client LeaseKV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req LeaseGrantRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+				return
+			}
+		}
+		ttl := defaultLeaseTTL
+		if req.TTL != 0 {
+			ttl = time.Duration(req.TTL) * time.Second
+			if ttl < minLeaseTTL || ttl > maxLeaseTTL {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "TTL must be between 5 and 3600 seconds"})
+				return
+			}
+		}
+		if len(req.Keys) > maxTxnOps {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Too many keys in one lease", "max": maxTxnOps})
+			return
+		}
+		keys := make([]string, 0, len(req.Keys))
+		for key := range req.Keys {
+			if !strings.HasPrefix(key, "/") || reservedPrefix(key) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Keys must start with / and not be under a gateway prefix", "key": key})
+				return
+			}
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		lease, err := client.Grant(ctx, int64(ttl/time.Second))
+		if err != nil {
+			logger.Error("Error granting lease", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if len(keys) > 0 {
+			ops := make([]clientv3.Op, len(keys))
+			for i, key := range keys {
+				ops[i] = clientv3.OpPut(key, req.Keys[key], clientv3.WithLease(lease.ID), clientv3.WithPrevKV())
+			}
+			txn, err := client.Txn(ctx).Then(ops...).Commit()
+			if err != nil {
+				client.Revoke(ctx, lease.ID)
+				logger.Error("Error writing keys to etcd", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+				return
+			}
+			changes := make([]AuditChange, len(keys))
+			for i, r := range txn.Responses {
+				changes[i] = putChange(keys[i], r.GetResponsePut().PrevKv, txn.Header.Revision)
+			}
+			audit.Record(ctx, requestActor(c), "lease", changes)
+		}
+		c.JSON(http.StatusCreated, Lease{ID: formatLeaseID(lease.ID), TTL: lease.TTL, GrantedTTL: lease.TTL, Keys: keys})
+	}
+}
+
+// LeaseHandler returns a lease's remaining TTL and attached keys.
+func LeaseHandler(client clientv3.Lease, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := leaseParam(c)
+		if !ok {
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.TimeToLive(ctx, id, clientv3.WithAttachedKeys())
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) || (err == nil && resp.TTL == -1) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lease not found or expired"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error fetching lease from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		lease := Lease{ID: formatLeaseID(id), TTL: resp.TTL, GrantedTTL: resp.GrantedTTL, Keys: make([]string, len(resp.Keys))}
+		for i, key := range resp.Keys {
+			lease.Keys[i] = string(key)
+		}
+		c.JSON(http.StatusOK, lease)
+	}
+}
+
+// LeaseKeepAliveHandler renews a lease for another TTL, translating one
+// HTTP request into one etcd keepalive.
+func LeaseKeepAliveHandler(client clientv3.Lease, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := leaseParam(c)
+		if !ok {
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.KeepAliveOnce(ctx, id)
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lease not found or expired"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error renewing lease", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": formatLeaseID(id), "ttl": resp.TTL})
+	}
+}
+
+// LeaseRevokeHandler revokes a lease, deleting the keys attached to it.
+func LeaseRevokeHandler(client clientv3.Lease, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := leaseParam(c)
+		if !ok {
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		if _, err := client.Revoke(ctx, id); errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lease not found or expired"})
+			return
+		} else if err != nil {
+			logger.Error("Error revoking lease", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// LeaseOverviewHandler lists every active lease with its remaining TTL and
+// attached keys, soonest to expire first. ?within= (e.g. 5m) only lists
+// leases expiring within that long, ?prefix= only those with keys under it.
+func LeaseOverviewHandler(client clientv3.Lease, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var within time.Duration
+		if s := c.Query("within"); s != "" {
+			var err error
+			if within, err = time.ParseDuration(s); err != nil || within <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid within duration"})
+				return
+			}
+		}
+		prefix := c.Query("prefix")
+
+		ctx, cancel := longRequestContext(c, 30*time.Second)
+		defer cancel()
+		resp, err := client.Leases(ctx)
+		if err != nil {
+			logger.Error("Error listing leases", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		ids := make(chan clientv3.LeaseID)
+		var mu sync.Mutex
+		var firstErr error
+		leases := []Lease{}
+		var wg sync.WaitGroup
+		for i := 0; i < leaseOverviewWorkers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for id := range ids {
+					ttl, err := client.TimeToLive(ctx, id, clientv3.WithAttachedKeys())
+					if errors.Is(err, rpctypes.ErrLeaseNotFound) || (err == nil && ttl.TTL == -1) {
+						// Expired since it was listed.
+						continue
+					}
+					mu.Lock()
+					if err != nil {
+						if firstErr == nil {
+							firstErr = err
+						}
+						mu.Unlock()
+						continue
+					}
+					if within == 0 || time.Duration(ttl.TTL)*time.Second <= within {
+						lease := Lease{ID: formatLeaseID(id), TTL: ttl.TTL, GrantedTTL: ttl.GrantedTTL, Keys: []string{}}
+						for _, key := range ttl.Keys {
+							if strings.HasPrefix(string(key), prefix) {
+								lease.Keys = append(lease.Keys, string(key))
+							}
+						}
+						if prefix == "" || len(lease.Keys) > 0 {
+							leases = append(leases, lease)
+						}
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		for _, l := range resp.Leases {
+			ids <- l.ID
+		}
+		close(ids)
+		wg.Wait()
+		if firstErr != nil {
+			logger.Error("Error fetching leases from etcd", zap.Error(firstErr))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		sort.Slice(leases, func(i, j int) bool {
+			if leases[i].TTL != leases[j].TTL {
+				return leases[i].TTL < leases[j].TTL
+			}
+			return leases[i].ID < leases[j].ID
+		})
+		keys := 0
+		for _, lease := range leases {
+			keys += len(lease.Keys)
+		}
+		c.JSON(http.StatusOK, gin.H{"leases": leases, "count": len(leases), "keys": keys})
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+func TestLeaseHandlers(t *testing.T) {
+	store := apitest.NewStore()
+	client := store.Client()
+	logger := zap.NewNop()
+
+	// Grant a lease with keys attached.
+	req := httptest.NewRequest("POST", "/leases", strings.NewReader(`{"ttl":30,"keys":{"/svc/a":"1","/svc/b":"2"}}`))
+	rec := apitest.Serve("/leases", req, LeaseGrantHandler(client, nil, logger))
+	if rec.Code != 201 {
+		t.Fatalf("grant: status %d: %s", rec.Code, rec.Body)
+	}
+	var granted Lease
+	if err := json.Unmarshal(rec.Body.Bytes(), &granted); err != nil {
+		t.Fatal(err)
+	}
+	if granted.TTL != 30 || !reflect.DeepEqual(granted.Keys, []string{"/svc/a", "/svc/b"}) {
+		t.Errorf("granted %+v", granted)
+	}
+
+	rec = apitest.Serve("/leases/:id", httptest.NewRequest("GET", "/leases/"+granted.ID, nil), LeaseHandler(client, logger))
+	var lease Lease
+	json.Unmarshal(rec.Body.Bytes(), &lease)
+	if rec.Code != 200 || lease.GrantedTTL != 30 || !reflect.DeepEqual(lease.Keys, []string{"/svc/a", "/svc/b"}) {
+		t.Errorf("get: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = apitest.Serve("/leases/:id/keepalive", httptest.NewRequest("POST", "/leases/"+granted.ID+"/keepalive", nil), LeaseKeepAliveHandler(client, logger))
+	if want := `{"id":"` + granted.ID + `","ttl":30}`; rec.Code != 200 || rec.Body.String() != want {
+		t.Errorf("keepalive: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = apitest.Serve("/leases/overview", httptest.NewRequest("GET", "/leases/overview?prefix=/svc/b", nil), LeaseOverviewHandler(client, logger))
+	var overview struct {
+		Leases []Lease
+		Count  int
+		Keys   int
+	}
+	json.Unmarshal(rec.Body.Bytes(), &overview)
+	if rec.Code != 200 || overview.Count != 1 || overview.Keys != 1 || overview.Leases[0].ID != granted.ID {
+		t.Errorf("overview: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// Revoking deletes the keys; the lease is gone afterwards.
+	rec = apitest.Serve("/leases/:id", httptest.NewRequest("DELETE", "/leases/"+granted.ID, nil), LeaseRevokeHandler(client, logger))
+	if rec.Code != 204 {
+		t.Errorf("revoke: status %d: %s", rec.Code, rec.Body)
+	}
+	if resp, _ := client.Get(context.Background(), "/svc/", clientv3.WithPrefix()); len(resp.Kvs) != 0 {
+		t.Errorf("revoking left %v", keysOf(resp))
+	}
+	for _, tt := range []struct {
+		method, route string
+		handler       func(clientv3.Lease, *zap.Logger) gin.HandlerFunc
+	}{
+		{"GET", "/leases/:id", LeaseHandler},
+		{"POST", "/leases/:id/keepalive", LeaseKeepAliveHandler},
+		{"DELETE", "/leases/:id", LeaseRevokeHandler},
+	} {
+		url := strings.Replace(tt.route, ":id", granted.ID, 1)
+		if rec := apitest.Serve(tt.route, httptest.NewRequest(tt.method, url, nil), tt.handler(client, logger)); rec.Code != 404 {
+			t.Errorf("%s %s after revoking: status %d", tt.method, url, rec.Code)
+		}
+	}
+}
+
+func TestLeaseGrantInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"short TTL", `{"ttl":1}`},
+		{"reserved key", `{"keys":{"/.audit/x":"1"}}`},
+		{"relative key", `{"keys":{"a":"1"}}`},
+		{"invalid body", `[`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := apitest.NewStore()
+			rec := apitest.Serve("/leases", httptest.NewRequest("POST", "/leases", strings.NewReader(tt.body)), LeaseGrantHandler(store.Client(), nil, zap.NewNop()))
+			if rec.Code != 400 {
+				t.Errorf("got status %d: %s", rec.Code, rec.Body)
+			}
+			if leases, _ := store.Lease().Leases(context.Background()); len(leases.Leases) != 0 {
+				t.Errorf("granted %v", leases.Leases)
+			}
+		})
+	}
+}
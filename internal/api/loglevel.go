@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logLevelBody is the body of GET and PUT /admin/loglevel.
+type logLevelBody struct {
+	Level string `json:"level" binding:"required"`
+}
+
+// LogLevelHandler returns the gateway's current log level.
+func LogLevelHandler(level zap.AtomicLevel) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, logLevelBody{Level: level.Level().String()})
+	}
+}
+
+// LogLevelPutHandler changes the gateway's log level without a restart. It
+// only changes the replica serving the request, and access logs are
+// unaffected.
+func LogLevelPutHandler(level zap.AtomicLevel, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body logLevelBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		var l zapcore.Level
+		if err := l.UnmarshalText([]byte(body.Level)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid level, expected debug, info, warn, error, dpanic, panic or fatal"})
+			return
+		}
+		previous := level.Level()
+		level.SetLevel(l)
+		// Logged at WARN so the change shows at any level but error and above.
+		logger.Warn("Log level changed", zap.Stringer("from", previous), zap.Stringer("to", l), zap.String("user", requestUser(c)))
+		c.JSON(http.StatusOK, logLevelBody{Level: l.String()})
+	}
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// Compaction modes, named after etcd's --auto-compaction-mode.
+const (
+	CompactionRevision = "revision"
+	CompactionPeriodic = "periodic"
+)
+
+// compactionHistoryKey holds the revisions periodic compaction sampled.
+const compactionHistoryKey = schedulerPrefix + "compaction"
+
+// CompactionPolicy is how much history compaction keeps: the last Retain
+// revisions in revision mode, or the revisions of the last Retention in
+// periodic mode.
+type CompactionPolicy struct {
+	Mode      string
+	Retain    int64
+	Retention time.Duration
+}
+
+// compactionSample is the revision the cluster was at when compaction ran.
+type compactionSample struct {
+	Time     time.Time `json:"time"`
+	Revision int64     `json:"revision"`
+}
+
+// CompactionTask compacts the keyspace according to policy. Periodic
+// compaction samples the revision on each run and compacts to the last one
+// sampled at least the retention ago, so its precision is the interval.
+// Samples are kept in etcd, so a new leader carries on where the last left
+// off.
+func CompactionTask(client clientv3.KV, logger *zap.Logger, interval time.Duration, policy CompactionPolicy) ScheduledTask {
+	return ScheduledTask{Name: "compaction", Interval: interval, Run: func(ctx context.Context) error {
+		resp, err := client.Get(ctx, compactionHistoryKey)
+		if err != nil {
+			return err
+		}
+		rev := resp.Header.Revision - policy.Retain
+		if policy.Mode == CompactionPeriodic {
+			if rev, err = periodicCompactionRevision(ctx, client, resp, policy.Retention); err != nil {
+				return err
+			}
+		}
+		if rev <= 0 {
+			return nil
+		}
+		_, err = client.Compact(ctx, rev, clientv3.WithCompactPhysical())
+		if errors.Is(err, rpctypes.ErrCompacted) {
+			// Nothing was written since the last compaction.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		logger.Info("Compacted etcd", zap.Int64("revision", rev), zap.String("mode", policy.Mode))
+		return nil
+	}}
+}
+
+// periodicCompactionRevision records the current revision among the
+// samples read in resp, and returns the latest sampled at least retention
+// ago, or 0 if there is none yet. Older samples are dropped.
+func periodicCompactionRevision(ctx context.Context, client clientv3.KV, resp *clientv3.GetResponse, retention time.Duration) (int64, error) {
+	var samples []compactionSample
+	if len(resp.Kvs) > 0 {
+		if err := json.Unmarshal(resp.Kvs[0].Value, &samples); err != nil {
+			return 0, err
+		}
+	}
+	now := time.Now().UTC()
+	samples = append(samples, compactionSample{Time: now, Revision: resp.Header.Revision})
+	var rev int64
+	keep := 0
+	for i, sample := range samples {
+		if now.Sub(sample.Time) >= retention {
+			rev, keep = sample.Revision, i
+		}
+	}
+	samples = samples[keep:]
+	data, err := json.Marshal(samples)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := client.Put(ctx, compactionHistoryKey, string(data)); err != nil {
+		return 0, err
+	}
+	return rev, nil
+}
+
+const (
+	// defragSettle is how long a defragmented member has to become
+	// reachable and catch up with the leader before a rolling defrag aborts.
+	defragSettle = time.Minute
+	// defragMaxLag is how many raft entries a member may be behind the
+	// leader and still count as caught up.
+	defragMaxLag = 100
+)
+
+// DefragTask defragments the members one at a time as a "defrag" job, so
+// its progress shows in the jobs API. A member does not serve requests while
+// it is defragmented, so members are never done together, and the leader,
+// whose stall costs the most, goes last.
+func DefragTask(client *clientv3.Client, jobs *Jobs, interval time.Duration) ScheduledTask {
+	return ScheduledTask{Name: "defrag", Interval: interval, Run: func(ctx context.Context) error {
+		job, err := jobs.Do(ctx, "defrag", "scheduler", func(ctx context.Context, progress *JobProgress) (interface{}, error) {
+			return rollingDefrag(ctx, client, progress)
+		})
+		if err != nil {
+			return err
+		}
+		if job.Status != jobSucceeded {
+			return fmt.Errorf("defrag job %s %s: %s", job.ID, job.Status, job.Error)
+		}
+		return nil
+	}}
+}
+
+// rollingDefrag defragments each member in turn, choosing the next one
+// afresh each time since leadership may move, and waiting for each to catch
+// up before the next. It aborts as soon as the cluster looks degraded: a
+// member unreachable, lagging or corrupt.
+func rollingDefrag(ctx context.Context, client *clientv3.Client, progress *JobProgress) (DefragResult, error) {
+	result := DefragResult{Members: []DefraggedMember{}}
+	done := make(map[string]bool)
+	progress.SetTotal(int64(len(client.Endpoints())))
+	for {
+		members := memberStatuses(ctx, client)
+		if err := clusterDegraded(ctx, client, members); err != nil {
+			progress.Logf("Aborting: %v", err)
+			return result, err
+		}
+		var next *MemberStatus
+		for i := range members {
+			if !done[members[i].Endpoint] {
+				next = &members[i]
+				break
+			}
+		}
+		if next == nil {
+			return result, nil
+		}
+
+		role := "follower"
+		if next.Leader {
+			role = "leader"
+		}
+		progress.Logf("Defragmenting %s %s at %s, %d of %d bytes in use", role, next.ID, next.Endpoint, next.DBSizeInUse, next.DBSize)
+		if _, err := client.Defragment(ctx, next.Endpoint); err != nil {
+			progress.Logf("Defragmenting %s failed: %v", next.ID, err)
+			return result, fmt.Errorf("defragment %s: %w", next.Endpoint, err)
+		}
+		after, err := awaitCaughtUp(ctx, client, next.Endpoint)
+		if err != nil {
+			progress.Logf("Aborting: %v", err)
+			return result, err
+		}
+		progress.Logf("Defragmented %s from %d to %d bytes", next.ID, next.DBSize, after.DBSize)
+		result.Members = append(result.Members, DefraggedMember{ID: next.ID, Endpoint: next.Endpoint, DBSizeBefore: next.DBSize, DBSizeAfter: after.DBSize})
+		done[next.Endpoint] = true
+		progress.Add(1)
+	}
+}
+
+// clusterDegraded returns why the cluster should not be defragmented
+// further, or nil if every member is reachable and none is corrupt.
+func clusterDegraded(ctx context.Context, client *clientv3.Client, members []MemberStatus) error {
+	for _, m := range members {
+		if m.Error != "" {
+			return fmt.Errorf("member at %s is unreachable: %s", m.Endpoint, m.Error)
+		}
+	}
+	alarms, err := client.AlarmList(ctx)
+	if err != nil {
+		return err
+	}
+	for _, a := range alarms.Alarms {
+		if a.Alarm == pb.AlarmType_CORRUPT {
+			return fmt.Errorf("member %x is corrupt", a.MemberID)
+		}
+	}
+	return nil
+}
+
+// awaitCaughtUp waits up to defragSettle for every member to be reachable
+// and the one at endpoint to be within defragMaxLag of the leader,
+// returning its status.
+func awaitCaughtUp(ctx context.Context, client *clientv3.Client, endpoint string) (MemberStatus, error) {
+	deadline := time.Now().Add(defragSettle)
+	for {
+		var member MemberStatus
+		var leaderIndex uint64
+		var err error
+		for _, m := range memberStatuses(ctx, client) {
+			if m.Error != "" {
+				err = fmt.Errorf("member at %s is unreachable: %s", m.Endpoint, m.Error)
+			}
+			if m.Endpoint == endpoint {
+				member = m
+			}
+			if m.Leader {
+				leaderIndex = m.RaftIndex
+			}
+		}
+		if err == nil && member.RaftIndex+defragMaxLag < leaderIndex {
+			err = fmt.Errorf("member %s is %d entries behind the leader", member.ID, leaderIndex-member.RaftIndex)
+		}
+		if err == nil {
+			return member, nil
+		}
+		if time.Now().After(deadline) {
+			return member, fmt.Errorf("cluster did not recover within %s: %w", defragSettle, err)
+		}
+		select {
+		case <-ctx.Done():
+			return member, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// TrashPurgeTask purges expired trash entries.
+func TrashPurgeTask(trash *Trash, interval time.Duration) ScheduledTask {
+	return ScheduledTask{Name: "trash-purge", Interval: interval, Run: trash.Purge}
+}
+
+// LeaseCleanupTask revokes leases with no keys attached. A lease is only
+// revoked once it has been found without keys on two runs in a row, so a
+// client that has just granted one has time to use it.
+func LeaseCleanupTask(client *clientv3.Client, logger *zap.Logger, interval time.Duration) ScheduledTask {
+	unused := make(map[clientv3.LeaseID]bool)
+	return ScheduledTask{Name: "lease-cleanup", Interval: interval, Run: func(ctx context.Context) error {
+		leases, err := client.Leases(ctx)
+		if err != nil {
+			return err
+		}
+		seen := make(map[clientv3.LeaseID]bool)
+		var revoked []string
+		for _, lease := range leases.Leases {
+			ttl, err := client.TimeToLive(ctx, lease.ID, clientv3.WithAttachedKeys())
+			if err != nil {
+				return err
+			}
+			if ttl.TTL <= 0 || len(ttl.Keys) > 0 {
+				continue
+			}
+			if !unused[lease.ID] {
+				seen[lease.ID] = true
+				continue
+			}
+			if _, err := client.Revoke(ctx, lease.ID); err != nil {
+				return err
+			}
+			revoked = append(revoked, fmt.Sprintf("%x", lease.ID))
+		}
+		unused = seen
+		if len(revoked) > 0 {
+			logger.Info("Revoked unused leases", zap.String("leases", strings.Join(revoked, ",")))
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/zap"
+)
+
+const (
+	// managedLeasePrefix holds the leader election and the leases marked as
+	// managed, each record attached to the lease it marks so it goes when
+	// the lease does.
+	managedLeasePrefix = "/.managedleases/"
+	// managedLeaseRescan is how often the leader looks for leases under the
+	// managed prefixes. Those must outlive it to be picked up; marked leases
+	// are picked up as soon as they are marked.
+	managedLeaseRescan = 10 * time.Second
+)
+
+// LeaseKeeper keeps leases alive on behalf of clients that may not: those
+// marked as managed and those of keys under its prefixes. One replica at a
+// time keeps them alive, elected like the maintenance scheduler; when it
+// stops, the next one elected takes over within the session TTL, which
+// leases should comfortably outlive. Leases that expire are not revived.
+type LeaseKeeper struct {
+	client   *clientv3.Client
+	logger   *zap.Logger
+	prefixes []string
+	id       string
+
+	mu   sync.Mutex
+	kept map[clientv3.LeaseID]context.CancelFunc
+}
+
+// NewLeaseKeeper creates a keeper; call Run to start campaigning.
+func NewLeaseKeeper(client *clientv3.Client, logger *zap.Logger, prefixes []string) *LeaseKeeper {
+	host, _ := os.Hostname()
+	return &LeaseKeeper{
+		client:   client,
+		logger:   logger,
+		prefixes: coveringPrefixes(prefixes),
+		id:       fmt.Sprintf("%s/%d", host, os.Getpid()),
+		kept:     make(map[clientv3.LeaseID]context.CancelFunc),
+	}
+}
+
+// Run campaigns for leadership and keeps leases alive while leader, until
+// ctx is cancelled.
+func (k *LeaseKeeper) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := k.lead(ctx); err != nil && ctx.Err() == nil {
+			k.logger.Warn("Lease keeper lost leadership", zap.Error(err))
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// lead waits to be elected, then keeps leases alive until leadership is
+// lost.
+func (k *LeaseKeeper) lead(ctx context.Context) error {
+	session, err := concurrency.NewSession(k.client, concurrency.WithContext(ctx), concurrency.WithTTL(schedulerSessionTTL))
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	election := concurrency.NewElection(session, managedLeasePrefix+"leader")
+	if err := election.Campaign(ctx, k.id); err != nil {
+		return err
+	}
+	k.logger.Info("Elected lease keeper", zap.String("replica", k.id))
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-session.Done():
+			cancel()
+		case <-leadCtx.Done():
+		}
+	}()
+	err = k.keep(leadCtx)
+
+	resignCtx, cancelResign := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelResign()
+	_ = election.Resign(resignCtx)
+	return err
+}
+
+// keep keeps the managed leases alive, looking for new ones every
+// managedLeaseRescan and as soon as one is marked, until ctx is cancelled.
+func (k *LeaseKeeper) keep(ctx context.Context) error {
+	marked := k.client.Watch(ctx, managedLeasePrefix+"leases/", clientv3.WithPrefix(), clientv3.WithFilterDelete())
+	defer func() {
+		k.mu.Lock()
+		for id, stop := range k.kept {
+			stop()
+			delete(k.kept, id)
+		}
+		k.mu.Unlock()
+	}()
+	for {
+		leases, err := k.managed(ctx)
+		if err != nil && ctx.Err() == nil {
+			k.logger.Warn("Cannot look for managed leases", zap.Error(err))
+		}
+		k.mu.Lock()
+		for id := range leases {
+			if _, ok := k.kept[id]; !ok {
+				k.start(ctx, id)
+			}
+		}
+		if err == nil {
+			for id, stop := range k.kept {
+				if !leases[id] {
+					stop()
+					delete(k.kept, id)
+				}
+			}
+		}
+		k.mu.Unlock()
+		select {
+		case <-time.After(managedLeaseRescan):
+		case wresp, ok := <-marked:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !ok || wresp.Err() != nil {
+				return fmt.Errorf("watching marked leases: %v", wresp.Err())
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// start keeps lease id alive until ctx is cancelled, it is stopped, or it
+// expires. k.mu must be held.
+func (k *LeaseKeeper) start(ctx context.Context, id clientv3.LeaseID) {
+	leaseCtx, stop := context.WithCancel(ctx)
+	responses, err := k.client.KeepAlive(leaseCtx, id)
+	if err != nil {
+		stop()
+		k.logger.Warn("Cannot keep lease alive", zap.String("lease", formatLeaseID(id)), zap.Error(err))
+		return
+	}
+	k.kept[id] = stop
+	go func() {
+		for range responses {
+		}
+		// Otherwise it was stopped, and is no longer kept.
+		if leaseCtx.Err() == nil {
+			k.logger.Warn("Managed lease expired", zap.String("lease", formatLeaseID(id)))
+			k.mu.Lock()
+			delete(k.kept, id)
+			k.mu.Unlock()
+		}
+		stop()
+	}()
+}
+
+// managed returns the leases marked as managed and those of keys under the
+// keeper's prefixes.
+func (k *LeaseKeeper) managed(ctx context.Context) (map[clientv3.LeaseID]bool, error) {
+	leases := make(map[clientv3.LeaseID]bool)
+	_, err := scanPrefixAt(ctx, k.client, managedLeasePrefix+"leases/", 0, func(kv *mvccpb.KeyValue) {
+		leases[clientv3.LeaseID(kv.Lease)] = true
+	}, clientv3.WithKeysOnly())
+	if err != nil {
+		return leases, err
+	}
+	for _, prefix := range k.prefixes {
+		if _, err := scanPrefixAt(ctx, k.client, prefix, 0, func(kv *mvccpb.KeyValue) {
+			if kv.Lease != 0 {
+				leases[clientv3.LeaseID(kv.Lease)] = true
+			}
+		}, clientv3.WithKeysOnly()); err != nil {
+			return leases, err
+		}
+	}
+	return leases, nil
+}
+
+// Mark makes the keeper keep lease id alive until it is unmarked or
+// revoked. It fails with rpctypes.ErrLeaseNotFound if the lease has
+// already expired.
+func (k *LeaseKeeper) Mark(ctx context.Context, id clientv3.LeaseID, actor string) (ManagedLease, error) {
+	mark := ManagedLease{ID: formatLeaseID(id), MarkedBy: actor, MarkedAt: time.Now().UTC()}
+	data, err := json.Marshal(mark)
+	if err != nil {
+		return mark, err
+	}
+	// Attaching the record fails if the lease is gone.
+	if _, err := k.client.Put(ctx, managedLeasePrefix+"leases/"+mark.ID, string(data), clientv3.WithLease(id)); err != nil {
+		return mark, err
+	}
+	return mark, nil
+}
+
+// Unmark stops keeping lease id alive, reporting whether it was marked.
+// The lease then expires unless its client keeps it alive.
+func (k *LeaseKeeper) Unmark(ctx context.Context, id clientv3.LeaseID) (bool, error) {
+	resp, err := k.client.Delete(ctx, managedLeasePrefix+"leases/"+formatLeaseID(id))
+	if err != nil {
+		return false, err
+	}
+	return resp.Deleted > 0, nil
+}
+
+// List returns the marked leases and the replica keeping leases alive, if
+// one is elected.
+func (k *LeaseKeeper) List(ctx context.Context) ([]ManagedLease, string, error) {
+	resp, err := k.client.Txn(ctx).Then(
+		clientv3.OpGet(managedLeasePrefix+"leases/", clientv3.WithPrefix()),
+		clientv3.OpGet(managedLeasePrefix+"leader/", clientv3.WithFirstCreate()...),
+	).Commit()
+	if err != nil {
+		return nil, "", err
+	}
+	marks := []ManagedLease{}
+	for _, kv := range resp.Responses[0].GetResponseRange().Kvs {
+		var mark ManagedLease
+		if err := json.Unmarshal(kv.Value, &mark); err != nil {
+			return nil, "", err
+		}
+		marks = append(marks, mark)
+	}
+	var leader string
+	if kvs := resp.Responses[1].GetResponseRange().Kvs; len(kvs) > 0 {
+		leader = string(kvs[0].Value)
+	}
+	return marks, leader, nil
+}
+
+// ManagedLeasesHandler lists the leases marked as managed, the prefixes
+// whose leases are managed and the replica keeping them alive.
+func ManagedLeasesHandler(keeper *LeaseKeeper, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keeper == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Managed leases are not enabled"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		marks, leader, err := keeper.List(ctx)
+		if err != nil {
+			logger.Error("Error listing managed leases", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		prefixes := keeper.prefixes
+		if prefixes == nil {
+			prefixes = []string{}
+		}
+		c.JSON(http.StatusOK, gin.H{"leases": marks, "prefixes": prefixes, "replica": leader})
+	}
+}
+
+// ManagedLeaseMarkHandler marks a lease for the gateway to keep alive.
+func ManagedLeaseMarkHandler(keeper *LeaseKeeper, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keeper == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Managed leases are not enabled"})
+			return
+		}
+		id, ok := leaseParam(c)
+		if !ok {
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		mark, err := keeper.Mark(ctx, id, requestActor(c))
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lease not found or expired"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error marking lease as managed", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, mark)
+	}
+}
+
+// ManagedLeaseUnmarkHandler stops the gateway keeping a lease alive.
+func ManagedLeaseUnmarkHandler(keeper *LeaseKeeper, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keeper == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Managed leases are not enabled"})
+			return
+		}
+		id, ok := leaseParam(c)
+		if !ok {
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		deleted, err := keeper.Unmark(ctx, id)
+		if err != nil {
+			logger.Error("Error unmarking managed lease", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if !deleted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lease is not managed"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+func toKeyMeta(kv *mvccpb.KeyValue, clock *RevisionClock) KeyMeta {
+	meta := KeyMeta{
+		Key:            string(kv.Key),
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+		ValueSize:      len(kv.Value),
+	}
+	if kv.Lease != 0 {
+		meta.Lease = strconv.FormatInt(kv.Lease, 16)
+	}
+	if t, ok := clock.TimeOf(kv.ModRevision); ok {
+		meta.ModifiedAt = &t
+	}
+	return meta
+}
+
+// FetchMetaHandler returns a key's metadata: revisions, version, lease, value
+// size and any annotation, or only the ?fields= asked for.
+func FetchMetaHandler(client clientv3.KV, clock *RevisionClock, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Key is required"})
+			return
+		}
+		fields, err := parseFields(c, KeyMeta{})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Txn(ctx).Then(clientv3.OpGet(key), clientv3.OpGet(annotationPrefix+key)).Commit()
+		if err != nil {
+			logger.Error("Error fetching key from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		kvs := resp.Responses[0].GetResponseRange().Kvs
+		if len(kvs) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+			return
+		}
+
+		kv := kvs[0]
+		etag := keyETag(kv.ModRevision)
+		annotation := resp.Responses[1].GetResponseRange().Kvs
+		if len(annotation) > 0 {
+			// Editing the annotation must invalidate cached metadata too.
+			etag = fmt.Sprintf(`"%d-%d"`, kv.ModRevision, annotation[0].ModRevision)
+		}
+		if notModified(c, etag) {
+			return
+		}
+		meta := toKeyMeta(kv, clock)
+		if len(annotation) > 0 {
+			meta.Annotation = decodeAnnotation(annotation[0])
+		}
+		sparseJSON(c, http.StatusOK, fields, meta)
+	}
+}
@@ -0,0 +1,501 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/zap"
+)
+
+const (
+	// mirrorPrefix holds the leader election, the status and resync requests.
+	mirrorPrefix = "/.mirror/"
+	// mirrorReportInterval is how often the leader measures lag and saves
+	// its status.
+	mirrorReportInterval = 5 * time.Second
+	// mirrorRetry is how long to wait after a failed sync or write.
+	mirrorRetry = 5 * time.Second
+	// mirrorWriteTimeout bounds a write to the destination, which would
+	// otherwise wait for an unreachable cluster indefinitely.
+	mirrorWriteTimeout = 10 * time.Second
+)
+
+var errResync = errors.New("resync requested")
+
+// Mirror states.
+const (
+	mirrorStarting  = "starting"
+	mirrorSyncing   = "syncing"
+	mirrorMirroring = "mirroring"
+	mirrorFailing   = "failing"
+)
+
+// MirrorConfig is the destination cluster and what to copy to it. Keys are
+// written to DestPrefix followed by the source key.
+type MirrorConfig struct {
+	Endpoints  []string
+	Username   string
+	Password   string
+	Prefixes   []string
+	DestPrefix string
+	Tuning     EtcdTuning
+}
+
+// Mirror replicates prefixes to a second etcd cluster, such as a DR site,
+// like `etcdctl make-mirror`. One replica at a time mirrors, elected like
+// the maintenance scheduler. It copies every key, deleting stale ones at
+// the destination, then applies watched changes in order. Leases are not
+// mirrored.
+type Mirror struct {
+	client   *clientv3.Client
+	dest     *clientv3.Client
+	logger   *zap.Logger
+	cfg      MirrorConfig
+	prefixes []string
+	id       string
+
+	mu      sync.Mutex
+	status  MirrorStatus
+	applied map[string]int64
+	probes  []mirrorProbe
+	lag     time.Duration
+}
+
+// mirrorProbe is a source revision and when it was read, to time when the
+// destination catches up with it.
+type mirrorProbe struct {
+	rev int64
+	at  time.Time
+}
+
+// NewMirror creates a mirror to cfg's cluster; call Run to start
+// campaigning. The destination is dialed lazily, so it can be down.
+func NewMirror(client *clientv3.Client, logger *zap.Logger, cfg MirrorConfig) (*Mirror, error) {
+	destCfg := cfg.Tuning.Config(cfg.Endpoints)
+	destCfg.Username, destCfg.Password = cfg.Username, cfg.Password
+	dest, err := clientv3.New(destCfg)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	return &Mirror{
+		client:   client,
+		dest:     dest,
+		logger:   logger,
+		cfg:      cfg,
+		prefixes: coveringPrefixes(cfg.Prefixes),
+		id:       fmt.Sprintf("%s/%d", host, os.Getpid()),
+	}, nil
+}
+
+// Run campaigns for leadership and mirrors while leader, until ctx is
+// cancelled.
+func (m *Mirror) Run(ctx context.Context) {
+	defer m.dest.Close()
+	for ctx.Err() == nil {
+		if err := m.lead(ctx); err != nil && ctx.Err() == nil {
+			m.logger.Warn("Mirror lost leadership", zap.Error(err))
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// lead waits to be elected, then mirrors until leadership is lost.
+func (m *Mirror) lead(ctx context.Context) error {
+	session, err := concurrency.NewSession(m.client, concurrency.WithContext(ctx), concurrency.WithTTL(schedulerSessionTTL))
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	election := concurrency.NewElection(session, mirrorPrefix+"leader")
+	if err := election.Campaign(ctx, m.id); err != nil {
+		return err
+	}
+	m.logger.Info("Elected mirror leader", zap.String("replica", m.id))
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-session.Done():
+			cancel()
+		case <-leadCtx.Done():
+		}
+	}()
+	m.mu.Lock()
+	m.status = MirrorStatus{State: mirrorStarting, Replica: m.id, Prefixes: m.prefixes, Destination: m.cfg.Endpoints, DestPrefix: m.cfg.DestPrefix}
+	m.applied, m.probes, m.lag = nil, nil, 0
+	m.mu.Unlock()
+	go m.report(leadCtx)
+	err = m.mirror(leadCtx)
+
+	resignCtx, cancelResign := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelResign()
+	_ = election.Resign(resignCtx)
+	return err
+}
+
+// mirror copies every key, then follows changes, starting over when the
+// watch is compacted or a resync is requested.
+func (m *Mirror) mirror(ctx context.Context) error {
+	for {
+		rev, err := m.sync(ctx)
+		if err == nil {
+			err = m.follow(ctx, rev)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, errResync) {
+			m.logger.Info("Resyncing mirror")
+			continue
+		}
+		m.fail(err)
+		if errors.Is(err, rpctypes.ErrCompacted) {
+			continue
+		}
+		select {
+		case <-time.After(mirrorRetry):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// fail records a failed sync or write.
+func (m *Mirror) fail(err error) {
+	m.logger.Warn("Mirror failed", zap.Error(err))
+	m.mu.Lock()
+	m.status.State, m.status.Error = mirrorFailing, err.Error()
+	m.mu.Unlock()
+}
+
+// destKey is where key is mirrored to.
+func (m *Mirror) destKey(key string) string {
+	return m.cfg.DestPrefix + key
+}
+
+// sync copies every key under the prefixes as of one revision and deletes
+// keys at the destination that are not in the source, returning the
+// revision copied.
+func (m *Mirror) sync(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	m.status.State = mirrorSyncing
+	m.mu.Unlock()
+	resp, err := m.client.Get(ctx, "/", clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	rev := resp.Header.Revision
+	var keys int64
+	for _, prefix := range m.prefixes {
+		source := make(map[string]bool)
+		var ops []clientv3.Op
+		var writeErr error
+		_, err := scanPrefixAt(ctx, m.client, prefix, rev, func(kv *mvccpb.KeyValue) {
+			if writeErr != nil || strings.HasPrefix(string(kv.Key), "/.") {
+				return
+			}
+			source[string(kv.Key)] = true
+			ops = append(ops, clientv3.OpPut(m.destKey(string(kv.Key)), string(kv.Value)))
+			if len(ops) == maxTxnOps {
+				writeErr = m.write(ctx, ops)
+				ops = ops[:0]
+			}
+		})
+		if err == nil {
+			err = writeErr
+		}
+		if err == nil {
+			err = m.write(ctx, ops)
+		}
+		if err != nil {
+			return 0, err
+		}
+		keys += int64(len(source))
+
+		ops = ops[:0]
+		_, err = scanPrefixAt(ctx, m.dest, m.destKey(prefix), 0, func(kv *mvccpb.KeyValue) {
+			key := strings.TrimPrefix(string(kv.Key), m.cfg.DestPrefix)
+			if writeErr != nil || source[key] || strings.HasPrefix(key, "/.") {
+				return
+			}
+			ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+			if len(ops) == maxTxnOps {
+				writeErr = m.write(ctx, ops)
+				ops = ops[:0]
+			}
+		}, clientv3.WithKeysOnly())
+		if err == nil {
+			err = writeErr
+		}
+		if err == nil {
+			err = m.write(ctx, ops)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	m.applied = make(map[string]int64, len(m.prefixes))
+	for _, prefix := range m.prefixes {
+		m.applied[prefix] = rev
+	}
+	m.status.State, m.status.Error = mirrorMirroring, ""
+	m.status.ResyncedAt = &now
+	m.status.SyncedKeys, m.status.Applied = keys, 0
+	m.caughtUp()
+	m.mu.Unlock()
+	m.logger.Info("Mirror synced", zap.Int64("keys", keys), zap.Int64("revision", rev))
+	return rev, nil
+}
+
+// write applies ops to the destination in one transaction.
+func (m *Mirror) write(ctx context.Context, ops []clientv3.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, mirrorWriteTimeout)
+	defer cancel()
+	_, err := m.dest.Txn(ctx).Then(ops...).Commit()
+	return err
+}
+
+// mirrorResponse is a watch response for one of the prefixes.
+type mirrorResponse struct {
+	prefix string
+	resp   clientv3.WatchResponse
+}
+
+// follow applies changes after rev until the watch fails or a resync is
+// requested. Failed writes are retried, so changes are never skipped.
+func (m *Mirror) follow(ctx context.Context, rev int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	responses := make(chan mirrorResponse)
+	failed := make(chan error, len(m.prefixes)+1)
+	for _, prefix := range m.prefixes {
+		go func(prefix string) {
+			for wresp := range m.client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1)) {
+				if err := wresp.Err(); err != nil {
+					failed <- err
+					return
+				}
+				select {
+				case responses <- mirrorResponse{prefix: prefix, resp: wresp}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			failed <- ctx.Err()
+		}(prefix)
+	}
+	go func() {
+		for wresp := range m.client.Watch(ctx, mirrorPrefix+"resync", clientv3.WithRev(rev+1)) {
+			if len(wresp.Events) > 0 {
+				failed <- errResync
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case r := <-responses:
+			if err := m.apply(ctx, r); err != nil {
+				return err
+			}
+		case err := <-failed:
+			return err
+		}
+	}
+}
+
+// apply writes a watch response's changes to the destination, retrying
+// until they are written, and advances the prefix's applied revision.
+func (m *Mirror) apply(ctx context.Context, r mirrorResponse) error {
+	var ops []clientv3.Op
+	for _, ev := range r.resp.Events {
+		key := string(ev.Kv.Key)
+		if strings.HasPrefix(key, "/.") {
+			continue
+		}
+		if ev.Type == clientv3.EventTypeDelete {
+			ops = append(ops, clientv3.OpDelete(m.destKey(key)))
+		} else {
+			ops = append(ops, clientv3.OpPut(m.destKey(key), string(ev.Kv.Value)))
+		}
+	}
+	for start := 0; start < len(ops); {
+		end := start + maxTxnOps
+		if end > len(ops) {
+			end = len(ops)
+		}
+		if err := m.write(ctx, ops[start:end]); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			m.fail(err)
+			select {
+			case <-time.After(mirrorRetry):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		start = end
+	}
+
+	// A progress notification means everything up to its revision has been
+	// sent; otherwise only up to the last event is known to be.
+	rev := r.resp.Header.Revision
+	if n := len(r.resp.Events); n > 0 {
+		rev = r.resp.Events[n-1].Kv.ModRevision
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rev > m.applied[r.prefix] {
+		m.applied[r.prefix] = rev
+	}
+	m.status.State, m.status.Error = mirrorMirroring, ""
+	m.status.Applied += int64(len(ops))
+	m.caughtUp()
+	return nil
+}
+
+// caughtUp advances the applied revision to the lowest of the prefixes'
+// and resolves the probes the destination has caught up with. m.mu must
+// be held.
+func (m *Mirror) caughtUp() {
+	var applied int64
+	for _, rev := range m.applied {
+		if applied == 0 || rev < applied {
+			applied = rev
+		}
+	}
+	m.status.AppliedRevision = applied
+	for len(m.probes) > 0 && m.probes[0].rev <= applied {
+		at := m.probes[0].at
+		m.status.SyncedAt = &at
+		m.lag = time.Since(at)
+		m.probes = m.probes[1:]
+	}
+}
+
+// report saves the status every mirrorReportInterval. Each time it reads
+// the source revision and asks for watch progress, so the applied revision
+// advances even when nothing under the prefixes changes. The lag is how
+// long the destination took to reach the last revision read, or how long
+// it has been behind one.
+func (m *Mirror) report(ctx context.Context) {
+	ticker := time.NewTicker(mirrorReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		m.mu.Lock()
+		if m.applied != nil {
+			m.status.LagSeconds = m.lag.Seconds()
+			if len(m.probes) > 0 {
+				m.status.LagSeconds = time.Since(m.probes[0].at).Seconds()
+			}
+		}
+		m.status.UpdatedAt = time.Now()
+		data, err := json.Marshal(m.status)
+		m.mu.Unlock()
+		if err == nil {
+			if _, err := m.client.Put(ctx, mirrorPrefix+"status", string(data)); err != nil && ctx.Err() == nil {
+				m.logger.Warn("Cannot save mirror status", zap.Error(err))
+			}
+		}
+
+		if resp, err := m.client.Get(ctx, "/", clientv3.WithCountOnly()); err == nil {
+			m.mu.Lock()
+			m.probes = append(m.probes, mirrorProbe{rev: resp.Header.Revision, at: time.Now()})
+			m.status.SourceRevision = resp.Header.Revision
+			m.mu.Unlock()
+			_ = m.client.RequestProgress(ctx)
+		}
+	}
+}
+
+// Status returns the status the mirroring replica last saved, with the lag
+// as of now.
+func (m *Mirror) Status(ctx context.Context) (MirrorStatus, error) {
+	resp, err := m.client.Get(ctx, mirrorPrefix+"status")
+	if err != nil {
+		return MirrorStatus{}, err
+	}
+	if len(resp.Kvs) == 0 {
+		return MirrorStatus{State: mirrorStarting, Prefixes: m.prefixes, Destination: m.cfg.Endpoints, DestPrefix: m.cfg.DestPrefix}, nil
+	}
+	var status MirrorStatus
+	if err := json.Unmarshal(resp.Kvs[0].Value, &status); err != nil {
+		return status, err
+	}
+	// The mirroring replica has stopped reporting; the lag keeps growing.
+	if time.Since(status.UpdatedAt) > 3*mirrorReportInterval && status.SyncedAt != nil {
+		status.LagSeconds = time.Since(*status.SyncedAt).Seconds()
+	}
+	return status, nil
+}
+
+// Resync asks the mirroring replica to copy every key again.
+func (m *Mirror) Resync(ctx context.Context) error {
+	_, err := m.client.Put(ctx, mirrorPrefix+"resync", time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// MirrorStatusHandler reports the mirror's state and lag.
+func MirrorStatusHandler(mirror *Mirror, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if mirror == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Mirroring is not enabled"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		status, err := mirror.Status(ctx)
+		if err != nil {
+			logger.Error("Error reading mirror status from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+// MirrorResyncHandler asks the mirror to copy every key again.
+func MirrorResyncHandler(mirror *Mirror, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if mirror == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Mirroring is not enabled"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		if err := mirror.Resync(ctx); err != nil {
+			logger.Error("Error requesting mirror resync", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"status": "Resync requested"})
+	}
+}
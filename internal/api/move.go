@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// maxCollisionsReported caps the colliding keys listed in a move conflict.
+const maxCollisionsReported = 20
+
+// collisions returns the destination keys that already exist for kvs.
+func collisions(ctx context.Context, client clientv3.KV, kvs []*mvccpb.KeyValue, source, destination string) ([]string, error) {
+	resp, err := client.Get(ctx, destination, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		existing[string(kv.Key)] = true
+	}
+	var found []string
+	for _, kv := range kvs {
+		if dest := rebase(string(kv.Key), source, destination); existing[dest] {
+			found = append(found, dest)
+		}
+	}
+	return found, nil
+}
+
+// moveKeys renames kvs from under source to under destination with put+delete
+// transactions of up to maxTxnOps/2 keys. Each transaction requires the
+// source keys to be unchanged since they were read and, unless overwrite is
+// set, the destination keys to be absent.
+func moveKeys(ctx context.Context, client clientv3.KV, kvs []*mvccpb.KeyValue, source, destination string, overwrite bool) ([]AuditChange, error) {
+	var changes []AuditChange
+	for start := 0; start < len(kvs); start += maxTxnOps / 2 {
+		end := start + maxTxnOps/2
+		if end > len(kvs) {
+			end = len(kvs)
+		}
+		var cmps []clientv3.Cmp
+		var ops []clientv3.Op
+		for _, kv := range kvs[start:end] {
+			dest := rebase(string(kv.Key), source, destination)
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision))
+			if !overwrite {
+				cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(dest), "=", 0))
+			}
+			ops = append(ops,
+				clientv3.OpPut(dest, string(kv.Value), clientv3.WithPrevKV()),
+				clientv3.OpDelete(string(kv.Key), clientv3.WithPrevKV()),
+			)
+		}
+		txn, err := guardedTxn(client.Txn(ctx), cmps, ops...)
+		if err != nil {
+			return changes, err
+		}
+		for i := 0; i < len(txn.Responses); i += 2 {
+			dest := rebase(string(kvs[start+i/2].Key), source, destination)
+			changes = append(changes, putChange(dest, txn.Responses[i].GetResponsePut().PrevKv, txn.Header.Revision))
+			changes = append(changes, deleteChanges(txn.Responses[i+1].GetResponseDeleteRange().PrevKvs)...)
+		}
+	}
+	return changes, nil
+}
+
+// MoveHandler renames a key or subtree. Destination collisions are checked
+// before anything is written; trees larger than one transaction are moved in
+// chunks, so a concurrent change part way through leaves a partial move that
+// can be finished by repeating the request.
+func MoveHandler(client clientv3.KV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CopyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if err := validateCopy(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := longRequestContext(c, 30*time.Second)
+		defer cancel()
+
+		kvs, _, err := readSource(ctx, client, req.Source)
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if len(kvs) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Source not found"})
+			return
+		}
+
+		if !req.Overwrite {
+			found, err := collisions(ctx, client, kvs, req.Source, req.Destination)
+			if err != nil {
+				logger.Error("Error fetching keys from etcd", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+				return
+			}
+			if len(found) > 0 {
+				if len(found) > maxCollisionsReported {
+					found = found[:maxCollisionsReported]
+				}
+				c.JSON(http.StatusConflict, gin.H{"error": "Destination keys already exist, set overwrite to replace them", "collisions": found})
+				return
+			}
+		}
+
+		changes, err := moveKeys(ctx, client, kvs, req.Source, req.Destination, req.Overwrite)
+		audit.Record(ctx, requestActor(c), "move", changes)
+		moved := len(changes) / 2
+		if errors.Is(err, errConflict) {
+			conflictResponse(c, err, gin.H{"error": "Keys were modified during the move, retry", "moved": moved})
+			return
+		}
+		if err != nil {
+			logger.Error("Error writing keys to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error", "moved": moved})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"moved": moved})
+	}
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// mutationSteps are the steps a mutation rule can apply to a value.
+var mutationSteps = map[string]func([]byte) ([]byte, error){
+	// strip-comments removes // and /* */ comments outside strings, turning
+	// JSON with comments into JSON.
+	"strip-comments": stripComments,
+	// canonical-json re-encodes JSON compactly with object keys sorted.
+	"canonical-json": canonicalJSON,
+	// lowercase-keys lowercases the keys of JSON objects, re-encoding the
+	// value as canonical-json does.
+	"lowercase-keys": lowercaseJSONKeys,
+	// trim-space removes leading and trailing whitespace.
+	"trim-space": func(v []byte) ([]byte, error) { return bytes.TrimSpace(v), nil },
+}
+
+// MutationRule applies Steps, in order, to values written under Prefix.
+type MutationRule struct {
+	Prefix string
+	Steps  []string
+}
+
+// ParseMutationRules parses "/prefix/=step;step" entries.
+func ParseMutationRules(entries []string) ([]MutationRule, error) {
+	var rules []MutationRule
+	for _, entry := range entries {
+		prefix, steps, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") || steps == "" {
+			return nil, errors.New("invalid mutation rule " + strconv.Quote(entry) + ", expected /prefix/=step;step")
+		}
+		rule := MutationRule{Prefix: prefix, Steps: strings.Split(steps, ";")}
+		for _, step := range rule.Steps {
+			if mutationSteps[step] == nil {
+				return nil, errors.New("unknown mutation step " + strconv.Quote(step) + ", expected strip-comments, canonical-json, lowercase-keys or trim-space")
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// stripComments removes // and /* */ comments outside double-quoted strings.
+func stripComments(v []byte) ([]byte, error) {
+	out := make([]byte, 0, len(v))
+	inString := false
+	for i := 0; i < len(v); i++ {
+		switch {
+		case inString:
+			out = append(out, v[i])
+			if v[i] == '\\' && i+1 < len(v) {
+				i++
+				out = append(out, v[i])
+			} else if v[i] == '"' {
+				inString = false
+			}
+		case v[i] == '"':
+			inString = true
+			out = append(out, v[i])
+		case v[i] == '/' && i+1 < len(v) && v[i+1] == '/':
+			for i < len(v) && v[i] != '\n' {
+				i++
+			}
+			if i < len(v) {
+				out = append(out, '\n')
+			}
+		case v[i] == '/' && i+1 < len(v) && v[i+1] == '*':
+			end := bytes.Index(v[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			i += end + 3
+		default:
+			out = append(out, v[i])
+		}
+	}
+	return out, nil
+}
+
+// decodeJSON decodes a single JSON value, keeping numbers exact.
+func decodeJSON(v []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(v))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.New("not valid JSON")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("not valid JSON")
+	}
+	return doc, nil
+}
+
+// encodeJSON encodes doc compactly, with object keys sorted and without
+// escaping HTML.
+func encodeJSON(doc interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func canonicalJSON(v []byte) ([]byte, error) {
+	doc, err := decodeJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return encodeJSON(doc)
+}
+
+func lowercaseJSONKeys(v []byte) ([]byte, error) {
+	doc, err := decodeJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	var lower func(doc interface{}) (interface{}, error)
+	lower = func(doc interface{}) (interface{}, error) {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			out := make(map[string]interface{}, len(d))
+			for k, v := range d {
+				lk := strings.ToLower(k)
+				if _, ok := out[lk]; ok {
+					return nil, errors.New("keys " + strconv.Quote(lk) + " collide when lowercased")
+				}
+				lv, err := lower(v)
+				if err != nil {
+					return nil, err
+				}
+				out[lk] = lv
+			}
+			return out, nil
+		case []interface{}:
+			for i, v := range d {
+				lv, err := lower(v)
+				if err != nil {
+					return nil, err
+				}
+				d[i] = lv
+			}
+		}
+		return doc, nil
+	}
+	if doc, err = lower(doc); err != nil {
+		return nil, err
+	}
+	return encodeJSON(doc)
+}
+
+// errMutationFailed is returned for writes whose value a step could not
+// apply to.
+var errMutationFailed = errors.New("value cannot be normalized")
+
+// mutatingKV is a KV rewriting values before they are stored.
+type mutatingKV struct {
+	clientv3.KV
+	rules []MutationRule
+}
+
+// NewMutatingKV wraps kv to apply the steps of every rule whose prefix a
+// written key is under to its value, in the order the rules are given.
+// Writes whose value a step cannot apply to, such as canonical-json to a
+// value that is not JSON, are refused with 422. Empty values are kept.
+func NewMutatingKV(kv clientv3.KV, rules []MutationRule) clientv3.KV {
+	return &mutatingKV{KV: kv, rules: rules}
+}
+
+// mutate returns op with its value rewritten, or the ops of a transaction.
+func (kv *mutatingKV) mutate(ctx context.Context, op clientv3.Op) (clientv3.Op, error) {
+	switch {
+	case op.IsPut():
+		key, v := op.KeyBytes(), op.ValueBytes()
+		if len(v) == 0 {
+			// Empty values, including those of puts keeping the value, are
+			// stored as is.
+			return op, nil
+		}
+		for _, rule := range kv.rules {
+			if !bytes.HasPrefix(key, []byte(rule.Prefix)) {
+				continue
+			}
+			for _, step := range rule.Steps {
+				var err error
+				if v, err = mutationSteps[step](v); err != nil {
+					return op, refuse(ctx, http.StatusUnprocessableEntity, "Cannot apply "+step+" to the value: "+err.Error(), errMutationFailed)
+				}
+			}
+		}
+		op.WithValueBytes(v)
+	case op.IsTxn():
+		cmps, thenOps, elseOps := op.Txn()
+		thenOps, err := kv.mutateAll(ctx, thenOps)
+		if err != nil {
+			return op, err
+		}
+		if elseOps, err = kv.mutateAll(ctx, elseOps); err != nil {
+			return op, err
+		}
+		return clientv3.OpTxn(cmps, thenOps, elseOps), nil
+	}
+	return op, nil
+}
+
+func (kv *mutatingKV) mutateAll(ctx context.Context, ops []clientv3.Op) ([]clientv3.Op, error) {
+	out := make([]clientv3.Op, len(ops))
+	for i, op := range ops {
+		var err error
+		if out[i], err = kv.mutate(ctx, op); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (kv *mutatingKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	resp, err := kv.Do(ctx, clientv3.OpPut(key, val, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Put(), nil
+}
+
+func (kv *mutatingKV) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	op, err := kv.mutate(ctx, op)
+	if err != nil {
+		return clientv3.OpResponse{}, err
+	}
+	return kv.KV.Do(ctx, op)
+}
+
+func (kv *mutatingKV) Txn(ctx context.Context) clientv3.Txn {
+	return &mutatingTxn{Txn: kv.KV.Txn(ctx), ctx: ctx, kv: kv}
+}
+
+// mutatingTxn is a transaction whose ops are rewritten as they are added.
+// A failure to rewrite one is returned by Commit.
+type mutatingTxn struct {
+	clientv3.Txn
+	ctx context.Context
+	kv  *mutatingKV
+	err error
+}
+
+func (txn *mutatingTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	txn.Txn = txn.Txn.If(cs...)
+	return txn
+}
+
+func (txn *mutatingTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	ops, err := txn.kv.mutateAll(txn.ctx, ops)
+	if err != nil {
+		txn.err = err
+		return txn
+	}
+	txn.Txn = txn.Txn.Then(ops...)
+	return txn
+}
+
+func (txn *mutatingTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	ops, err := txn.kv.mutateAll(txn.ctx, ops)
+	if err != nil {
+		txn.err = err
+		return txn
+	}
+	txn.Txn = txn.Txn.Else(ops...)
+	return txn
+}
+
+func (txn *mutatingTxn) Commit() (*clientv3.TxnResponse, error) {
+	if txn.err != nil {
+		return nil, txn.err
+	}
+	return txn.Txn.Commit()
+}
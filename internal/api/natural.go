@@ -0,0 +1,61 @@
+package api
+
+import "strings"
+
+// naturalLess reports whether key a orders before key b naturally: segment
+// by segment, with runs of digits within a segment compared as numbers, so
+// /nodes/node-2 comes before /nodes/node-10.
+func naturalLess(a, b string) bool {
+	for {
+		segA, restA, moreA := strings.Cut(a, "/")
+		segB, restB, moreB := strings.Cut(b, "/")
+		if segA != segB {
+			return naturalSegmentLess(segA, segB)
+		}
+		if !moreA || !moreB {
+			return !moreA && moreB
+		}
+		a, b = restA, restB
+	}
+}
+
+// naturalSegmentLess compares a and b naturally. Numbers equal but for
+// leading zeros order the shorter first, and otherwise bytes compare as
+// etcd compares them.
+func naturalSegmentLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			numA, numB := digitRun(a), digitRun(b)
+			trimA, trimB := strings.TrimLeft(numA, "0"), strings.TrimLeft(numB, "0")
+			if len(trimA) != len(trimB) {
+				return len(trimA) < len(trimB)
+			}
+			if trimA != trimB {
+				return trimA < trimB
+			}
+			if len(numA) != len(numB) {
+				return len(numA) < len(numB)
+			}
+			a, b = a[len(numA):], b[len(numB):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// digitRun returns the digits s starts with.
+func digitRun(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i]
+}
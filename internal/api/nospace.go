@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// NOSPACE remediation steps, in the order they should be run.
+const (
+	noSpaceCompact = "compact"
+	noSpaceDefrag  = "defrag"
+	noSpaceDisarm  = "disarm"
+)
+
+// noSpaceTimeout bounds a remediation step; defragmenting a large member
+// can take minutes.
+const noSpaceTimeout = 30 * time.Minute
+
+// memberStatuses asks every endpoint the client knows for its member's
+// status, followers first and the leader last, the order to defragment
+// them in so the leader only stalls once the rest are done.
+func memberStatuses(ctx context.Context, client *clientv3.Client) []MemberStatus {
+	endpoints := client.Endpoints()
+	members := make([]MemberStatus, len(endpoints))
+	for i, ep := range endpoints {
+		members[i] = memberStatus(ctx, client, ep)
+	}
+	sort.SliceStable(members, func(i, j int) bool { return !members[i].Leader && members[j].Leader })
+	return members
+}
+
+func memberStatus(ctx context.Context, client *clientv3.Client, ep string) MemberStatus {
+	m := MemberStatus{Endpoint: ep}
+	status, err := client.Status(ctx, ep)
+	if err != nil {
+		m.Error = err.Error()
+		return m
+	}
+	m.ID = strconv.FormatUint(status.Header.MemberId, 16)
+	m.Leader = status.Leader == status.Header.MemberId
+	// status.Errors only repeats the member's alarms, which are listed on
+	// their own, so it stays healthy while NOSPACE is raised.
+	m.DBSize, m.DBSizeInUse, m.RaftIndex = status.DbSize, status.DbSizeInUse, status.RaftIndex
+	return m
+}
+
+// noSpaceRun runs the steps of a NOSPACE remediation, logging each action
+// both to the gateway log and for the response.
+type noSpaceRun struct {
+	client *clientv3.Client
+	logger *zap.Logger
+	actor  string
+	logs   []JobLog
+}
+
+func (r *noSpaceRun) logf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	r.logs = append(r.logs, JobLog{Time: time.Now(), Message: message})
+	r.logger.Warn("NOSPACE remediation", zap.String("action", message), zap.String("actor", r.actor))
+}
+
+func noSpaceStatus(ctx context.Context, client *clientv3.Client) (NoSpaceStatus, error) {
+	status := NoSpaceStatus{Alarms: []Alarm{}}
+	alarms, err := client.AlarmList(ctx)
+	if err != nil {
+		return status, err
+	}
+	for _, a := range alarms.Alarms {
+		status.Alarms = append(status.Alarms, Alarm{MemberID: strconv.FormatUint(a.MemberID, 16), Alarm: a.Alarm.String()})
+	}
+	resp, err := client.Get(ctx, "/", clientv3.WithCountOnly())
+	if err != nil {
+		return status, err
+	}
+	status.Revision = resp.Header.Revision
+	status.Members = memberStatuses(ctx, client)
+
+	order := make([]string, len(status.Members))
+	for i, m := range status.Members {
+		order[i] = m.Endpoint
+	}
+	var noSpace []string
+	for _, a := range status.Alarms {
+		if a.Alarm == pb.AlarmType_NOSPACE.String() {
+			noSpace = append(noSpace, a.MemberID)
+		}
+	}
+	disarm := "No NOSPACE alarm is raised"
+	if len(noSpace) > 0 {
+		disarm = "Disarm the NOSPACE alarm on members " + strings.Join(noSpace, ", ") + " so writes are accepted again"
+	}
+	status.Steps = []NoSpaceStep{
+		{Name: noSpaceCompact, Description: fmt.Sprintf("Compact history up to revision %d, discarding every older revision", status.Revision)},
+		{Name: noSpaceDefrag, Description: "Defragment one member at a time, leader last, to release the compacted space: " + strings.Join(order, ", ")},
+		{Name: noSpaceDisarm, Description: disarm},
+	}
+	return status, nil
+}
+
+func (r *noSpaceRun) compact(ctx context.Context) error {
+	resp, err := r.client.Get(ctx, "/", clientv3.WithCountOnly())
+	if err != nil {
+		return err
+	}
+	rev := resp.Header.Revision
+	r.logf("Compacting to revision %d", rev)
+	_, err = r.client.Compact(ctx, rev, clientv3.WithCompactPhysical())
+	if errors.Is(err, rpctypes.ErrCompacted) {
+		r.logf("Already compacted to revision %d", rev)
+		return nil
+	}
+	if err != nil {
+		r.logf("Compaction failed: %v", err)
+		return err
+	}
+	r.logf("Compacted to revision %d", rev)
+	return nil
+}
+
+func (r *noSpaceRun) defrag(ctx context.Context) error {
+	members := memberStatuses(ctx, r.client)
+	for _, m := range members {
+		if m.Error != "" {
+			r.logf("Member at %s is unhealthy, not defragmenting: %s", m.Endpoint, m.Error)
+			return errors.New("member at " + m.Endpoint + " is unhealthy")
+		}
+	}
+	for _, m := range members {
+		r.logf("Defragmenting member %s at %s, %d of %d bytes in use", m.ID, m.Endpoint, m.DBSizeInUse, m.DBSize)
+		if _, err := r.client.Defragment(ctx, m.Endpoint); err != nil {
+			r.logf("Defragmenting member %s failed: %v", m.ID, err)
+			return fmt.Errorf("defragment %s: %w", m.Endpoint, err)
+		}
+		after := memberStatus(ctx, r.client, m.Endpoint)
+		if after.Error != "" {
+			r.logf("Member %s is unhealthy after defragmenting, stopping: %s", m.ID, after.Error)
+			return errors.New("member at " + m.Endpoint + " is unhealthy")
+		}
+		r.logf("Defragmented member %s from %d to %d bytes", m.ID, m.DBSize, after.DBSize)
+	}
+	return nil
+}
+
+func (r *noSpaceRun) disarm(ctx context.Context) error {
+	alarms, err := r.client.AlarmList(ctx)
+	if err != nil {
+		return err
+	}
+	disarmed := 0
+	for _, a := range alarms.Alarms {
+		if a.Alarm != pb.AlarmType_NOSPACE {
+			continue
+		}
+		member := strconv.FormatUint(a.MemberID, 16)
+		r.logf("Disarming NOSPACE alarm on member %s", member)
+		if _, err := r.client.AlarmDisarm(ctx, (*clientv3.AlarmMember)(a)); err != nil {
+			r.logf("Disarming NOSPACE alarm on member %s failed: %v", member, err)
+			return err
+		}
+		disarmed++
+	}
+	if disarmed == 0 {
+		r.logf("No NOSPACE alarm to disarm")
+	}
+	return nil
+}
+
+// NoSpaceHandler shows the cluster's alarms and storage, and the steps that
+// remediate a NOSPACE alarm with what each would do now.
+func NoSpaceHandler(client *clientv3.Client, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := longRequestContext(c, 10*time.Second)
+		defer cancel()
+		status, err := noSpaceStatus(ctx, client)
+		if err != nil {
+			logger.Error("Error fetching cluster status from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+// NoSpaceStepHandler runs one step of NOSPACE remediation once confirmed,
+// returning what it did. Steps are meant to be run in order, compact,
+// defrag then disarm, reviewing the cluster in between.
+func NoSpaceStepHandler(client *clientv3.Client, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		run := &noSpaceRun{client: client, logger: logger, actor: requestActor(c), logs: []JobLog{}}
+		steps := map[string]func(context.Context) error{
+			noSpaceCompact: run.compact,
+			noSpaceDefrag:  run.defrag,
+			noSpaceDisarm:  run.disarm,
+		}
+		step := c.Param("step")
+		fn, ok := steps[step]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown step, expected compact, defrag or disarm"})
+			return
+		}
+		var req NoSpaceStepRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if !req.Confirm {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Review the step with GET /admin/nospace, then confirm it"})
+			return
+		}
+
+		ctx, cancel := longRequestContext(c, noSpaceTimeout)
+		defer cancel()
+		if err := fn(ctx); err != nil {
+			logger.Error("NOSPACE remediation step failed", zap.String("step", step), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error", "step": step, "logs": run.logs})
+			return
+		}
+		status, err := noSpaceStatus(ctx, client)
+		if err != nil {
+			logger.Error("Error fetching cluster status from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error", "step": step, "logs": run.logs})
+			return
+		}
+		c.JSON(http.StatusOK, NoSpaceStepResult{Step: step, Logs: run.logs, Status: status})
+	}
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// notifyQuiet is how long a change is held before notifiers see it, so the
+// audit log has named the writer.
+const notifyQuiet = 2 * time.Second
+
+// notifyCursorPrefix holds the last revision each notifier was given, so it
+// resumes from there after a restart.
+const notifyCursorPrefix = "/.notify/"
+
+// KeyChange is a key being written or deleted, as reported to notifiers.
+type KeyChange struct {
+	Key      string
+	Revision int64
+	Created  bool
+	Deleted  bool
+	Before   []byte
+	After    []byte
+	// Actor is who made the write through the gateway, or empty when it
+	// was made some other way.
+	Actor string
+	Time  time.Time
+	// MissedFrom is set on a notice standing in for the changes under the
+	// prefix in Key that were compacted before they could be notified:
+	// those after MissedFrom up to Revision.
+	MissedFrom int64
+}
+
+// under reports whether change concerns prefix. A missed changes notice
+// concerns every prefix overlapping the one it names.
+func (change KeyChange) under(prefix string) bool {
+	if change.MissedFrom > 0 {
+		return strings.HasPrefix(prefix, change.Key) || strings.HasPrefix(change.Key, prefix)
+	}
+	return strings.HasPrefix(change.Key, prefix)
+}
+
+// changeFeed watches a set of prefixes for notifiers. Writes made through
+// the gateway are attributed to the audited actor. The revision last given
+// to the notifier is saved under its name, so changes made while no
+// gateway was running are notified once one starts.
+type changeFeed struct {
+	client   *clientv3.Client
+	logger   *zap.Logger
+	prefixes []string
+	cursor   string
+
+	mu     sync.Mutex
+	actors map[writeRef]noted
+}
+
+// writeRef identifies a write to a key. Audit entries carry no revision for
+// deletes, so deletes use revision zero.
+type writeRef struct {
+	key      string
+	revision int64
+}
+
+type noted struct {
+	actor string
+	at    time.Time
+}
+
+func newChangeFeed(client *clientv3.Client, audit *AuditLog, logger *zap.Logger, name string, prefixes []string) *changeFeed {
+	f := &changeFeed{client: client, logger: logger, prefixes: coveringPrefixes(prefixes), cursor: notifyCursorPrefix + name, actors: make(map[writeRef]noted)}
+	audit.Subscribe(f.noteActor)
+	return f
+}
+
+// coveringPrefixes drops prefixes nested in others, so no key is watched twice.
+func coveringPrefixes(prefixes []string) []string {
+	sorted := append([]string(nil), prefixes...)
+	sort.Strings(sorted)
+	var out []string
+	for _, prefix := range sorted {
+		if len(out) == 0 || !strings.HasPrefix(prefix, out[len(out)-1]) {
+			out = append(out, prefix)
+		}
+	}
+	return out
+}
+
+func (f *changeFeed) watched(key string) bool {
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *changeFeed) noteActor(entry AuditEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, change := range entry.Changes {
+		if f.watched(change.Key) {
+			f.actors[writeRef{change.Key, change.Revision}] = noted{entry.Actor, entry.Time}
+		}
+	}
+}
+
+// attribute fills in the actor of each change, and forgets actors noted
+// long enough ago that their change must have been missed.
+func (f *changeFeed) attribute(changes []KeyChange) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range changes {
+		ref := writeRef{changes[i].Key, changes[i].Revision}
+		if changes[i].Deleted {
+			ref.revision = 0
+		}
+		if n, ok := f.actors[ref]; ok {
+			changes[i].Actor = n.actor
+			delete(f.actors, ref)
+		}
+	}
+	for ref, n := range f.actors {
+		if time.Since(n.at) > time.Minute {
+			delete(f.actors, ref)
+		}
+	}
+}
+
+// run sends changes made after the saved revision, or after it starts if
+// there is none, to out until ctx is cancelled. The audit entry for a write
+// is recorded after it, so each change is held for notifyQuiet and then
+// attributed.
+func (f *changeFeed) run(ctx context.Context, out chan<- KeyChange) {
+	changes := make(chan KeyChange)
+	go f.watch(ctx, changes)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	var arriving []KeyChange
+	var saved int64
+	for {
+		select {
+		case change := <-changes:
+			arriving = append(arriving, change)
+		case now := <-tick.C:
+			ready := 0
+			for ready < len(arriving) && now.Sub(arriving[ready].Time) >= notifyQuiet {
+				ready++
+			}
+			f.attribute(arriving[:ready])
+			for _, change := range arriving[:ready] {
+				select {
+				case out <- change:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if ready > 0 && arriving[ready-1].Revision > saved {
+				saved = arriving[ready-1].Revision
+				if _, err := f.client.Put(ctx, f.cursor, strconv.FormatInt(saved, 10)); err != nil && ctx.Err() == nil {
+					f.logger.Warn("Cannot save change notification revision", zap.Error(err))
+				}
+			}
+			arriving = append(arriving[:0], arriving[ready:]...)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watch sends changes to out until ctx is cancelled, starting after the
+// saved revision. A lost watch resumes where it stopped; if that revision
+// has been compacted, a notice of the changes missed is sent in their place.
+func (f *changeFeed) watch(ctx context.Context, out chan<- KeyChange) {
+	var rev int64
+	for ctx.Err() == nil {
+		if rev == 0 {
+			resp, err := f.client.Get(ctx, f.cursor)
+			if err != nil {
+				if ctx.Err() == nil {
+					f.logger.Warn("Cannot start change notifications", zap.Error(err))
+					time.Sleep(5 * time.Second)
+				}
+				continue
+			}
+			rev = resp.Header.Revision
+			if len(resp.Kvs) > 0 {
+				if saved, err := strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64); err == nil && saved > 0 {
+					rev = saved
+				}
+			}
+		}
+		var err error
+		rev, err = f.follow(ctx, rev, out)
+		if ctx.Err() == nil {
+			f.logger.Warn("Change notification watch failed", zap.Error(err))
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// follow sends changes after rev until the watch fails, and returns the last
+// revision sent. If rev has been compacted, it sends a notice of the changes
+// missed and returns the last of them.
+func (f *changeFeed) follow(ctx context.Context, rev int64, out chan<- KeyChange) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := make(chan *clientv3.Event)
+	failed := make(chan clientv3.WatchResponse, len(f.prefixes))
+	for _, prefix := range f.prefixes {
+		go func(prefix string) {
+			opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithPrevKV(), clientv3.WithRev(rev + 1)}
+			for wresp := range f.client.Watch(ctx, prefix, opts...) {
+				if wresp.Err() != nil {
+					failed <- wresp
+					return
+				}
+				for _, ev := range wresp.Events {
+					select {
+					case events <- ev:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			// Closed because ctx was cancelled.
+			failed <- clientv3.WatchResponse{}
+		}(prefix)
+	}
+
+	for {
+		select {
+		case ev := <-events:
+			if strings.HasPrefix(string(ev.Kv.Key), notifyCursorPrefix) {
+				continue
+			}
+			change := KeyChange{
+				Key:      string(ev.Kv.Key),
+				Revision: ev.Kv.ModRevision,
+				Created:  ev.IsCreate(),
+				Deleted:  ev.Type == clientv3.EventTypeDelete,
+				After:    ev.Kv.Value,
+				Time:     time.Now(),
+			}
+			if ev.PrevKv != nil {
+				change.Before = ev.PrevKv.Value
+			}
+			select {
+			case out <- change:
+			case <-ctx.Done():
+				return rev, ctx.Err()
+			}
+			if change.Revision > rev {
+				rev = change.Revision
+			}
+		case wresp := <-failed:
+			err := wresp.Err()
+			if err == nil {
+				return rev, ctx.Err()
+			}
+			if !errors.Is(err, rpctypes.ErrCompacted) {
+				return rev, err
+			}
+			// Events of the compaction revision itself are still there.
+			missed := wresp.CompactRevision - 1
+			f.logger.Warn("Changes were compacted before they could be notified", zap.Int64("from", rev+1), zap.Int64("to", missed))
+			for _, prefix := range f.prefixes {
+				select {
+				case out <- KeyChange{Key: prefix, Revision: missed, MissedFrom: rev, Time: time.Now()}:
+				case <-ctx.Done():
+					return rev, ctx.Err()
+				}
+			}
+			return missed, err
+		}
+	}
+}
+
+// diffSnippet returns the changed lines between before and after, at most
+// maxLines of them, each cut to maxWidth bytes.
+func diffSnippet(before, after []byte, maxLines, maxWidth int) []string {
+	var lines []string
+	if len(before) == 0 {
+		for _, line := range strings.Split(string(after), "\n") {
+			lines = append(lines, "+"+line)
+		}
+	} else {
+		for _, line := range lineDiff(string(before), string(after)) {
+			if line[0] != ' ' {
+				lines = append(lines, line)
+			}
+		}
+	}
+	if len(lines) > maxLines {
+		lines = append(lines[:maxLines], "…")
+	}
+	for i, line := range lines {
+		if len(line) > maxWidth {
+			lines[i] = line[:maxWidth] + "…"
+		}
+	}
+	return lines
+}
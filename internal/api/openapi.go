@@ -0,0 +1,28 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed static/openapi.json
+var openAPISpec []byte
+
+//go:embed static/docs.html
+var swaggerUIPage []byte
+
+// OpenAPIHandler serves the OpenAPI 3 document describing the gateway's endpoints.
+func OpenAPIHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", openAPISpec)
+	}
+}
+
+// SwaggerUIHandler serves an interactive Swagger UI backed by /openapi.json.
+func SwaggerUIHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", swaggerUIPage)
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// planPrefix holds computed plans until they are applied or expire.
+const planPrefix = "/.plans/"
+
+// planTTL is how long a plan can wait to be applied.
+const planTTL = time.Hour
+
+// planSeq disambiguates plans created in the same nanosecond.
+var planSeq uint32
+
+// reservedPrefix reports whether prefix is the root or one of the gateway's
+// own /.* prefixes, which desired-state operations must not touch: planning
+// the root would delete the gateway's state along with everything else.
+func reservedPrefix(prefix string) bool {
+	return prefix == "/" || strings.HasPrefix(prefix, "/.")
+}
+
+// reservedWrite reports whether writing key, or with recursive deleting
+// every key under it, would touch the root or the gateway's own keys.
+func reservedWrite(key string, recursive bool) bool {
+	if recursive {
+		return reservedRange([]byte(key), []byte(clientv3.GetPrefixRangeEnd(key)))
+	}
+	return reservedPrefix(key)
+}
+
+// computePlan diffs desired against the keys currently under its prefix.
+func computePlan(ctx context.Context, client clientv3.KV, desired DesiredState) (Plan, error) {
+	plan := Plan{Prefix: desired.Prefix, Changes: []PlanChange{}}
+	seen := make(map[string]bool)
+	rev, err := scanPrefix(ctx, client, desired.Prefix, func(kv *mvccpb.KeyValue) {
+		rel := strings.TrimPrefix(string(kv.Key), desired.Prefix)
+		before := string(kv.Value)
+		change := PlanChange{Key: string(kv.Key), Before: &before, ModRevision: kv.ModRevision}
+		after, ok := desired.Values[rel]
+		switch {
+		case !ok:
+			change.Action = "delete"
+		case after == before:
+			seen[rel] = true
+			plan.Unchanged++
+			return
+		default:
+			change.Action = "update"
+			change.After = &after
+		}
+		seen[rel] = true
+		plan.Changes = append(plan.Changes, change)
+	})
+	if err != nil {
+		return plan, err
+	}
+	plan.Revision = rev
+	for rel, value := range desired.Values {
+		if !seen[rel] {
+			after := value
+			plan.Changes = append(plan.Changes, PlanChange{Key: desired.Prefix + rel, Action: "create", After: &after})
+		}
+	}
+	sort.Slice(plan.Changes, func(i, j int) bool { return plan.Changes[i].Key < plan.Changes[j].Key })
+	return plan, nil
+}
+
+// executePlan makes plan's changes in one transaction, guarded by each key's
+// planned revision and by cmps, and runs extra alongside. It returns
+// a ConflictError if any guard fails.
+func executePlan(ctx context.Context, client clientv3.KV, plan Plan, cmps []clientv3.Cmp, extra ...clientv3.Op) ([]AuditChange, int64, error) {
+	var ops []clientv3.Op
+	for _, change := range plan.Changes {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(change.Key), "=", change.ModRevision))
+		if change.Action == "delete" {
+			ops = append(ops, clientv3.OpDelete(change.Key, clientv3.WithPrevKV()))
+		} else {
+			ops = append(ops, clientv3.OpPut(change.Key, *change.After, clientv3.WithPrevKV()))
+		}
+	}
+	txn, err := guardedTxn(client.Txn(ctx), cmps, append(ops, extra...)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	var changes []AuditChange
+	for i, change := range plan.Changes {
+		r := txn.Responses[i]
+		if change.Action == "delete" {
+			changes = append(changes, deleteChanges(r.GetResponseDeleteRange().PrevKvs)...)
+		} else {
+			changes = append(changes, putChange(change.Key, r.GetResponsePut().PrevKv, txn.Header.Revision))
+		}
+	}
+	return changes, txn.Header.Revision, nil
+}
+
+// PlanHandler computes the create, update and delete set that brings a
+// prefix to the posted desired state and stores it for POST /apply/:id.
+func PlanHandler(client *clientv3.Client, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var desired DesiredState
+		if err := c.ShouldBindJSON(&desired); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if !strings.HasPrefix(desired.Prefix, "/") || !strings.HasSuffix(desired.Prefix, "/") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Prefix must start and end with /"})
+			return
+		}
+		if reservedPrefix(desired.Prefix) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Prefix must not be the root or a gateway prefix"})
+			return
+		}
+		for rel := range desired.Values {
+			if rel == "" || strings.HasPrefix(rel, "/") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Keys must be relative to the prefix"})
+				return
+			}
+		}
+
+		ctx, cancel := longRequestContext(c, 30*time.Second)
+		defer cancel()
+
+		plan, err := computePlan(ctx, client, desired)
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		// Applying also deletes the plan record in the same transaction.
+		if len(plan.Changes) > maxTxnOps-1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Plans are limited to %d changes", maxTxnOps-1), "changes": len(plan.Changes)})
+			return
+		}
+
+		now := time.Now()
+		plan.ID = fmt.Sprintf("%019d-%04x", now.UnixNano(), uint16(atomic.AddUint32(&planSeq, 1)))
+		plan.Actor = requestActor(c)
+		plan.ExpiresAt = now.Add(planTTL)
+		data, err := json.Marshal(plan)
+		if err != nil {
+			logger.Error("Error encoding plan", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		lease, err := client.Grant(ctx, int64(planTTL/time.Second))
+		if err == nil {
+			_, err = client.Put(ctx, planPrefix+plan.ID, string(data), clientv3.WithLease(lease.ID))
+		}
+		if err != nil {
+			logger.Error("Error writing plan to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusCreated, plan)
+	}
+}
+
+// ApplyPlanHandler executes a stored plan in one transaction, provided none
+// of the keys it changes has been modified since it was computed. A plan
+// can only be applied once.
+func ApplyPlanHandler(client clientv3.KV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		planKey := planPrefix + c.Param("id")
+		resp, err := client.Get(ctx, planKey)
+		if err != nil {
+			logger.Error("Error fetching plan from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if len(resp.Kvs) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found or expired"})
+			return
+		}
+		var plan Plan
+		if err := json.Unmarshal(resp.Kvs[0].Value, &plan); err != nil {
+			logger.Error("Error decoding plan", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		changes, rev, err := executePlan(ctx, client, plan,
+			[]clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(planKey), "=", resp.Kvs[0].ModRevision)},
+			clientv3.OpDelete(planKey))
+		if errors.Is(err, errConflict) {
+			conflictResponse(c, err, gin.H{"error": "Keys have changed since the plan was computed"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error writing keys to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		audit.Record(ctx, requestActor(c), "plan:"+plan.ID, changes)
+		c.JSON(http.StatusOK, gin.H{"applied": len(plan.Changes), "revision": rev})
+	}
+}
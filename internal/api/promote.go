@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// errTooManyKeys is returned when a promotion would not fit in one transaction.
+var errTooManyKeys = fmt.Errorf("Promotions are limited to %d keys", maxTxnOps)
+
+// EnvironmentStore is the client and audit log one environment is served from.
+type EnvironmentStore struct {
+	Client *clientv3.Client
+	Audit  *AuditLog
+}
+
+// getKeys reads keys in a single transaction, so at one revision.
+func getKeys(ctx context.Context, client clientv3.KV, keys []string) (map[string]*mvccpb.KeyValue, error) {
+	ops := make([]clientv3.Op, len(keys))
+	for i, key := range keys {
+		ops[i] = clientv3.OpGet(key)
+	}
+	txn, err := client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return nil, err
+	}
+	kvs := make(map[string]*mvccpb.KeyValue, len(keys))
+	for _, r := range txn.Responses {
+		for _, kv := range r.GetResponseRange().Kvs {
+			kvs[string(kv.Key)] = kv
+		}
+	}
+	return kvs, nil
+}
+
+// planPromotion diffs the selected keys between from and to, also returning
+// the destination keys it read. The token fingerprints the revision of every
+// key on both sides, so it only matches a later plan if neither side has
+// changed.
+func planPromotion(ctx context.Context, from, to *clientv3.Client, req PromoteRequest) (PromotePlan, map[string]*mvccpb.KeyValue, error) {
+	keySet := make(map[string]bool)
+	for _, entry := range req.Keys {
+		if !strings.HasSuffix(entry, "/") {
+			keySet[entry] = true
+			continue
+		}
+		kvs, _, err := readSource(ctx, from, entry)
+		if err != nil {
+			return PromotePlan{}, nil, err
+		}
+		for _, kv := range kvs {
+			keySet[string(kv.Key)] = true
+		}
+	}
+	if len(keySet) > maxTxnOps {
+		return PromotePlan{}, nil, errTooManyKeys
+	}
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	source, err := getKeys(ctx, from, keys)
+	if err != nil {
+		return PromotePlan{}, nil, err
+	}
+	destination, err := getKeys(ctx, to, keys)
+	if err != nil {
+		return PromotePlan{}, nil, err
+	}
+
+	plan := PromotePlan{From: req.From, To: req.To, FromCluster: req.FromCluster, ToCluster: req.ToCluster, Changes: make([]PromoteChange, 0, len(keys))}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\n", req.From, req.To, req.FromCluster, req.ToCluster)
+	for _, key := range keys {
+		change := PromoteChange{Key: key}
+		src, dst := source[key], destination[key]
+		var srcRev, dstRev int64
+		if src != nil {
+			after := string(src.Value)
+			change.After, srcRev = &after, src.ModRevision
+		}
+		if dst != nil {
+			before := string(dst.Value)
+			change.Before, dstRev = &before, dst.ModRevision
+		}
+		switch {
+		case src == nil && dst == nil:
+			continue
+		case src == nil:
+			change.Action = "delete"
+		case dst == nil:
+			change.Action = "create"
+		case *change.Before == *change.After:
+			change.Action = "unchanged"
+		default:
+			change.Action = "update"
+		}
+		plan.Changes = append(plan.Changes, change)
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", key, srcRev, dstRev)
+	}
+	plan.Token = hex.EncodeToString(h.Sum(nil)[:16])
+	return plan, destination, nil
+}
+
+// applyPromotion writes plan to the destination in one transaction, guarded
+// by the revisions of the destination keys it was planned against.
+func applyPromotion(ctx context.Context, to *clientv3.Client, plan PromotePlan, destination map[string]*mvccpb.KeyValue) ([]AuditChange, error) {
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	var keys []string
+	for _, change := range plan.Changes {
+		var rev int64
+		if kv := destination[change.Key]; kv != nil {
+			rev = kv.ModRevision
+		}
+		switch change.Action {
+		case "create", "update":
+			ops = append(ops, clientv3.OpPut(change.Key, *change.After, clientv3.WithPrevKV()))
+		case "delete":
+			ops = append(ops, clientv3.OpDelete(change.Key, clientv3.WithPrevKV()))
+		default:
+			continue
+		}
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(change.Key), "=", rev))
+		keys = append(keys, change.Key)
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	txn, err := guardedTxn(to.Txn(ctx), cmps, ops...)
+	if err != nil {
+		return nil, err
+	}
+	var changes []AuditChange
+	for i, r := range txn.Responses {
+		if put := r.GetResponsePut(); put != nil {
+			changes = append(changes, putChange(keys[i], put.PrevKv, txn.Header.Revision))
+		} else {
+			changes = append(changes, deleteChanges(r.GetResponseDeleteRange().PrevKvs)...)
+		}
+	}
+	return changes, nil
+}
+
+// PromoteHandler previews or applies copying a reviewed set of keys from one
+// environment or cluster to another; own is the gateway's own cluster. A
+// request without a token returns the diff and a token; repeating the
+// request with that token applies exactly that diff, atomically, or fails
+// with 409 if either side has changed since.
+func PromoteHandler(own EnvironmentStore, stores map[string]EnvironmentStore, clusters *Clusters, logger *zap.Logger) gin.HandlerFunc {
+	side := func(env, cluster string) (EnvironmentStore, bool) {
+		switch {
+		case env != "" && cluster != "":
+			return EnvironmentStore{}, false
+		case cluster != "":
+			return clusters.Store(cluster)
+		case env != "":
+			store, ok := stores[env]
+			return store, ok
+		}
+		return own, true
+	}
+	return func(c *gin.Context) {
+		var req PromoteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		from, ok := side(req.From, req.FromCluster)
+		to, ok2 := side(req.To, req.ToCluster)
+		if !ok || !ok2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown environment or cluster"})
+			return
+		}
+		if req.From == req.To && req.FromCluster == req.ToCluster {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Source and destination must differ"})
+			return
+		}
+		for _, key := range req.Keys {
+			if !strings.HasPrefix(key, "/") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Keys must start with /"})
+				return
+			}
+		}
+
+		ctx, cancel := longRequestContext(c, 10*time.Second)
+		defer cancel()
+
+		plan, destination, err := planPromotion(ctx, from.Client, to.Client, req)
+		if errors.Is(err, errTooManyKeys) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if req.Token == "" {
+			c.JSON(http.StatusOK, plan)
+			return
+		}
+		if req.Token != plan.Token {
+			c.JSON(http.StatusConflict, gin.H{"error": "Keys have changed since the preview", "plan": plan})
+			return
+		}
+
+		changes, err := applyPromotion(ctx, to.Client, plan, destination)
+		if errors.Is(err, errConflict) {
+			conflictResponse(c, err, gin.H{"error": "Keys have changed since the preview"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error writing keys to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		to.Audit.Record(ctx, requestActor(c), "promote", changes)
+		c.JSON(http.StatusOK, gin.H{"promoted": len(changes), "changes": plan.Changes})
+	}
+}
@@ -0,0 +1,377 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// proposalPrefix holds submitted change proposals, keyed by creation time.
+const proposalPrefix = "/.proposals/"
+
+// Proposal states.
+const (
+	proposalPending  = "pending"
+	proposalApproved = "approved"
+	proposalRejected = "rejected"
+	proposalApplied  = "applied"
+)
+
+// proposalSeq disambiguates proposals submitted in the same nanosecond.
+var proposalSeq uint32
+
+var (
+	errProposalNotFound = errors.New("proposal not found")
+	errProposalState    = errors.New("proposal is not in the right state")
+	errNotApprover      = errors.New("not an approver")
+	errOwnProposal      = errors.New("proposal is the reviewer's own")
+	errUntrustedUser    = errors.New("no trusted user")
+)
+
+// validateProposal checks every op names an absolute key and does exactly
+// one thing.
+func validateProposal(r ProposalRequest) error {
+	// Applying also rewrites the proposal record in the same transaction.
+	if len(r.Ops) == 0 || len(r.Ops) > maxTxnOps-1 {
+		return fmt.Errorf("A proposal must have between 1 and %d ops", maxTxnOps-1)
+	}
+	seen := make(map[string]bool)
+	for _, op := range r.Ops {
+		if !strings.HasPrefix(op.Key, "/") {
+			return errors.New("Keys must start with /")
+		}
+		if op.Delete == (op.Value != nil) {
+			return errors.New("Each op must set either value or delete")
+		}
+		if seen[op.Key] {
+			return errors.New("Each key may appear only once")
+		}
+		seen[op.Key] = true
+	}
+	return nil
+}
+
+// Proposals stores change proposals and moves them through review. Only
+// the configured approvers may approve or reject, and never their own.
+// Reviewers and appliers must be users the fronting proxy authenticated, as
+// trust tells.
+type Proposals struct {
+	client    clientv3.KV
+	audit     *AuditLog
+	logger    *zap.Logger
+	approvers map[string]bool
+	trust     IdentityTrust
+}
+
+// NewProposals creates a proposal store whose reviewers are approvers.
+func NewProposals(client clientv3.KV, audit *AuditLog, logger *zap.Logger, approvers []string, trust IdentityTrust) *Proposals {
+	p := &Proposals{client: client, audit: audit, logger: logger, approvers: make(map[string]bool), trust: trust}
+	for _, a := range approvers {
+		p.approvers[a] = true
+	}
+	return p
+}
+
+// Submit stores req as a pending proposal.
+func (p *Proposals) Submit(ctx context.Context, req ProposalRequest, author string) (Proposal, error) {
+	keys := make([]string, len(req.Ops))
+	for i, op := range req.Ops {
+		keys[i] = op.Key
+	}
+	current, err := getKeys(ctx, p.client, keys)
+	if err != nil {
+		return Proposal{}, err
+	}
+	now := time.Now()
+	prop := Proposal{
+		ID:        fmt.Sprintf("%019d-%04x", now.UnixNano(), uint16(atomic.AddUint32(&proposalSeq, 1))),
+		Title:     req.Title,
+		Author:    author,
+		Status:    proposalPending,
+		Ops:       req.Ops,
+		Base:      make(map[string]int64, len(keys)),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, key := range keys {
+		prop.Base[key] = 0
+		if kv := current[key]; kv != nil {
+			prop.Base[key] = kv.ModRevision
+		}
+	}
+	data, err := json.Marshal(prop)
+	if err != nil {
+		return prop, err
+	}
+	_, err = p.client.Put(ctx, proposalPrefix+prop.ID, string(data))
+	return prop, err
+}
+
+// Get returns a proposal and the ModRevision of its record.
+func (p *Proposals) Get(ctx context.Context, id string) (Proposal, int64, error) {
+	var prop Proposal
+	resp, err := p.client.Get(ctx, proposalPrefix+id)
+	if err != nil {
+		return prop, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return prop, 0, errProposalNotFound
+	}
+	err = json.Unmarshal(resp.Kvs[0].Value, &prop)
+	return prop, resp.Kvs[0].ModRevision, err
+}
+
+// List returns proposals oldest first, optionally only those in status.
+func (p *Proposals) List(ctx context.Context, status string) ([]Proposal, error) {
+	resp, err := p.client.Get(ctx, proposalPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	props := []Proposal{}
+	for _, kv := range resp.Kvs {
+		var prop Proposal
+		if err := json.Unmarshal(kv.Value, &prop); err != nil {
+			return nil, err
+		}
+		if status == "" || prop.Status == status {
+			props = append(props, prop)
+		}
+	}
+	return props, nil
+}
+
+// Review approves or rejects a pending proposal as reviewer, the trusted
+// user reviewing it, or "" when there is none.
+func (p *Proposals) Review(ctx context.Context, id, reviewer string, approve bool, comment string) (Proposal, error) {
+	prop, rev, err := p.Get(ctx, id)
+	if err != nil {
+		return prop, err
+	}
+	if reviewer == "" {
+		return prop, errUntrustedUser
+	}
+	if reviewer == prop.Author {
+		return prop, errOwnProposal
+	}
+	if !p.approvers[reviewer] {
+		return prop, errNotApprover
+	}
+	if prop.Status != proposalPending {
+		return prop, errProposalState
+	}
+	prop.Status = proposalRejected
+	if approve {
+		prop.Status = proposalApproved
+	}
+	prop.Reviewer = reviewer
+	prop.Comment = comment
+	prop.UpdatedAt = time.Now()
+	data, err := json.Marshal(prop)
+	if err != nil {
+		return prop, err
+	}
+	txn, err := p.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(proposalPrefix+id), "=", rev)).
+		Then(clientv3.OpPut(proposalPrefix+id, string(data))).
+		Commit()
+	if err != nil {
+		return prop, err
+	}
+	if !txn.Succeeded {
+		return prop, errProposalState
+	}
+	return prop, nil
+}
+
+// Apply writes an approved proposal and marks it applied in one transaction,
+// as actor, a trusted user or "". It fails with a ConflictError if any key
+// has changed since submission.
+func (p *Proposals) Apply(ctx context.Context, id, actor string) (Proposal, error) {
+	prop, rev, err := p.Get(ctx, id)
+	if err != nil {
+		return prop, err
+	}
+	if actor == "" {
+		return prop, errUntrustedUser
+	}
+	if actor != prop.Author && !p.approvers[actor] {
+		return prop, errNotApprover
+	}
+	if prop.Status != proposalApproved {
+		return prop, errProposalState
+	}
+
+	cmps := []clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(proposalPrefix+id), "=", rev)}
+	var ops []clientv3.Op
+	for _, op := range prop.Ops {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(op.Key), "=", prop.Base[op.Key]))
+		if op.Delete {
+			ops = append(ops, clientv3.OpDelete(op.Key, clientv3.WithPrevKV()))
+		} else {
+			ops = append(ops, clientv3.OpPut(op.Key, *op.Value, clientv3.WithPrevKV()))
+		}
+	}
+	applied := prop
+	applied.Status = proposalApplied
+	applied.UpdatedAt = time.Now()
+	data, err := json.Marshal(applied)
+	if err != nil {
+		return prop, err
+	}
+	ops = append(ops, clientv3.OpPut(proposalPrefix+id, string(data)))
+
+	txn, err := guardedTxn(p.client.Txn(ctx), cmps, ops...)
+	if err != nil {
+		return prop, err
+	}
+	var changes []AuditChange
+	for i, op := range prop.Ops {
+		r := txn.Responses[i]
+		if op.Delete {
+			changes = append(changes, deleteChanges(r.GetResponseDeleteRange().PrevKvs)...)
+		} else {
+			changes = append(changes, putChange(op.Key, r.GetResponsePut().PrevKv, txn.Header.Revision))
+		}
+	}
+	p.audit.Record(ctx, actor, "proposal:"+id, changes)
+	return applied, nil
+}
+
+// proposalError writes the response for an error from Proposals.
+func proposalError(c *gin.Context, err error, logger *zap.Logger) {
+	switch {
+	case errors.Is(err, errProposalNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proposal not found"})
+	case errors.Is(err, errUntrustedUser):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+	case errors.Is(err, errOwnProposal):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Proposals cannot be reviewed by their author"})
+	case errors.Is(err, errNotApprover):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not permitted to review or apply this proposal"})
+	case errors.Is(err, errProposalState):
+		c.JSON(http.StatusConflict, gin.H{"error": "Proposal is not in a state that allows this"})
+	case errors.Is(err, errConflict):
+		conflictResponse(c, err, gin.H{"error": "Keys have changed since the proposal was submitted"})
+	default:
+		logger.Error("Error updating proposal in etcd", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+	}
+}
+
+// ProposalSubmitHandler stores a draft set of writes for review.
+func ProposalSubmitHandler(proposals *Proposals, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if proposals == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Proposals are not enabled"})
+			return
+		}
+		var req ProposalRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if err := validateProposal(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		prop, err := proposals.Submit(ctx, req, proposals.trust.Actor(c))
+		if err != nil {
+			proposalError(c, err, logger)
+			return
+		}
+		c.JSON(http.StatusCreated, prop)
+	}
+}
+
+// ProposalListHandler lists proposals, optionally filtered by ?status.
+func ProposalListHandler(proposals *Proposals, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if proposals == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Proposals are not enabled"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		props, err := proposals.List(ctx, c.Query("status"))
+		if err != nil {
+			proposalError(c, err, logger)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"proposals": props})
+	}
+}
+
+// ProposalHandler returns one proposal.
+func ProposalHandler(proposals *Proposals, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if proposals == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Proposals are not enabled"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		prop, _, err := proposals.Get(ctx, c.Param("id"))
+		if err != nil {
+			proposalError(c, err, logger)
+			return
+		}
+		c.JSON(http.StatusOK, prop)
+	}
+}
+
+// ProposalReviewHandler approves or rejects a pending proposal, with an
+// optional {"comment": ...} body.
+func ProposalReviewHandler(proposals *Proposals, approve bool, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if proposals == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Proposals are not enabled"})
+			return
+		}
+		var body struct {
+			Comment string `json:"comment"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+				return
+			}
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		prop, err := proposals.Review(ctx, c.Param("id"), proposals.trust.User(c), approve, body.Comment)
+		if err != nil {
+			proposalError(c, err, logger)
+			return
+		}
+		c.JSON(http.StatusOK, prop)
+	}
+}
+
+// ProposalApplyHandler atomically applies an approved proposal.
+func ProposalApplyHandler(proposals *Proposals, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if proposals == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Proposals are not enabled"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		prop, err := proposals.Apply(ctx, c.Param("id"), proposals.trust.User(c))
+		if err != nil {
+			proposalError(c, err, logger)
+			return
+		}
+		c.JSON(http.StatusOK, prop)
+	}
+}
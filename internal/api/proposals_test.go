@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestProposalReview(t *testing.T) {
+	trust := IdentityTrust{Secret: "s3cret"}
+	// serve makes a request as user, or anonymously for "".
+	serve := func(method, route, url, body, user string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if user != "" {
+			req.Header.Set(actorHeader, user)
+			req.Header.Set(proxySecretHeader, "s3cret")
+		}
+		return apitest.Serve(route, req, handler)
+	}
+
+	tests := []struct {
+		name string
+		// change writes to the proposal's key after it is submitted.
+		change     bool
+		reviewer   string
+		applier    string
+		wantReview int
+		wantApply  int
+		wantValue  string
+		wantStatus string
+	}{
+		{"applied", false, "bob", "alice", 200, 200, "2", proposalApplied},
+		{"changed since submitted", true, "bob", "alice", 200, 409, "changed", proposalApproved},
+		{"own proposal", false, "alice", "alice", 403, 409, "1", proposalPending},
+		{"not an approver", false, "carol", "alice", 403, 409, "1", proposalPending},
+		{"anonymous reviewer", false, "", "alice", 401, 409, "1", proposalPending},
+		{"anonymous applier", false, "bob", "", 200, 401, "1", proposalApproved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := apitest.NewStore()
+			kv := store.KV()
+			ctx := context.Background()
+			if _, err := kv.Put(ctx, "/app/a", "1"); err != nil {
+				t.Fatal(err)
+			}
+			proposals := NewProposals(kv, nil, zap.NewNop(), []string{"bob"}, trust)
+
+			rec := serve("POST", "/proposals", "/proposals", `{"title":"t","ops":[{"key":"/app/a","value":"2"}]}`, "alice", ProposalSubmitHandler(proposals, zap.NewNop()))
+			var prop Proposal
+			if err := json.Unmarshal(rec.Body.Bytes(), &prop); err != nil || rec.Code != 201 || prop.Author != "alice" {
+				t.Fatalf("submit: status %d: %s", rec.Code, rec.Body)
+			}
+			if tt.change {
+				kv.Put(ctx, "/app/a", "changed")
+			}
+			if rec := serve("POST", "/proposals/:id/approve", "/proposals/"+prop.ID+"/approve", "", tt.reviewer, ProposalReviewHandler(proposals, true, zap.NewNop())); rec.Code != tt.wantReview {
+				t.Errorf("review: got status %d, want %d: %s", rec.Code, tt.wantReview, rec.Body)
+			}
+			if rec := serve("POST", "/proposals/:id/apply", "/proposals/"+prop.ID+"/apply", "", tt.applier, ProposalApplyHandler(proposals, zap.NewNop())); rec.Code != tt.wantApply {
+				t.Errorf("apply: got status %d, want %d: %s", rec.Code, tt.wantApply, rec.Body)
+			}
+
+			if resp, _ := kv.Get(ctx, "/app/a"); string(resp.Kvs[0].Value) != tt.wantValue {
+				t.Errorf("got value %q, want %q", resp.Kvs[0].Value, tt.wantValue)
+			}
+			if got, _, err := proposals.Get(ctx, prop.ID); err != nil || got.Status != tt.wantStatus {
+				t.Errorf("got status %q, error %v, want %q", got.Status, err, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ParseProxyTokens parses "user=token" entries, the bearer tokens native
+// etcd clients authenticate to the proxy with, into users by token.
+func ParseProxyTokens(entries []string) (map[string]string, error) {
+	tokens := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		user, token, ok := strings.Cut(entry, "=")
+		if !ok || user == "" || token == "" {
+			return nil, errors.New("invalid proxy token, expected user=token")
+		}
+		if _, dup := tokens[token]; dup {
+			return nil, errors.New("proxy token of " + strconv.Quote(user) + " is already another user's")
+		}
+		tokens[token] = user
+	}
+	return tokens, nil
+}
+
+// rpcUserKey holds the user a gRPC call authenticated as.
+type rpcUserKey struct{}
+
+// rpcUser returns the user ctx's call authenticated as, if any.
+func rpcUser(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(rpcUserKey{}).(string)
+	return user, ok
+}
+
+// ProxyAuth authenticates calls to the etcd proxy by bearer token, and
+// limits the rate at which each user may make them.
+type ProxyAuth struct {
+	tokens map[string]string
+	// rate is calls per second and burst how many may be made at once;
+	// a zero rate is unlimited.
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket holds the calls a user may still make.
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// NewProxyAuth creates a ProxyAuth accepting the tokens ParseProxyTokens
+// returned, each user making at most rate calls per second on average and
+// burst at once. A zero rate does not limit calls.
+func NewProxyAuth(tokens map[string]string, rate, burst int) *ProxyAuth {
+	if burst < rate {
+		burst = rate
+	}
+	return &ProxyAuth{tokens: tokens, rate: float64(rate), burst: float64(burst), now: time.Now, buckets: map[string]*tokenBucket{}}
+}
+
+// authenticate returns ctx with the user whose token the call carries.
+func (a *ProxyAuth) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if v := md.Get("authorization"); len(v) > 0 {
+		token, _ = strings.CutPrefix(v[0], "Bearer ")
+	}
+	user := ""
+	for t, u := range a.tokens {
+		// Every token is compared, so timing tells nothing of which matched.
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			user = u
+		}
+	}
+	if user == "" {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	if !a.allow(user) {
+		return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return context.WithValue(ctx, rpcUserKey{}, user), nil
+}
+
+// allow takes one call from user's bucket, if there is one left.
+func (a *ProxyAuth) allow(user string) bool {
+	if a.rate == 0 {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	b := a.buckets[user]
+	if b == nil {
+		b = &tokenBucket{tokens: a.burst, at: now}
+		a.buckets[user] = b
+	}
+	b.tokens += now.Sub(b.at).Seconds() * a.rate
+	if b.tokens > a.burst {
+		b.tokens = a.burst
+	}
+	b.at = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// UnaryInterceptor refuses unauthenticated and rate limited calls.
+func (a *ProxyAuth) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor is the streaming counterpart of UnaryInterceptor. Each
+// stream counts as one call.
+func (a *ProxyAuth) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authedStream is a ServerStream carrying the authenticated user.
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context {
+	return s.ctx
+}
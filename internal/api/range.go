@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// defaultRangeLimit caps range reads that don't specify ?limit=.
+const defaultRangeLimit = 1000
+
+func toKeyValue(kv *mvccpb.KeyValue, clock *RevisionClock) KeyValue {
+	out := KeyValue{
+		Key:            string(kv.Key),
+		Value:          string(kv.Value),
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+	}
+	if t, ok := clock.TimeOf(kv.ModRevision); ok {
+		out.ModifiedAt = &t
+	}
+	return out
+}
+
+// RangeHandler reads the lexicographic key range [start, end). Without ?end=
+// the range extends to the end of the keyspace.
+func RangeHandler(client clientv3.KV, clock *RevisionClock, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := c.Query("start")
+		if start == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Start is required"})
+			return
+		}
+
+		limit := int64(defaultRangeLimit)
+		if s := c.Query("limit"); s != "" {
+			var err error
+			if limit, err = strconv.ParseInt(s, 10, 64); err != nil || limit <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+		}
+
+		opts := []clientv3.OpOption{
+			clientv3.WithLimit(limit),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+		}
+		if end := c.Query("end"); end != "" {
+			if end <= start {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "End must sort after start"})
+				return
+			}
+			opts = append(opts, clientv3.WithRange(end))
+		} else {
+			opts = append(opts, clientv3.WithFromKey())
+		}
+		if c.Query("keysOnly") == "true" {
+			opts = append(opts, clientv3.WithKeysOnly())
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		resp, err := client.Get(ctx, start, opts...)
+		if err != nil {
+			logger.Error("Error fetching range from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		kvs := make([]KeyValue, len(resp.Kvs))
+		for i, kv := range resp.Kvs {
+			kvs[i] = toKeyValue(kv, clock)
+		}
+		c.JSON(http.StatusOK, gin.H{"kvs": kvs, "more": resp.More, "revision": resp.Header.Revision})
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// maxProbeBackoff bounds how long EtcdMonitor waits between probes while
+// etcd cannot be reached.
+const maxProbeBackoff = 30 * time.Second
+
+// EtcdMonitor probes etcd in the background, so readiness can report the
+// gateway degraded while it cannot reach etcd. The client connects lazily
+// and reconnects by itself; while etcd is down the monitor probes with
+// exponential backoff, resetting the client's own reconnect backoff before
+// each probe, so the gateway recovers within one probe of etcd returning
+// rather than after gRPC's backoff of up to two minutes.
+type EtcdMonitor struct {
+	client   *clientv3.Client
+	logger   *zap.Logger
+	interval time.Duration
+
+	mu     sync.RWMutex
+	status EtcdConnection
+}
+
+// NewEtcdMonitor creates a monitor probing every interval while etcd is
+// reachable; call Run to start it. Until the first probe succeeds the
+// gateway is reported degraded.
+func NewEtcdMonitor(client *clientv3.Client, interval time.Duration, logger *zap.Logger) *EtcdMonitor {
+	return &EtcdMonitor{
+		client:   client,
+		logger:   logger,
+		interval: interval,
+		status:   EtcdConnection{Since: time.Now(), Error: "not probed yet"},
+	}
+}
+
+// Run probes until ctx is cancelled.
+func (m *EtcdMonitor) Run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		wait := m.interval
+		if !m.probe(ctx) {
+			wait = backoff
+			if backoff *= 2; backoff > maxProbeBackoff {
+				backoff = maxProbeBackoff
+			}
+		} else {
+			backoff = time.Second
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// probe reads a key from etcd, as etcd's own health check does, and
+// records whether it could. It reports whether etcd was reached.
+func (m *EtcdMonitor) probe(ctx context.Context) bool {
+	if conn := m.client.ActiveConnection(); conn != nil && !m.Status().Connected {
+		conn.ResetConnectBackoff()
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	_, err := m.client.Get(probeCtx, "health", clientv3.WithCountOnly())
+	cancel()
+	if ctx.Err() != nil {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.status
+	now := time.Now()
+	if err == nil {
+		m.status = EtcdConnection{Connected: true, Since: previous.Since}
+		if !previous.Connected {
+			m.status.Since = now
+			m.logger.Info("Connected to etcd", zap.Duration("unavailableFor", now.Sub(previous.Since)))
+		}
+		return true
+	}
+	m.status = EtcdConnection{Since: previous.Since, Error: err.Error(), Failures: previous.Failures + 1}
+	if previous.Connected {
+		m.status.Since = now
+		m.logger.Error("Lost connection to etcd, reporting degraded", zap.Error(err))
+	} else {
+		m.logger.Warn("Cannot reach etcd", zap.Int("failures", m.status.Failures), zap.Error(err))
+	}
+	return false
+}
+
+// Status returns whether etcd was reachable at the last probe.
+func (m *EtcdMonitor) Status() EtcdConnection {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// ReadinessHandler answers 200 while the gateway can reach etcd, and 503
+// with status "degraded" while it cannot. Unlike /health, which only says
+// the process is serving, it tells load balancers to route elsewhere.
+func ReadinessHandler(monitor *EtcdMonitor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := monitor.Status()
+		if !status.Connected {
+			c.JSON(http.StatusServiceUnavailable, Readiness{Status: "degraded", Etcd: status})
+			return
+		}
+		c.JSON(http.StatusOK, Readiness{Status: "ready", Etcd: status})
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// maxRenderLookups bounds the etcd reads one template render may make.
+const maxRenderLookups = 100
+
+var (
+	errRenderLookups  = errors.New("template reads too many keys")
+	errRenderReserved = errors.New("key is reserved by the gateway")
+)
+
+// renderData is the dot of a rendered template.
+type renderData struct {
+	Key  string
+	Vars map[string]string
+}
+
+// templateReader serves a template's key lookups, all at one revision so the
+// rendered result is consistent.
+type templateReader struct {
+	ctx     context.Context
+	client  clientv3.KV
+	rev     int64
+	lookups int
+}
+
+// get reads key, or with prefix every key under it. The gateway's own keys
+// are refused, or left out of listings of the root.
+func (r *templateReader) get(key string, prefix bool) (*clientv3.GetResponse, error) {
+	if reservedPrefix(key) && !(prefix && key == "/") {
+		return nil, errRenderReserved
+	}
+	if r.lookups++; r.lookups > maxRenderLookups {
+		return nil, errRenderLookups
+	}
+	opts := []clientv3.OpOption{clientv3.WithRev(r.rev)}
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	resp, err := r.client.Get(r.ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if r.rev == 0 {
+		r.rev = resp.Header.Revision
+	}
+	if prefix {
+		kvs := resp.Kvs[:0]
+		for _, kv := range resp.Kvs {
+			if !reservedPrefix(string(kv.Key)) {
+				kvs = append(kvs, kv)
+			}
+		}
+		resp.Kvs = kvs
+	}
+	return resp, nil
+}
+
+func (r *templateReader) funcs() template.FuncMap {
+	return template.FuncMap{
+		// key returns the value of a key, failing the render if it is missing.
+		"key": func(key string) (string, error) {
+			resp, err := r.get(key, false)
+			if err != nil {
+				return "", err
+			}
+			if len(resp.Kvs) == 0 {
+				return "", fmt.Errorf("key %q not found", key)
+			}
+			return string(resp.Kvs[0].Value), nil
+		},
+		// keyOrDefault returns the value of a key, or def if it is missing.
+		"keyOrDefault": func(key, def string) (string, error) {
+			resp, err := r.get(key, false)
+			if err != nil || len(resp.Kvs) == 0 {
+				return def, err
+			}
+			return string(resp.Kvs[0].Value), nil
+		},
+		// ls returns the keys directly under prefix, by name relative to it.
+		"ls": func(prefix string) (map[string]string, error) {
+			prefix = strings.TrimSuffix(prefix, "/") + "/"
+			resp, err := r.get(prefix, true)
+			if err != nil {
+				return nil, err
+			}
+			out := make(map[string]string)
+			for _, kv := range resp.Kvs {
+				if name := strings.TrimPrefix(string(kv.Key), prefix); !strings.Contains(name, "/") {
+					out[name] = string(kv.Value)
+				}
+			}
+			return out, nil
+		},
+	}
+}
+
+// parseRenderVars reads ?vars=name=value,... (repeatable) into a map.
+func parseRenderVars(c *gin.Context) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, param := range c.QueryArray("vars") {
+		for _, pair := range splitNonEmpty(param, ",") {
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("Invalid variable %q, expected name=value", pair)
+			}
+			vars[name] = value
+		}
+	}
+	return vars, nil
+}
+
+func splitNonEmpty(s, sep string) []string {
+	var out []string
+	for _, part := range strings.Split(s, sep) {
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// RenderHandler treats the value of a key as a Go text/template and returns
+// the rendered result. Templates see .Key and .Vars (from ?vars=name=value,...)
+// and can read other keys with key, keyOrDefault and ls, consul-template style.
+// Neither the template nor the keys it reads may be the gateway's own.
+func RenderHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Key is required"})
+			return
+		}
+		vars, err := parseRenderVars(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		reader := &templateReader{ctx: ctx, client: client}
+		resp, err := reader.get(key, false)
+		if errors.Is(err, errRenderReserved) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Key is reserved by the gateway"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error fetching key from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if len(resp.Kvs) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+			return
+		}
+
+		tmpl, err := template.New(key).Option("missingkey=error").Funcs(reader.funcs()).Parse(string(resp.Kvs[0].Value))
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid template", "detail": err.Error()})
+			return
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, renderData{Key: key, Vars: vars}); err != nil {
+			if ctx.Err() != nil {
+				logger.Error("Error fetching key from etcd", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+				return
+			}
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Template failed to render", "detail": err.Error()})
+			return
+		}
+		c.Header("X-Etcd-Revision", strconv.FormatInt(reader.rev, 10))
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", out.Bytes())
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	"go.uber.org/zap"
+)
+
+func TestRenderReservedKeys(t *testing.T) {
+	store := apitest.NewStore()
+	kv := store.KV()
+	for key, value := range map[string]string{
+		"/app/a":               "1",
+		"/top":                 "2",
+		"/.proposals/p":        "secret",
+		"/.flag":               "secret",
+		"/tmpl/key":            `{{key "/app/a"}}`,
+		"/tmpl/ls":             `{{range $k, $v := ls "/"}}{{$k}}={{$v}};{{end}}`,
+		"/tmpl/reserved":       `{{key "/.proposals/p"}}`,
+		"/tmpl/default":        `{{keyOrDefault "/.proposals/p" "none"}}`,
+		"/tmpl/reserved-ls":    `{{ls "/.proposals"}}`,
+		"/tmpl/root":           `{{key "/"}}`,
+		"/tmpl/missing-prefix": `{{ls "/nothing"}}`,
+	} {
+		if _, err := kv.Put(context.Background(), key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		wantCode int
+		wantBody string
+	}{
+		{"key", "/render//tmpl/key", 200, "1"},
+		{"ls root", "/render//tmpl/ls", 200, "top=2;"},
+		{"empty ls", "/render//tmpl/missing-prefix", 200, "map[]"},
+		{"reserved key", "/render//tmpl/reserved", 422, ""},
+		{"reserved default", "/render//tmpl/default", 422, ""},
+		{"reserved ls", "/render//tmpl/reserved-ls", 422, ""},
+		{"root key", "/render//tmpl/root", 422, ""},
+		{"reserved template", "/render//.proposals/p", 403, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := apitest.Serve("/render/*key", httptest.NewRequest("GET", tt.url, nil), RenderHandler(kv, zap.NewNop()))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode == 200 && rec.Body.String() != tt.wantBody {
+				t.Errorf("got %q, want %q", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// Conflict policies for keys that already exist when loading keys.
+const (
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+	conflictFail      = "fail"
+)
+
+// loadKeys writes keys in transactions of up to maxTxnOps keys, resolving
+// existing keys by policy. Unless overwriting, each put is guarded on the
+// key still not existing, so a key created meanwhile fails the batch rather
+// than being replaced. With conflictFail every key is checked before
+// anything is written. Each key adds one to progress; callers set the total.
+func loadKeys(ctx context.Context, client clientv3.KV, audit *AuditLog, actor, action string, keys []DumpedKey, policy string, progress *JobProgress) (RestoreResult, error) {
+	var result RestoreResult
+	if policy == conflictFail {
+		names := dumpedKeyNames(keys)
+		for start := 0; start < len(names); start += maxTxnOps {
+			end := start + maxTxnOps
+			if end > len(names) {
+				end = len(names)
+			}
+			existing, err := getKeys(ctx, client, names[start:end])
+			if err != nil {
+				return result, err
+			}
+			if len(existing) > 0 {
+				return result, fmt.Errorf("%d keys already exist", len(existing))
+			}
+		}
+	}
+	for start := 0; start < len(keys); start += maxTxnOps {
+		end := start + maxTxnOps
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := keys[start:end]
+		if policy == conflictSkip {
+			existing, err := getKeys(ctx, client, dumpedKeyNames(batch))
+			if err != nil {
+				return result, err
+			}
+			missing := batch[:0:0]
+			for _, k := range batch {
+				if _, ok := existing[k.Key]; !ok {
+					missing = append(missing, k)
+				}
+			}
+			result.Skipped += int64(len(batch) - len(missing))
+			batch = missing
+		}
+		if len(batch) > 0 {
+			var cmps []clientv3.Cmp
+			ops := make([]clientv3.Op, len(batch))
+			for i, k := range batch {
+				if policy != conflictOverwrite {
+					cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(k.Key), "=", 0))
+				}
+				ops[i] = clientv3.OpPut(k.Key, k.Value, clientv3.WithPrevKV())
+			}
+			resp, err := client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+			if err != nil {
+				return result, err
+			}
+			if !resp.Succeeded {
+				return result, errConflict
+			}
+			changes := make([]AuditChange, len(batch))
+			for i, r := range resp.Responses {
+				changes[i] = putChange(batch[i].Key, r.GetResponsePut().PrevKv, resp.Header.Revision)
+			}
+			audit.Record(ctx, actor, action, changes)
+			result.Restored += int64(len(batch))
+			result.Revision = resp.Header.Revision
+		}
+		progress.Add(int64(end - start))
+	}
+	return result, nil
+}
+
+func dumpedKeyNames(keys []DumpedKey) []string {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.Key
+	}
+	return names
+}
+
+// restoreKeys restores req.Keys, skipping existing keys unless
+// req.Overwrite is set.
+func restoreKeys(ctx context.Context, client clientv3.KV, audit *AuditLog, actor string, req RestoreRequest, progress *JobProgress) (RestoreResult, error) {
+	progress.SetTotal(int64(len(req.Keys)))
+	policy := conflictSkip
+	if req.Overwrite {
+		policy = conflictOverwrite
+	}
+	result, err := loadKeys(ctx, client, audit, actor, "restore", req.Keys, policy, progress)
+	if err != nil {
+		return result, err
+	}
+	progress.Logf("Restored %d keys, skipped %d that already existed", result.Restored, result.Skipped)
+	return result, nil
+}
+
+// RestoreJobHandler starts a job restoring a set of keys, e.g. from an
+// export, and returns it immediately.
+func RestoreJobHandler(client clientv3.KV, jobs *Jobs, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RestoreRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		seen := make(map[string]bool, len(req.Keys))
+		for _, k := range req.Keys {
+			if !strings.HasPrefix(k.Key, "/") || reservedPrefix(k.Key) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Keys must start with / and be outside reserved prefixes"})
+				return
+			}
+			if seen[k.Key] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate key " + k.Key})
+				return
+			}
+			seen[k.Key] = true
+		}
+
+		actor := requestActor(c)
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		job, err := jobs.Start(ctx, "restore", actor, func(ctx context.Context, progress *JobProgress) (interface{}, error) {
+			return restoreKeys(ctx, client, audit, actor, req, progress)
+		})
+		if err != nil {
+			logger.Error("Error starting restore job", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusAccepted, job)
+	}
+}
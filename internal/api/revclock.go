@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// maxRevisionSamples bounds the memory used by a RevisionClock.
+const maxRevisionSamples = 100000
+
+type revisionSample struct {
+	revision int64
+	at       time.Time
+}
+
+// RevisionClock maps etcd revisions to approximate wall-clock times by
+// watching the keyspace and recording when each revision was observed.
+// Revisions from before the clock started are unknown.
+type RevisionClock struct {
+	client WatchKV
+	logger *zap.Logger
+
+	mu      sync.RWMutex
+	samples []revisionSample
+}
+
+// NewRevisionClock creates a clock; call Run to start recording.
+func NewRevisionClock(client WatchKV, logger *zap.Logger) *RevisionClock {
+	return &RevisionClock{client: client, logger: logger}
+}
+
+// Run watches the keyspace until ctx is cancelled.
+func (rc *RevisionClock) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		resp, err := rc.client.Get(ctx, "/", clientv3.WithCountOnly())
+		if err != nil {
+			rc.logger.Warn("Revision clock cannot read current revision", zap.Error(err))
+			time.Sleep(time.Second)
+			continue
+		}
+		rc.record(resp.Header.Revision, time.Now())
+
+		for wresp := range rc.client.Watch(ctx, "/", clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1)) {
+			if err := wresp.Err(); err != nil {
+				rc.logger.Warn("Revision clock watch failed", zap.Error(err))
+				break
+			}
+			now := time.Now()
+			for _, ev := range wresp.Events {
+				rc.record(ev.Kv.ModRevision, now)
+			}
+		}
+	}
+}
+
+func (rc *RevisionClock) record(revision int64, at time.Time) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if n := len(rc.samples); n > 0 && rc.samples[n-1].revision >= revision {
+		return
+	}
+	if len(rc.samples) >= maxRevisionSamples {
+		rc.samples = append(rc.samples[:0], rc.samples[len(rc.samples)/2:]...)
+	}
+	rc.samples = append(rc.samples, revisionSample{revision: revision, at: at})
+}
+
+// TimeOf returns when revision was first observed, or false if it predates
+// the clock's history.
+func (rc *RevisionClock) TimeOf(revision int64) (time.Time, bool) {
+	if rc == nil {
+		return time.Time{}, false
+	}
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	// The first sample is the revision current at startup; anything at or
+	// below it happened at some unknown earlier time.
+	if len(rc.samples) == 0 || revision <= rc.samples[0].revision {
+		return time.Time{}, false
+	}
+	i := sort.Search(len(rc.samples), func(i int) bool { return rc.samples[i].revision >= revision })
+	if i == len(rc.samples) {
+		return time.Time{}, false
+	}
+	return rc.samples[i].at, true
+}
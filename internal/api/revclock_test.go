@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"etcd-gateway/internal/api/apitest"
+	"go.uber.org/zap"
+)
+
+func TestRevisionClock(t *testing.T) {
+	store := apitest.NewStore()
+	client := store.Client()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	before, _ := client.Put(ctx, "/app/a", "1")
+
+	clock := NewRevisionClock(client, zap.NewNop())
+	go clock.Run(ctx)
+	// Writes made before the clock's watch is created are not seen by it,
+	// so write until one is.
+	var started time.Time
+	var after int64
+	waitFor(t, "the clock to observe a write", func() bool {
+		started = time.Now()
+		resp, _ := client.Put(ctx, "/app/b", "1")
+		after = resp.Header.Revision
+		time.Sleep(10 * time.Millisecond)
+		_, ok := clock.TimeOf(after)
+		return ok
+	})
+
+	if _, ok := clock.TimeOf(before.Header.Revision); ok {
+		t.Error("got a time for a revision from before the clock started")
+	}
+	if at, ok := clock.TimeOf(after); !ok || at.Before(started) {
+		t.Errorf("got time %v, %v for the revision written at %v", at, ok, started)
+	}
+	if _, ok := clock.TimeOf(after + 1); ok {
+		t.Error("got a time for a future revision")
+	}
+}
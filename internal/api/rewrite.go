@@ -0,0 +1,324 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// rewriteJobPrefix holds the state of prefix rewrite jobs, so progress
+// survives a gateway restart.
+const rewriteJobPrefix = "/.jobs/rewrite/"
+
+// rewritePreviewSize is how many renames a dry run lists.
+const rewritePreviewSize = 20
+
+// Rewrite job states.
+const (
+	rewriteRunning   = "running"
+	rewriteFailed    = "failed"
+	rewriteCompleted = "completed"
+)
+
+var errJobNotFound = errors.New("job not found")
+
+// Rewriter moves every key under one prefix to another in the background.
+// Moved keys disappear from the source, so a job resumes simply by moving
+// whatever is left; jobs interrupted by a restart are resumed by Run.
+type Rewriter struct {
+	client *clientv3.Client
+	audit  *AuditLog
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	ctx     context.Context
+	running map[string]bool
+}
+
+// NewRewriter creates a rewriter; call Run to allow jobs to start.
+func NewRewriter(client *clientv3.Client, audit *AuditLog, logger *zap.Logger) *Rewriter {
+	return &Rewriter{client: client, audit: audit, logger: logger, running: make(map[string]bool)}
+}
+
+// Run resumes interrupted jobs and keeps new ones running until ctx is cancelled.
+func (rw *Rewriter) Run(ctx context.Context) {
+	rw.mu.Lock()
+	rw.ctx = ctx
+	rw.mu.Unlock()
+
+	jobs, err := rw.List(ctx)
+	if err != nil {
+		rw.logger.Warn("Cannot load rewrite jobs", zap.Error(err))
+	}
+	for _, job := range jobs {
+		if job.Status == rewriteRunning {
+			rw.start(job)
+		}
+	}
+	<-ctx.Done()
+}
+
+// Preview reports what a rewrite would do without writing anything.
+func (rw *Rewriter) Preview(ctx context.Context, req RewriteRequest) (RewritePreview, error) {
+	preview := RewritePreview{Renames: make(map[string]string), Collisions: []string{}}
+	var kvs []*mvccpb.KeyValue
+	rev, err := scanPrefix(ctx, rw.client, req.From, func(kv *mvccpb.KeyValue) {
+		preview.Keys++
+		if len(preview.Renames) < rewritePreviewSize {
+			preview.Renames[string(kv.Key)] = rebase(string(kv.Key), req.From, req.To)
+		}
+		kvs = append(kvs, &mvccpb.KeyValue{Key: kv.Key})
+	})
+	if err != nil {
+		return preview, err
+	}
+	preview.Revision = rev
+	if found, err := collisions(ctx, rw.client, kvs, req.From, req.To); err != nil {
+		return preview, err
+	} else if found != nil {
+		preview.Collisions = found
+	}
+	return preview, nil
+}
+
+// Start creates a job for req and starts it.
+func (rw *Rewriter) Start(ctx context.Context, req RewriteRequest, actor string) (RewriteJob, error) {
+	count, err := rw.client.Get(ctx, req.From, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return RewriteJob{}, err
+	}
+	now := time.Now()
+	job := RewriteJob{
+		ID:        fmt.Sprintf("%019d", now.UnixNano()),
+		From:      req.From,
+		To:        req.To,
+		Overwrite: req.Overwrite,
+		Actor:     actor,
+		Status:    rewriteRunning,
+		Total:     count.Count,
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+	if err := rw.save(ctx, &job); err != nil {
+		return job, err
+	}
+	rw.start(job)
+	return job, nil
+}
+
+// Resume restarts a failed job from wherever it stopped.
+func (rw *Rewriter) Resume(ctx context.Context, id string) (RewriteJob, error) {
+	job, err := rw.Get(ctx, id)
+	if err != nil {
+		return job, err
+	}
+	if job.Status != rewriteCompleted {
+		job.Status = rewriteRunning
+		job.Error = ""
+		if err := rw.save(ctx, &job); err != nil {
+			return job, err
+		}
+		rw.start(job)
+	}
+	return job, nil
+}
+
+// Get returns a job's current state.
+func (rw *Rewriter) Get(ctx context.Context, id string) (RewriteJob, error) {
+	var job RewriteJob
+	resp, err := rw.client.Get(ctx, rewriteJobPrefix+id)
+	if err != nil {
+		return job, err
+	}
+	if len(resp.Kvs) == 0 {
+		return job, errJobNotFound
+	}
+	err = json.Unmarshal(resp.Kvs[0].Value, &job)
+	return job, err
+}
+
+// List returns every job, oldest first.
+func (rw *Rewriter) List(ctx context.Context) ([]RewriteJob, error) {
+	resp, err := rw.client.Get(ctx, rewriteJobPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	jobs := []RewriteJob{}
+	for _, kv := range resp.Kvs {
+		var job RewriteJob
+		if err := json.Unmarshal(kv.Value, &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (rw *Rewriter) save(ctx context.Context, job *RewriteJob) error {
+	job.UpdatedAt = time.Now()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = rw.client.Put(ctx, rewriteJobPrefix+job.ID, string(data))
+	return err
+}
+
+// start runs job in the background unless it is already running.
+func (rw *Rewriter) start(job RewriteJob) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.running[job.ID] || rw.ctx == nil {
+		return
+	}
+	rw.running[job.ID] = true
+	go func() {
+		rw.run(rw.ctx, job)
+		rw.mu.Lock()
+		delete(rw.running, job.ID)
+		rw.mu.Unlock()
+	}()
+}
+
+func (rw *Rewriter) run(ctx context.Context, job RewriteJob) {
+	// Moved keys are gone, so each batch starts at the first key left,
+	// unless keys that cannot be moved, being denied, come first.
+	start := job.From
+	readCtx, cursor := withReadCursor(ctx)
+	for ctx.Err() == nil {
+		resp, err := rw.client.Get(readCtx, start, clientv3.WithRange(clientv3.GetPrefixRangeEnd(job.From)), clientv3.WithLimit(maxTxnOps/2))
+		if err == nil && len(resp.Kvs) == 0 {
+			if resp.More {
+				start = cursor.next(resp)
+				continue
+			}
+			job.Status = rewriteCompleted
+			break
+		}
+		var changes []AuditChange
+		if err == nil {
+			changes, err = moveKeys(ctx, rw.client, resp.Kvs, job.From, job.To, job.Overwrite)
+			rw.audit.Record(ctx, job.Actor, "rewrite:"+job.ID, changes)
+		}
+		if errors.Is(err, errConflict) && job.Overwrite {
+			// A source key changed under us; the next batch re-reads it.
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			job.Status = rewriteFailed
+			job.Error = err.Error()
+			if errors.Is(err, errConflict) {
+				job.Error = "destination keys already exist or source keys changed, resume to retry"
+			}
+			break
+		}
+		job.Moved += int64(len(changes) / 2)
+		job.LastKey = string(resp.Kvs[len(resp.Kvs)-1].Key)
+		if err := rw.save(ctx, &job); err != nil && ctx.Err() == nil {
+			rw.logger.Warn("Cannot save rewrite progress", zap.String("job", job.ID), zap.Error(err))
+		}
+	}
+	if ctx.Err() != nil {
+		// Left as running, to be resumed when the gateway restarts.
+		return
+	}
+	if err := rw.save(ctx, &job); err != nil {
+		rw.logger.Warn("Cannot save rewrite progress", zap.String("job", job.ID), zap.Error(err))
+	}
+	rw.logger.Info("Rewrite finished", zap.String("job", job.ID), zap.String("status", job.Status), zap.Int64("moved", job.Moved))
+}
+
+// RewriteHandler previews (dryRun) or starts a prefix rewrite.
+func RewriteHandler(rewriter *Rewriter, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RewriteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if !strings.HasSuffix(req.From, "/") || !strings.HasSuffix(req.To, "/") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "From and to must be prefixes ending in /"})
+			return
+		}
+		if err := validateCopy(CopyRequest{Source: req.From, Destination: req.To}); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.DryRun {
+			ctx, cancel := longRequestContext(c, 30*time.Second)
+			defer cancel()
+			preview, err := rewriter.Preview(ctx, req)
+			if err != nil {
+				logger.Error("Error fetching keys from etcd", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+				return
+			}
+			c.JSON(http.StatusOK, preview)
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		job, err := rewriter.Start(ctx, req, requestActor(c))
+		if err != nil {
+			logger.Error("Error starting rewrite", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusAccepted, job)
+	}
+}
+
+// RewriteListHandler lists rewrite jobs.
+func RewriteListHandler(rewriter *Rewriter, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		jobs, err := rewriter.List(ctx)
+		if err != nil {
+			logger.Error("Error listing rewrite jobs", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+	}
+}
+
+// RewriteJobHandler reports a job's progress, or with resume set restarts it.
+func RewriteJobHandler(rewriter *Rewriter, resume bool, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		var job RewriteJob
+		var err error
+		if resume {
+			job, err = rewriter.Resume(ctx, c.Param("id"))
+		} else {
+			job, err = rewriter.Get(ctx, c.Param("id"))
+		}
+		if errors.Is(err, errJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error reading rewrite job", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	}
+}
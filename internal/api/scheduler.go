@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/zap"
+)
+
+const (
+	// schedulerPrefix holds the leader election and each task's last run.
+	schedulerPrefix = "/.scheduler/"
+	// schedulerSessionTTL is how long, in seconds, leadership outlives a
+	// replica that stops responding.
+	schedulerSessionTTL = 15
+)
+
+// ScheduledTask is a periodic maintenance job. Run should return once ctx
+// is cancelled, which happens when the replica loses leadership.
+type ScheduledTask struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs maintenance tasks on one gateway replica at a time. The
+// replicas elect a leader through etcd. Last runs are stored in etcd, so a
+// new leader keeps to each task's interval.
+type Scheduler struct {
+	client *clientv3.Client
+	logger *zap.Logger
+	tasks  []ScheduledTask
+	id     string
+}
+
+// NewScheduler creates a scheduler for tasks; call Run to start campaigning.
+func NewScheduler(client *clientv3.Client, logger *zap.Logger, tasks []ScheduledTask) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{client: client, logger: logger, tasks: tasks, id: fmt.Sprintf("%s/%d", host, os.Getpid())}
+}
+
+// Run campaigns for leadership and runs tasks while leader, until ctx is
+// cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := s.lead(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Warn("Maintenance scheduler lost leadership", zap.Error(err))
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// lead waits to be elected, then runs tasks until leadership is lost.
+func (s *Scheduler) lead(ctx context.Context) error {
+	session, err := concurrency.NewSession(s.client, concurrency.WithContext(ctx), concurrency.WithTTL(schedulerSessionTTL))
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	election := concurrency.NewElection(session, schedulerPrefix+"leader")
+	if err := election.Campaign(ctx, s.id); err != nil {
+		return err
+	}
+	s.logger.Info("Elected maintenance scheduler leader", zap.String("replica", s.id))
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-session.Done():
+			cancel()
+		case <-leadCtx.Done():
+		}
+	}()
+	err = s.runTasks(leadCtx)
+
+	resignCtx, cancelResign := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelResign()
+	_ = election.Resign(resignCtx)
+	return err
+}
+
+// runTasks runs each task when it is due, one at a time, until ctx is
+// cancelled.
+func (s *Scheduler) runTasks(ctx context.Context) error {
+	next := make([]time.Time, len(s.tasks))
+	for i, task := range s.tasks {
+		last, err := s.LastRun(ctx, task.Name)
+		if err != nil {
+			return err
+		}
+		if last != nil {
+			next[i] = last.StartedAt.Add(task.Interval)
+		}
+	}
+	for {
+		due := 0
+		for i := range next {
+			if next[i].Before(next[due]) {
+				due = i
+			}
+		}
+		timer := time.NewTimer(time.Until(next[due]))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		task := s.tasks[due]
+		run := TaskRun{StartedAt: time.Now(), Replica: s.id}
+		err := task.Run(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		run.Duration = time.Since(run.StartedAt)
+		if err != nil {
+			run.Error = err.Error()
+			s.logger.Warn("Maintenance task failed", zap.String("task", task.Name), zap.Error(err))
+		} else {
+			s.logger.Info("Maintenance task completed", zap.String("task", task.Name), zap.Duration("duration", run.Duration))
+		}
+		next[due] = run.StartedAt.Add(task.Interval)
+		data, _ := json.Marshal(run)
+		if _, err := s.client.Put(ctx, schedulerPrefix+"tasks/"+task.Name, string(data)); err != nil {
+			return err
+		}
+	}
+}
+
+// LastRun returns the last run of the named task, or nil if it has never run
+// or its record cannot be read.
+func (s *Scheduler) LastRun(ctx context.Context, name string) (*TaskRun, error) {
+	resp, err := s.client.Get(ctx, schedulerPrefix+"tasks/"+name)
+	if err != nil || len(resp.Kvs) == 0 {
+		return nil, err
+	}
+	var run TaskRun
+	if json.Unmarshal(resp.Kvs[0].Value, &run) != nil {
+		return nil, nil
+	}
+	return &run, nil
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// defaultSearchLimit caps search results when ?limit= isn't given.
+const defaultSearchLimit = 100
+
+// globToRegexp converts a glob to an anchored regular expression. Unlike
+// path.Match, * also matches across slashes, so */database/* finds
+// /app/database/host.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// keySearch accumulates up to limit matches of re across batches of keys.
+type keySearch struct {
+	re        *regexp.Regexp
+	values    bool
+	limit     int
+	clock     *RevisionClock
+	matches   []SearchMatch
+	truncated bool
+}
+
+func (s *keySearch) add(kvs []*mvccpb.KeyValue) {
+	for _, kv := range kvs {
+		m := SearchMatch{MatchedKey: s.re.Match(kv.Key)}
+		if s.values {
+			m.MatchedValue = s.re.Match(kv.Value)
+		}
+		if !m.MatchedKey && !m.MatchedValue {
+			continue
+		}
+		if len(s.matches) == s.limit {
+			s.truncated = true
+			return
+		}
+		m.KeyValue = toKeyValue(kv, s.clock)
+		if !s.values {
+			m.Value = ""
+		}
+		s.matches = append(s.matches, m)
+	}
+}
+
+// SearchHandler matches key paths, and with ?values=true also values,
+// against a glob or regular expression, scanning ?prefix= in batches or
+// searching the cache when it covers the prefix.
+func SearchHandler(client clientv3.KV, cache *KeyspaceCache, clock *RevisionClock, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := c.Query("q")
+		if q == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query is required"})
+			return
+		}
+
+		var re *regexp.Regexp
+		var err error
+		switch c.DefaultQuery("mode", "glob") {
+		case "glob":
+			re, err = globToRegexp(q)
+		case "regex":
+			re, err = regexp.Compile(q)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode, expected glob or regex"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pattern: " + err.Error()})
+			return
+		}
+
+		limit := defaultSearchLimit
+		if s := c.Query("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+		}
+		searchValues := c.Query("values") == "true"
+		prefix := c.DefaultQuery("prefix", "/")
+
+		search := &keySearch{re: re, values: searchValues, limit: limit, clock: clock, matches: []SearchMatch{}}
+		if kvs, rev, ok := cache.Range(prefix); ok {
+			setStaleness(c, cache, false)
+			search.add(kvs)
+			c.JSON(http.StatusOK, gin.H{"matches": search.matches, "truncated": search.truncated, "revision": rev})
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		key := prefix
+		end := clientv3.GetPrefixRangeEnd(prefix)
+		var rev int64
+		ctx, cursor := withReadCursor(ctx)
+		for !search.truncated {
+			opts := []clientv3.OpOption{
+				clientv3.WithRange(end),
+				clientv3.WithLimit(streamBatchSize),
+				clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+				clientv3.WithRev(rev),
+			}
+			if !searchValues {
+				opts = append(opts, clientv3.WithKeysOnly())
+			}
+			resp, err := client.Get(ctx, key, opts...)
+			if err != nil {
+				logger.Error("Error searching keys in etcd", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+				return
+			}
+			rev = resp.Header.Revision
+			search.add(resp.Kvs)
+
+			if !resp.More {
+				break
+			}
+			key = cursor.next(resp)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"matches": search.matches, "truncated": search.truncated, "revision": rev})
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// slackMaxChanges is how many changes one message describes; the rest
+	// are counted.
+	slackMaxChanges = 10
+	// slackSnippetLines and slackSnippetWidth bound each change's diff.
+	slackSnippetLines = 8
+	slackSnippetWidth = 120
+)
+
+// SlackTarget posts changes to keys under Prefix to an incoming webhook, at
+// most once per Throttle. Changes made in between are posted together.
+type SlackTarget struct {
+	Prefix     string
+	WebhookURL string
+	Throttle   time.Duration
+}
+
+// ParseSlackTargets parses "/prefix/=webhook-url" entries, each optionally
+// followed by ";throttle" (e.g. ";5m") to override the default throttle.
+func ParseSlackTargets(entries []string, throttle time.Duration) ([]SlackTarget, error) {
+	var targets []SlackTarget
+	for _, entry := range entries {
+		prefix, rest, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") {
+			return nil, errors.New("invalid target " + strconv.Quote(entry) + ", expected /prefix/=webhook-url[;throttle]")
+		}
+		target := SlackTarget{Prefix: prefix, WebhookURL: rest, Throttle: throttle}
+		if webhook, d, ok := strings.Cut(rest, ";"); ok {
+			t, err := time.ParseDuration(d)
+			if err != nil || t < 0 {
+				return nil, errors.New("invalid throttle in " + strconv.Quote(entry))
+			}
+			target.WebhookURL, target.Throttle = webhook, t
+		}
+		if u, err := url.Parse(target.WebhookURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, errors.New("invalid webhook URL in target for " + prefix)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+type slackQueue struct {
+	SlackTarget
+	pending []KeyChange
+	// dropped counts changes beyond slackMaxChanges since the last post.
+	dropped int
+	last    time.Time
+}
+
+// SlackNotifier posts a message to a Slack webhook when keys under a
+// target's prefix change, naming who changed what with a short diff.
+type SlackNotifier struct {
+	feed   *changeFeed
+	logger *zap.Logger
+	http   *http.Client
+	queues []*slackQueue
+}
+
+// NewSlackNotifier creates a notifier; call Run to start posting. audit may
+// be nil, in which case no change names its writer.
+func NewSlackNotifier(client *clientv3.Client, audit *AuditLog, logger *zap.Logger, targets []SlackTarget) *SlackNotifier {
+	n := &SlackNotifier{logger: logger, http: &http.Client{Timeout: 10 * time.Second}}
+	prefixes := make([]string, len(targets))
+	for i, target := range targets {
+		prefixes[i] = target.Prefix
+		n.queues = append(n.queues, &slackQueue{SlackTarget: target})
+	}
+	n.feed = newChangeFeed(client, audit, logger, "slack", prefixes)
+	return n
+}
+
+// Run posts changes until ctx is cancelled.
+func (n *SlackNotifier) Run(ctx context.Context) {
+	changes := make(chan KeyChange)
+	go n.feed.run(ctx, changes)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case change := <-changes:
+			n.enqueue(change)
+		case now := <-tick.C:
+			for _, q := range n.queues {
+				if len(q.pending) > 0 && now.Sub(q.last) >= q.Throttle {
+					n.post(ctx, q)
+					q.pending, q.dropped, q.last = nil, 0, now
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// enqueue adds change to the queue of every target whose prefix it is under.
+func (n *SlackNotifier) enqueue(change KeyChange) {
+	for _, q := range n.queues {
+		if !change.under(q.Prefix) {
+			continue
+		}
+		if len(q.pending) < slackMaxChanges {
+			q.pending = append(q.pending, change)
+		} else {
+			q.dropped++
+		}
+	}
+}
+
+func (n *SlackNotifier) post(ctx context.Context, q *slackQueue) {
+	body, err := json.Marshal(map[string]string{"text": slackMessage(q.Prefix, q.pending, q.dropped)})
+	if err == nil {
+		err = n.send(ctx, q.WebhookURL, body)
+	}
+	if err != nil {
+		n.logger.Warn("Cannot post Slack notification", zap.String("prefix", q.Prefix), zap.Error(err))
+	}
+}
+
+func (n *SlackNotifier) send(ctx context.Context, webhook string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("slack webhook: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// slackEscape escapes the characters Slack treats as markup in message text.
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackMessage formats changes under prefix, plus a count of dropped ones,
+// in Slack mrkdwn.
+func slackMessage(prefix string, changes []KeyChange, dropped int) string {
+	var b strings.Builder
+	total := dropped
+	for _, change := range changes {
+		if change.MissedFrom == 0 {
+			total++
+		}
+	}
+	switch total {
+	case 0:
+		fmt.Fprintf(&b, "Missed changes under `%s`\n", slackEscape.Replace(prefix))
+	case 1:
+		fmt.Fprintf(&b, "1 change under `%s`\n", slackEscape.Replace(prefix))
+	default:
+		fmt.Fprintf(&b, "%d changes under `%s`\n", total, slackEscape.Replace(prefix))
+	}
+	for _, change := range changes {
+		if change.MissedFrom > 0 {
+			fmt.Fprintf(&b, "• Changes after revision %d up to %d were compacted before they could be notified; check `%s` for its current state\n",
+				change.MissedFrom, change.Revision, slackEscape.Replace(prefix))
+			continue
+		}
+		actor := change.Actor
+		if actor == "" {
+			actor = "Someone outside the gateway"
+		}
+		verb := "updated"
+		switch {
+		case change.Deleted:
+			verb = "deleted"
+		case change.Created:
+			verb = "created"
+		}
+		fmt.Fprintf(&b, "• *%s* %s `%s` at revision %d\n", slackEscape.Replace(actor), verb, slackEscape.Replace(change.Key), change.Revision)
+		if change.Deleted {
+			continue
+		}
+		// Backticks would end the code block early.
+		snippet := strings.Join(diffSnippet(change.Before, change.After, slackSnippetLines, slackSnippetWidth), "\n")
+		fmt.Fprintf(&b, "```%s```\n", slackEscape.Replace(strings.ReplaceAll(snippet, "```", "'''")))
+	}
+	if dropped > 0 {
+		fmt.Fprintf(&b, "…and %d more\n", dropped)
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+type requestTimingKey struct{}
+
+// requestTiming accumulates the etcd calls made while serving a request.
+type requestTiming struct {
+	start time.Time
+
+	mu          sync.Mutex
+	calls       int
+	etcd        time.Duration
+	first, last time.Time
+	slowest     time.Duration
+	slowestCall string
+	slowestKey  string
+}
+
+func (t *requestTiming) record(method, key string, start time.Time, rtt time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls++
+	t.etcd += rtt
+	if t.first.IsZero() || start.Before(t.first) {
+		t.first = start
+	}
+	if end := start.Add(rtt); end.After(t.last) {
+		t.last = end
+	}
+	if rtt > t.slowest {
+		t.slowest, t.slowestCall, t.slowestKey = rtt, method[strings.LastIndexByte(method, '/')+1:], key
+	}
+}
+
+// etcdRequestKey is the key an etcd request is for, if it has one.
+func etcdRequestKey(req interface{}) string {
+	switch r := req.(type) {
+	case *pb.RangeRequest:
+		return string(r.Key)
+	case *pb.PutRequest:
+		return string(r.Key)
+	case *pb.DeleteRangeRequest:
+		return string(r.Key)
+	}
+	return ""
+}
+
+// EtcdTimingInterceptor records the round trip of each unary etcd call in
+// the timing of the request it was made for, if SlowRequestMiddleware is
+// timing one. Install it with grpc.WithChainUnaryInterceptor so it runs
+// inside the client's retries and times each attempt.
+func EtcdTimingInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	t, _ := ctx.Value(requestTimingKey{}).(*requestTiming)
+	if t == nil {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	t.record(method, etcdRequestKey(req), start, time.Since(start))
+	return err
+}
+
+// SlowRequestMiddleware logs requests taking threshold or longer at WARN
+// with where the time went: queue is the time before the first etcd call,
+// etcd the sum of the calls' round trips, and serialization the time after
+// the last call returned, mostly spent encoding and writing the response.
+// Calls made concurrently can add up to more than the request took.
+func SlowRequestMiddleware(threshold time.Duration, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := &requestTiming{start: time.Now()}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestTimingKey{}, t))
+
+		c.Next()
+
+		end := time.Now()
+		latency := end.Sub(t.start)
+		if latency < threshold {
+			return
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		fields := []zap.Field{
+			zap.String("requestId", requestID(c)),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("route", c.FullPath()),
+			zap.String("query", c.Request.URL.RawQuery),
+			zap.Int("status", c.Writer.Status()),
+			zap.Duration("latency", latency),
+			zap.Int("etcdCalls", t.calls),
+		}
+		if t.calls > 0 {
+			fields = append(fields,
+				zap.Duration("queue", t.first.Sub(t.start)),
+				zap.Duration("etcd", t.etcd),
+				zap.Duration("serialization", end.Sub(t.last)),
+				zap.String("slowestCall", t.slowestCall),
+				zap.String("slowestKey", t.slowestKey),
+				zap.Duration("slowestCallLatency", t.slowest),
+			)
+		}
+		logger.Warn("Slow request", fields...)
+	}
+}
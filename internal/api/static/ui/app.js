@@ -0,0 +1,78 @@
+// Minimal key browser. Keys are addressed as /ui/key/<key>, which the
+// gateway answers with index.html so deep links and reloads work.
+(function () {
+  "use strict";
+
+  var tree = document.getElementById("tree");
+  var detail = document.getElementById("detail");
+
+  function keyFromLocation() {
+    var m = location.pathname.match(/^\/ui\/key(\/.*)$/);
+    return m ? decodeURIComponent(m[1]) : null;
+  }
+
+  function text(tag, s) {
+    var el = document.createElement(tag);
+    el.textContent = s;
+    return el;
+  }
+
+  function showKey(key) {
+    document.querySelectorAll("#tree a.selected").forEach(function (a) { a.classList.remove("selected"); });
+    var link = document.querySelector('#tree a[data-key="' + CSS.escape(key) + '"]');
+    if (link) link.classList.add("selected");
+
+    detail.replaceChildren(text("h2", key));
+    fetch("/api/v1/value/" + encodeURI(key) + "?annotations=true")
+      .then(function (r) { return r.json().then(function (body) { return { ok: r.ok, body: body }; }); })
+      .then(function (res) {
+        if (!res.ok) {
+          detail.appendChild(text("p", res.body.error || "Cannot load key"));
+          return;
+        }
+        if (res.body.modifiedAt) detail.appendChild(text("p", "Modified " + res.body.modifiedAt));
+        var a = res.body.annotation;
+        if (a && a.description) detail.appendChild(text("p", a.description));
+        if (a && a.owner) detail.appendChild(text("p", "Owner: " + a.owner));
+        detail.appendChild(text("pre", res.body.value));
+      });
+  }
+
+  function render(nodes) {
+    var ul = document.createElement("ul");
+    nodes.forEach(function (node) {
+      var li = document.createElement("li");
+      var a = text("a", node.name + (node.children && node.children.length ? "/" : ""));
+      if (node.children && node.children.length) {
+        var sub = render(node.children);
+        sub.hidden = true;
+        a.onclick = function () { sub.hidden = !sub.hidden; };
+        li.append(a, sub);
+      } else {
+        a.dataset.key = node.id;
+        a.href = "key" + node.id;
+        a.onclick = function (e) {
+          e.preventDefault();
+          history.pushState(null, "", "/ui/key" + node.id);
+          showKey(node.id);
+        };
+        li.append(a);
+      }
+      ul.appendChild(li);
+    });
+    return ul;
+  }
+
+  fetch("/api/v1/keys")
+    .then(function (r) { return r.json(); })
+    .then(function (nodes) {
+      tree.replaceChildren(render(nodes || []));
+      var key = keyFromLocation();
+      if (key) showKey(key);
+    });
+
+  window.onpopstate = function () {
+    var key = keyFromLocation();
+    if (key) showKey(key);
+  };
+})();
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"container/heap"
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// defaultStatsTop is how many of the largest keys a stats report lists.
+const defaultStatsTop = 10
+
+// keySizeHeap is a min-heap, so the smallest of the current top N is evicted first.
+type keySizeHeap []KeySize
+
+func (h keySizeHeap) Len() int            { return len(h) }
+func (h keySizeHeap) Less(i, j int) bool  { return h[i].Size < h[j].Size }
+func (h keySizeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keySizeHeap) Push(x interface{}) { *h = append(*h, x.(KeySize)) }
+func (h *keySizeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// topKeySizes tracks the n largest values seen.
+type topKeySizes struct {
+	n int
+	h keySizeHeap
+}
+
+func (t *topKeySizes) add(key string, size int) {
+	if t.n <= 0 {
+		return
+	}
+	if len(t.h) < t.n {
+		heap.Push(&t.h, KeySize{Key: key, Size: size})
+	} else if size > t.h[0].Size {
+		t.h[0] = KeySize{Key: key, Size: size}
+		heap.Fix(&t.h, 0)
+	}
+}
+
+// sorted returns the tracked keys, largest first.
+func (t *topKeySizes) sorted() []KeySize {
+	out := append([]KeySize{}, t.h...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Size > out[j].Size })
+	return out
+}
+
+// scanPrefix calls fn for every key under prefix, reading in batches pinned to
+// the revision of the first batch so the scan sees a consistent snapshot. It
+// returns that revision. An empty prefix scans the whole keyspace.
+func scanPrefix(ctx context.Context, client clientv3.KV, prefix string, fn func(kv *mvccpb.KeyValue)) (int64, error) {
+	return scanPrefixAt(ctx, client, prefix, 0, fn)
+}
+
+// scanPrefixAt is scanPrefix reading at rev, or the current revision if 0.
+// Extra opts such as WithKeysOnly are applied to every batch.
+func scanPrefixAt(ctx context.Context, client clientv3.KV, prefix string, rev int64, fn func(kv *mvccpb.KeyValue), opts ...clientv3.OpOption) (int64, error) {
+	key := prefix
+	end := clientv3.GetPrefixRangeEnd(prefix)
+	if key == "" {
+		key = "\x00"
+	}
+	ctx, cursor := withReadCursor(ctx)
+	for {
+		resp, err := client.Get(ctx, key, append([]clientv3.OpOption{
+			clientv3.WithRange(end),
+			clientv3.WithLimit(streamBatchSize),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+			clientv3.WithRev(rev),
+		}, opts...)...)
+		if err != nil {
+			return 0, err
+		}
+		if rev == 0 {
+			rev = resp.Header.Revision
+		}
+		for _, kv := range resp.Kvs {
+			fn(kv)
+		}
+		if !resp.More {
+			return rev, nil
+		}
+		key = cursor.next(resp)
+	}
+}
+
+// StatsHandler reports the key count, sizes, depth and largest keys under
+// ?prefix=. ?top= sets how many of the largest keys are listed.
+func StatsHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats := PrefixStats{Prefix: c.DefaultQuery("prefix", "/")}
+		top := topKeySizes{n: defaultStatsTop}
+		if s := c.Query("top"); s != "" {
+			var err error
+			if top.n, err = strconv.Atoi(s); err != nil || top.n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid top"})
+				return
+			}
+		}
+
+		ctx, cancel := longRequestContext(c, 30*time.Second)
+		defer cancel()
+
+		rev, err := scanPrefix(ctx, client, stats.Prefix, func(kv *mvccpb.KeyValue) {
+			stats.Count++
+			stats.KeyBytes += int64(len(kv.Key))
+			stats.ValueBytes += int64(len(kv.Value))
+			if depth := strings.Count(strings.Trim(string(kv.Key), "/"), "/") + 1; depth > stats.MaxDepth {
+				stats.MaxDepth = depth
+				stats.DeepestPath = string(kv.Key)
+			}
+			top.add(string(kv.Key), len(kv.Value))
+		})
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		stats.Revision = rev
+		stats.LargestKeys = top.sorted()
+		c.JSON(http.StatusOK, stats)
+	}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// streamBatchSize is how many keys are read from etcd per round trip while streaming.
+const streamBatchSize = 1000
+
+// treeStreamWriter writes top-level tree nodes, or the keys of a flat
+// listing, to the response as soon as they are complete, either as the
+// elements of a JSON array or as NDJSON.
+type treeStreamWriter struct {
+	c       *gin.Context
+	ndjson  bool
+	fields  fieldSet
+	started bool
+	count   int
+}
+
+func (w *treeStreamWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	if w.ndjson {
+		w.c.Header("Content-Type", "application/x-ndjson")
+	} else {
+		w.c.Header("Content-Type", "application/json; charset=utf-8")
+	}
+	w.c.Status(http.StatusOK)
+	if !w.ndjson {
+		w.c.Writer.WriteString("[")
+	}
+}
+
+func (w *treeStreamWriter) write(node interface{}) error {
+	w.start()
+	v, err := w.fields.apply(node)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	switch {
+	case w.ndjson:
+		b = append(b, '\n')
+	case w.count > 0:
+		w.c.Writer.WriteString(",")
+	}
+	w.count++
+	if _, err := w.c.Writer.Write(b); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+func (w *treeStreamWriter) finish() {
+	w.start()
+	if !w.ndjson {
+		w.c.Writer.WriteString("]")
+	}
+}
+
+// wantsNDJSON reports whether the client asked for newline delimited JSON.
+func wantsNDJSON(c *gin.Context) bool {
+	return c.Query("format") == "ndjson" || strings.Contains(c.GetHeader("Accept"), "application/x-ndjson")
+}
+
+// streamKeys writes the key tree, or flat listing, under q.prefix without
+// materializing it. Keys are read in key order in batches pinned to a single
+// revision, and each top-level node is written out once no key still to
+// come can be beneath it, so peak memory is bounded by the largest top-level
+// subtree, and the names sorting between it and its children, rather than
+// the store.
+func streamKeys(ctx context.Context, c *gin.Context, client clientv3.KV, q keysQuery, logger *zap.Logger) {
+	w := &treeStreamWriter{c: c, ndjson: wantsNDJSON(c), fields: q.fields}
+	root := &TreeNode{Name: "root"}
+
+	// emit writes the top-level nodes complete before key, or all of them
+	// when key is empty. Names such as a-b and a.b sort between a and a/,
+	// so a node is only complete once keys have passed all of its own.
+	emit := func(key string) error {
+		for len(root.Children) > 0 {
+			node := root.Children[0]
+			if key != "" && key < clientv3.GetPrefixRangeEnd("/"+node.Name+"/") {
+				return nil
+			}
+			if q.depth > 0 {
+				truncateTree([]*TreeNode{node}, q.depth)
+			}
+			if err := w.write(node); err != nil {
+				return err
+			}
+			root.Children = root.Children[1:]
+		}
+		return nil
+	}
+
+	key := q.prefix
+	end := clientv3.GetPrefixRangeEnd(q.prefix)
+	var rev int64
+	ctx, cursor := withReadCursor(ctx)
+	for {
+		opts := append([]clientv3.OpOption{
+			clientv3.WithRange(end),
+			clientv3.WithLimit(streamBatchSize),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+			clientv3.WithRev(rev),
+		}, q.opts...)
+		resp, err := client.Get(ctx, key, opts...)
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			if !w.started {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			}
+			// Otherwise the status line has already been sent; the truncated
+			// body is the only signal left to give the client.
+			return
+		}
+		rev = resp.Header.Revision
+
+		if q.flat {
+			for _, key := range flatKeys(resp.Kvs) {
+				if err := w.write(key); err != nil {
+					return
+				}
+			}
+		} else {
+			for _, kv := range resp.Kvs {
+				if err := emit(string(kv.Key)); err != nil {
+					return
+				}
+				keyParts := strings.Split(string(kv.Key), "/")[1:]
+				insertNode(root, keyParts, string(kv.Value))
+			}
+		}
+
+		if !resp.More {
+			break
+		}
+		key = cursor.next(resp)
+	}
+
+	if err := emit(""); err != nil {
+		return
+	}
+	w.finish()
+}
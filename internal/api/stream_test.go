@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	"go.uber.org/zap"
+)
+
+// expectedTree builds the tree of keys as the unstreamed listing does.
+func expectedTree(keys map[string]string) []*TreeNode {
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	root := &TreeNode{Name: "root"}
+	for _, key := range sorted {
+		insertNode(root, strings.Split(key, "/")[1:], keys[key])
+	}
+	return root.Children
+}
+
+func TestStreamKeys(t *testing.T) {
+	many := map[string]string{}
+	for i := 0; i < streamBatchSize+5; i++ {
+		many[fmt.Sprintf("/big/%04d", i)] = "v"
+	}
+	many["/big-x"] = "1"
+	many["/big/zz/a"] = "2"
+
+	tests := []struct {
+		name string
+		keys map[string]string
+	}{
+		{"single", map[string]string{"/a": "1"}},
+		{"siblings", map[string]string{"/a/x": "1", "/b/y": "2", "/c": "3"}},
+		// - and . sort before /, so a-b comes between a and a's children.
+		{"names sorting before slash", map[string]string{"/a": "1", "/a-b/x": "2", "/a/c": "3", "/a.d": "4", "/a/e/f": "5", "/b": "6"}},
+		{"across batches", many},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := apitest.NewStore()
+			for key, value := range tt.keys {
+				if _, err := store.KV().Put(context.Background(), key, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, _ := json.Marshal(expectedTree(tt.keys))
+
+			rec := apitest.Serve("/keys", httptest.NewRequest("GET", "/keys?prefix=/", nil), FetchKeysHandler(store.KV(), nil, zap.NewNop()))
+			if rec.Code != 200 {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Body.String(); got != string(want) {
+				t.Errorf("got %s\nwant %s", got, want)
+			}
+
+			rec = apitest.Serve("/keys", httptest.NewRequest("GET", "/keys?prefix=/&format=ndjson", nil), FetchKeysHandler(store.KV(), nil, zap.NewNop()))
+			var nodes []*TreeNode
+			for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+				var node TreeNode
+				if err := json.Unmarshal([]byte(line), &node); err != nil {
+					t.Fatalf("line %q: %v", line, err)
+				}
+				nodes = append(nodes, &node)
+			}
+			if got, _ := json.Marshal(nodes); string(got) != string(want) {
+				t.Errorf("ndjson got %s\nwant %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// tagPrefix holds named revisions of prefixes.
+const tagPrefix = "/.tags/"
+
+var errTagNotFound = errors.New("tag not found")
+
+// getTag reads the named tag.
+func getTag(ctx context.Context, client clientv3.KV, name string) (Tag, error) {
+	var tag Tag
+	resp, err := client.Get(ctx, tagPrefix+name)
+	if err != nil {
+		return tag, err
+	}
+	if len(resp.Kvs) == 0 {
+		return tag, errTagNotFound
+	}
+	err = json.Unmarshal(resp.Kvs[0].Value, &tag)
+	return tag, err
+}
+
+// tagError writes the response for an error reading a tag or its keys.
+func tagError(c *gin.Context, err error, logger *zap.Logger) {
+	switch {
+	case errors.Is(err, errTagNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+	case errors.Is(err, rpctypes.ErrCompacted):
+		c.JSON(http.StatusGone, gin.H{"error": "The tagged revision has been compacted"})
+	default:
+		logger.Error("Error reading tag from etcd", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+	}
+}
+
+// TagCreateHandler names the current revision of a prefix.
+func TagCreateHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TagRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if !flagNamePattern.MatchString(req.Name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tag names may only contain letters, digits, '.', '_' and '-'"})
+			return
+		}
+		if !strings.HasPrefix(req.Prefix, "/") || !strings.HasSuffix(req.Prefix, "/") || reservedPrefix(req.Prefix) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Prefix must start and end with / and not be the root or a gateway prefix"})
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Get(ctx, req.Prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		tag := Tag{
+			Name:      req.Name,
+			Prefix:    req.Prefix,
+			Revision:  resp.Header.Revision,
+			CreatedBy: requestActor(c),
+			CreatedAt: time.Now(),
+		}
+		data, err := json.Marshal(tag)
+		if err != nil {
+			logger.Error("Error encoding tag", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		txn, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(tagPrefix+tag.Name), "=", 0)).
+			Then(clientv3.OpPut(tagPrefix+tag.Name, string(data))).
+			Commit()
+		if err != nil {
+			logger.Error("Error writing tag to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if !txn.Succeeded {
+			c.JSON(http.StatusConflict, gin.H{"error": "Tag already exists"})
+			return
+		}
+		c.JSON(http.StatusCreated, tag)
+	}
+}
+
+// TagListHandler lists tags, optionally only those of ?prefix.
+func TagListHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Get(ctx, tagPrefix, clientv3.WithPrefix())
+		if err != nil {
+			logger.Error("Error fetching tags from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		prefix := c.Query("prefix")
+		tags := []Tag{}
+		for _, kv := range resp.Kvs {
+			var tag Tag
+			if err := json.Unmarshal(kv.Value, &tag); err != nil {
+				logger.Warn("Skipping unreadable tag", zap.ByteString("key", kv.Key), zap.Error(err))
+				continue
+			}
+			if prefix == "" || tag.Prefix == prefix {
+				tags = append(tags, tag)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"tags": tags})
+	}
+}
+
+// TagDeleteHandler removes a tag. The tagged keys are not affected.
+func TagDeleteHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Delete(ctx, tagPrefix+c.Param("name"))
+		if err != nil {
+			logger.Error("Error deleting tag from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if resp.Deleted == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// TagValuesHandler returns the keys under a tag's prefix as they were at the
+// tagged revision.
+func TagValuesHandler(client clientv3.KV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := longRequestContext(c, 30*time.Second)
+		defer cancel()
+		tag, err := getTag(ctx, client, c.Param("name"))
+		if err != nil {
+			tagError(c, err, logger)
+			return
+		}
+		kvs := []KeyValue{}
+		if _, err := scanPrefixAt(ctx, client, tag.Prefix, tag.Revision, func(kv *mvccpb.KeyValue) {
+			kvs = append(kvs, toKeyValue(kv, nil))
+		}); err != nil {
+			tagError(c, err, logger)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tag": tag, "kvs": kvs})
+	}
+}
+
+// TagDiffHandler compares a tag's prefix at the tagged revision (left) with
+// its current state, or with another tag of the same prefix given as ?against.
+func TagDiffHandler(client *clientv3.Client, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := longRequestContext(c, 30*time.Second)
+		defer cancel()
+		tag, err := getTag(ctx, client, c.Param("name"))
+		if err != nil {
+			tagError(c, err, logger)
+			return
+		}
+		var againstRev int64
+		if name := c.Query("against"); name != "" {
+			against, err := getTag(ctx, client, name)
+			if err != nil {
+				tagError(c, err, logger)
+				return
+			}
+			if against.Prefix != tag.Prefix {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Tags must be of the same prefix"})
+				return
+			}
+			againstRev = against.Revision
+		}
+		d, err := diffPrefixes(ctx, client, client, tag.Prefix, tag.Prefix, tag.Revision, againstRev)
+		if err != nil {
+			tagError(c, err, logger)
+			return
+		}
+		c.JSON(http.StatusOK, d)
+	}
+}
+
+// TagRollbackHandler restores a tag's prefix to its tagged state in one
+// transaction: keys created since are deleted and changed keys reverted.
+func TagRollbackHandler(client clientv3.KV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := longRequestContext(c, 30*time.Second)
+		defer cancel()
+		tag, err := getTag(ctx, client, c.Param("name"))
+		if err != nil {
+			tagError(c, err, logger)
+			return
+		}
+		desired := DesiredState{Prefix: tag.Prefix, Values: make(map[string]string)}
+		if _, err := scanPrefixAt(ctx, client, tag.Prefix, tag.Revision, func(kv *mvccpb.KeyValue) {
+			desired.Values[strings.TrimPrefix(string(kv.Key), tag.Prefix)] = string(kv.Value)
+		}); err != nil {
+			tagError(c, err, logger)
+			return
+		}
+		plan, err := computePlan(ctx, client, desired)
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if len(plan.Changes) > maxTxnOps {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Rollbacks are limited to %d changes", maxTxnOps), "changes": len(plan.Changes)})
+			return
+		}
+		changes, rev, err := executePlan(ctx, client, plan, nil)
+		if errors.Is(err, errConflict) {
+			conflictResponse(c, err, gin.H{"error": "Keys changed during the rollback, retry"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error writing keys to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		audit.Record(ctx, requestActor(c), "rollback:"+tag.Name, changes)
+		c.JSON(http.StatusOK, gin.H{"changes": plan.Changes, "revision": rev})
+	}
+}
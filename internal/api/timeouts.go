@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// defaultTimeout bounds each kind of operation unless configured otherwise.
+const defaultTimeout = 5 * time.Second
+
+// requestTimeoutHeader lets a client bound how long its request may take.
+const requestTimeoutHeader = "X-Request-Timeout"
+
+// errWatchTimeout is returned when etcd does not confirm a watch in time.
+var errWatchTimeout = errors.New("watch was not established in time")
+
+// Timeouts bound the etcd calls made while serving a request, by kind of
+// operation. Watch bounds establishing a watch, not how long it stays open.
+// Routes maps a route, as "METHOD /path/:param", to a timeout replacing its
+// operation's. Long operations such as exports and defragmentation run as
+// jobs or scheduled tasks and are not bound by them.
+type Timeouts struct {
+	Read   time.Duration
+	Write  time.Duration
+	Watch  time.Duration
+	Admin  time.Duration
+	Routes map[string]time.Duration
+}
+
+// DefaultTimeouts returns the timeouts used when none are configured.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{Read: defaultTimeout, Write: defaultTimeout, Watch: defaultTimeout, Admin: defaultTimeout}
+}
+
+// ParseRouteTimeouts parses "METHOD /path=duration" entries.
+func ParseRouteTimeouts(entries []string) (map[string]time.Duration, error) {
+	routes := make(map[string]time.Duration, len(entries))
+	for _, entry := range entries {
+		route, value, ok := strings.Cut(entry, "=")
+		method, path, ok2 := strings.Cut(route, " ")
+		if !ok || !ok2 || !strings.HasPrefix(path, "/") {
+			return nil, errors.New("invalid route timeout " + strconv.Quote(entry) + ", expected METHOD /path=duration")
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, errors.New("invalid duration in route timeout " + strconv.Quote(entry))
+		}
+		routes[strings.ToUpper(method)+" "+path] = d
+	}
+	return routes, nil
+}
+
+type timeoutsKey struct{}
+
+// requestTimeouts is what TimeoutMiddleware records in a request's context.
+type requestTimeouts struct {
+	Timeouts
+	route     time.Duration
+	operation time.Duration
+}
+
+// parseRequestTimeout parses an X-Request-Timeout value, a duration such as
+// "1.5s" or a number of seconds.
+func parseRequestTimeout(v string) (time.Duration, bool) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, false
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	return d, d > 0
+}
+
+// TimeoutMiddleware records the timeouts for the request's handler: the
+// route's own if configured, otherwise Admin under /admin, Read for GET and
+// HEAD and Write for anything else. A client can shorten them, never extend
+// them, with an X-Request-Timeout header, which becomes the request's
+// deadline.
+func TimeoutMiddleware(timeouts Timeouts) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if v := c.GetHeader(requestTimeoutHeader); v != "" {
+			d, ok := parseRequestTimeout(v)
+			if !ok {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid X-Request-Timeout"})
+				return
+			}
+			ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+			defer cancel()
+			c.Request = c.Request.WithContext(ctx)
+		}
+		t := requestTimeouts{Timeouts: timeouts, route: timeouts.Routes[c.Request.Method+" "+c.FullPath()]}
+		switch {
+		case strings.HasPrefix(c.FullPath(), "/admin/"):
+			t.operation = timeouts.Admin
+		case c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead:
+			t.operation = timeouts.Read
+		default:
+			t.operation = timeouts.Write
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), timeoutsKey{}, t))
+		c.Next()
+	}
+}
+
+// timeoutsOf returns the timeouts recorded for the request, or the defaults.
+func timeoutsOf(c *gin.Context) requestTimeouts {
+	if t, ok := c.Request.Context().Value(timeoutsKey{}).(requestTimeouts); ok {
+		return t
+	}
+	d := DefaultTimeouts()
+	return requestTimeouts{Timeouts: d, operation: defaultTimeout}
+}
+
+// requestContext bounds a handler's etcd calls by the route's timeout, or
+// its kind of operation's. It derives from the request's context, so calls
+// also end when the client goes away or its deadline passes.
+func requestContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	t := timeoutsOf(c)
+	if t.route > 0 {
+		return context.WithTimeout(c.Request.Context(), t.route)
+	}
+	return context.WithTimeout(c.Request.Context(), t.operation)
+}
+
+// longRequestContext is requestContext for handlers scanning whole prefixes,
+// which are exempt from the operation timeouts and bounded by d instead.
+func longRequestContext(c *gin.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if t := timeoutsOf(c); t.route > 0 {
+		d = t.route
+	}
+	return context.WithTimeout(c.Request.Context(), d)
+}
+
+// watchCreated opens a watch and waits up to timeout for etcd to confirm it.
+// On error the caller must cancel ctx to release the watch.
+func watchCreated(ctx context.Context, client clientv3.Watcher, timeout time.Duration, key string, opts ...clientv3.OpOption) (clientv3.WatchChan, error) {
+	wch := client.Watch(ctx, key, append(opts, clientv3.WithCreatedNotify())...)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case wresp, ok := <-wch:
+		if !ok {
+			return nil, ctx.Err()
+		}
+		return wch, wresp.Err()
+	case <-timer.C:
+		return nil, errWatchTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// trashPrefix holds soft-deleted keys. Entries are named by deletion time
+// followed by the original key, so they list oldest first and expired ones
+// can be purged with a single range delete.
+const trashPrefix = "/.trash/"
+
+var (
+	errConflict      = errors.New("keys were modified concurrently")
+	errTrashNotFound = errors.New("trash entry not found")
+)
+
+// Trash moves deleted keys under trashPrefix instead of removing them, and
+// purges them once they are older than the retention period.
+type Trash struct {
+	client    clientv3.KV
+	logger    *zap.Logger
+	retention time.Duration
+	// now dates deletions and the purge cutoff.
+	now func() time.Time
+}
+
+// NewTrash creates a trash; call Run to start purging expired entries.
+func NewTrash(client clientv3.KV, logger *zap.Logger, retention time.Duration) *Trash {
+	return &Trash{client: client, logger: logger, retention: retention, now: time.Now}
+}
+
+func trashID(t time.Time, key string) string {
+	return fmt.Sprintf("%019d%s", t.UnixNano(), key)
+}
+
+// Delete moves key, or every key under it when recursive, to the trash and
+// returns the keys moved. Keys are moved in transactions of up to maxTxnOps
+// operations, each guarded against concurrent modification.
+func (t *Trash) Delete(ctx context.Context, key string, recursive bool) ([]*mvccpb.KeyValue, error) {
+	var opts []clientv3.OpOption
+	if recursive {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	resp, err := t.client.Get(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	now := t.now()
+	var moved []*mvccpb.KeyValue
+	for start := 0; start < len(resp.Kvs); start += maxTxnOps / 2 {
+		end := start + maxTxnOps/2
+		if end > len(resp.Kvs) {
+			end = len(resp.Kvs)
+		}
+		var chunk []*mvccpb.KeyValue
+		var cmps []clientv3.Cmp
+		var ops []clientv3.Op
+		for _, kv := range resp.Kvs[start:end] {
+			if strings.HasPrefix(string(kv.Key), trashPrefix) {
+				continue
+			}
+			entry, err := json.Marshal(toTrashEntry(now, kv))
+			if err != nil {
+				return moved, err
+			}
+			chunk = append(chunk, kv)
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision))
+			ops = append(ops,
+				clientv3.OpDelete(string(kv.Key)),
+				clientv3.OpPut(trashPrefix+trashID(now, string(kv.Key)), string(entry)),
+			)
+		}
+		if len(ops) == 0 {
+			continue
+		}
+		if _, err := guardedTxn(t.client.Txn(ctx), cmps, ops...); err != nil {
+			return moved, err
+		}
+		moved = append(moved, chunk...)
+	}
+	return moved, nil
+}
+
+// DeleteKeys moves the given keys to the trash in one transaction and
+// returns those that existed. It fails with a ConflictError if any of them
+// is changed, created or deleted while being moved.
+func (t *Trash) DeleteKeys(ctx context.Context, keys []string) ([]*mvccpb.KeyValue, error) {
+	gets := make([]clientv3.Op, len(keys))
+	for i, key := range keys {
+		gets[i] = clientv3.OpGet(key)
+	}
+	resp, err := t.client.Txn(ctx).Then(gets...).Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	now := t.now()
+	var moved []*mvccpb.KeyValue
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	for i, r := range resp.Responses {
+		kvs := r.GetResponseRange().Kvs
+		if len(kvs) == 0 {
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(keys[i]), "=", 0))
+			continue
+		}
+		kv := kvs[0]
+		entry, err := json.Marshal(toTrashEntry(now, kv))
+		if err != nil {
+			return nil, err
+		}
+		moved = append(moved, kv)
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(keys[i]), "=", kv.ModRevision))
+		ops = append(ops,
+			clientv3.OpDelete(keys[i]),
+			clientv3.OpPut(trashPrefix+trashID(now, keys[i]), string(entry)),
+		)
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	if _, err := guardedTxn(t.client.Txn(ctx), cmps, ops...); err != nil {
+		return nil, err
+	}
+	return moved, nil
+}
+
+func toTrashEntry(now time.Time, kv *mvccpb.KeyValue) TrashEntry {
+	return TrashEntry{
+		ID:        trashID(now, string(kv.Key)),
+		Key:       string(kv.Key),
+		Value:     string(kv.Value),
+		DeletedAt: now,
+		Revision:  kv.ModRevision,
+	}
+}
+
+// List returns the trash entries whose original key starts with prefix.
+func (t *Trash) List(ctx context.Context, prefix string) ([]TrashEntry, error) {
+	entries := []TrashEntry{}
+	var decodeErr error
+	_, err := scanPrefix(ctx, t.client, trashPrefix, func(kv *mvccpb.KeyValue) {
+		var entry TrashEntry
+		if err := json.Unmarshal(kv.Value, &entry); err != nil {
+			decodeErr = err
+			return
+		}
+		if strings.HasPrefix(entry.Key, prefix) {
+			entries = append(entries, entry)
+		}
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	return entries, err
+}
+
+// Restore puts a trash entry back at its original key. Unless overwrite is
+// set, restoring fails if the key has been recreated in the meantime. The
+// returned change describes the restored key.
+func (t *Trash) Restore(ctx context.Context, id string, overwrite bool) (TrashEntry, AuditChange, error) {
+	var entry TrashEntry
+	resp, err := t.client.Get(ctx, trashPrefix+id)
+	if err != nil {
+		return entry, AuditChange{}, err
+	}
+	if len(resp.Kvs) == 0 {
+		return entry, AuditChange{}, errTrashNotFound
+	}
+	if err := json.Unmarshal(resp.Kvs[0].Value, &entry); err != nil {
+		return entry, AuditChange{}, err
+	}
+
+	cmps := []clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(trashPrefix+id), "=", resp.Kvs[0].ModRevision)}
+	if !overwrite {
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(entry.Key), "=", 0))
+	}
+	txn, err := guardedTxn(t.client.Txn(ctx), cmps,
+		clientv3.OpPut(entry.Key, entry.Value, clientv3.WithPrevKV()),
+		clientv3.OpDelete(trashPrefix+id),
+	)
+	if err != nil {
+		return entry, AuditChange{}, err
+	}
+	return entry, putChange(entry.Key, txn.Responses[0].GetResponsePut().PrevKv, txn.Header.Revision), nil
+}
+
+// Purge removes entries older than the retention period.
+func (t *Trash) Purge(ctx context.Context) error {
+	cutoff := trashPrefix + fmt.Sprintf("%019d", t.now().Add(-t.retention).UnixNano())
+	resp, err := t.client.Delete(ctx, trashPrefix, clientv3.WithRange(cutoff))
+	if err != nil {
+		return err
+	}
+	if resp.Deleted > 0 {
+		t.logger.Info("Purged expired trash entries", zap.Int64("count", resp.Deleted))
+	}
+	return nil
+}
+
+// Run purges expired entries until ctx is cancelled.
+func (t *Trash) Run(ctx context.Context) {
+	interval := t.retention / 10
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := t.Purge(ctx); err != nil && ctx.Err() == nil {
+			t.logger.Warn("Cannot purge trash", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// TrashListHandler lists soft-deleted keys, optionally only those whose
+// original key is under ?prefix=.
+func TrashListHandler(trash *Trash, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if trash == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trash is not enabled"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		entries, err := trash.List(ctx, c.Query("prefix"))
+		if err != nil {
+			logger.Error("Error listing trash", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": entries})
+	}
+}
+
+// TrashRestoreHandler moves a trash entry back to its original key.
+func TrashRestoreHandler(trash *Trash, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if trash == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trash is not enabled"})
+			return
+		}
+		var req TrashRestoreRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		entry, change, err := trash.Restore(ctx, req.ID, req.Overwrite)
+		switch {
+		case errors.Is(err, errTrashNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trash entry not found"})
+		case errors.Is(err, errConflict):
+			conflictResponse(c, err, gin.H{"error": "Key already exists, set overwrite to replace it"})
+		case err != nil:
+			logger.Error("Error restoring key from trash", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		default:
+			audit.Record(ctx, requestActor(c), "restore", []AuditChange{change})
+			c.JSON(http.StatusOK, entry)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"etcd-gateway/internal/api/apitest"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+func TestTrashRestore(t *testing.T) {
+	store := apitest.NewStore()
+	kv := store.KV()
+	ctx := context.Background()
+	for _, key := range []string{"/app/a", "/app/b", "/other"} {
+		if _, err := kv.Put(ctx, key, "v"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deletedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	trash := NewTrash(kv, zap.NewNop(), time.Hour)
+	trash.now = func() time.Time { return deletedAt }
+	audit := NewAuditLog(kv, zap.NewNop(), time.Hour)
+	audit.now = func() time.Time { return deletedAt }
+
+	rec := apitest.Serve("/value/*key", httptest.NewRequest("DELETE", "/value//app/?recursive=true", nil), DeleteValueHandler(kv, trash, audit, zap.NewNop()))
+	if rec.Code != 200 || rec.Body.String() != `{"deleted":2,"trashed":true}` {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = apitest.Serve("/trash", httptest.NewRequest("GET", "/trash?prefix=/app/", nil), TrashListHandler(trash, zap.NewNop()))
+	var list struct{ Entries []TrashEntry }
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Entries) != 2 {
+		t.Fatalf("listed %s", rec.Body)
+	}
+	for _, entry := range list.Entries {
+		if entry.ID != trashID(deletedAt, entry.Key) || !entry.DeletedAt.Equal(deletedAt) || entry.Value != "v"+entry.Key {
+			t.Errorf("got entry %+v", entry)
+		}
+	}
+
+	// /app/b is recreated before it is restored.
+	if _, err := kv.Put(ctx, "/app/b", "new"); err != nil {
+		t.Fatal(err)
+	}
+	idA, idB := trashID(deletedAt, "/app/a"), trashID(deletedAt, "/app/b")
+	tests := []struct {
+		name      string
+		body      string
+		wantCode  int
+		key       string
+		wantValue string
+	}{
+		{"restore", `{"id":"` + idA + `"}`, 200, "/app/a", "v/app/a"},
+		{"already restored", `{"id":"` + idA + `"}`, 404, "/app/a", "v/app/a"},
+		{"recreated", `{"id":"` + idB + `"}`, 409, "/app/b", "new"},
+		{"overwrite", `{"id":"` + idB + `","overwrite":true}`, 200, "/app/b", "v/app/b"},
+		{"no id", `{}`, 400, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/trash/restore", strings.NewReader(tt.body))
+			rec := apitest.Serve("/trash/restore", req, TrashRestoreHandler(trash, audit, zap.NewNop()))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.key == "" {
+				return
+			}
+			resp, err := kv.Get(ctx, tt.key)
+			if err != nil || len(resp.Kvs) == 0 || string(resp.Kvs[0].Value) != tt.wantValue {
+				t.Errorf("%s holds %v, want %q", tt.key, resp.Kvs, tt.wantValue)
+			}
+		})
+	}
+
+	entries, _, err := audit.List(ctx, "/app/", 10, continueToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, entry := range entries {
+		if !entry.Time.Equal(deletedAt) {
+			t.Errorf("entry %s recorded at %v", entry.ID, entry.Time)
+		}
+		actions = append(actions, entry.Action)
+	}
+	if strings.Join(actions, ",") != "restore,restore,delete" {
+		t.Errorf("audited %v", actions)
+	}
+
+	// Entries expire a retention period after their deletion.
+	trash.now = func() time.Time { return deletedAt.Add(2 * time.Hour) }
+	if _, err := kv.Put(ctx, "/other", "v/other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trash.Delete(ctx, "/other", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := trash.Purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	left, err := trash.List(ctx, "")
+	if err != nil || len(left) != 1 || left[0].Key != "/other" {
+		t.Errorf("left %+v, error %v", left, err)
+	}
+}
+
+func TestDeleteValueReserved(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		wantCode int
+		wantKeys []string
+	}{
+		{"key", "/value//app/a", 200, []string{"/.audit/x", "/app/b"}},
+		{"prefix", "/value//app/?recursive=true", 200, []string{"/.audit/x"}},
+		{"root", "/value//?recursive=true", 400, []string{"/.audit/x", "/app/a", "/app/b"}},
+		{"reserved key", "/value//.audit/x", 400, []string{"/.audit/x", "/app/a", "/app/b"}},
+		{"reserved prefix", "/value//.au?recursive=true", 400, []string{"/.audit/x", "/app/a", "/app/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := apitest.NewStore()
+			kv := store.KV()
+			for _, key := range []string{"/app/a", "/app/b", "/.audit/x"} {
+				if _, err := kv.Put(context.Background(), key, "v"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rec := apitest.Serve("/value/*key", httptest.NewRequest("DELETE", tt.url, nil), DeleteValueHandler(kv, nil, nil, zap.NewNop()))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			resp, err := kv.Get(context.Background(), "/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := keysOf(resp); !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("got keys %v, want %v", got, tt.wantKeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import "etcd-gateway/pkg/types"
+
+// The bodies the API reads and writes are defined in pkg/types, where
+// pkg/client shares them; the handlers keep using these names for them.
+type (
+	Annotation             = types.Annotation
+	AnnotatedKey           = types.AnnotatedKey
+	AuditChange            = types.AuditChange
+	AuditEntry             = types.AuditEntry
+	RestorePoint           = types.RestorePoint
+	BatchGetRequest        = types.BatchGetRequest
+	BatchPut               = types.BatchPut
+	BatchPutRequest        = types.BatchPutRequest
+	BatchDeleteRequest     = types.BatchDeleteRequest
+	BatchDeleteResult      = types.BatchDeleteResult
+	ChangeEvent            = types.ChangeEvent
+	ChildNode              = types.ChildNode
+	ClusterProfile         = types.ClusterProfile
+	KeyConflict            = types.KeyConflict
+	ConsulImportRequest    = types.ConsulImportRequest
+	ConsulImportResult     = types.ConsulImportResult
+	CopyRequest            = types.CopyRequest
+	EndpointHealth         = types.EndpointHealth
+	Alarm                  = types.Alarm
+	Dashboard              = types.Dashboard
+	KeyDiff                = types.KeyDiff
+	PrefixDiff             = types.PrefixDiff
+	EditLock               = types.EditLock
+	EditLockRequest        = types.EditLockRequest
+	ExpiryEvent            = types.ExpiryEvent
+	ExportRequest          = types.ExportRequest
+	ExportResult           = types.ExportResult
+	FaultConfig            = types.FaultConfig
+	Flag                   = types.Flag
+	FlagRule               = types.FlagRule
+	FlagContext            = types.FlagContext
+	FlagResult             = types.FlagResult
+	GitOpsStatus           = types.GitOpsStatus
+	PrefixGrowth           = types.PrefixGrowth
+	KeyspaceReport         = types.KeyspaceReport
+	TreeNode               = types.TreeNode
+	KeysPage               = types.KeysPage
+	FlatKey                = types.FlatKey
+	FlatKeysPage           = types.FlatKeysPage
+	Value                  = types.Value
+	ImportResult           = types.ImportResult
+	JobLog                 = types.JobLog
+	Job                    = types.Job
+	Lease                  = types.Lease
+	LeaseGrantRequest      = types.LeaseGrantRequest
+	DefraggedMember        = types.DefraggedMember
+	DefragResult           = types.DefragResult
+	ManagedLease           = types.ManagedLease
+	KeyMeta                = types.KeyMeta
+	MirrorStatus           = types.MirrorStatus
+	MemberStatus           = types.MemberStatus
+	NoSpaceStep            = types.NoSpaceStep
+	NoSpaceStatus          = types.NoSpaceStatus
+	NoSpaceStepRequest     = types.NoSpaceStepRequest
+	NoSpaceStepResult      = types.NoSpaceStepResult
+	DesiredState           = types.DesiredState
+	PlanChange             = types.PlanChange
+	Plan                   = types.Plan
+	PromoteRequest         = types.PromoteRequest
+	PromoteChange          = types.PromoteChange
+	PromotePlan            = types.PromotePlan
+	ProposalOp             = types.ProposalOp
+	ProposalRequest        = types.ProposalRequest
+	Proposal               = types.Proposal
+	KeyValue               = types.KeyValue
+	DumpedKey              = types.DumpedKey
+	EtcdConnection         = types.EtcdConnection
+	Readiness              = types.Readiness
+	RestoreRequest         = types.RestoreRequest
+	RestoreResult          = types.RestoreResult
+	RewriteRequest         = types.RewriteRequest
+	RewritePreview         = types.RewritePreview
+	RewriteJob             = types.RewriteJob
+	TaskRun                = types.TaskRun
+	SearchMatch            = types.SearchMatch
+	KeySize                = types.KeySize
+	PrefixStats            = types.PrefixStats
+	TagRequest             = types.TagRequest
+	Tag                    = types.Tag
+	TrashEntry             = types.TrashEntry
+	TrashRestoreRequest    = types.TrashRestoreRequest
+	Bookmark               = types.Bookmark
+	RecentKey              = types.RecentKey
+	WriteChange            = types.WriteChange
+	ValidationRequest      = types.ValidationRequest
+	ValidationResponse     = types.ValidationResponse
+	ValueHit               = types.ValueHit
+	ZNodeRule              = types.ZNodeRule
+	ZooKeeperImportRequest = types.ZooKeeperImportRequest
+	ZooKeeperImportResult  = types.ZooKeeperImportResult
+)
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uiFiles is the web UI. A frontend build can replace the contents of
+// static/ui; whatever is there is embedded when the gateway is built.
+//
+//go:embed static/ui
+var uiFiles embed.FS
+
+// UIHandler serves the embedded web UI for GET /ui/*path. Any path that is
+// not a file is a client-side route and gets index.html; keys routinely end
+// in extensions, so those cannot be told apart from missing assets.
+func UIHandler() gin.HandlerFunc {
+	root, err := fs.Sub(uiFiles, "static/ui")
+	if err != nil {
+		panic(err)
+	}
+	files := http.FileServer(http.FS(root))
+	index, err := fs.ReadFile(root, "index.html")
+	if err != nil {
+		panic(err)
+	}
+	return func(c *gin.Context) {
+		name := strings.TrimPrefix(c.Param("path"), "/")
+		if name != "" && name != "index.html" {
+			if info, err := fs.Stat(root, name); err == nil && !info.IsDir() {
+				req := c.Request.Clone(c)
+				req.URL.Path = "/" + name
+				files.ServeHTTP(c.Writer, req)
+				return
+			}
+		}
+		c.Header("Cache-Control", "no-cache")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+	}
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// userPrefix holds per-user state, under the path-escaped user name.
+const userPrefix = "/.users/"
+
+// maxRecentKeys is how many recently viewed keys are kept per user.
+const maxRecentKeys = 20
+
+// requestUser returns the user the request claims to be made by, or "" for
+// anonymous requests, for logging. Only IdentityTrust.User can be trusted.
+func requestUser(c *gin.Context) string {
+	return c.GetHeader(actorHeader)
+}
+
+func bookmarkPrefix(user string) string {
+	return userPrefix + url.PathEscape(user) + "/bookmarks/"
+}
+
+func recentKey(user string) string {
+	return userPrefix + url.PathEscape(user) + "/recent"
+}
+
+// requireUser answers 401 and returns "" unless the fronting proxy
+// authenticated the request.
+func requireUser(c *gin.Context, trust IdentityTrust) string {
+	user := trust.User(c)
+	if user == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+	}
+	return user
+}
+
+// BookmarkListHandler lists the user's bookmarks ordered by key.
+func BookmarkListHandler(client clientv3.KV, trust IdentityTrust, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := requireUser(c, trust)
+		if user == "" {
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Get(ctx, bookmarkPrefix(user), clientv3.WithPrefix())
+		if err != nil {
+			logger.Error("Error fetching bookmarks from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		bookmarks := make([]Bookmark, 0, len(resp.Kvs))
+		for _, kv := range resp.Kvs {
+			var b Bookmark
+			if err := json.Unmarshal(kv.Value, &b); err == nil {
+				bookmarks = append(bookmarks, b)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"bookmarks": bookmarks})
+	}
+}
+
+// BookmarkPutHandler bookmarks a key or prefix, with an optional
+// {"label": ...} body.
+func BookmarkPutHandler(client clientv3.KV, trust IdentityTrust, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := requireUser(c, trust)
+		if user == "" {
+			return
+		}
+		b := Bookmark{Key: strings.TrimPrefix(c.Param("key"), "/")}
+		if b.Key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Key is required"})
+			return
+		}
+		if c.Request.ContentLength > 0 {
+			var body struct {
+				Label string `json:"label"`
+			}
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+				return
+			}
+			b.Label = body.Label
+		}
+		b.CreatedAt = time.Now()
+		data, err := json.Marshal(b)
+		if err != nil {
+			logger.Error("Error encoding bookmark", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		if _, err := client.Put(ctx, bookmarkPrefix(user)+b.Key, string(data)); err != nil {
+			logger.Error("Error writing bookmark to etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, b)
+	}
+}
+
+// BookmarkDeleteHandler removes a bookmark.
+func BookmarkDeleteHandler(client clientv3.KV, trust IdentityTrust, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := requireUser(c, trust)
+		if user == "" {
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Delete(ctx, bookmarkPrefix(user)+strings.TrimPrefix(c.Param("key"), "/"))
+		if err != nil {
+			logger.Error("Error deleting bookmark from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		if resp.Deleted == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bookmark not found"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// decodeRecent returns the list stored in kv, tolerating a missing key.
+func decodeRecent(kvs []*mvccpb.KeyValue) []RecentKey {
+	recent := []RecentKey{}
+	if len(kvs) > 0 {
+		_ = json.Unmarshal(kvs[0].Value, &recent)
+	}
+	return recent
+}
+
+// recordRecent moves key to the front of the user's recent list. Concurrent
+// views are merged by retrying on conflict.
+func recordRecent(ctx context.Context, client clientv3.KV, user, key string) error {
+	rk := recentKey(user)
+	for attempt := 0; attempt < 3; attempt++ {
+		resp, err := client.Get(ctx, rk)
+		if err != nil {
+			return err
+		}
+		recent := []RecentKey{{Key: key, ViewedAt: time.Now()}}
+		for _, r := range decodeRecent(resp.Kvs) {
+			if r.Key != key && len(recent) < maxRecentKeys {
+				recent = append(recent, r)
+			}
+		}
+		data, err := json.Marshal(recent)
+		if err != nil {
+			return err
+		}
+		var modRevision int64
+		if len(resp.Kvs) > 0 {
+			modRevision = resp.Kvs[0].ModRevision
+		}
+		txn, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(rk), "=", modRevision)).
+			Then(clientv3.OpPut(rk, string(data))).
+			Commit()
+		if err != nil || txn.Succeeded {
+			return err
+		}
+	}
+	return errConflict
+}
+
+// RecentKeysMiddleware records keys successfully read by authenticated
+// users. Recording happens after the response and does not delay it.
+func RecentKeysMiddleware(client clientv3.KV, trust IdentityTrust, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		user := trust.User(c)
+		if user == "" || c.Writer.Status() != http.StatusOK {
+			return
+		}
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := recordRecent(ctx, client, user, key); err != nil {
+				logger.Warn("Cannot record recently viewed key", zap.String("user", user), zap.Error(err))
+			}
+		}()
+	}
+}
+
+// RecentKeysHandler lists the user's recently viewed keys, newest first.
+func RecentKeysHandler(client clientv3.KV, trust IdentityTrust, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := requireUser(c, trust)
+		if user == "" {
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		resp, err := client.Get(ctx, recentKey(user))
+		if err != nil {
+			logger.Error("Error fetching recent keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"recent": decodeRecent(resp.Kvs)})
+	}
+}
+
+// RecentKeysClearHandler forgets the user's recently viewed keys.
+func RecentKeysClearHandler(client clientv3.KV, trust IdentityTrust, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := requireUser(c, trust)
+		if user == "" {
+			return
+		}
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		if _, err := client.Delete(ctx, recentKey(user)); err != nil {
+			logger.Error("Error deleting recent keys from etcd", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	"go.uber.org/zap"
+)
+
+func TestBookmarkOwners(t *testing.T) {
+	store := apitest.NewStore()
+	trust := IdentityTrust{Secret: "s3cret"}
+	serve := func(method, url, user, secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		if user != "" {
+			req.Header.Set(actorHeader, user)
+		}
+		if secret != "" {
+			req.Header.Set(proxySecretHeader, secret)
+		}
+		handler := BookmarkListHandler(store.KV(), trust, zap.NewNop())
+		if method == "PUT" {
+			handler = BookmarkPutHandler(store.KV(), trust, zap.NewNop())
+		}
+		return apitest.Serve("/bookmarks/*key", req, handler)
+	}
+	if rec := serve("PUT", "/bookmarks/app/a", "alice", "s3cret"); rec.Code != 200 {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		secret   string
+		wantCode int
+		wantKeys []string
+	}{
+		{"owner", "alice", "s3cret", 200, []string{"app/a"}},
+		{"other user", "bob", "s3cret", 200, []string{}},
+		{"untrusted header", "alice", "", 401, nil},
+		{"wrong secret", "alice", "guess", 401, nil},
+		{"anonymous", "", "s3cret", 401, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve("GET", "/bookmarks/", tt.user, tt.secret)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantKeys == nil {
+				return
+			}
+			var resp struct{ Bookmarks []Bookmark }
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			keys := []string{}
+			for _, b := range resp.Bookmarks {
+				keys = append(keys, b.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("got bookmarks %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+
+	// An untrusted header cannot write into another user's bookmarks.
+	if rec := serve("PUT", "/bookmarks/app/b", "alice", ""); rec.Code != 401 {
+		t.Errorf("got status %d writing with an untrusted header", rec.Code)
+	}
+}
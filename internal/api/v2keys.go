@@ -0,0 +1,430 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// etcd v2 error codes used by the compatibility shim.
+const (
+	v2EcodeKeyNotFound  = 100
+	v2EcodeTestFailed   = 101
+	v2EcodeNotFile      = 102
+	v2EcodeNodeExist    = 105
+	v2EcodeRootROnly    = 107
+	v2EcodeDirNotEmpty  = 108
+	v2EcodeInvalidField = 209
+	v2EcodeRaftInternal = 300
+)
+
+// V2Node is a node in the etcd v2 key space. Directories are synthesized
+// from the slash separated v3 keys beneath them.
+type V2Node struct {
+	Key           string    `json:"key"`
+	Value         *string   `json:"value,omitempty"`
+	Dir           bool      `json:"dir,omitempty"`
+	Nodes         []*V2Node `json:"nodes,omitempty"`
+	ModifiedIndex int64     `json:"modifiedIndex,omitempty"`
+	CreatedIndex  int64     `json:"createdIndex,omitempty"`
+	TTL           int64     `json:"ttl,omitempty"`
+}
+
+// V2Response is the envelope returned by every successful v2 request.
+type V2Response struct {
+	Action   string  `json:"action"`
+	Node     *V2Node `json:"node"`
+	PrevNode *V2Node `json:"prevNode,omitempty"`
+}
+
+func v2Key(c *gin.Context) string {
+	return "/" + strings.Trim(c.Param("key"), "/")
+}
+
+// v2Form returns the form field name, or the query parameter when the form
+// has none: v2 clients send fields either way.
+func v2Form(c *gin.Context, name string) string {
+	if v := c.PostForm(name); v != "" {
+		return v
+	}
+	return c.Query(name)
+}
+
+func v2Error(c *gin.Context, status, code int, message, cause string, index int64) {
+	c.Header("X-Etcd-Index", strconv.FormatInt(index, 10))
+	c.JSON(status, gin.H{"errorCode": code, "message": message, "cause": cause, "index": index})
+}
+
+func v2LeafNode(kv *mvccpb.KeyValue) *V2Node {
+	value := string(kv.Value)
+	return &V2Node{
+		Key:           string(kv.Key),
+		Value:         &value,
+		ModifiedIndex: kv.ModRevision,
+		CreatedIndex:  kv.CreateRevision,
+	}
+}
+
+// v2DirNode builds a directory node for dir from the keys beneath it. When
+// recursive is false only the immediate children are listed and nested
+// directories are returned without their contents, as etcd v2 did.
+func v2DirNode(dir string, kvs []*mvccpb.KeyValue, recursive bool) *V2Node {
+	root := &V2Node{Key: dir, Dir: true}
+	index := map[string]*V2Node{dir: root}
+
+	var parent func(key string) *V2Node
+	parent = func(key string) *V2Node {
+		p := key[:strings.LastIndex(key, "/")]
+		if p == "" {
+			p = "/"
+		}
+		if n, ok := index[p]; ok {
+			return n
+		}
+		n := &V2Node{Key: p, Dir: true}
+		index[p] = n
+		pp := parent(p)
+		pp.Nodes = append(pp.Nodes, n)
+		return n
+	}
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for _, kv := range kvs {
+		key := string(kv.Key)
+		if !recursive {
+			rest := strings.TrimPrefix(key, prefix)
+			if i := strings.Index(rest, "/"); i >= 0 {
+				child := prefix + rest[:i]
+				if _, ok := index[child]; !ok {
+					n := &V2Node{Key: child, Dir: true}
+					index[child] = n
+					root.Nodes = append(root.Nodes, n)
+				}
+				continue
+			}
+		}
+		p := parent(key)
+		p.Nodes = append(p.Nodes, v2LeafNode(kv))
+	}
+
+	var sortNodes func(n *V2Node)
+	sortNodes = func(n *V2Node) {
+		sort.Slice(n.Nodes, func(i, j int) bool { return n.Nodes[i].Key < n.Nodes[j].Key })
+		for _, child := range n.Nodes {
+			sortNodes(child)
+		}
+	}
+	sortNodes(root)
+	return root
+}
+
+// V2GetHandler implements GET /v2/keys/*key, including directory listings
+// and long-polling via ?wait=true, which is mapped onto an etcd watch.
+func V2GetHandler(client WatchKV, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := v2Key(c)
+		recursive := c.Query("recursive") == "true"
+
+		if c.Query("wait") == "true" {
+			v2Wait(c, client, logger, key, recursive)
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		resp, err := client.Get(ctx, key)
+		if err != nil {
+			logger.Error("Error fetching key from etcd", zap.Error(err))
+			v2Error(c, http.StatusInternalServerError, v2EcodeRaftInternal, "Raft Internal Error", err.Error(), 0)
+			return
+		}
+		c.Header("X-Etcd-Index", strconv.FormatInt(resp.Header.Revision, 10))
+		if len(resp.Kvs) > 0 {
+			c.JSON(http.StatusOK, V2Response{Action: "get", Node: v2LeafNode(resp.Kvs[0])})
+			return
+		}
+
+		prefix := strings.TrimSuffix(key, "/") + "/"
+		children, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision))
+		if err != nil {
+			logger.Error("Error fetching keys from etcd", zap.Error(err))
+			v2Error(c, http.StatusInternalServerError, v2EcodeRaftInternal, "Raft Internal Error", err.Error(), 0)
+			return
+		}
+		if len(children.Kvs) == 0 && key != "/" {
+			v2Error(c, http.StatusNotFound, v2EcodeKeyNotFound, "Key not found", key, resp.Header.Revision)
+			return
+		}
+		c.JSON(http.StatusOK, V2Response{Action: "get", Node: v2DirNode(key, children.Kvs, recursive)})
+	}
+}
+
+func v2Wait(c *gin.Context, client clientv3.Watcher, logger *zap.Logger, key string, recursive bool) {
+	opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+	if recursive {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	if waitIndex := c.Query("waitIndex"); waitIndex != "" {
+		rev, err := strconv.ParseInt(waitIndex, 10, 64)
+		if err != nil {
+			v2Error(c, http.StatusBadRequest, v2EcodeInvalidField, "Invalid field", "invalid waitIndex", 0)
+			return
+		}
+		opts = append(opts, clientv3.WithRev(rev))
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	wch, err := watchCreated(ctx, client, timeoutsOf(c).Watch, key, opts...)
+	if err != nil {
+		logger.Error("Error watching key in etcd", zap.Error(err))
+		v2Error(c, http.StatusInternalServerError, v2EcodeRaftInternal, "Raft Internal Error", err.Error(), 0)
+		return
+	}
+	for wresp := range wch {
+		if err := wresp.Err(); err != nil {
+			logger.Error("Error watching key in etcd", zap.Error(err))
+			v2Error(c, http.StatusInternalServerError, v2EcodeRaftInternal, "Raft Internal Error", err.Error(), 0)
+			return
+		}
+		if len(wresp.Events) == 0 {
+			continue
+		}
+		ev := wresp.Events[0]
+		out := V2Response{Action: "set", Node: v2LeafNode(ev.Kv)}
+		if ev.Type == clientv3.EventTypeDelete {
+			out.Action = "delete"
+			out.Node = &V2Node{Key: string(ev.Kv.Key), ModifiedIndex: ev.Kv.ModRevision}
+		}
+		if ev.PrevKv != nil {
+			out.PrevNode = v2LeafNode(ev.PrevKv)
+		}
+		c.Header("X-Etcd-Index", strconv.FormatInt(wresp.Header.Revision, 10))
+		c.JSON(http.StatusOK, out)
+		return
+	}
+	// The watch only ends early when the client's deadline passes.
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		v2Error(c, http.StatusGatewayTimeout, v2EcodeRaftInternal, "Raft Internal Error", "request deadline exceeded", 0)
+	}
+}
+
+// V2PutHandler implements PUT /v2/keys/*key with the prevExist, prevValue
+// and prevIndex conditions and lease-backed ttl. The gateway's own keys are
+// read only.
+func V2PutHandler(client LeaseKV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := v2Key(c)
+		if reservedWrite(key, false) {
+			v2Error(c, http.StatusForbidden, v2EcodeRootROnly, "Key is reserved by the gateway", key, 0)
+			return
+		}
+		value := v2Form(c, "value")
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		if c.Query("dir") == "true" || c.PostForm("dir") == "true" {
+			// v3 has no directories; they exist implicitly once a key is written beneath them.
+			c.JSON(http.StatusCreated, V2Response{Action: "set", Node: &V2Node{Key: key, Dir: true}})
+			return
+		}
+
+		var putOpts []clientv3.OpOption
+		var ttl int64
+		if s := v2Form(c, "ttl"); s != "" {
+			var err error
+			if ttl, err = strconv.ParseInt(s, 10, 64); err != nil || ttl <= 0 {
+				v2Error(c, http.StatusBadRequest, v2EcodeInvalidField, "Invalid field", "invalid ttl", 0)
+				return
+			}
+			lease, err := client.Grant(ctx, ttl)
+			if err != nil {
+				logger.Error("Error granting lease", zap.Error(err))
+				v2Error(c, http.StatusInternalServerError, v2EcodeRaftInternal, "Raft Internal Error", err.Error(), 0)
+				return
+			}
+			putOpts = append(putOpts, clientv3.WithLease(lease.ID))
+		}
+
+		var cmps []clientv3.Cmp
+		action := "set"
+		switch c.Query("prevExist") {
+		case "false":
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+			action = "create"
+		case "true":
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), ">", 0))
+			action = "update"
+		}
+		if prev, ok := c.GetQuery("prevValue"); ok {
+			cmps = append(cmps, clientv3.Compare(clientv3.Value(key), "=", prev))
+			action = "compareAndSwap"
+		}
+		if prev := c.Query("prevIndex"); prev != "" {
+			index, err := strconv.ParseInt(prev, 10, 64)
+			if err != nil {
+				v2Error(c, http.StatusBadRequest, v2EcodeInvalidField, "Invalid field", "invalid prevIndex", 0)
+				return
+			}
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", index))
+			action = "compareAndSwap"
+		}
+
+		resp, err := client.Txn(ctx).
+			If(cmps...).
+			Then(clientv3.OpPut(key, value, append(putOpts, clientv3.WithPrevKV())...)).
+			Else(clientv3.OpGet(key)).
+			Commit()
+		if err != nil {
+			logger.Error("Error writing key to etcd", zap.Error(err))
+			v2Error(c, http.StatusInternalServerError, v2EcodeRaftInternal, "Raft Internal Error", err.Error(), 0)
+			return
+		}
+		if !resp.Succeeded {
+			current := resp.Responses[0].GetResponseRange()
+			switch {
+			case action == "create":
+				v2Error(c, http.StatusPreconditionFailed, v2EcodeNodeExist, "Key already exists", key, resp.Header.Revision)
+			case len(current.Kvs) == 0:
+				v2Error(c, http.StatusNotFound, v2EcodeKeyNotFound, "Key not found", key, resp.Header.Revision)
+			default:
+				v2Error(c, http.StatusPreconditionFailed, v2EcodeTestFailed, "Compare failed", key, resp.Header.Revision)
+			}
+			return
+		}
+
+		put := resp.Responses[0].GetResponsePut()
+		audit.Record(ctx, requestActor(c), "put", []AuditChange{putChange(key, put.PrevKv, resp.Header.Revision)})
+		out := V2Response{
+			Action: action,
+			Node: &V2Node{
+				Key:           key,
+				Value:         &value,
+				ModifiedIndex: resp.Header.Revision,
+				CreatedIndex:  resp.Header.Revision,
+				TTL:           ttl,
+			},
+		}
+		status := http.StatusCreated
+		if put.PrevKv != nil {
+			out.PrevNode = v2LeafNode(put.PrevKv)
+			out.Node.CreatedIndex = put.PrevKv.CreateRevision
+			status = http.StatusOK
+		}
+		c.Header("X-Etcd-Index", strconv.FormatInt(resp.Header.Revision, 10))
+		c.JSON(status, out)
+	}
+}
+
+// V2DeleteHandler implements DELETE /v2/keys/*key. Directories may only be
+// removed with ?dir=true when empty, or with ?recursive=true. The gateway's
+// own keys are read only.
+func V2DeleteHandler(client clientv3.KV, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := v2Key(c)
+		recursive := c.Query("recursive") == "true"
+		if reservedWrite(key, recursive) {
+			v2Error(c, http.StatusForbidden, v2EcodeRootROnly, "Key is reserved by the gateway", key, 0)
+			return
+		}
+
+		ctx, cancel := requestContext(c)
+		defer cancel()
+
+		prefix := strings.TrimSuffix(key, "/") + "/"
+		if c.Query("dir") == "true" || recursive {
+			// The directory and its children go in one transaction, which
+			// without ?recursive= only applies while there are none.
+			var cmps []clientv3.Cmp
+			if !recursive {
+				cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(prefix), "=", 0).WithPrefix())
+			}
+			resp, err := client.Txn(ctx).
+				If(cmps...).
+				Then(
+					clientv3.OpDelete(prefix, clientv3.WithPrefix(), clientv3.WithPrevKV()),
+					clientv3.OpDelete(key, clientv3.WithPrevKV()),
+				).
+				Commit()
+			if err != nil {
+				logger.Error("Error deleting keys from etcd", zap.Error(err))
+				v2Error(c, http.StatusInternalServerError, v2EcodeRaftInternal, "Raft Internal Error", err.Error(), 0)
+				return
+			}
+			if !resp.Succeeded {
+				v2Error(c, http.StatusForbidden, v2EcodeDirNotEmpty, "Directory not empty", key, resp.Header.Revision)
+				return
+			}
+			removed := resp.Responses[0].GetResponseDeleteRange().PrevKvs
+			removed = append(removed, resp.Responses[1].GetResponseDeleteRange().PrevKvs...)
+			audit.Record(ctx, requestActor(c), "delete", deleteChanges(removed))
+			c.Header("X-Etcd-Index", strconv.FormatInt(resp.Header.Revision, 10))
+			c.JSON(http.StatusOK, V2Response{Action: "delete", Node: &V2Node{Key: key, Dir: true, ModifiedIndex: resp.Header.Revision}})
+			return
+		}
+
+		var cmps []clientv3.Cmp
+		action := "delete"
+		if prev, ok := c.GetQuery("prevValue"); ok {
+			cmps = append(cmps, clientv3.Compare(clientv3.Value(key), "=", prev))
+			action = "compareAndDelete"
+		}
+		if prev := c.Query("prevIndex"); prev != "" {
+			index, err := strconv.ParseInt(prev, 10, 64)
+			if err != nil {
+				v2Error(c, http.StatusBadRequest, v2EcodeInvalidField, "Invalid field", "invalid prevIndex", 0)
+				return
+			}
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", index))
+			action = "compareAndDelete"
+		}
+
+		resp, err := client.Txn(ctx).
+			If(cmps...).
+			Then(clientv3.OpDelete(key, clientv3.WithPrevKV())).
+			Else(clientv3.OpGet(key)).
+			Commit()
+		if err != nil {
+			logger.Error("Error deleting key from etcd", zap.Error(err))
+			v2Error(c, http.StatusInternalServerError, v2EcodeRaftInternal, "Raft Internal Error", err.Error(), 0)
+			return
+		}
+		if !resp.Succeeded {
+			if len(resp.Responses[0].GetResponseRange().Kvs) == 0 {
+				v2Error(c, http.StatusNotFound, v2EcodeKeyNotFound, "Key not found", key, resp.Header.Revision)
+			} else {
+				v2Error(c, http.StatusPreconditionFailed, v2EcodeTestFailed, "Compare failed", key, resp.Header.Revision)
+			}
+			return
+		}
+
+		del := resp.Responses[0].GetResponseDeleteRange()
+		audit.Record(ctx, requestActor(c), "delete", deleteChanges(del.PrevKvs))
+		if len(del.PrevKvs) == 0 {
+			children, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+			if err == nil && children.Count > 0 {
+				v2Error(c, http.StatusForbidden, v2EcodeNotFile, "Not a file", key, resp.Header.Revision)
+				return
+			}
+			v2Error(c, http.StatusNotFound, v2EcodeKeyNotFound, "Key not found", key, resp.Header.Revision)
+			return
+		}
+		c.Header("X-Etcd-Index", strconv.FormatInt(resp.Header.Revision, 10))
+		c.JSON(http.StatusOK, V2Response{
+			Action:   action,
+			Node:     &V2Node{Key: key, ModifiedIndex: resp.Header.Revision, CreatedIndex: del.PrevKvs[0].CreateRevision},
+			PrevNode: v2LeafNode(del.PrevKvs[0]),
+		})
+	}
+}
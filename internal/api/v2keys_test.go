@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"etcd-gateway/internal/api/apitest"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+func TestV2Delete(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		wantCode int
+		wantKeys []string
+	}{
+		{"key", "/v2/keys/dir/a", 200, []string{"/.audit/x", "/dir/b/c"}},
+		{"non-empty directory", "/v2/keys/dir?dir=true", 403, []string{"/.audit/x", "/dir/a", "/dir/b/c"}},
+		{"empty directory", "/v2/keys/empty?dir=true", 200, []string{"/.audit/x", "/dir/a", "/dir/b/c"}},
+		{"recursive", "/v2/keys/dir?recursive=true", 200, []string{"/.audit/x"}},
+		{"root", "/v2/keys/?recursive=true", 403, []string{"/.audit/x", "/dir/a", "/dir/b/c"}},
+		{"reserved key", "/v2/keys/.audit/x", 403, []string{"/.audit/x", "/dir/a", "/dir/b/c"}},
+		{"reserved directory", "/v2/keys/.audit?recursive=true", 403, []string{"/.audit/x", "/dir/a", "/dir/b/c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := apitest.NewStore()
+			kv := store.KV()
+			for _, key := range []string{"/dir/a", "/dir/b/c", "/.audit/x"} {
+				if _, err := kv.Put(context.Background(), key, "v"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rec := apitest.Serve("/v2/keys/*key", httptest.NewRequest("DELETE", tt.url, nil), V2DeleteHandler(kv, nil, zap.NewNop()))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			resp, err := kv.Get(context.Background(), "/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := keysOf(resp); !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("got keys %v, want %v", got, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestV2Put(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		form       string
+		wantCode   int
+		wantAction string
+		wantValue  string
+		wantLease  bool
+	}{
+		{"set", "/v2/keys/a?value=2", "", 200, "set", "2", false},
+		{"form value", "/v2/keys/b", "value=3", 201, "set", "3", false},
+		{"ttl", "/v2/keys/b", "value=3&ttl=30", 201, "set", "3", true},
+		{"create existing", "/v2/keys/a?value=2&prevExist=false", "", 412, "", "1", false},
+		{"update missing", "/v2/keys/b?value=2&prevExist=true", "", 404, "", "", false},
+		{"compare and swap", "/v2/keys/a?value=2&prevValue=1", "", 200, "compareAndSwap", "2", false},
+		{"compare failed", "/v2/keys/a?value=2&prevIndex=1", "", 412, "", "1", false},
+		{"invalid ttl", "/v2/keys/a?value=2&ttl=x", "", 400, "", "1", false},
+		{"reserved key", "/v2/keys/.audit/x?value=2", "", 403, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := apitest.NewStore()
+			client := store.Client()
+			if _, err := client.Put(context.Background(), "/a", "1"); err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest("PUT", tt.url, strings.NewReader(tt.form))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := apitest.Serve("/v2/keys/*key", req, V2PutHandler(client, nil, zap.NewNop()))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			var resp V2Response
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Action != tt.wantAction {
+				t.Errorf("got action %q, want %q", resp.Action, tt.wantAction)
+			}
+
+			key := "/" + strings.Trim(strings.SplitN(strings.TrimPrefix(tt.url, "/v2/keys"), "?", 2)[0], "/")
+			get, err := client.Get(context.Background(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var value string
+			var lease int64
+			if len(get.Kvs) > 0 {
+				value, lease = string(get.Kvs[0].Value), get.Kvs[0].Lease
+			}
+			if value != tt.wantValue || (lease != 0) != tt.wantLease {
+				t.Errorf("got value %q with lease %d, want %q", value, lease, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestV2Get(t *testing.T) {
+	store := apitest.NewStore()
+	client := store.Client()
+	for _, key := range []string{"/dir/a", "/dir/sub/b"} {
+		if _, err := client.Put(context.Background(), key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name     string
+		url      string
+		wantCode int
+		wantKeys []string
+	}{
+		{"key", "/v2/keys/dir/a", 200, []string{"/dir/a"}},
+		{"directory", "/v2/keys/dir", 200, []string{"/dir", "/dir/a", "/dir/sub"}},
+		{"recursive", "/v2/keys/dir?recursive=true", 200, []string{"/dir", "/dir/a", "/dir/sub", "/dir/sub/b"}},
+		{"missing", "/v2/keys/nothing", 404, nil},
+		// Waiting from an index already past answers with its change.
+		{"wait", "/v2/keys/dir/sub/b?wait=true&waitIndex=3", 200, []string{"/dir/sub/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := apitest.Serve("/v2/keys/*key", httptest.NewRequest("GET", tt.url, nil), V2GetHandler(client, zap.NewNop()))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantKeys == nil {
+				return
+			}
+			var resp V2Response
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			var walk func(n *V2Node)
+			walk = func(n *V2Node) {
+				keys = append(keys, n.Key)
+				for _, child := range n.Nodes {
+					walk(child)
+				}
+			}
+			walk(resp.Node)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("got nodes %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// ValidationWebhook is asked to approve every write to keys under Prefix
+// before it is made.
+type ValidationWebhook struct {
+	Prefix string
+	URL    string
+}
+
+// ParseValidationWebhooks parses "/prefix/=url" entries.
+func ParseValidationWebhooks(entries []string) ([]ValidationWebhook, error) {
+	var webhooks []ValidationWebhook
+	for _, entry := range entries {
+		prefix, target, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, errors.New("invalid validation webhook " + strconv.Quote(entry) + ", expected /prefix/=url")
+		}
+		if u, err := url.Parse(target); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, errors.New("invalid URL in validation webhook for " + prefix)
+		}
+		webhooks = append(webhooks, ValidationWebhook{Prefix: prefix, URL: target})
+	}
+	return webhooks, nil
+}
+
+// WriteRejectedError is returned for writes a validation webhook rejected.
+type WriteRejectedError struct {
+	Reason string
+}
+
+func (e *WriteRejectedError) Error() string {
+	return "write rejected by validation webhook: " + e.Reason
+}
+
+// errValidationUnavailable is returned for writes no answer could be had for.
+var errValidationUnavailable = errors.New("validation webhook unavailable")
+
+// validatingKV is a KV asking validation webhooks to approve writes.
+type validatingKV struct {
+	clientv3.KV
+	webhooks []ValidationWebhook
+	failOpen bool
+	http     *http.Client
+	logger   *zap.Logger
+}
+
+// NewValidatingKV wraps kv to ask the webhooks whose prefix a write touches
+// to approve it first. A write is made only if every one allows it. When a
+// webhook cannot be reached, or answers with an error, writes are rejected
+// unless failOpen is set, in which case they go ahead.
+func NewValidatingKV(kv clientv3.KV, webhooks []ValidationWebhook, timeout time.Duration, failOpen bool, logger *zap.Logger) clientv3.KV {
+	return &validatingKV{
+		KV:       kv,
+		webhooks: webhooks,
+		failOpen: failOpen,
+		http:     &http.Client{Timeout: timeout},
+		logger:   logger,
+	}
+}
+
+// writeChanges lists the changes ops would make, including those of nested
+// transactions.
+func writeChanges(ops []clientv3.Op) []WriteChange {
+	var changes []WriteChange
+	for _, op := range ops {
+		switch {
+		case op.IsPut():
+			changes = append(changes, WriteChange{Operation: "PUT", Key: string(op.KeyBytes()), Value: string(op.ValueBytes())})
+		case op.IsDelete():
+			changes = append(changes, WriteChange{Operation: "DELETE", Key: string(op.KeyBytes()), RangeEnd: string(op.RangeBytes())})
+		case op.IsTxn():
+			_, thenOps, elseOps := op.Txn()
+			changes = append(changes, writeChanges(thenOps)...)
+			changes = append(changes, writeChanges(elseOps)...)
+		}
+	}
+	return changes
+}
+
+// validate asks every webhook whose prefix the changes touch to approve
+// them.
+func (kv *validatingKV) validate(ctx context.Context, changes []WriteChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	req := ValidationRequest{}
+	if r := kvRequestOf(ctx); r != nil {
+		req.User, req.RequestID = r.user, r.requestID
+	}
+	for _, webhook := range kv.webhooks {
+		req.Changes = nil
+		for _, change := range changes {
+			if rangeOverlapsPrefix([]byte(change.Key), []byte(change.RangeEnd), webhook.Prefix) {
+				req.Changes = append(req.Changes, change)
+			}
+		}
+		if len(req.Changes) == 0 {
+			continue
+		}
+		uid := make([]byte, 16)
+		rand.Read(uid)
+		req.UID = hex.EncodeToString(uid)
+		var resp ValidationResponse
+		if err := postJSONResponse(ctx, kv.http, webhook.URL, nil, req, &resp); err != nil {
+			if kv.failOpen {
+				kv.logger.Warn("Validation webhook failed, allowing write", zap.String("prefix", webhook.Prefix), zap.Error(err))
+				continue
+			}
+			kv.logger.Error("Validation webhook failed, rejecting write", zap.String("prefix", webhook.Prefix), zap.Error(err))
+			return refuse(ctx, http.StatusServiceUnavailable, "Validation webhook unavailable", errValidationUnavailable)
+		}
+		if !resp.Allowed {
+			reason := resp.Reason
+			if reason == "" {
+				reason = "no reason given"
+			}
+			return refuse(ctx, http.StatusUnprocessableEntity, "Write rejected: "+reason, &WriteRejectedError{Reason: reason})
+		}
+	}
+	return nil
+}
+
+func (kv *validatingKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	resp, err := kv.Do(ctx, clientv3.OpPut(key, val, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Put(), nil
+}
+
+func (kv *validatingKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	resp, err := kv.Do(ctx, clientv3.OpDelete(key, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Del(), nil
+}
+
+func (kv *validatingKV) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	if err := kv.validate(ctx, writeChanges([]clientv3.Op{op})); err != nil {
+		return clientv3.OpResponse{}, err
+	}
+	return kv.KV.Do(ctx, op)
+}
+
+func (kv *validatingKV) Txn(ctx context.Context) clientv3.Txn {
+	return &checkedTxn{Txn: kv.KV.Txn(ctx), ctx: ctx, check: func(ctx context.Context, _ []clientv3.Cmp, thenOps, elseOps []clientv3.Op) error {
+		return kv.validate(ctx, append(writeChanges(thenOps), writeChanges(elseOps)...))
+	}}
+}
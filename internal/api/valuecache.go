@@ -0,0 +1,131 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// valueCacheStats exposes hit/miss/eviction counters at /admin/debug/vars.
+var valueCacheStats = expvar.NewMap("value_cache")
+
+type valueCacheEntry struct {
+	key     string
+	kv      *mvccpb.KeyValue
+	expires time.Time
+}
+
+// ValueCache is an LRU cache of single-key reads with a TTL. Entries are
+// invalidated by a watch on the whole keyspace; the TTL bounds staleness if
+// the watch falls behind.
+type ValueCache struct {
+	client clientv3.Watcher
+	logger *zap.Logger
+	size   int
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	ll       *list.List
+	items    map[string]*list.Element
+	watchRev int64
+}
+
+// NewValueCache creates a cache of up to size keys; call Run to start invalidation.
+func NewValueCache(client clientv3.Watcher, logger *zap.Logger, size int, ttl time.Duration) *ValueCache {
+	return &ValueCache{
+		client: client,
+		logger: logger,
+		size:   size,
+		ttl:    ttl,
+		ll:     list.New(),
+		items:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached key, if present and not expired.
+func (vc *ValueCache) Get(key string) (*mvccpb.KeyValue, bool) {
+	if vc == nil {
+		return nil, false
+	}
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	el, ok := vc.items[key]
+	if !ok {
+		valueCacheStats.Add("misses", 1)
+		return nil, false
+	}
+	entry := el.Value.(*valueCacheEntry)
+	if time.Now().After(entry.expires) {
+		vc.removeElement(el)
+		valueCacheStats.Add("expired", 1)
+		valueCacheStats.Add("misses", 1)
+		return nil, false
+	}
+	vc.ll.MoveToFront(el)
+	valueCacheStats.Add("hits", 1)
+	return entry.kv, true
+}
+
+// Add caches kv as read at revision. Reads older than the last event the
+// watch delivered are dropped, since that event may have changed the key
+// after it was read.
+func (vc *ValueCache) Add(key string, kv *mvccpb.KeyValue, revision int64) {
+	if vc == nil {
+		return
+	}
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	if revision < vc.watchRev {
+		return
+	}
+	if el, ok := vc.items[key]; ok {
+		vc.removeElement(el)
+	}
+	vc.items[key] = vc.ll.PushFront(&valueCacheEntry{key: key, kv: kv, expires: time.Now().Add(vc.ttl)})
+	for vc.ll.Len() > vc.size {
+		vc.removeElement(vc.ll.Back())
+		valueCacheStats.Add("evictions", 1)
+	}
+}
+
+func (vc *ValueCache) removeElement(el *list.Element) {
+	vc.ll.Remove(el)
+	delete(vc.items, el.Value.(*valueCacheEntry).key)
+}
+
+func (vc *ValueCache) purge() {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.ll.Init()
+	vc.items = make(map[string]*list.Element)
+}
+
+// Run invalidates changed keys until ctx is cancelled. Whenever the watch
+// is lost the whole cache is purged, since events may have been missed.
+func (vc *ValueCache) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		wch := vc.client.Watch(ctx, "", clientv3.WithPrefix(), clientv3.WithProgressNotify())
+		for wresp := range wch {
+			if err := wresp.Err(); err != nil {
+				vc.logger.Warn("Value cache watch failed", zap.Error(err))
+				break
+			}
+			vc.mu.Lock()
+			for _, ev := range wresp.Events {
+				if el, ok := vc.items[string(ev.Kv.Key)]; ok {
+					vc.removeElement(el)
+					valueCacheStats.Add("invalidations", 1)
+				}
+			}
+			vc.watchRev = wresp.Header.Revision
+			vc.mu.Unlock()
+		}
+		vc.purge()
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"etcd-gateway/internal/api/apitest"
+	"go.uber.org/zap"
+)
+
+func TestValueCacheInvalidation(t *testing.T) {
+	store := apitest.NewStore()
+	client := store.Client()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache := NewValueCache(client, zap.NewNop(), 10, time.Minute)
+	go cache.Run(ctx)
+	// Writes made before the watch is created are not seen by it.
+	waitFor(t, "the watch to start", func() bool {
+		resp, _ := client.Put(ctx, "/sync", "")
+		time.Sleep(10 * time.Millisecond)
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return cache.watchRev >= resp.Header.Revision
+	})
+
+	read, _ := client.Get(ctx, "/sync")
+	cache.Add("/sync", read.Kvs[0], read.Header.Revision)
+	if _, ok := cache.Get("/sync"); !ok {
+		t.Fatal("Get missed a key just added")
+	}
+	client.Put(ctx, "/sync", "changed")
+	waitFor(t, "the key to be invalidated", func() bool {
+		_, ok := cache.Get("/sync")
+		return !ok
+	})
+
+	// A read from before the last event the watch delivered may be stale.
+	cache.Add("/sync", read.Kvs[0], read.Header.Revision)
+	if _, ok := cache.Get("/sync"); ok {
+		t.Error("cached a read older than the watch")
+	}
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// snippetContext is how many bytes of value surround a highlighted term.
+const snippetContext = 40
+
+// ValueIndex is an in-memory inverted index over the values of keys under a
+// set of prefixes, kept current by watching them.
+type ValueIndex struct {
+	client   *clientv3.Client
+	logger   *zap.Logger
+	prefixes []string
+
+	mu       sync.RWMutex
+	values   map[string]string
+	postings map[string]map[string]struct{}
+}
+
+// NewValueIndex creates an index over prefixes; call Run to populate it.
+func NewValueIndex(client *clientv3.Client, logger *zap.Logger, prefixes []string) *ValueIndex {
+	return &ValueIndex{
+		client:   client,
+		logger:   logger,
+		prefixes: prefixes,
+		values:   make(map[string]string),
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (ix *ValueIndex) put(key, value string) {
+	ix.remove(key)
+	ix.values[key] = value
+	for _, tok := range tokenize(value) {
+		keys, ok := ix.postings[tok]
+		if !ok {
+			keys = make(map[string]struct{})
+			ix.postings[tok] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (ix *ValueIndex) remove(key string) {
+	old, ok := ix.values[key]
+	if !ok {
+		return
+	}
+	delete(ix.values, key)
+	for _, tok := range tokenize(old) {
+		if keys, ok := ix.postings[tok]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(ix.postings, tok)
+			}
+		}
+	}
+}
+
+// Run loads and then watches each prefix until ctx is cancelled, reloading
+// a prefix from scratch whenever its watch fails (e.g. after compaction).
+func (ix *ValueIndex) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, prefix := range ix.prefixes {
+		wg.Add(1)
+		go func(prefix string) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				rev, err := ix.load(ctx, prefix)
+				if err != nil {
+					ix.logger.Warn("Value index cannot load prefix", zap.String("prefix", prefix), zap.Error(err))
+					time.Sleep(time.Second)
+					continue
+				}
+				ix.watch(ctx, prefix, rev)
+			}
+		}(prefix)
+	}
+	wg.Wait()
+}
+
+func (ix *ValueIndex) load(ctx context.Context, prefix string) (int64, error) {
+	resp, err := ix.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for key := range ix.values {
+		if strings.HasPrefix(key, prefix) {
+			ix.remove(key)
+		}
+	}
+	for _, kv := range resp.Kvs {
+		ix.put(string(kv.Key), string(kv.Value))
+	}
+	return resp.Header.Revision, nil
+}
+
+func (ix *ValueIndex) watch(ctx context.Context, prefix string, rev int64) {
+	for wresp := range ix.client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1)) {
+		if err := wresp.Err(); err != nil {
+			ix.logger.Warn("Value index watch failed", zap.String("prefix", prefix), zap.Error(err))
+			return
+		}
+		ix.mu.Lock()
+		for _, ev := range wresp.Events {
+			if ev.Type == clientv3.EventTypeDelete {
+				ix.remove(string(ev.Kv.Key))
+			} else {
+				ix.put(string(ev.Kv.Key), string(ev.Kv.Value))
+			}
+		}
+		ix.mu.Unlock()
+	}
+}
+
+// Search returns keys whose values contain every term of query, with a
+// snippet around the first matching term highlighted in <em> tags.
+func (ix *ValueIndex) Search(query string, limit int) []ValueHit {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var keys []string
+	for key := range ix.postings[terms[0]] {
+		match := true
+		for _, term := range terms[1:] {
+			if _, ok := ix.postings[term][key]; !ok {
+				match = false
+				break
+			}
+		}
+		if match {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	hits := make([]ValueHit, len(keys))
+	for i, key := range keys {
+		hits[i] = ValueHit{Key: key, Snippet: snippet(ix.values[key], terms)}
+	}
+	return hits
+}
+
+func snippet(value string, terms []string) string {
+	lower := strings.ToLower(value)
+	start, end := -1, -1
+	for _, term := range terms {
+		if i := strings.Index(lower, term); i >= 0 && (start < 0 || i < start) {
+			start, end = i, i+len(term)
+		}
+	}
+	if start < 0 {
+		return ""
+	}
+	from := start - snippetContext
+	if from < 0 {
+		from = 0
+	}
+	for from > 0 && !utf8.RuneStart(value[from]) {
+		from--
+	}
+	to := end + snippetContext
+	if to > len(value) {
+		to = len(value)
+	}
+	for to < len(value) && !utf8.RuneStart(value[to]) {
+		to++
+	}
+	return html.EscapeString(value[from:start]) + "<em>" + html.EscapeString(value[start:end]) + "</em>" + html.EscapeString(value[end:to])
+}
+
+// ValueSearchHandler serves full-text queries against index. It answers 404
+// when no index is configured.
+func ValueSearchHandler(index *ValueIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if index == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Value search is not enabled"})
+			return
+		}
+		q := c.Query("q")
+		if q == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query is required"})
+			return
+		}
+		limit := defaultSearchLimit
+		if s := c.Query("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+		}
+		hits := index.Search(q, limit)
+		if hits == nil {
+			hits = []ValueHit{}
+		}
+		c.JSON(http.StatusOK, gin.H{"hits": hits})
+	}
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-zookeeper/zk"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// How znode data that is not valid UTF-8 is imported.
+const (
+	binaryRaw    = "raw"
+	binaryBase64 = "base64"
+	binarySkip   = "skip"
+)
+
+// zkConnectTimeout bounds waiting for a ZooKeeper session.
+const zkConnectTimeout = 10 * time.Second
+
+// zkLogger sends the ZooKeeper client's logs to zap at debug level.
+type zkLogger struct {
+	logger *zap.Logger
+}
+
+func (l zkLogger) Printf(format string, args ...interface{}) {
+	l.logger.Debug(fmt.Sprintf(format, args...))
+}
+
+// zkConnect opens a session, failing if none is established in time.
+func zkConnect(ctx context.Context, servers []string, digest string, logger *zap.Logger) (*zk.Conn, error) {
+	conn, events, err := zk.Connect(servers, zkConnectTimeout, zk.WithLogger(zkLogger{logger}))
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(zkConnectTimeout)
+	defer timer.Stop()
+	for connected := false; !connected; {
+		select {
+		case ev := <-events:
+			connected = ev.State == zk.StateHasSession
+			if ev.State == zk.StateAuthFailed {
+				conn.Close()
+				return nil, errors.New("ZooKeeper authentication failed")
+			}
+		case <-timer.C:
+			conn.Close()
+			return nil, fmt.Errorf("cannot connect to ZooKeeper at %s", strings.Join(servers, ","))
+		case <-ctx.Done():
+			conn.Close()
+			return nil, ctx.Err()
+		}
+	}
+	if digest != "" {
+		if err := conn.AddAuth("digest", []byte(digest)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// zkKey maps the path of a znode relative to the root to its key, or
+// returns false if a rule skips it.
+func zkKey(prefix, rel string, rules []ZNodeRule) (string, bool) {
+	for _, rule := range rules {
+		if rel != rule.Path && !strings.HasPrefix(rel, strings.TrimSuffix(rule.Path, "/")+"/") {
+			continue
+		}
+		if rule.Skip {
+			return "", false
+		}
+		rel = strings.TrimSuffix(rule.Key, "/") + "/" + strings.TrimPrefix(rel[len(rule.Path):], "/")
+		break
+	}
+	return prefix + strings.Trim(rel, "/"), true
+}
+
+// importZooKeeper copies the znodes under req.Root to etcd, or with
+// req.DryRun reports what it would write. Ephemeral znodes belong to live
+// sessions and are left out, as is /zookeeper.
+func importZooKeeper(ctx context.Context, client clientv3.KV, audit *AuditLog, actor string, req ZooKeeperImportRequest, progress *JobProgress, logger *zap.Logger) (ZooKeeperImportResult, error) {
+	result := ZooKeeperImportResult{DryRun: req.DryRun}
+	conn, err := zkConnect(ctx, req.Servers, req.Digest, logger)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+	// The client has no contexts; closing the connection fails pending calls.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var keys []DumpedKey
+	queue := []string{req.Root}
+	total := int64(1)
+	progress.SetTotal(total)
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		data, stat, err := conn.Get(path)
+		if errors.Is(err, zk.ErrNoNode) {
+			progress.Add(1)
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("get %s: %w", path, err)
+		}
+		result.Nodes++
+		progress.Add(1)
+
+		rel := "/" + strings.TrimPrefix(strings.TrimPrefix(path, req.Root), "/")
+		key, ok := zkKey(req.Prefix, rel, req.Rules)
+		if !ok {
+			result.Ignored++
+			continue
+		}
+		if stat.NumChildren > 0 {
+			children, _, err := conn.Children(path)
+			if err != nil && !errors.Is(err, zk.ErrNoNode) {
+				return result, fmt.Errorf("list %s: %w", path, err)
+			}
+			sort.Strings(children)
+			for _, child := range children {
+				if child := strings.TrimSuffix(path, "/") + "/" + child; child != "/zookeeper" {
+					queue = append(queue, child)
+					total++
+				}
+			}
+			progress.SetTotal(total)
+		}
+		switch {
+		case stat.EphemeralOwner != 0, stat.NumChildren > 0 && len(data) == 0, key == req.Prefix:
+			result.Ignored++
+			continue
+		case !utf8.Valid(data) && req.Binary == binarySkip:
+			result.Ignored++
+			continue
+		case !utf8.Valid(data) && req.Binary == binaryBase64:
+			data = []byte(base64.StdEncoding.EncodeToString(data))
+		}
+		keys = append(keys, DumpedKey{Key: key, Value: string(data)})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	for i := 1; i < len(keys); i++ {
+		if keys[i].Key == keys[i-1].Key {
+			return result, fmt.Errorf("several znodes map to %s", keys[i].Key)
+		}
+	}
+	result.Keys = int64(len(keys))
+	progress.Logf("Read %d znodes from ZooKeeper under %s, %d to import", result.Nodes, req.Root, len(keys))
+
+	if req.DryRun {
+		for _, k := range keys {
+			if len(result.Preview) == consulPreviewKeys {
+				break
+			}
+			result.Preview = append(result.Preview, k.Key)
+		}
+		return result, nil
+	}
+
+	progress.SetTotal(total + result.Keys)
+	loaded, err := loadKeys(ctx, client, audit, actor, "zookeeper-import", keys, req.Conflict, progress)
+	result.Imported, result.Skipped, result.Revision = loaded.Restored, loaded.Skipped, loaded.Revision
+	if err != nil {
+		return result, err
+	}
+	progress.Logf("Imported %d keys, skipped %d that already existed", result.Imported, result.Skipped)
+	return result, nil
+}
+
+// ZooKeeperImportJobHandler starts a job migrating a ZooKeeper tree into
+// etcd and returns it immediately.
+func ZooKeeperImportJobHandler(client clientv3.KV, jobs *Jobs, audit *AuditLog, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ZooKeeperImportRequest
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Servers) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if !strings.HasPrefix(req.Prefix, "/") || !strings.HasSuffix(req.Prefix, "/") || strings.HasPrefix(req.Prefix, "/.") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Prefix must start and end with / and be outside reserved prefixes"})
+			return
+		}
+		if req.Root = strings.TrimSuffix(req.Root, "/"); req.Root == "" {
+			req.Root = "/"
+		}
+		if !strings.HasPrefix(req.Root, "/") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Root must be a znode path"})
+			return
+		}
+		for _, rule := range req.Rules {
+			if !strings.HasPrefix(rule.Path, "/") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Rule paths must start with /"})
+				return
+			}
+		}
+		if req.Binary == "" {
+			req.Binary = binaryRaw
+		}
+		if req.Binary != binaryRaw && req.Binary != binaryBase64 && req.Binary != binarySkip {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Binary must be raw, base64 or skip"})
+			return
+		}
+		if req.Conflict == "" {
+			req.Conflict = conflictSkip
+		}
+		if req.Conflict != conflictSkip && req.Conflict != conflictOverwrite && req.Conflict != conflictFail {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Conflict must be skip, overwrite or fail"})
+			return
+		}
+
+		actor := requestActor(c)
+		ctx, cancel := requestContext(c)
+		defer cancel()
+		job, err := jobs.Start(ctx, "zookeeper-import", actor, func(ctx context.Context, progress *JobProgress) (interface{}, error) {
+			return importZooKeeper(ctx, client, audit, actor, req, progress, logger)
+		})
+		if err != nil {
+			logger.Error("Error starting ZooKeeper import job", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+		c.JSON(http.StatusAccepted, job)
+	}
+}
@@ -0,0 +1,4 @@
+// Package gatewaypb contains the generated protobuf and gRPC bindings for proto/gateway.proto.
+package gatewaypb
+
+//go:generate protoc -I ../../proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative gateway.proto
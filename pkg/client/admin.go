@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"etcd-gateway/pkg/types"
+)
+
+// Mirror returns the state of mirroring to another cluster.
+func (c *Client) Mirror(ctx context.Context) (types.MirrorStatus, error) {
+	var status types.MirrorStatus
+	err := c.get(ctx, "/api/v1/mirror", nil, &status)
+	return status, err
+}
+
+// ResyncMirror asks the mirror to copy the mirrored prefixes afresh.
+func (c *Client) ResyncMirror(ctx context.Context) error {
+	return c.post(ctx, "/api/v1/mirror/resync", nil, nil)
+}
+
+// KeyspaceReport returns the top largest keys and fastest growing prefixes
+// over window, or the gateway's defaults when 0.
+func (c *Client) KeyspaceReport(ctx context.Context, window time.Duration, top int) (types.KeyspaceReport, error) {
+	var report types.KeyspaceReport
+	q := query("top", itoa(top))
+	if window > 0 {
+		q.Set("window", window.String())
+	}
+	err := c.get(ctx, "/admin/reports/keyspace", q, &report)
+	return report, err
+}
+
+// Rewrites returns the prefix rewrite jobs.
+func (c *Client) Rewrites(ctx context.Context) ([]types.RewriteJob, error) {
+	var resp struct {
+		Jobs []types.RewriteJob `json:"jobs"`
+	}
+	err := c.get(ctx, "/admin/rewrites", nil, &resp)
+	return resp.Jobs, err
+}
+
+// PreviewRewrite returns what rewriting req.From to req.To would rename,
+// without renaming anything.
+func (c *Client) PreviewRewrite(ctx context.Context, req types.RewriteRequest) (types.RewritePreview, error) {
+	req.DryRun = true
+	var preview types.RewritePreview
+	err := c.post(ctx, "/admin/rewrites", req, &preview)
+	return preview, err
+}
+
+// StartRewrite starts a job renaming every key under req.From to be under
+// req.To.
+func (c *Client) StartRewrite(ctx context.Context, req types.RewriteRequest) (types.RewriteJob, error) {
+	req.DryRun = false
+	var job types.RewriteJob
+	err := c.post(ctx, "/admin/rewrites", req, &job)
+	return job, err
+}
+
+// Rewrite returns rewrite job id.
+func (c *Client) Rewrite(ctx context.Context, id string) (types.RewriteJob, error) {
+	var job types.RewriteJob
+	err := c.get(ctx, "/admin/rewrites/"+url.PathEscape(id), nil, &job)
+	return job, err
+}
+
+// ResumeRewrite resumes interrupted rewrite job id.
+func (c *Client) ResumeRewrite(ctx context.Context, id string) (types.RewriteJob, error) {
+	var job types.RewriteJob
+	err := c.post(ctx, "/admin/rewrites/"+url.PathEscape(id)+"/resume", nil, &job)
+	return job, err
+}
+
+// GitOps returns the state of syncing from git.
+func (c *Client) GitOps(ctx context.Context) (types.GitOpsStatus, error) {
+	var status types.GitOpsStatus
+	err := c.get(ctx, "/admin/gitops", nil, &status)
+	return status, err
+}
+
+// SyncGitOps asks for a sync from git now.
+func (c *Client) SyncGitOps(ctx context.Context) error {
+	return c.post(ctx, "/admin/gitops/sync", nil, nil)
+}
+
+// PutCluster registers cluster name, or replaces its registration.
+func (c *Client) PutCluster(ctx context.Context, name string, profile types.ClusterProfile) (types.Cluster, error) {
+	var cluster types.Cluster
+	err := c.put(ctx, "/admin/clusters/"+url.PathEscape(name), profile, &cluster)
+	return cluster, err
+}
+
+// DeleteCluster removes registered cluster name.
+func (c *Client) DeleteCluster(ctx context.Context, name string) error {
+	return c.delete(ctx, "/admin/clusters/"+url.PathEscape(name), nil)
+}
+
+// NoSpace returns the cluster's alarms and storage, and the steps that
+// remediate a NOSPACE alarm.
+func (c *Client) NoSpace(ctx context.Context) (types.NoSpaceStatus, error) {
+	var status types.NoSpaceStatus
+	err := c.get(ctx, "/admin/nospace", nil, &status)
+	return status, err
+}
+
+// RunNoSpaceStep runs NOSPACE remediation step "compact", "defrag" or
+// "disarm". Review the steps with NoSpace first; they are meant to be run in
+// that order.
+func (c *Client) RunNoSpaceStep(ctx context.Context, step string) (types.NoSpaceStepResult, error) {
+	var result types.NoSpaceStepResult
+	err := c.post(ctx, "/admin/nospace/"+url.PathEscape(step), types.NoSpaceStepRequest{Confirm: true}, &result)
+	return result, err
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// LogLevel returns the gateway's log level.
+func (c *Client) LogLevel(ctx context.Context) (string, error) {
+	var l logLevel
+	err := c.get(ctx, "/admin/loglevel", nil, &l)
+	return l.Level, err
+}
+
+// SetLogLevel changes the log level of the replica serving the request until
+// it restarts.
+func (c *Client) SetLogLevel(ctx context.Context, level string) (string, error) {
+	var l logLevel
+	err := c.put(ctx, "/admin/loglevel", logLevel{Level: level}, &l)
+	return l.Level, err
+}
@@ -0,0 +1,341 @@
+// Package client is a typed Go client for the gateway's HTTP API.
+//
+//	c, err := client.New("http://gateway:8080", client.WithToken(token))
+//	value, err := c.Value(ctx, "/app/config")
+//
+// Requests and responses use the bodies in pkg/types. Errors the gateway
+// answers with are returned as *APIError.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"etcd-gateway/pkg/types"
+)
+
+const (
+	// userHeader names the user a request is made for. The gateway records
+	// it as the actor of writes and keeps bookmarks and recent keys per user;
+	// a proxy in front of it normally sets it after authenticating.
+	userHeader        = "X-Forwarded-User"
+	environmentHeader = "X-Environment"
+	clusterHeader     = "X-Cluster"
+	editLockHeader    = "X-Edit-Lock-Token"
+
+	defaultRetries = 2
+	defaultBackoff = 200 * time.Millisecond
+)
+
+// Client calls one gateway. It is safe for concurrent use.
+type Client struct {
+	base    string
+	http    *http.Client
+	header  http.Header
+	retries int
+	backoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken authenticates requests with a bearer token, for gateways behind
+// an authenticating proxy.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithBasicAuth authenticates requests with a user name and password.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		req := http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(username, password)
+		c.header.Set("Authorization", req.Header.Get("Authorization"))
+	}
+}
+
+// WithUser makes requests on behalf of user, for gateways reached without a
+// proxy setting X-Forwarded-User.
+func WithUser(user string) Option {
+	return func(c *Client) {
+		c.header.Set(userHeader, user)
+	}
+}
+
+// WithHeader sets a header on every request.
+func WithHeader(name, value string) Option {
+	return func(c *Client) {
+		c.header.Set(name, value)
+	}
+}
+
+// WithHTTPClient sends requests with hc instead of a client with a 30
+// second timeout.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithRetries retries requests that failed to reach the gateway or that it
+// answered with a 5xx up to n times, waiting backoff before the first retry
+// and twice as long before each next one. Only requests that are safe to
+// repeat are retried: reads, PUTs and DELETEs. The default is 2 retries
+// after 200ms.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries, c.backoff = n, backoff
+	}
+}
+
+// New creates a client for the gateway at baseURL, such as
+// "http://gateway:8080".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("invalid gateway URL " + baseURL)
+	}
+	c := &Client{
+		base:    strings.TrimSuffix(baseURL, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+		header:  make(http.Header),
+		retries: defaultRetries,
+		backoff: defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// with returns a copy of c sending header name as value.
+func (c *Client) with(name, value string) *Client {
+	copied := *c
+	copied.header = c.header.Clone()
+	copied.header.Set(name, value)
+	return &copied
+}
+
+// Environment returns a client whose v1 API calls are served from
+// environment name, as listed by Environments.
+func (c *Client) Environment(name string) *Client {
+	return c.with(environmentHeader, name)
+}
+
+// Cluster returns a client whose v1 API calls are served from cluster name,
+// as listed by Clusters.
+func (c *Client) Cluster(name string) *Client {
+	return c.with(clusterHeader, name)
+}
+
+// APIError is an error response from the gateway.
+type APIError struct {
+	StatusCode int
+	Body       types.Error
+}
+
+func (e *APIError) Error() string {
+	if e.Body.Error == "" {
+		return fmt.Sprintf("gateway answered %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("gateway answered %d: %s", e.StatusCode, e.Body.Error)
+}
+
+// IsNotFound reports whether err is a 404 from the gateway.
+func IsNotFound(err error) bool {
+	return statusOf(err) == http.StatusNotFound
+}
+
+// IsConflict reports whether err is a 409 from the gateway, such as a
+// conditional write whose keys have changed.
+func IsConflict(err error) bool {
+	return statusOf(err) == http.StatusConflict
+}
+
+// IsGone reports whether err is a 410 from the gateway, such as asking for
+// changes since a revision that has been compacted.
+func IsGone(err error) bool {
+	return statusOf(err) == http.StatusGone
+}
+
+func statusOf(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// request is one call to the gateway. body is encoded as JSON unless it is
+// an io.Reader, which is sent as is and never retried. The responses of
+// stream requests are read for as long as they last, without the HTTP
+// client's timeout.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   interface{}
+	stream bool
+}
+
+// escapeKey escapes key for use in a path, keeping its slashes. Routes
+// ending in a key take it verbatim after their own slash, so "/app/x" is
+// addressed as /value//app/x.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// send makes r, retrying it if it is safe to, and returns the response of
+// the last attempt if the gateway answered with a 2xx, or an *APIError.
+// The caller closes the response body.
+func (c *Client) send(ctx context.Context, r request) (*http.Response, error) {
+	var data []byte
+	reader, raw := r.body.(io.Reader)
+	if r.body != nil && !raw {
+		var err error
+		if data, err = json.Marshal(r.body); err != nil {
+			return nil, err
+		}
+	}
+	retries := 0
+	if !raw && r.method != http.MethodPost {
+		retries = c.retries
+	}
+	hc := c.http
+	if r.stream && hc.Timeout > 0 {
+		untimed := *hc
+		untimed.Timeout = 0
+		hc = &untimed
+	}
+	u := c.base + r.path
+	if len(r.query) > 0 {
+		u += "?" + r.query.Encode()
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		if raw {
+			body = reader
+		} else if data != nil {
+			body = bytes.NewReader(data)
+		}
+		req, err := http.NewRequestWithContext(ctx, r.method, u, body)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range c.header {
+			req.Header[name] = values
+		}
+		for name, values := range r.header {
+			req.Header[name] = values
+		}
+		if data != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := hc.Do(req)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+		if err == nil {
+			err = responseError(resp)
+		}
+		if status := statusOf(err); attempt >= retries || ctx.Err() != nil || (status > 0 && status < 500) {
+			return nil, err
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// responseError reads an error response into an *APIError and closes it.
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if json.Unmarshal(data, &apiErr.Body) != nil {
+		apiErr.Body.Error = strings.TrimSpace(string(data))
+	}
+	return apiErr
+}
+
+// do makes r and decodes the response into out, unless out is nil.
+func (c *Client) do(ctx context.Context, r request, out interface{}) error {
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	return c.do(ctx, request{method: http.MethodGet, path: path, query: query}, out)
+}
+
+func (c *Client) post(ctx context.Context, path string, body, out interface{}) error {
+	return c.do(ctx, request{method: http.MethodPost, path: path, body: body}, out)
+}
+
+func (c *Client) put(ctx context.Context, path string, body, out interface{}) error {
+	return c.do(ctx, request{method: http.MethodPut, path: path, body: body}, out)
+}
+
+func (c *Client) delete(ctx context.Context, path string, query url.Values) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: path, query: query}, nil)
+}
+
+// Health checks that the gateway is serving.
+func (c *Client) Health(ctx context.Context) error {
+	return c.get(ctx, "/health", nil, nil)
+}
+
+// query builds query parameters from name, value pairs, leaving out those
+// whose value is empty.
+func query(pairs ...string) url.Values {
+	q := make(url.Values)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			q.Set(pairs[i], pairs[i+1])
+		}
+	}
+	return q
+}
+
+func itoa(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprint(n)
+}
+
+func btoa(b bool) string {
+	if !b {
+		return ""
+	}
+	return "true"
+}
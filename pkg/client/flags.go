@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"etcd-gateway/pkg/types"
+)
+
+// Flags returns every feature flag.
+func (c *Client) Flags(ctx context.Context) ([]types.Flag, error) {
+	var resp struct {
+		Flags []types.Flag `json:"flags"`
+	}
+	err := c.get(ctx, "/api/v1/flags", nil, &resp)
+	return resp.Flags, err
+}
+
+// Flag returns feature flag name.
+func (c *Client) Flag(ctx context.Context, name string) (types.Flag, error) {
+	var flag types.Flag
+	err := c.get(ctx, "/api/v1/flags/"+url.PathEscape(name), nil, &flag)
+	return flag, err
+}
+
+// PutFlag creates or replaces the feature flag named flag.Name.
+func (c *Client) PutFlag(ctx context.Context, flag types.Flag) (types.Flag, error) {
+	var stored types.Flag
+	err := c.put(ctx, "/api/v1/flags/"+url.PathEscape(flag.Name), flag, &stored)
+	return stored, err
+}
+
+// DeleteFlag deletes feature flag name.
+func (c *Client) DeleteFlag(ctx context.Context, name string) error {
+	return c.delete(ctx, "/api/v1/flags/"+url.PathEscape(name), nil)
+}
+
+// EvaluateFlags resolves the flags named, or every flag if none are, for a
+// subject.
+func (c *Client) EvaluateFlags(ctx context.Context, fc types.FlagContext, names ...string) (map[string]types.FlagResult, error) {
+	var resp struct {
+		Flags map[string]types.FlagResult `json:"flags"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/v1/flags/evaluate",
+		query:  url.Values{"flag": names},
+		body:   fc,
+	}, &resp)
+	return resp.Flags, err
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"etcd-gateway/pkg/types"
+)
+
+// Audit returns the most recent limit audit entries touching keys under
+// prefix, newest first.
+func (c *Client) Audit(ctx context.Context, prefix string, limit int) ([]types.AuditEntry, error) {
+	var resp struct {
+		Entries []types.AuditEntry `json:"entries"`
+	}
+	err := c.get(ctx, "/api/v1/audit", query("prefix", prefix, "limit", itoa(limit)), &resp)
+	return resp.Entries, err
+}
+
+// Undo reverts the write audit entry id recorded, returning the changes
+// reverting it made. Unless force is set it fails with a 409 if the keys
+// have changed since.
+func (c *Client) Undo(ctx context.Context, id string, force bool) ([]types.AuditChange, error) {
+	var resp struct {
+		Changes []types.AuditChange `json:"changes"`
+	}
+	err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/undo/" + url.PathEscape(id), query: query("force", btoa(force))}, &resp)
+	return resp.Changes, err
+}
+
+// Trash returns the soft-deleted keys under prefix.
+func (c *Client) Trash(ctx context.Context, prefix string) ([]types.TrashEntry, error) {
+	var resp struct {
+		Entries []types.TrashEntry `json:"entries"`
+	}
+	err := c.get(ctx, "/api/v1/trash", query("prefix", prefix), &resp)
+	return resp.Entries, err
+}
+
+// RestoreTrash puts a soft-deleted key back.
+func (c *Client) RestoreTrash(ctx context.Context, req types.TrashRestoreRequest) (types.TrashEntry, error) {
+	var entry types.TrashEntry
+	err := c.post(ctx, "/api/v1/trash/restore", req, &entry)
+	return entry, err
+}
+
+// Backups returns the snapshots available to restore from.
+func (c *Client) Backups(ctx context.Context) ([]types.RestorePoint, error) {
+	var points []types.RestorePoint
+	err := c.get(ctx, "/api/v1/backups", nil, &points)
+	return points, err
+}
+
+// Tags returns the tags, only those of prefix if it is not empty.
+func (c *Client) Tags(ctx context.Context, prefix string) ([]types.Tag, error) {
+	var resp struct {
+		Tags []types.Tag `json:"tags"`
+	}
+	err := c.get(ctx, "/api/v1/tags", query("prefix", prefix), &resp)
+	return resp.Tags, err
+}
+
+// CreateTag names the current state of a prefix.
+func (c *Client) CreateTag(ctx context.Context, req types.TagRequest) (types.Tag, error) {
+	var tag types.Tag
+	err := c.post(ctx, "/api/v1/tags", req, &tag)
+	return tag, err
+}
+
+// DeleteTag deletes tag name.
+func (c *Client) DeleteTag(ctx context.Context, name string) error {
+	return c.delete(ctx, "/api/v1/tags/"+url.PathEscape(name), nil)
+}
+
+// TagValues returns tag name and the keys under its prefix as they were
+// when it was taken.
+func (c *Client) TagValues(ctx context.Context, name string) (types.Tag, []types.KeyValue, error) {
+	var resp struct {
+		Tag types.Tag        `json:"tag"`
+		KVs []types.KeyValue `json:"kvs"`
+	}
+	err := c.get(ctx, "/api/v1/tags/"+url.PathEscape(name)+"/values", nil, &resp)
+	return resp.Tag, resp.KVs, err
+}
+
+// TagDiff compares tag name against tag against, or the current state when
+// against is empty.
+func (c *Client) TagDiff(ctx context.Context, name, against string) (types.PrefixDiff, error) {
+	var d types.PrefixDiff
+	err := c.get(ctx, "/api/v1/tags/"+url.PathEscape(name)+"/diff", query("against", against), &d)
+	return d, err
+}
+
+// RollbackResponse is the changes a rollback made and the revision they were
+// made at.
+type RollbackResponse struct {
+	Changes  []types.PlanChange `json:"changes"`
+	Revision int64              `json:"revision"`
+}
+
+// RollbackTag puts the keys under tag name's prefix back as they were when
+// it was taken.
+func (c *Client) RollbackTag(ctx context.Context, name string) (RollbackResponse, error) {
+	var resp RollbackResponse
+	err := c.post(ctx, "/api/v1/tags/"+url.PathEscape(name)+"/rollback", nil, &resp)
+	return resp, err
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"etcd-gateway/pkg/types"
+)
+
+// JobRunning is the status of a job that has not finished.
+const JobRunning = "running"
+
+// Jobs returns the most recent limit jobs, newest first, without their
+// logs.
+func (c *Client) Jobs(ctx context.Context, limit int) ([]types.Job, error) {
+	var resp struct {
+		Jobs []types.Job `json:"jobs"`
+	}
+	err := c.get(ctx, "/api/v1/jobs", query("limit", itoa(limit)), &resp)
+	return resp.Jobs, err
+}
+
+// Job returns job id's progress, logs and result.
+func (c *Client) Job(ctx context.Context, id string) (types.Job, error) {
+	var job types.Job
+	err := c.get(ctx, "/api/v1/jobs/"+url.PathEscape(id), nil, &job)
+	return job, err
+}
+
+// CancelJob cancels running job id. The job stops shortly after.
+func (c *Client) CancelJob(ctx context.Context, id string) (types.Job, error) {
+	var job types.Job
+	err := c.post(ctx, "/api/v1/jobs/"+url.PathEscape(id)+"/cancel", nil, &job)
+	return job, err
+}
+
+// WaitJob polls job id every interval until it finishes, returning it as it
+// finished. Whether it succeeded is up to the caller to check.
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration) (types.Job, error) {
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil || job.Status != JobRunning {
+			return job, err
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return job, ctx.Err()
+		}
+	}
+}
+
+// JobResult decodes the result of finished job into out.
+func JobResult(job types.Job, out interface{}) error {
+	return json.Unmarshal(job.Result, out)
+}
+
+// JobArtifact downloads the file finished job id produced. The caller
+// closes it.
+func (c *Client) JobArtifact(ctx context.Context, id string) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: "/api/v1/jobs/" + url.PathEscape(id) + "/artifact", stream: true})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// StartExport starts a job exporting the keys under a prefix; download the
+// result with JobArtifact once it has finished.
+func (c *Client) StartExport(ctx context.Context, req types.ExportRequest) (types.Job, error) {
+	var job types.Job
+	err := c.post(ctx, "/api/v1/jobs/export", req, &job)
+	return job, err
+}
+
+// ImportOptions says how to import a file.
+type ImportOptions struct {
+	// Prefix is where keys are written.
+	Prefix string
+	// Strip is removed from the start of each imported key first.
+	Strip string
+	// Format is "json" (the default), "snapshot" or "tar.gz".
+	Format string
+	// Conflict is what to do about existing keys: "skip" (the default),
+	// "overwrite" or "fail".
+	Conflict string
+}
+
+// StartImport starts a job importing the file read from r.
+func (c *Client) StartImport(ctx context.Context, r io.Reader, opts ImportOptions) (types.Job, error) {
+	var job types.Job
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/v1/jobs/import",
+		query:  query("prefix", opts.Prefix, "strip", opts.Strip, "format", opts.Format, "conflict", opts.Conflict),
+		header: http.Header{"Content-Type": {"application/octet-stream"}},
+		body:   r,
+		stream: true,
+	}, &job)
+	return job, err
+}
+
+// StartRestore starts a job restoring a set of keys.
+func (c *Client) StartRestore(ctx context.Context, req types.RestoreRequest) (types.Job, error) {
+	var job types.Job
+	err := c.post(ctx, "/api/v1/jobs/restore", req, &job)
+	return job, err
+}
+
+// StartConsulImport starts a job migrating a Consul KV tree.
+func (c *Client) StartConsulImport(ctx context.Context, req types.ConsulImportRequest) (types.Job, error) {
+	var job types.Job
+	err := c.post(ctx, "/api/v1/jobs/consul-import", req, &job)
+	return job, err
+}
+
+// StartZooKeeperImport starts a job migrating a ZooKeeper tree.
+func (c *Client) StartZooKeeperImport(ctx context.Context, req types.ZooKeeperImportRequest) (types.Job, error) {
+	var job types.Job
+	err := c.post(ctx, "/api/v1/jobs/zookeeper-import", req, &job)
+	return job, err
+}
@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"etcd-gateway/pkg/types"
+)
+
+// KeysOptions selects and orders a keys listing.
+type KeysOptions struct {
+	// Prefix defaults to "/".
+	Prefix string
+	// Depth limits the tree to that many levels below Prefix; 0 is all.
+	Depth int
+	// KeysOnly leaves out values.
+	KeysOnly bool
+	// SortBy is "key" (the default), "natural", "create", "mod" or
+	// "version".
+	SortBy string
+	// Order is "asc" (the default) or "desc".
+	Order string
+}
+
+func (o KeysOptions) query() url.Values {
+	return query("prefix", o.Prefix, "depth", itoa(o.Depth), "keysOnly", btoa(o.KeysOnly), "sortBy", o.SortBy, "order", o.Order)
+}
+
+// Keys returns the tree of keys under opts.Prefix.
+func (c *Client) Keys(ctx context.Context, opts KeysOptions) ([]*types.TreeNode, error) {
+	var nodes []*types.TreeNode
+	err := c.get(ctx, "/api/v1/keys", opts.query(), &nodes)
+	return nodes, err
+}
+
+// KeysPage returns one page of up to limit keys under opts.Prefix, starting
+// where the page whose Continue is cont ended, or at the start when cont is
+// empty.
+func (c *Client) KeysPage(ctx context.Context, opts KeysOptions, limit int, cont string) (types.KeysPage, error) {
+	q := opts.query()
+	q.Set("limit", strconv.Itoa(limit))
+	if cont != "" {
+		q.Set("continue", cont)
+	}
+	var page types.KeysPage
+	err := c.get(ctx, "/api/v1/keys", q, &page)
+	return page, err
+}
+
+// Value returns the value of key.
+func (c *Client) Value(ctx context.Context, key string) (types.Value, error) {
+	var value types.Value
+	err := c.get(ctx, "/api/v1/value/"+escapeKey(key), nil, &value)
+	return value, err
+}
+
+// AnnotatedValue returns the value of key with its annotation, if it has
+// one.
+func (c *Client) AnnotatedValue(ctx context.Context, key string) (types.Value, error) {
+	var value types.Value
+	err := c.get(ctx, "/api/v1/value/"+escapeKey(key), query("annotations", "true"), &value)
+	return value, err
+}
+
+// Exists reports whether key exists, without reading its value.
+func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
+	err := c.do(ctx, request{method: http.MethodHead, path: "/api/v1/value/" + escapeKey(key)}, nil)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteResponse says how many keys a delete removed, and whether they were
+// moved to the trash rather than deleted outright.
+type DeleteResponse struct {
+	Deleted int  `json:"deleted"`
+	Trashed bool `json:"trashed"`
+}
+
+// Delete deletes key, or with recursive every key under it too.
+func (c *Client) Delete(ctx context.Context, key string, recursive bool) (DeleteResponse, error) {
+	var resp DeleteResponse
+	err := c.do(ctx, request{method: http.MethodDelete, path: "/api/v1/value/" + escapeKey(key), query: query("recursive", btoa(recursive))}, &resp)
+	return resp, err
+}
+
+// Put writes value to key, returning the revision it was written at.
+func (c *Client) Put(ctx context.Context, key, value string) (int64, error) {
+	resp, err := c.BatchPut(ctx, types.BatchPutRequest{KVs: []types.BatchPut{{Key: key, Value: value}}})
+	return resp.Revision, err
+}
+
+// Meta returns the metadata of key.
+func (c *Client) Meta(ctx context.Context, key string) (types.KeyMeta, error) {
+	var meta types.KeyMeta
+	err := c.get(ctx, "/api/v1/meta/"+escapeKey(key), nil, &meta)
+	return meta, err
+}
+
+// Children returns the immediate children of prefix, such as "/app/", or
+// "/" for the top level.
+func (c *Client) Children(ctx context.Context, prefix string) ([]types.ChildNode, error) {
+	var children []types.ChildNode
+	err := c.get(ctx, "/api/v1/children"+escapeKey(prefix), nil, &children)
+	return children, err
+}
+
+// CountResponse is how many keys are under Prefix at Revision.
+type CountResponse struct {
+	Prefix   string `json:"prefix"`
+	Count    int64  `json:"count"`
+	Revision int64  `json:"revision"`
+}
+
+// Count counts the keys under prefix.
+func (c *Client) Count(ctx context.Context, prefix string) (CountResponse, error) {
+	var resp CountResponse
+	err := c.get(ctx, "/api/v1/count", query("prefix", prefix), &resp)
+	return resp, err
+}
+
+// RangeOptions selects the keys from Start up to, but not including, End.
+type RangeOptions struct {
+	Start string
+	// End defaults to the key after Start, selecting only Start.
+	End      string
+	Limit    int
+	KeysOnly bool
+}
+
+// RangeResponse is the keys in a range; More is set when Limit cut it short.
+type RangeResponse struct {
+	KVs      []types.KeyValue `json:"kvs"`
+	More     bool             `json:"more"`
+	Revision int64            `json:"revision"`
+}
+
+// Range returns the keys in a range.
+func (c *Client) Range(ctx context.Context, opts RangeOptions) (RangeResponse, error) {
+	var resp RangeResponse
+	err := c.get(ctx, "/api/v1/range", query("start", opts.Start, "end", opts.End, "limit", itoa(opts.Limit), "keysOnly", btoa(opts.KeysOnly)), &resp)
+	return resp, err
+}
+
+// BatchGetResponse is the keys a batch get found, and those it did not.
+type BatchGetResponse struct {
+	KVs      []types.KeyValue `json:"kvs"`
+	Missing  []string         `json:"missing"`
+	Revision int64            `json:"revision"`
+}
+
+// BatchGet reads keys and prefixes at one revision.
+func (c *Client) BatchGet(ctx context.Context, req types.BatchGetRequest) (BatchGetResponse, error) {
+	var resp BatchGetResponse
+	err := c.post(ctx, "/api/v1/batch/get", req, &resp)
+	return resp, err
+}
+
+// BatchPutResponse is how many keys a batch put wrote, in how many
+// transactions, and the revision of the last.
+type BatchPutResponse struct {
+	Written      int   `json:"written"`
+	Revision     int64 `json:"revision"`
+	Transactions int   `json:"transactions"`
+}
+
+// BatchPut writes keys, conditionally on their ModRevision where given.
+func (c *Client) BatchPut(ctx context.Context, req types.BatchPutRequest) (BatchPutResponse, error) {
+	var resp BatchPutResponse
+	err := c.post(ctx, "/api/v1/batch/put", req, &resp)
+	return resp, err
+}
+
+// BatchDeleteResponse is the outcome of deleting each key of a batch.
+type BatchDeleteResponse struct {
+	Results []types.BatchDeleteResult `json:"results"`
+	Deleted int                       `json:"deleted"`
+	Trashed bool                      `json:"trashed"`
+}
+
+// BatchDelete deletes keys.
+func (c *Client) BatchDelete(ctx context.Context, req types.BatchDeleteRequest) (BatchDeleteResponse, error) {
+	var resp BatchDeleteResponse
+	err := c.post(ctx, "/api/v1/batch/delete", req, &resp)
+	return resp, err
+}
+
+// SearchOptions is a key, and optionally value, search.
+type SearchOptions struct {
+	Query string
+	// Mode is "glob" (the default) or "regex".
+	Mode string
+	// Values also matches Query against values.
+	Values bool
+	Prefix string
+	Limit  int
+}
+
+// SearchResponse is the keys a search matched; Truncated is set when it
+// stopped at the limit.
+type SearchResponse struct {
+	Matches   []types.SearchMatch `json:"matches"`
+	Truncated bool                `json:"truncated"`
+	Revision  int64               `json:"revision"`
+}
+
+// Search matches keys, and optionally values, against a pattern.
+func (c *Client) Search(ctx context.Context, opts SearchOptions) (SearchResponse, error) {
+	var resp SearchResponse
+	err := c.get(ctx, "/api/v1/search", query("q", opts.Query, "mode", opts.Mode, "values", btoa(opts.Values), "prefix", opts.Prefix, "limit", itoa(opts.Limit)), &resp)
+	return resp, err
+}
+
+// SearchValues runs a full-text query against the value index.
+func (c *Client) SearchValues(ctx context.Context, q string, limit int) ([]types.ValueHit, error) {
+	var resp struct {
+		Hits []types.ValueHit `json:"hits"`
+	}
+	err := c.get(ctx, "/api/v1/search/values", query("q", q, "limit", itoa(limit)), &resp)
+	return resp.Hits, err
+}
+
+// ChangesResponse is the events after a revision. Continue from Revision;
+// More is set when there are further events to fetch.
+type ChangesResponse struct {
+	Events   []types.ChangeEvent `json:"events"`
+	Revision int64               `json:"revision"`
+	More     bool                `json:"more"`
+}
+
+// Changes returns up to limit events under prefix after revision since. It
+// fails with a 410 once since has been compacted.
+func (c *Client) Changes(ctx context.Context, prefix string, since int64, limit int) (ChangesResponse, error) {
+	var resp ChangesResponse
+	err := c.get(ctx, "/api/v1/changes", query("prefix", prefix, "since", strconv.FormatInt(since, 10), "limit", itoa(limit)), &resp)
+	return resp, err
+}
+
+// Stats returns the size and shape of the keys under prefix, with the top
+// largest keys.
+func (c *Client) Stats(ctx context.Context, prefix string, top int) (types.PrefixStats, error) {
+	var stats types.PrefixStats
+	err := c.get(ctx, "/api/v1/stats", query("prefix", prefix, "top", itoa(top)), &stats)
+	return stats, err
+}
+
+// Dashboard returns the cluster's health and an overview of its keys.
+func (c *Client) Dashboard(ctx context.Context) (types.Dashboard, error) {
+	var d types.Dashboard
+	err := c.get(ctx, "/api/v1/dashboard", nil, &d)
+	return d, err
+}
+
+// Render returns the value of key with its template variables expanded,
+// from vars and other keys.
+func (c *Client) Render(ctx context.Context, key string, vars map[string]string) ([]byte, error) {
+	q := make(url.Values)
+	for name, value := range vars {
+		q.Add("vars", name+"="+value)
+	}
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: "/api/v1/render/" + escapeKey(key), query: q})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
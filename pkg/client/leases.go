@@ -0,0 +1,122 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"etcd-gateway/pkg/types"
+)
+
+// GrantLease grants a lease, writing req.Keys attached to it.
+func (c *Client) GrantLease(ctx context.Context, req types.LeaseGrantRequest) (types.Lease, error) {
+	var lease types.Lease
+	err := c.post(ctx, "/api/v1/leases", req, &lease)
+	return lease, err
+}
+
+// Lease returns lease id's remaining TTL and attached keys. It fails with a
+// 404 once the lease has expired.
+func (c *Client) Lease(ctx context.Context, id string) (types.Lease, error) {
+	var lease types.Lease
+	err := c.get(ctx, "/api/v1/leases/"+url.PathEscape(id), nil, &lease)
+	return lease, err
+}
+
+// KeepAliveLease renews lease id, returning its TTL in seconds.
+func (c *Client) KeepAliveLease(ctx context.Context, id string) (int64, error) {
+	var resp struct {
+		TTL int64 `json:"ttl"`
+	}
+	err := c.post(ctx, "/api/v1/leases/"+url.PathEscape(id)+"/keepalive", nil, &resp)
+	return resp.TTL, err
+}
+
+// RevokeLease revokes lease id, deleting the keys attached to it.
+func (c *Client) RevokeLease(ctx context.Context, id string) error {
+	return c.delete(ctx, "/api/v1/leases/"+url.PathEscape(id), nil)
+}
+
+// LeaseOverview is the active leases, soonest to expire first, and how many
+// keys are attached to them.
+type LeaseOverview struct {
+	Leases []types.Lease `json:"leases"`
+	Count  int           `json:"count"`
+	Keys   int           `json:"keys"`
+}
+
+// Leases lists the active leases, only those expiring within within if it is
+// not 0, and only those with keys under prefix if it is not empty.
+func (c *Client) Leases(ctx context.Context, within time.Duration, prefix string) (LeaseOverview, error) {
+	var overview LeaseOverview
+	q := query("prefix", prefix)
+	if within > 0 {
+		q.Set("within", within.String())
+	}
+	err := c.get(ctx, "/api/v1/leases/overview", q, &overview)
+	return overview, err
+}
+
+// ManagedLeases is the leases the gateway keeps alive: those marked as
+// managed and those of keys under Prefixes, kept by Replica.
+type ManagedLeases struct {
+	Leases   []types.ManagedLease `json:"leases"`
+	Prefixes []string             `json:"prefixes"`
+	Replica  string               `json:"replica"`
+}
+
+// ManagedLeases lists the leases the gateway keeps alive.
+func (c *Client) ManagedLeases(ctx context.Context) (ManagedLeases, error) {
+	var managed ManagedLeases
+	err := c.get(ctx, "/api/v1/leases/managed", nil, &managed)
+	return managed, err
+}
+
+// MarkLeaseManaged has the gateway keep lease id alive.
+func (c *Client) MarkLeaseManaged(ctx context.Context, id string) (types.ManagedLease, error) {
+	var mark types.ManagedLease
+	err := c.put(ctx, "/api/v1/leases/"+url.PathEscape(id)+"/managed", nil, &mark)
+	return mark, err
+}
+
+// UnmarkLeaseManaged stops the gateway keeping lease id alive.
+func (c *Client) UnmarkLeaseManaged(ctx context.Context, id string) error {
+	return c.delete(ctx, "/api/v1/leases/"+url.PathEscape(id)+"/managed", nil)
+}
+
+// WatchExpiry calls fn with each expiry event for keys under prefix until
+// ctx is cancelled or the stream ends. The stream is not resumed; events
+// sent while it is down are missed.
+func (c *Client) WatchExpiry(ctx context.Context, prefix string, fn func(types.ExpiryEvent)) error {
+	resp, err := c.send(ctx, request{
+		method: http.MethodGet,
+		path:   "/api/v1/leases/expiry",
+		query:  query("prefix", prefix),
+		header: http.Header{"Accept": {"text/event-stream"}},
+		stream: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event types.ExpiryEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return err
+		}
+		fn(event)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
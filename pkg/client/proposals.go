@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"etcd-gateway/pkg/types"
+)
+
+// Proposals returns the proposals, only those with status if it is not
+// empty.
+func (c *Client) Proposals(ctx context.Context, status string) ([]types.Proposal, error) {
+	var resp struct {
+		Proposals []types.Proposal `json:"proposals"`
+	}
+	err := c.get(ctx, "/api/v1/proposals", query("status", status), &resp)
+	return resp.Proposals, err
+}
+
+// SubmitProposal stores a draft set of writes for review.
+func (c *Client) SubmitProposal(ctx context.Context, req types.ProposalRequest) (types.Proposal, error) {
+	var prop types.Proposal
+	err := c.post(ctx, "/api/v1/proposals", req, &prop)
+	return prop, err
+}
+
+// Proposal returns proposal id.
+func (c *Client) Proposal(ctx context.Context, id string) (types.Proposal, error) {
+	var prop types.Proposal
+	err := c.get(ctx, "/api/v1/proposals/"+url.PathEscape(id), nil, &prop)
+	return prop, err
+}
+
+// ApproveProposal approves pending proposal id, with an optional comment.
+func (c *Client) ApproveProposal(ctx context.Context, id, comment string) (types.Proposal, error) {
+	return c.reviewProposal(ctx, id, "approve", comment)
+}
+
+// RejectProposal rejects pending proposal id, with an optional comment.
+func (c *Client) RejectProposal(ctx context.Context, id, comment string) (types.Proposal, error) {
+	return c.reviewProposal(ctx, id, "reject", comment)
+}
+
+func (c *Client) reviewProposal(ctx context.Context, id, verdict, comment string) (types.Proposal, error) {
+	var prop types.Proposal
+	err := c.post(ctx, "/api/v1/proposals/"+url.PathEscape(id)+"/"+verdict, map[string]string{"comment": comment}, &prop)
+	return prop, err
+}
+
+// ApplyProposal atomically applies approved proposal id.
+func (c *Client) ApplyProposal(ctx context.Context, id string) (types.Proposal, error) {
+	var prop types.Proposal
+	err := c.post(ctx, "/api/v1/proposals/"+url.PathEscape(id)+"/apply", nil, &prop)
+	return prop, err
+}
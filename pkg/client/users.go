@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"etcd-gateway/pkg/types"
+)
+
+// AnnotationQuery searches annotations.
+type AnnotationQuery struct {
+	// Query matches annotations containing it, case-insensitively.
+	Query string
+	Owner string
+	// Prefix only searches annotations of keys under it.
+	Prefix string
+	Limit  int
+}
+
+// AnnotationsResponse is the annotated keys a search found; Truncated is set
+// when it stopped at the limit.
+type AnnotationsResponse struct {
+	Annotations []types.AnnotatedKey `json:"annotations"`
+	Truncated   bool                 `json:"truncated"`
+}
+
+// Annotations searches the annotations of keys.
+func (c *Client) Annotations(ctx context.Context, q AnnotationQuery) (AnnotationsResponse, error) {
+	var resp AnnotationsResponse
+	err := c.get(ctx, "/api/v1/annotations", query("q", q.Query, "owner", q.Owner, "prefix", q.Prefix, "limit", itoa(q.Limit)), &resp)
+	return resp, err
+}
+
+// Annotation returns the annotation of key.
+func (c *Client) Annotation(ctx context.Context, key string) (types.Annotation, error) {
+	var a types.Annotation
+	err := c.get(ctx, "/api/v1/annotations/"+escapeKey(key), nil, &a)
+	return a, err
+}
+
+// PutAnnotation sets the annotation of key, which need not exist yet.
+func (c *Client) PutAnnotation(ctx context.Context, key string, a types.Annotation) (types.Annotation, error) {
+	var stored types.Annotation
+	err := c.put(ctx, "/api/v1/annotations/"+escapeKey(key), a, &stored)
+	return stored, err
+}
+
+// DeleteAnnotation removes the annotation of key.
+func (c *Client) DeleteAnnotation(ctx context.Context, key string) error {
+	return c.delete(ctx, "/api/v1/annotations/"+escapeKey(key), nil)
+}
+
+// Bookmarks returns the user's bookmarks ordered by key. Bookmarks and
+// recent keys are kept per user, so need WithUser or a proxy naming one.
+func (c *Client) Bookmarks(ctx context.Context) ([]types.Bookmark, error) {
+	var resp struct {
+		Bookmarks []types.Bookmark `json:"bookmarks"`
+	}
+	err := c.get(ctx, "/api/v1/me/bookmarks", nil, &resp)
+	return resp.Bookmarks, err
+}
+
+// PutBookmark bookmarks a key or prefix, with an optional label.
+func (c *Client) PutBookmark(ctx context.Context, key, label string) (types.Bookmark, error) {
+	var b types.Bookmark
+	err := c.put(ctx, "/api/v1/me/bookmarks/"+escapeKey(key), map[string]string{"label": label}, &b)
+	return b, err
+}
+
+// DeleteBookmark removes a bookmark.
+func (c *Client) DeleteBookmark(ctx context.Context, key string) error {
+	return c.delete(ctx, "/api/v1/me/bookmarks/"+escapeKey(key), nil)
+}
+
+// RecentKeys returns the keys the user viewed recently, newest first.
+func (c *Client) RecentKeys(ctx context.Context) ([]types.RecentKey, error) {
+	var resp struct {
+		Recent []types.RecentKey `json:"recent"`
+	}
+	err := c.get(ctx, "/api/v1/me/recent", nil, &resp)
+	return resp.Recent, err
+}
+
+// ClearRecentKeys forgets the keys the user viewed recently.
+func (c *Client) ClearRecentKeys(ctx context.Context) error {
+	return c.delete(ctx, "/api/v1/me/recent", nil)
+}
+
+// EditLock returns who is editing key. It fails with a 404 if no one is.
+func (c *Client) EditLock(ctx context.Context, key string) (types.EditLock, error) {
+	var lock types.EditLock
+	err := c.get(ctx, "/api/v1/edit-lock/"+escapeKey(key), nil, &lock)
+	return lock, err
+}
+
+// AcquireEditLock takes the edit lock on key for ttl seconds, or the
+// gateway's default when 0, returning the lock and the token to renew and
+// release it with. If someone else holds it, it fails with a 409 unless
+// force is set, which takes it over.
+func (c *Client) AcquireEditLock(ctx context.Context, key string, ttl int64, force bool) (types.EditLock, string, error) {
+	var resp struct {
+		Lock  types.EditLock `json:"lock"`
+		Token string         `json:"token"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/v1/edit-lock/" + escapeKey(key),
+		query:  query("force", btoa(force)),
+		body:   types.EditLockRequest{TTL: ttl},
+	}, &resp)
+	return resp.Lock, resp.Token, err
+}
+
+// RenewEditLock renews the edit lock on key held with token for another TTL.
+func (c *Client) RenewEditLock(ctx context.Context, key, token string) (types.EditLock, error) {
+	var lock types.EditLock
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		path:   "/api/v1/edit-lock/" + escapeKey(key),
+		header: http.Header{editLockHeader: {token}},
+	}, &lock)
+	return lock, err
+}
+
+// ReleaseEditLock releases the edit lock on key held with token.
+func (c *Client) ReleaseEditLock(ctx context.Context, key, token string) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/api/v1/edit-lock/" + escapeKey(key),
+		header: http.Header{editLockHeader: {token}},
+	}, nil)
+}
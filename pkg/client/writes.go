@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"etcd-gateway/pkg/types"
+)
+
+// CopyResponse is how many keys a copy wrote, read at SourceRevision.
+type CopyResponse struct {
+	Copied         int   `json:"copied"`
+	SourceRevision int64 `json:"sourceRevision"`
+}
+
+// Copy copies a key or subtree to a new location.
+func (c *Client) Copy(ctx context.Context, req types.CopyRequest) (CopyResponse, error) {
+	var resp CopyResponse
+	err := c.post(ctx, "/api/v1/copy", req, &resp)
+	return resp, err
+}
+
+// Move renames a key or subtree, returning how many keys it moved. A move
+// that fails part way through can be finished by repeating it.
+func (c *Client) Move(ctx context.Context, req types.CopyRequest) (int, error) {
+	var resp struct {
+		Moved int `json:"moved"`
+	}
+	err := c.post(ctx, "/api/v1/move", req, &resp)
+	return resp.Moved, err
+}
+
+// Plan computes the changes that bring a prefix to a desired state, to
+// review before applying them with ApplyPlan.
+func (c *Client) Plan(ctx context.Context, desired types.DesiredState) (types.Plan, error) {
+	var plan types.Plan
+	err := c.post(ctx, "/api/v1/plan", desired, &plan)
+	return plan, err
+}
+
+// ApplyResponse is how many changes a plan made and the revision it made
+// them at.
+type ApplyResponse struct {
+	Applied  int   `json:"applied"`
+	Revision int64 `json:"revision"`
+}
+
+// ApplyPlan applies plan id, failing with a 409 if any key it changes has
+// been modified since it was computed.
+func (c *Client) ApplyPlan(ctx context.Context, id string) (ApplyResponse, error) {
+	var resp ApplyResponse
+	err := c.post(ctx, "/api/v1/apply/"+url.PathEscape(id), nil, &resp)
+	return resp, err
+}
+
+// DiffOptions names the subtrees to compare. Either side can be read from
+// another environment or cluster.
+type DiffOptions struct {
+	Left, Right               string
+	LeftEnv, RightEnv         string
+	LeftCluster, RightCluster string
+}
+
+// DiffPrefixes compares two subtrees.
+func (c *Client) DiffPrefixes(ctx context.Context, opts DiffOptions) (types.PrefixDiff, error) {
+	var d types.PrefixDiff
+	err := c.get(ctx, "/api/v1/diff/prefixes", query(
+		"left", opts.Left, "right", opts.Right,
+		"leftEnv", opts.LeftEnv, "rightEnv", opts.RightEnv,
+		"leftCluster", opts.LeftCluster, "rightCluster", opts.RightCluster,
+	), &d)
+	return d, err
+}
+
+// PreviewPromotion returns the diff promoting keys would apply, with the
+// token to apply it with. req.Token is ignored.
+func (c *Client) PreviewPromotion(ctx context.Context, req types.PromoteRequest) (types.PromotePlan, error) {
+	req.Token = ""
+	var plan types.PromotePlan
+	err := c.post(ctx, "/api/v1/promote", req, &plan)
+	return plan, err
+}
+
+// PromoteResponse is the changes a promotion made.
+type PromoteResponse struct {
+	Promoted int                   `json:"promoted"`
+	Changes  []types.PromoteChange `json:"changes"`
+}
+
+// Promote applies a previewed promotion, req.Token being the preview's. It
+// fails with a 409 if either side has changed since the preview.
+func (c *Client) Promote(ctx context.Context, req types.PromoteRequest) (PromoteResponse, error) {
+	var resp PromoteResponse
+	if req.Token == "" {
+		return resp, errors.New("promoting needs the token of a preview")
+	}
+	err := c.post(ctx, "/api/v1/promote", req, &resp)
+	return resp, err
+}
+
+// Environments returns the configured environments.
+func (c *Client) Environments(ctx context.Context) ([]types.Environment, error) {
+	var envs []types.Environment
+	err := c.get(ctx, "/api/v1/environments", nil, &envs)
+	return envs, err
+}
+
+// Clusters returns the clusters the gateway fronts besides its own.
+func (c *Client) Clusters(ctx context.Context) ([]types.Cluster, error) {
+	var clusters []types.Cluster
+	err := c.get(ctx, "/api/v1/clusters", nil, &clusters)
+	return clusters, err
+}
@@ -0,0 +1,18 @@
+package types
+
+import "time"
+
+// Annotation is what people know about a key that etcd does not record.
+type Annotation struct {
+	Description string    `json:"description,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+	Links       []string  `json:"links,omitempty"`
+	UpdatedBy   string    `json:"updatedBy,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// AnnotatedKey pairs a key with its annotation in search results.
+type AnnotatedKey struct {
+	Key        string     `json:"key"`
+	Annotation Annotation `json:"annotation"`
+}
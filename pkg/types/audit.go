@@ -0,0 +1,22 @@
+package types
+
+import "time"
+
+// AuditChange is the effect of a write on one key. Before is the key as it
+// was, or nil if the write created it.
+type AuditChange struct {
+	Key     string    `json:"key"`
+	Before  *KeyValue `json:"before,omitempty"`
+	Deleted bool      `json:"deleted"`
+	// Revision is the key's ModRevision after the write; zero when deleted.
+	Revision int64 `json:"revision"`
+}
+
+// AuditEntry records a write made through the gateway.
+type AuditEntry struct {
+	ID      string        `json:"id"`
+	Time    time.Time     `json:"time"`
+	Actor   string        `json:"actor"`
+	Action  string        `json:"action"`
+	Changes []AuditChange `json:"changes"`
+}
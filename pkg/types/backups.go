@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// RestorePoint is a snapshot and the outcome of its verification.
+type RestorePoint struct {
+	Name       string     `json:"name"`
+	Time       time.Time  `json:"time"`
+	Size       int64      `json:"size"`
+	SHA256     string     `json:"sha256,omitempty"`
+	Revision   int64      `json:"revision,omitempty"`
+	Keys       int64      `json:"keys"`
+	Verified   bool       `json:"verified"`
+	Error      string     `json:"error,omitempty"`
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
+}
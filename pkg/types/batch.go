@@ -0,0 +1,32 @@
+package types
+
+// BatchGetRequest lists exact keys and/or prefixes to read together.
+type BatchGetRequest struct {
+	Keys     []string `json:"keys"`
+	Prefixes []string `json:"prefixes"`
+}
+
+// BatchPut is one key written by POST /batch/put. When ModRevision is set,
+// the key is only written if it is still at that revision, 0 meaning it must
+// not exist.
+type BatchPut struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ModRevision *int64 `json:"modRevision,omitempty"`
+}
+
+// BatchPutRequest lists the keys to write together.
+type BatchPutRequest struct {
+	KVs []BatchPut `json:"kvs"`
+}
+
+// BatchDeleteRequest lists the exact keys to delete together.
+type BatchDeleteRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// BatchDeleteResult says whether one key of a batch delete existed.
+type BatchDeleteResult struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+}
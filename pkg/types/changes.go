@@ -0,0 +1,8 @@
+package types
+
+// ChangeEvent is a single put or delete replayed from etcd's history.
+type ChangeEvent struct {
+	Type     string   `json:"type"`
+	Kv       KeyValue `json:"kv"`
+	Revision int64    `json:"revision"`
+}
@@ -0,0 +1,10 @@
+package types
+
+// ChildNode describes one immediate child of a node in the key tree.
+type ChildNode struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	HasValue   bool   `json:"hasValue"`
+	IsLeaf     bool   `json:"isLeaf"`
+	ChildCount int    `json:"childCount"`
+}
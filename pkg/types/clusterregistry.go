@@ -0,0 +1,14 @@
+package types
+
+// ClusterProfile is how to connect to a registered cluster, the body of
+// PUT /admin/clusters/:name. Setting CACert, Cert or Key implies TLS; TLS
+// alone trusts the system roots.
+type ClusterProfile struct {
+	Endpoints []string `json:"endpoints" binding:"required"`
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+	TLS       bool     `json:"tls"`
+	CACert    string   `json:"caCert"`
+	Cert      string   `json:"cert"`
+	Key       string   `json:"key"`
+}
@@ -0,0 +1,12 @@
+package types
+
+// Cluster is a named etcd cluster fronted by the gateway besides its own, as
+// GET /clusters lists it. Registered clusters were added through the cluster
+// registry rather than CLUSTERS.
+type Cluster struct {
+	Name       string   `json:"name"`
+	Endpoints  []string `json:"endpoints"`
+	Username   string   `json:"username,omitempty"`
+	TLS        bool     `json:"tls,omitempty"`
+	Registered bool     `json:"registered,omitempty"`
+}
@@ -0,0 +1,11 @@
+package types
+
+// KeyConflict is a key a conditional write expected at one revision and
+// found at another. A ModRevision or ExpectedRevision of 0 means the key
+// does not, or was expected not to, exist; Value is then omitted.
+type KeyConflict struct {
+	Key              string  `json:"key"`
+	ExpectedRevision int64   `json:"expectedRevision"`
+	ModRevision      int64   `json:"modRevision"`
+	Value            *string `json:"value,omitempty"`
+}
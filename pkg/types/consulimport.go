@@ -0,0 +1,27 @@
+package types
+
+// ConsulImportRequest is the body of POST /jobs/consul-import. Keys under
+// Source in Consul are written under Prefix with Source removed.
+type ConsulImportRequest struct {
+	Address    string `json:"address" binding:"required"`
+	Token      string `json:"token"`
+	Datacenter string `json:"datacenter"`
+	Source     string `json:"source"`
+	Prefix     string `json:"prefix" binding:"required"`
+	Conflict   string `json:"conflict"`
+	DryRun     bool   `json:"dryRun"`
+}
+
+// ConsulImportResult is a finished Consul import job's result. A dry run
+// only fills Keys, Create, Update and Unchanged, listing up to
+// 100 of the keys it would create or change.
+type ConsulImportResult struct {
+	Keys      int64    `json:"keys"`
+	Imported  int64    `json:"imported"`
+	Skipped   int64    `json:"skipped"`
+	Revision  int64    `json:"revision,omitempty"`
+	DryRun    bool     `json:"dryRun"`
+	Create    []string `json:"create,omitempty"`
+	Update    []string `json:"update,omitempty"`
+	Unchanged int64    `json:"unchanged,omitempty"`
+}
@@ -0,0 +1,9 @@
+package types
+
+// CopyRequest is the body of POST /copy and /move. Source and Destination are
+// prefixes, or exact keys when they do not end in a slash.
+type CopyRequest struct {
+	Source      string `json:"source" binding:"required"`
+	Destination string `json:"destination" binding:"required"`
+	Overwrite   bool   `json:"overwrite"`
+}
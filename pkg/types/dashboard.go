@@ -0,0 +1,28 @@
+package types
+
+// EndpointHealth is the status of one etcd endpoint.
+type EndpointHealth struct {
+	Endpoint  string `json:"endpoint"`
+	Healthy   bool   `json:"healthy"`
+	Version   string `json:"version,omitempty"`
+	Leader    bool   `json:"leader"`
+	DBSize    int64  `json:"dbSize,omitempty"`
+	RaftIndex uint64 `json:"raftIndex,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Alarm is an active etcd alarm, such as NOSPACE.
+type Alarm struct {
+	MemberID string `json:"memberId"`
+	Alarm    string `json:"alarm"`
+}
+
+// Dashboard is everything the UI home page shows, in one response.
+type Dashboard struct {
+	Endpoints       []EndpointHealth `json:"endpoints"`
+	Alarms          []Alarm          `json:"alarms"`
+	PrefixCounts    map[string]int64 `json:"prefixCounts"`
+	TotalKeys       int64            `json:"totalKeys"`
+	RecentlyChanged []KeyMeta        `json:"recentlyChanged"`
+	Revision        int64            `json:"revision"`
+}
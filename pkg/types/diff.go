@@ -0,0 +1,21 @@
+package types
+
+// KeyDiff is a key present under both prefixes with different values.
+type KeyDiff struct {
+	Key   string   `json:"key"`
+	Left  string   `json:"left"`
+	Right string   `json:"right"`
+	Diff  []string `json:"diff"`
+}
+
+// PrefixDiff compares two subtrees by key relative to their prefix.
+type PrefixDiff struct {
+	Left          string    `json:"left"`
+	Right         string    `json:"right"`
+	LeftRevision  int64     `json:"leftRevision"`
+	RightRevision int64     `json:"rightRevision"`
+	OnlyLeft      []string  `json:"onlyLeft"`
+	OnlyRight     []string  `json:"onlyRight"`
+	Changed       []KeyDiff `json:"changed"`
+	Identical     int       `json:"identical"`
+}
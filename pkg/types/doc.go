@@ -0,0 +1,3 @@
+// Package types holds the request and response bodies of the gateway's HTTP
+// API, shared by the server and pkg/client.
+package types
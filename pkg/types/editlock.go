@@ -0,0 +1,18 @@
+package types
+
+import "time"
+
+// EditLock says who is editing a key. It is advisory: it warns other
+// editors but does not stop anyone writing the key.
+type EditLock struct {
+	Key        string    `json:"key"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	TTL        int64     `json:"ttl"`
+}
+
+// EditLockRequest is the optional body of POST /edit-lock/*key.
+type EditLockRequest struct {
+	// TTL is how many seconds the lock lasts without a heartbeat.
+	TTL int64 `json:"ttl"`
+}
@@ -0,0 +1,10 @@
+package types
+
+// Environment is a logical environment such as "prod", as GET /environments
+// lists it: either a key prefix in the gateway's own cluster or a separate
+// cluster.
+type Environment struct {
+	Name      string   `json:"name"`
+	Prefix    string   `json:"prefix,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
+}
@@ -0,0 +1,10 @@
+package types
+
+// Error is the body of an error response. Some carry more detail than the
+// message: conditional writes that conflict add the keys that no longer
+// match and the revision they were read at.
+type Error struct {
+	Error     string        `json:"error"`
+	Revision  int64         `json:"revision,omitempty"`
+	Conflicts []KeyConflict `json:"conflicts,omitempty"`
+}
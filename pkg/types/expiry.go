@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// ExpiryEvent is a key attached to a lease that is about to expire, or that
+// was deleted because its lease expired or was revoked.
+type ExpiryEvent struct {
+	// Type is "expiring" or "expired".
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	// Lease is the hex encoded lease ID.
+	Lease string `json:"lease"`
+	// TTL is how many seconds an expiring key's lease has left.
+	TTL  int64     `json:"ttl,omitempty"`
+	Time time.Time `json:"time"`
+}
@@ -0,0 +1,22 @@
+package types
+
+// ExportRequest is the body of POST /jobs/export. Format is json, the
+// default, or tar.gz.
+type ExportRequest struct {
+	Prefix string `json:"prefix" binding:"required"`
+	Format string `json:"format"`
+}
+
+// ExportResult is a finished export job's result. A json artifact is an
+// object with the prefix, revision and keys, and can be posted to
+// /jobs/restore as is. A tar.gz artifact has a file per key, at the key's
+// path; /jobs/import reads it back.
+type ExportResult struct {
+	Artifact string `json:"artifact"`
+	Format   string `json:"format"`
+	Keys     int64  `json:"keys"`
+	Ignored  int64  `json:"ignored,omitempty"`
+	Bytes    int64  `json:"bytes"`
+	SHA256   string `json:"sha256"`
+	Revision int64  `json:"revision"`
+}
@@ -0,0 +1,18 @@
+package types
+
+// FaultConfig is the faults injected into etcd calls, the body of GET and
+// PUT /admin/faults on gateways built with the faults tag.
+type FaultConfig struct {
+	// LatencyMs delays every call by that many milliseconds, plus a random
+	// part of up to JitterMs.
+	LatencyMs int64 `json:"latencyMs"`
+	JitterMs  int64 `json:"jitterMs"`
+	// ErrorRate is the fraction of calls, from 0 to 1, failing as if etcd
+	// were unavailable.
+	ErrorRate float64 `json:"errorRate"`
+	// WatchDropRate is the fraction of watch responses, from 0 to 1, at
+	// which the watch is closed instead, as if its stream was lost.
+	WatchDropRate float64 `json:"watchDropRate"`
+	// Prefixes limits faults to calls on keys under them; empty is every key.
+	Prefixes []string `json:"prefixes,omitempty"`
+}
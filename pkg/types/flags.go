@@ -0,0 +1,37 @@
+package types
+
+import "time"
+
+// Flag is a feature flag. Enabled is the kill switch for every type and the
+// whole value of a boolean flag. A percentage flag is on for Percentage of
+// subjects; a targeted flag is on for subjects matching any rule, and
+// otherwise falls back to Percentage (0 unless set).
+type Flag struct {
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Description string     `json:"description,omitempty"`
+	Enabled     bool       `json:"enabled"`
+	Percentage  float64    `json:"percentage,omitempty"`
+	Rules       []FlagRule `json:"rules,omitempty"`
+	UpdatedBy   string     `json:"updatedBy,omitempty"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// FlagRule matches subjects whose Attribute is one of Values. The attribute
+// "userId" refers to the subject's user ID.
+type FlagRule struct {
+	Attribute string   `json:"attribute"`
+	Values    []string `json:"values"`
+}
+
+// FlagContext is the subject a flag is evaluated for.
+type FlagContext struct {
+	UserID     string            `json:"userId"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// FlagResult is a flag's value for one subject and why.
+type FlagResult struct {
+	Value  bool   `json:"value"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// GitOpsStatus reports the outcome of the most recent sync.
+type GitOpsStatus struct {
+	Repo      string    `json:"repo"`
+	Branch    string    `json:"branch"`
+	Prefix    string    `json:"prefix"`
+	Commit    string    `json:"commit,omitempty"`
+	LastSync  time.Time `json:"lastSync,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+	Created   int       `json:"created"`
+	Updated   int       `json:"updated"`
+	Deleted   int       `json:"deleted"`
+}
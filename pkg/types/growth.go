@@ -0,0 +1,21 @@
+package types
+
+import "time"
+
+// PrefixGrowth reports how much a prefix grew over the report window.
+type PrefixGrowth struct {
+	Prefix      string `json:"prefix"`
+	Keys        int64  `json:"keys"`
+	Bytes       int64  `json:"bytes"`
+	GrowthKeys  int64  `json:"growthKeys"`
+	GrowthBytes int64  `json:"growthBytes"`
+}
+
+// KeyspaceReport lists the largest values and fastest-growing prefixes.
+type KeyspaceReport struct {
+	SampledAt      time.Time      `json:"sampledAt"`
+	Revision       int64          `json:"revision"`
+	WindowStart    time.Time      `json:"windowStart"`
+	LargestKeys    []KeySize      `json:"largestKeys"`
+	FastestGrowing []PrefixGrowth `json:"fastestGrowing"`
+}
@@ -0,0 +1,44 @@
+package types
+
+import "time"
+
+// TreeNode is a key in the keys tree; ID is the full key and Name its last
+// segment.
+type TreeNode struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Value       string      `json:"value,omitempty"`
+	Children    []*TreeNode `json:"children,omitempty"`
+	HasChildren bool        `json:"hasChildren,omitempty"`
+}
+
+// KeysPage is the response for a paginated keys listing. Continue is empty
+// on the last page; otherwise it is passed back as ?continue= to fetch the next.
+type KeysPage struct {
+	Nodes    []*TreeNode `json:"nodes"`
+	Continue string      `json:"continue,omitempty"`
+	Revision int64       `json:"revision"`
+}
+
+// FlatKey is a key in a flat keys listing, as returned with ?flat=true.
+type FlatKey struct {
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	ModRevision int64  `json:"modRevision"`
+}
+
+// FlatKeysPage is the response for a paginated flat keys listing, like
+// KeysPage.
+type FlatKeysPage struct {
+	Keys     []FlatKey `json:"keys"`
+	Continue string    `json:"continue,omitempty"`
+	Revision int64     `json:"revision"`
+}
+
+// Value is the body of GET /value/{key}. Annotation is only included with
+// ?annotations=true.
+type Value struct {
+	Value      string      `json:"value"`
+	ModifiedAt *time.Time  `json:"modifiedAt,omitempty"`
+	Annotation *Annotation `json:"annotation,omitempty"`
+}
@@ -0,0 +1,10 @@
+package types
+
+// ImportResult is a finished import job's result. Ignored counts keys in
+// the source outside the strip prefix or under reserved prefixes.
+type ImportResult struct {
+	Imported int64 `json:"imported"`
+	Skipped  int64 `json:"skipped"`
+	Ignored  int64 `json:"ignored"`
+	Revision int64 `json:"revision"`
+}
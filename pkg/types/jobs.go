@@ -0,0 +1,28 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// JobLog is a line a job logged.
+type JobLog struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Job is the persisted state of a long-running operation.
+type Job struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	Actor      string          `json:"actor"`
+	Status     string          `json:"status"`
+	Done       int64           `json:"done"`
+	Total      int64           `json:"total"`
+	Logs       []JobLog        `json:"logs,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
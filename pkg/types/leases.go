@@ -0,0 +1,19 @@
+package types
+
+// Lease is an etcd lease. IDs are hex encoded, as etcdctl prints them,
+// since they do not fit in a JavaScript number.
+type Lease struct {
+	ID string `json:"id"`
+	// TTL is how many seconds the lease has left.
+	TTL        int64    `json:"ttl"`
+	GrantedTTL int64    `json:"grantedTtl"`
+	Keys       []string `json:"keys"`
+}
+
+// LeaseGrantRequest is the optional body of POST /leases. Keys are written
+// attached to the lease, so they are deleted when it expires.
+type LeaseGrantRequest struct {
+	// TTL is how many seconds the lease lasts without a keepalive.
+	TTL  int64             `json:"ttl"`
+	Keys map[string]string `json:"keys"`
+}
@@ -0,0 +1,14 @@
+package types
+
+// DefraggedMember is a member a rolling defrag defragmented.
+type DefraggedMember struct {
+	ID           string `json:"id"`
+	Endpoint     string `json:"endpoint"`
+	DBSizeBefore int64  `json:"dbSizeBefore"`
+	DBSizeAfter  int64  `json:"dbSizeAfter"`
+}
+
+// DefragResult is the result of a rolling defrag job.
+type DefragResult struct {
+	Members []DefraggedMember `json:"members"`
+}
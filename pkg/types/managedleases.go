@@ -0,0 +1,10 @@
+package types
+
+import "time"
+
+// ManagedLease is a lease marked for the gateway to keep alive.
+type ManagedLease struct {
+	ID       string    `json:"id"`
+	MarkedBy string    `json:"markedBy"`
+	MarkedAt time.Time `json:"markedAt"`
+}
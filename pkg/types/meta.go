@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+// KeyMeta is the metadata etcd keeps for a key, without its value.
+type KeyMeta struct {
+	Key            string `json:"key"`
+	CreateRevision int64  `json:"createRevision"`
+	ModRevision    int64  `json:"modRevision"`
+	Version        int64  `json:"version"`
+	// Lease is the hex encoded lease ID, as etcdctl prints it, or empty
+	// when the key is not attached to a lease.
+	Lease      string      `json:"lease,omitempty"`
+	ValueSize  int         `json:"valueSize"`
+	ModifiedAt *time.Time  `json:"modifiedAt,omitempty"`
+	Annotation *Annotation `json:"annotation,omitempty"`
+}
@@ -0,0 +1,24 @@
+package types
+
+import "time"
+
+// MirrorStatus is the mirror's progress, saved by the mirroring replica.
+// SyncedAt is when the source was last at a revision the destination has
+// caught up with; if the replica stops reporting, LagSeconds is the time
+// since then.
+type MirrorStatus struct {
+	State           string     `json:"state"`
+	Replica         string     `json:"replica,omitempty"`
+	Prefixes        []string   `json:"prefixes"`
+	Destination     []string   `json:"destination"`
+	DestPrefix      string     `json:"destPrefix,omitempty"`
+	SourceRevision  int64      `json:"sourceRevision"`
+	AppliedRevision int64      `json:"appliedRevision"`
+	LagSeconds      float64    `json:"lagSeconds"`
+	SyncedAt        *time.Time `json:"syncedAt,omitempty"`
+	ResyncedAt      *time.Time `json:"resyncedAt,omitempty"`
+	SyncedKeys      int64      `json:"syncedKeys"`
+	Applied         int64      `json:"applied"`
+	Error           string     `json:"error,omitempty"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
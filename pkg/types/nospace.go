@@ -0,0 +1,40 @@
+package types
+
+// MemberStatus is the storage status of the member behind one endpoint.
+// Error is set when the member cannot be reached.
+type MemberStatus struct {
+	ID          string `json:"id"`
+	Endpoint    string `json:"endpoint"`
+	Leader      bool   `json:"leader"`
+	DBSize      int64  `json:"dbSize"`
+	DBSizeInUse int64  `json:"dbSizeInUse"`
+	RaftIndex   uint64 `json:"raftIndex"`
+	Error       string `json:"error,omitempty"`
+}
+
+// NoSpaceStep is a remediation step and what running it would do now.
+type NoSpaceStep struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// NoSpaceStatus is the state of the cluster as NOSPACE remediation sees it.
+type NoSpaceStatus struct {
+	Alarms   []Alarm        `json:"alarms"`
+	Revision int64          `json:"revision"`
+	Members  []MemberStatus `json:"members"`
+	Steps    []NoSpaceStep  `json:"steps"`
+}
+
+// NoSpaceStepRequest is the body of POST /admin/nospace/:step. Steps only
+// run once confirmed, after reviewing them with GET /admin/nospace.
+type NoSpaceStepRequest struct {
+	Confirm bool `json:"confirm"`
+}
+
+// NoSpaceStepResult is what a remediation step did, and the cluster after.
+type NoSpaceStepResult struct {
+	Step   string        `json:"step"`
+	Logs   []JobLog      `json:"logs"`
+	Status NoSpaceStatus `json:"status"`
+}
@@ -0,0 +1,31 @@
+package types
+
+import "time"
+
+// DesiredState is the body of POST /plan: the complete set of keys that
+// should exist under Prefix. Keys in Values are relative to Prefix.
+type DesiredState struct {
+	Prefix string            `json:"prefix" binding:"required"`
+	Values map[string]string `json:"values" binding:"required"`
+}
+
+// PlanChange is one write a plan will make. ModRevision is the key's
+// revision when planned, 0 if it did not exist.
+type PlanChange struct {
+	Key         string  `json:"key"`
+	Action      string  `json:"action"`
+	Before      *string `json:"before,omitempty"`
+	After       *string `json:"after,omitempty"`
+	ModRevision int64   `json:"modRevision"`
+}
+
+// Plan is the set of changes that brings a prefix to a desired state.
+type Plan struct {
+	ID        string       `json:"id"`
+	Prefix    string       `json:"prefix"`
+	Actor     string       `json:"actor"`
+	Changes   []PlanChange `json:"changes"`
+	Unchanged int          `json:"unchanged"`
+	Revision  int64        `json:"revision"`
+	ExpiresAt time.Time    `json:"expiresAt"`
+}
@@ -0,0 +1,35 @@
+package types
+
+// PromoteRequest is the body of POST /promote. Each side is an environment,
+// a cluster, or with neither named the gateway's own cluster. Keys ending in
+// a slash select every key under that prefix in the source. Without Token
+// the request only previews the diff; sending the preview's token applies it.
+type PromoteRequest struct {
+	From        string   `json:"from"`
+	To          string   `json:"to"`
+	FromCluster string   `json:"fromCluster"`
+	ToCluster   string   `json:"toCluster"`
+	Keys        []string `json:"keys" binding:"required"`
+	Token       string   `json:"token"`
+}
+
+// PromoteChange is one line of a promotion diff. Action is "create",
+// "update", "delete" (a listed key that no longer exists in the source) or
+// "unchanged".
+type PromoteChange struct {
+	Key    string  `json:"key"`
+	Action string  `json:"action"`
+	Before *string `json:"before,omitempty"`
+	After  *string `json:"after,omitempty"`
+}
+
+// PromotePlan is the diff between two environments or clusters for a set
+// of keys.
+type PromotePlan struct {
+	From        string          `json:"from"`
+	To          string          `json:"to"`
+	FromCluster string          `json:"fromCluster,omitempty"`
+	ToCluster   string          `json:"toCluster,omitempty"`
+	Changes     []PromoteChange `json:"changes"`
+	Token       string          `json:"token"`
+}
@@ -0,0 +1,32 @@
+package types
+
+import "time"
+
+// ProposalOp is one write in a proposal: a put of Value, or a delete.
+type ProposalOp struct {
+	Key    string  `json:"key"`
+	Value  *string `json:"value,omitempty"`
+	Delete bool    `json:"delete,omitempty"`
+}
+
+// ProposalRequest is the body of POST /proposals.
+type ProposalRequest struct {
+	Title string       `json:"title" binding:"required"`
+	Ops   []ProposalOp `json:"ops" binding:"required"`
+}
+
+// Proposal is a set of writes awaiting review. Base records the ModRevision
+// of every key when the proposal was submitted; it is only applied if none
+// of them has changed since, so reviewers approve exactly what they saw.
+type Proposal struct {
+	ID        string           `json:"id"`
+	Title     string           `json:"title"`
+	Author    string           `json:"author"`
+	Status    string           `json:"status"`
+	Ops       []ProposalOp     `json:"ops"`
+	Base      map[string]int64 `json:"base"`
+	Reviewer  string           `json:"reviewer,omitempty"`
+	Comment   string           `json:"comment,omitempty"`
+	CreatedAt time.Time        `json:"createdAt"`
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
@@ -0,0 +1,14 @@
+package types
+
+import "time"
+
+// KeyValue is the flat JSON representation of an etcd key.
+type KeyValue struct {
+	Key            string `json:"key"`
+	Value          string `json:"value,omitempty"`
+	CreateRevision int64  `json:"createRevision"`
+	ModRevision    int64  `json:"modRevision"`
+	Version        int64  `json:"version"`
+	// ModifiedAt is the approximate time of ModRevision, when known.
+	ModifiedAt *time.Time `json:"modifiedAt,omitempty"`
+}
@@ -0,0 +1,19 @@
+package types
+
+import "time"
+
+// EtcdConnection is whether the gateway can reach etcd, and since when.
+// Error and Failures describe the failing probes while it cannot.
+type EtcdConnection struct {
+	Connected bool      `json:"connected"`
+	Since     time.Time `json:"since"`
+	Error     string    `json:"error,omitempty"`
+	Failures  int       `json:"failures,omitempty"`
+}
+
+// Readiness is the body of GET /ready: "ready", or "degraded" while etcd
+// cannot be reached.
+type Readiness struct {
+	Status string         `json:"status"`
+	Etcd   EtcdConnection `json:"etcd"`
+}
@@ -0,0 +1,21 @@
+package types
+
+// DumpedKey is a key and its value, as exports write and restores read them.
+type DumpedKey struct {
+	Key   string `json:"key" binding:"required"`
+	Value string `json:"value"`
+}
+
+// RestoreRequest is the body of POST /jobs/restore. Keys that already exist
+// are skipped unless Overwrite is set.
+type RestoreRequest struct {
+	Keys      []DumpedKey `json:"keys" binding:"required"`
+	Overwrite bool        `json:"overwrite"`
+}
+
+// RestoreResult is a finished restore job's result.
+type RestoreResult struct {
+	Restored int64 `json:"restored"`
+	Skipped  int64 `json:"skipped"`
+	Revision int64 `json:"revision"`
+}
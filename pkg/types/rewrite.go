@@ -0,0 +1,35 @@
+package types
+
+import "time"
+
+// RewriteRequest is the body of POST /admin/rewrites.
+type RewriteRequest struct {
+	From      string `json:"from" binding:"required"`
+	To        string `json:"to" binding:"required"`
+	Overwrite bool   `json:"overwrite"`
+	DryRun    bool   `json:"dryRun"`
+}
+
+// RewritePreview is the result of a dry run.
+type RewritePreview struct {
+	Keys       int               `json:"keys"`
+	Renames    map[string]string `json:"renames"`
+	Collisions []string          `json:"collisions"`
+	Revision   int64             `json:"revision"`
+}
+
+// RewriteJob is the persisted state of a prefix rewrite.
+type RewriteJob struct {
+	ID        string    `json:"id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Overwrite bool      `json:"overwrite"`
+	Actor     string    `json:"actor"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Total     int64     `json:"total"`
+	Moved     int64     `json:"moved"`
+	LastKey   string    `json:"lastKey,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,11 @@
+package types
+
+import "time"
+
+// TaskRun is the outcome of a task's last run, as stored in etcd.
+type TaskRun struct {
+	StartedAt time.Time     `json:"startedAt"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+	Replica   string        `json:"replica"`
+}
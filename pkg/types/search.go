@@ -0,0 +1,9 @@
+package types
+
+// SearchMatch is a key matched by a search, noting whether the pattern
+// matched the key path, the value, or both.
+type SearchMatch struct {
+	KeyValue
+	MatchedKey   bool `json:"matchedKey"`
+	MatchedValue bool `json:"matchedValue"`
+}
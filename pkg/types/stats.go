@@ -0,0 +1,19 @@
+package types
+
+// KeySize is a key and the size of its value in bytes.
+type KeySize struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+}
+
+// PrefixStats summarizes the footprint of a subtree.
+type PrefixStats struct {
+	Prefix      string    `json:"prefix"`
+	Count       int64     `json:"count"`
+	KeyBytes    int64     `json:"keyBytes"`
+	ValueBytes  int64     `json:"valueBytes"`
+	MaxDepth    int       `json:"maxDepth"`
+	DeepestPath string    `json:"deepestPath,omitempty"`
+	LargestKeys []KeySize `json:"largestKeys"`
+	Revision    int64     `json:"revision"`
+}
@@ -0,0 +1,19 @@
+package types
+
+import "time"
+
+// TagRequest is the body of POST /tags.
+type TagRequest struct {
+	Name   string `json:"name" binding:"required"`
+	Prefix string `json:"prefix" binding:"required"`
+}
+
+// Tag names the state of a prefix at a revision. It is only readable until
+// etcd compacts past Revision.
+type Tag struct {
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"`
+	Revision  int64     `json:"revision"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,19 @@
+package types
+
+import "time"
+
+// TrashEntry is a soft-deleted key and the value it held.
+type TrashEntry struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	DeletedAt time.Time `json:"deletedAt"`
+	// Revision is the key's ModRevision when it was deleted.
+	Revision int64 `json:"revision"`
+}
+
+// TrashRestoreRequest is the body of POST /trash/restore.
+type TrashRestoreRequest struct {
+	ID        string `json:"id" binding:"required"`
+	Overwrite bool   `json:"overwrite"`
+}
@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// Bookmark is a saved key or prefix.
+type Bookmark struct {
+	Key       string    `json:"key"`
+	Label     string    `json:"label,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// RecentKey is a key a user viewed.
+type RecentKey struct {
+	Key      string    `json:"key"`
+	ViewedAt time.Time `json:"viewedAt"`
+}
@@ -0,0 +1,27 @@
+package types
+
+// WriteChange is one change in a write a validation webhook is asked about.
+// Deletes of a range carry its exclusive end.
+type WriteChange struct {
+	Operation string `json:"operation"`
+	Key       string `json:"key"`
+	RangeEnd  string `json:"rangeEnd,omitempty"`
+	Value     string `json:"value,omitempty"`
+}
+
+// ValidationRequest is the body POSTed to a validation webhook: the changes
+// one write may make under its prefix, all or none of which are made. Those
+// of a transaction include both its branches.
+type ValidationRequest struct {
+	UID       string        `json:"uid"`
+	User      string        `json:"user,omitempty"`
+	RequestID string        `json:"requestId,omitempty"`
+	Changes   []WriteChange `json:"changes"`
+}
+
+// ValidationResponse is a validation webhook's answer. Reason is shown to
+// the client when the write is rejected.
+type ValidationResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+}
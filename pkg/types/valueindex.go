@@ -0,0 +1,7 @@
+package types
+
+// ValueHit is a key whose value matched a full-text query.
+type ValueHit struct {
+	Key     string `json:"key"`
+	Snippet string `json:"snippet"`
+}
@@ -0,0 +1,38 @@
+package types
+
+// ZNodeRule translates znode paths under Path, relative to the import root,
+// to keys under Key. Skip leaves the subtree out instead.
+type ZNodeRule struct {
+	Path string `json:"path" binding:"required"`
+	Key  string `json:"key"`
+	Skip bool   `json:"skip"`
+}
+
+// ZooKeeperImportRequest is the body of POST /jobs/zookeeper-import. Each
+// znode under Root is written to Prefix plus its path relative to Root,
+// after the first matching rule.
+type ZooKeeperImportRequest struct {
+	Servers  []string    `json:"servers" binding:"required"`
+	Digest   string      `json:"digest"`
+	Root     string      `json:"root"`
+	Prefix   string      `json:"prefix" binding:"required"`
+	Rules    []ZNodeRule `json:"rules"`
+	Binary   string      `json:"binary"`
+	Conflict string      `json:"conflict"`
+	DryRun   bool        `json:"dryRun"`
+}
+
+// ZooKeeperImportResult is a finished ZooKeeper import job's result. Nodes
+// counts the znodes visited; Ignored those left out as ephemeral, as data-less
+// parents, or as binary with binary=skip. A dry run lists up to
+// 100 of the keys it would write.
+type ZooKeeperImportResult struct {
+	Nodes    int64    `json:"nodes"`
+	Keys     int64    `json:"keys"`
+	Ignored  int64    `json:"ignored"`
+	Imported int64    `json:"imported"`
+	Skipped  int64    `json:"skipped"`
+	Revision int64    `json:"revision,omitempty"`
+	DryRun   bool     `json:"dryRun"`
+	Preview  []string `json:"preview,omitempty"`
+}